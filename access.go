@@ -0,0 +1,179 @@
+package badger
+
+import (
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"time"
+)
+
+// accessPrefix keys hold the last read of the blobs, as unix seconds. They
+// are kept when a blob is trashed or restored, and removed with the blob.
+var accessPrefix = []byte("acces")
+
+// accessBatchSize is the number of reads kept in memory before they are
+// written.
+var accessBatchSize = 1000
+
+// accessFlushInterval is the time after which the reads kept in memory are
+// written, even if there are less of them.
+var accessFlushInterval = time.Minute
+
+func accessKey(ref blobstore.BlobRef) []byte {
+	return concat(accessPrefix, ref.Namespace, ref.Key)
+}
+
+// accessKeyOf returns the access key of a blob or trash key.
+func accessKeyOf(key []byte) []byte {
+	return concat(accessPrefix, key[len(blobPrefix):len(key)-16])
+}
+
+// accessTracker records the last reads of the blobs. A read is recorded only
+// if the one recorded earlier is older than the interval, so the reads don't
+// turn into writes, and the reads are written in batches.
+type accessTracker struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+	flushed time.Time
+}
+
+func newAccessTracker(interval time.Duration) *accessTracker {
+	if interval <= 0 {
+		return nil
+	}
+	return &accessTracker{interval: interval, pending: map[string]time.Time{}}
+}
+
+// readAccess returns the last read recorded under the access key, zero if
+// there is none.
+func readAccess(txn kvTxn, key []byte) (time.Time, error) {
+	item, err := txn.Get(key)
+	if errs.Is(err, errKeyNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errs.Wrap(err)
+	}
+	var at time.Time
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return ErrCorrupt.New("access record %x has %d bytes", key, len(val))
+		}
+		at = time.Unix(int64(binary.BigEndian.Uint64(val)), 0)
+		return nil
+	})
+	return at, err
+}
+
+// removeAccess removes the last read of a blob or trash key, if recorded.
+func removeAccess(txn kvTxn, key []byte) error {
+	access := accessKeyOf(key)
+	if _, err := txn.Get(access); errs.Is(err, errKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Delete(access)
+}
+
+// lastAccess returns the last read of the blob, zero if it wasn't read since
+// the tracking was enabled.
+func (t *accessTracker) lastAccess(txn kvTxn, ref blobstore.BlobRef) (time.Time, error) {
+	if t == nil {
+		return time.Time{}, nil
+	}
+	key := accessKey(ref)
+	t.mu.Lock()
+	at, ok := t.pending[string(key)]
+	t.mu.Unlock()
+	if ok {
+		return at, nil
+	}
+	return readAccess(txn, key)
+}
+
+// touch records a read of the blob at now. The reads are written when a
+// batch is full or after accessFlushInterval.
+func (t *accessTracker) touch(db kvEngine, ref blobstore.BlobRef, now time.Time) error {
+	if t == nil {
+		return nil
+	}
+	key := accessKey(ref)
+	t.mu.Lock()
+	_, ok := t.pending[string(key)]
+	t.mu.Unlock()
+	if ok {
+		return nil
+	}
+	var last time.Time
+	err := view(db, func(txn kvTxn) (err error) {
+		last, err = readAccess(txn, key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if now.Sub(last) < t.interval {
+		return nil
+	}
+	t.mu.Lock()
+	t.pending[string(key)] = now
+	if t.flushed.IsZero() {
+		t.flushed = now
+	}
+	full := len(t.pending) >= accessBatchSize || now.Sub(t.flushed) >= accessFlushInterval
+	t.mu.Unlock()
+	if !full {
+		return nil
+	}
+	return t.flush(db, now)
+}
+
+// flush writes the reads kept in memory. The reads of the blobs deleted
+// meanwhile are dropped.
+func (t *accessTracker) flush(db kvEngine, now time.Time) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = map[string]time.Time{}
+	t.flushed = now
+	t.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	mon.IntVal("access_records_written").Observe(int64(len(pending)))
+	return update(db, func(txn kvTxn) error {
+		for key, at := range pending {
+			body := []byte(key[len(accessPrefix):])
+			if len(blobVersions(txn, concat(blobPrefix, body))) == 0 && len(blobVersions(txn, concat(trashPrefix, body))) == 0 {
+				continue
+			}
+			if err := txn.Set([]byte(key), binary.BigEndian.AppendUint64(nil, uint64(at.Unix()))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// accessed records a read of the blob with Config.AccessTracking. Failing to
+// record it doesn't fail the read.
+func (b *BlobStore) accessed(ref blobstore.BlobRef) {
+	if err := b.access.touch(b.db, ref, b.clock.Now()); err != nil {
+		mon.Counter("access_tracking_errors").Inc(1)
+		b.log.Warn("recording the last read of a blob failed", zap.Error(err))
+	}
+}
+
+// flushAccess writes the reads kept in memory when the store is closed.
+func (b *BlobStore) flushAccess() {
+	if err := b.access.flush(b.db, b.clock.Now()); err != nil {
+		b.log.Warn("writing the last reads of the blobs failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,74 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestAccessTracking(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{AccessTracking: 24 * time.Hour})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	now := time.Unix(time.Now().Unix(), 0)
+	store.SetClock(fixedClock(now))
+	blob := ref("ns", "key")
+	require.NoError(t, save(ctx, store, blob, "data"))
+
+	lastAccess := func() time.Time {
+		info, err := store.Stat(ctx, blob)
+		require.NoError(t, err)
+		return info.(BlobInfo).LastAccess()
+	}
+	read := func() {
+		r, err := store.Open(ctx, blob)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+	}
+	require.True(t, lastAccess().IsZero())
+
+	read()
+	require.Equal(t, now, lastAccess())
+	require.NoError(t, store.access.flush(store.db, now))
+	require.Equal(t, now, lastAccess())
+
+	// reads within the interval aren't recorded
+	store.SetClock(fixedClock(now.Add(time.Hour)))
+	read()
+	require.Empty(t, store.access.pending)
+	require.Equal(t, now, lastAccess())
+
+	later := now.Add(25 * time.Hour)
+	store.SetClock(fixedClock(later))
+	_, err = store.ReadRange(ctx, blob, 0, 2)
+	require.NoError(t, err)
+	require.Equal(t, later, lastAccess())
+
+	// the walks see it too, and it's kept in the trash
+	require.NoError(t, store.Trash(ctx, blob, later))
+	var walked []time.Time
+	require.NoError(t, store.WalkTrash(ctx, blob.Namespace, "", func(info blobstore.BlobInfo) error {
+		walked = append(walked, info.(BlobInfo).LastAccess())
+		return nil
+	}))
+	require.Equal(t, []time.Time{later}, walked)
+	_, err = store.RestoreTrash(ctx, blob.Namespace)
+	require.NoError(t, err)
+	require.NoError(t, store.access.flush(store.db, later))
+	require.Equal(t, later, lastAccess())
+
+	// it's removed with the blob
+	require.NoError(t, store.Delete(ctx, blob))
+	require.NoError(t, store.FlushDeletes(ctx))
+	require.NoError(t, store.db.View(func(txn kvTxn) error {
+		_, err := txn.Get(accessKey(blob))
+		require.ErrorIs(t, err, errKeyNotFound)
+		return nil
+	}))
+}
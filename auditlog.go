@@ -0,0 +1,142 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	auditDelete          = "delete"
+	auditTrash           = "trash"
+	auditRestore         = "restore"
+	auditEmptyTrash      = "empty-trash"
+	auditDeleteNamespace = "delete-namespace"
+	auditForgetNamespace = "forget-namespace"
+)
+
+// initiatorKey is the context key of the initiator of the mutations.
+type initiatorKey struct{}
+
+// WithInitiator returns a context which makes the audit log record the
+// mutations done with it as initiated by initiator, like "gc" or "operator".
+func WithInitiator(ctx context.Context, initiator string) context.Context {
+	return context.WithValue(ctx, initiatorKey{}, initiator)
+}
+
+func initiatorOf(ctx context.Context) string {
+	if initiator, ok := ctx.Value(initiatorKey{}).(string); ok {
+		return initiator
+	}
+	return "unknown"
+}
+
+// auditEntry is a line of the audit log. Namespace operations have no piece,
+// but the number of blobs they removed, if any.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Initiator string    `json:"initiator"`
+	Satellite string    `json:"satellite"`
+	PieceID   string    `json:"piece_id,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Blobs     int64     `json:"blobs,omitempty"`
+}
+
+// auditLog appends to the audit log file, which is opened on the first write
+// and kept open.
+type auditLog struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (a *auditLog) append(entries []auditEntry) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+			return errs.Wrap(err)
+		}
+		file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		a.file = file
+	}
+	_, err := a.file.Write(buffer.Bytes())
+	return errs.Wrap(err)
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return errs.Wrap(err)
+}
+
+// audit records the changed blob versions in the audit log.
+func (b *BlobStore) audit(ctx context.Context, operation string, changed []deletion) {
+	if b.auditLog == nil || len(changed) == 0 {
+		return
+	}
+	now, initiator := b.clock.Now(), initiatorOf(ctx)
+	entries := make([]auditEntry, 0, len(changed))
+	for _, d := range changed {
+		entries = append(entries, auditEntry{
+			Time:      now,
+			Operation: operation,
+			Initiator: initiator,
+			Satellite: formatSatellite(d.ref.Namespace),
+			PieceID:   formatPiece(d.ref.Key),
+			Size:      d.size,
+		})
+	}
+	b.writeAudit(entries)
+}
+
+// auditNamespace records an operation on the namespace in the audit log.
+func (b *BlobStore) auditNamespace(ctx context.Context, operation string, namespace []byte, blobs, size int64) {
+	if b.auditLog == nil {
+		return
+	}
+	b.writeAudit([]auditEntry{{
+		Time:      b.clock.Now(),
+		Operation: operation,
+		Initiator: initiatorOf(ctx),
+		Satellite: formatSatellite(namespace),
+		Size:      size,
+		Blobs:     blobs,
+	}})
+}
+
+// writeAudit appends the entries to the audit log. The mutations are
+// committed already, so failures are only logged.
+func (b *BlobStore) writeAudit(entries []auditEntry) {
+	if err := b.auditLog.append(entries); err != nil {
+		mon.Counter("audit_log_failures").Inc(1)
+		b.log.Warn("writing audit log failed", zap.String("path", b.auditLog.path), zap.Error(err))
+	}
+}
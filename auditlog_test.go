@@ -0,0 +1,94 @@
+package badger
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	path := filepath.Join(ctx.Dir("audit"), "audit.jsonl")
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{AuditLog: path})
+	require.NoError(t, err)
+
+	operator := WithInitiator(ctx, "operator")
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "12"))
+	require.NoError(t, store.Delete(operator, ref("ns", "key1")))
+	// deleting a missing blob changes nothing
+	require.NoError(t, store.Delete(operator, ref("ns", "key1")))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	_, err = store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteNamespace(operator, []byte("ns")))
+	require.NoError(t, save(ctx, store, ref("other", "key"), "123"))
+	require.NoError(t, store.ForgetNamespace(operator, []byte("other")))
+	require.NoError(t, store.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer ctx.Check(file.Close)
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		require.NotZero(t, entry.Time)
+		entry.Time = time.Time{}
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+
+	ns, other := formatSatellite([]byte("ns")), formatSatellite([]byte("other"))
+	require.Equal(t, []auditEntry{
+		{Operation: auditDelete, Initiator: "operator", Satellite: ns, PieceID: formatPiece([]byte("key1")), Size: 4},
+		{Operation: auditTrash, Initiator: "unknown", Satellite: ns, PieceID: formatPiece([]byte("key2")), Size: 2},
+		{Operation: auditRestore, Initiator: "unknown", Satellite: ns, PieceID: formatPiece([]byte("key2")), Size: 2},
+		{Operation: auditTrash, Initiator: "unknown", Satellite: ns, PieceID: formatPiece([]byte("key2")), Size: 2},
+		{Operation: auditEmptyTrash, Initiator: "unknown", Satellite: ns, PieceID: formatPiece([]byte("key2")), Size: 2},
+		{Operation: auditDeleteNamespace, Initiator: "operator", Satellite: ns},
+		{Operation: auditForgetNamespace, Initiator: "operator", Satellite: other, Size: 3, Blobs: 1},
+	}, entries)
+}
+
+func TestAuditLogRolledBack(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	path := filepath.Join(ctx.Dir("audit"), "audit.jsonl")
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{AuditLog: path})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	for _, key := range []string{"key1", "key2"} {
+		require.NoError(t, save(ctx, store, ref("ns", key), "1234"))
+		require.NoError(t, store.Trash(ctx, ref("ns", key), time.Now()))
+	}
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// the invalid trash time of key2 fails the transaction which emptied key1
+	err = store.db.Update(func(txn kvTxn) error {
+		keys := blobVersions(txn, concat(trashPrefix, []byte("ns"), []byte("key2")))
+		require.Len(t, keys, 1)
+		return txn.Set(trashTimeKey(keys[0].key), []byte{1})
+	})
+	require.NoError(t, err)
+	_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.True(t, ErrCorrupt.Has(err), err)
+	require.Error(t, store.DeleteTrashNamespace(ctx, []byte("ns")))
+
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), after.Size())
+}
@@ -0,0 +1,100 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBackpressure is returned by Create while the store can't keep up with
+// the writes. Uploads can be retried later.
+var ErrBackpressure = errs.Class("write backpressure")
+
+// backpressureCheckInterval is how often the stall conditions are checked.
+var backpressureCheckInterval = time.Second
+
+// backpressure tracks whether writes are about to stall, based on the number
+// of level 0 tables waiting for compaction and on the commit latency.
+type backpressure struct {
+	maxLevel0Tables int
+	maxLatency      time.Duration
+
+	active atomic.Bool
+
+	mu       sync.Mutex
+	latency  time.Duration // moving average of the commit latency
+	callback func(active bool)
+}
+
+// observeCommit records the latency of a commit.
+func (p *backpressure) observeCommit(latency time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency += (latency - p.latency) / 8
+}
+
+// stalled returns whether the store is overloaded with the given state.
+func (p *backpressure) stalled(level0Tables int, latency time.Duration) bool {
+	return (p.maxLevel0Tables > 0 && level0Tables >= p.maxLevel0Tables) ||
+		(p.maxLatency > 0 && latency > p.maxLatency)
+}
+
+// update sets the signal, calling the callback when it changes.
+func (p *backpressure) update(level0Tables int) {
+	p.mu.Lock()
+	latency, callback := p.latency, p.callback
+	p.mu.Unlock()
+
+	active := p.stalled(level0Tables, latency)
+	if p.active.Swap(active) != active {
+		mon.Counter("backpressure_changes").Inc(1)
+		if callback != nil {
+			callback(active)
+		}
+	}
+}
+
+// startBackpressure monitors the stall conditions, when enabled by the config.
+func (b *BlobStore) startBackpressure() {
+	if b.config.BackpressureLevel0Tables <= 0 && b.config.BackpressureCommitLatency <= 0 {
+		return
+	}
+	b.backpressure = &backpressure{
+		maxLevel0Tables: b.config.BackpressureLevel0Tables,
+		maxLatency:      b.config.BackpressureCommitLatency,
+	}
+	b.runBackground("backpressure", func(ctx context.Context) {
+		ticker := time.NewTicker(backpressureCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			b.backpressure.update(level0Tables(b.db))
+		}
+	})
+}
+
+// Backpressure returns whether new uploads are rejected because the store
+// can't keep up with the writes.
+func (b *BlobStore) Backpressure() bool {
+	return b.backpressure != nil && b.backpressure.active.Load()
+}
+
+// OnBackpressure sets the callback called when the backpressure signal turns
+// on or off. It's called from a background goroutine.
+func (b *BlobStore) OnBackpressure(callback func(active bool)) {
+	if b.backpressure == nil {
+		return
+	}
+	b.backpressure.mu.Lock()
+	defer b.backpressure.mu.Unlock()
+	b.backpressure.callback = callback
+}
@@ -0,0 +1,53 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackpressureConditions(t *testing.T) {
+	p := &backpressure{maxLevel0Tables: 10, maxLatency: time.Second}
+	require.False(t, p.stalled(9, time.Second))
+	require.True(t, p.stalled(10, 0))
+	require.True(t, p.stalled(0, 2*time.Second))
+
+	var changes []bool
+	p.callback = func(active bool) { changes = append(changes, active) }
+	p.update(10)
+	p.update(11)
+	p.update(0)
+	require.Equal(t, []bool{true, false}, changes)
+
+	for i := 0; i < 50; i++ {
+		p.observeCommit(3 * time.Second)
+	}
+	p.update(0)
+	require.True(t, p.active.Load())
+}
+
+func TestBackpressure(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(interval time.Duration) { backpressureCheckInterval = interval }(backpressureCheckInterval)
+	backpressureCheckInterval = 10 * time.Millisecond
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{BackpressureCommitLatency: time.Nanosecond})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	var signaled atomic.Bool
+	store.OnBackpressure(signaled.Store)
+	require.False(t, store.Backpressure())
+
+	// every commit is slower than a nanosecond
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.Eventually(t, store.Backpressure, 10*time.Second, 10*time.Millisecond)
+	require.True(t, signaled.Load())
+
+	_, err = store.Create(ctx, ref("ns", "other"))
+	require.True(t, ErrBackpressure.Has(err))
+}
@@ -0,0 +1,491 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBackup is returned when backing up or restoring the store fails.
+var ErrBackup = errs.Class("backup")
+
+// backupPrefix starts the names of the backups.
+const backupPrefix = "backup-"
+
+// manifestSuffix is appended to the name of the backup for its manifest.
+const manifestSuffix = ".manifest"
+
+// backupSegmentSize is the size of the checksummed and encrypted segments
+// of the backups.
+var backupSegmentSize = 4 << 20
+
+// BackupTarget stores backups by name. Targets are plugins: anything which
+// can store streams, like a directory or an S3 bucket.
+type BackupTarget interface {
+	// Create returns a writer for the backup, which is only stored once the
+	// writer is closed without errors.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the names of the stored backups in lexical order.
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// BackupInfo describes a backup.
+type BackupInfo struct {
+	Name string `json:"name"`
+	// Since is the version the backup continues from, 0 for full backups.
+	Since uint64 `json:"since"`
+	// Version is the last version in the backup, the one to continue from
+	// with the next incremental backup.
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// BackupManifest is stored next to every backup, named after it with
+// manifestSuffix. Backups are only complete with their manifest.
+type BackupManifest struct {
+	BackupInfo
+	Encrypted bool            `json:"encrypted"`
+	Segments  []BackupSegment `json:"segments"`
+	// Meta is the meta of the store when the backup was made.
+	Meta json.RawMessage `json:"meta"`
+}
+
+// BackupSegment is a part of the backup as stored, encrypted if the backup is.
+type BackupSegment struct {
+	Size   int64  `json:"size"`
+	SHA256 []byte `json:"sha256"`
+}
+
+// Backup streams the changes of the store after the version to a new backup
+// of the target: all the data for 0, an incremental backup otherwise. The
+// backups are named after their time, so they sort in the order to restore
+// them in. The backup is encrypted when a BackupKey is configured.
+func (b *BlobStore) Backup(ctx context.Context, target BackupTarget, since uint64) (info BackupInfo, err error) {
+	var m meta
+	err = b.db.View(func(txn kvTxn) (err error) {
+		m, _, err = readMeta(txn)
+		return err
+	})
+	if err != nil {
+		return BackupInfo{Since: since}, ErrBackup.Wrap(err)
+	}
+	return b.writeBackup(ctx, target, since, m, func(w io.Writer) (uint64, error) {
+		return b.db.Backup(w, since)
+	})
+}
+
+// writeBackup stores the data written by dump as a new backup of the target,
+// with its manifest. dump returns the version of the backup.
+func (b *BlobStore) writeBackup(ctx context.Context, target BackupTarget, since uint64, m meta, dump func(w io.Writer) (uint64, error)) (info BackupInfo, err error) {
+	info = BackupInfo{Since: since, Time: time.Now().UTC()}
+	kind := "full"
+	if since > 0 {
+		kind = "incremental"
+	}
+	info.Name = backupPrefix + info.Time.Format("20060102T150405.000000000Z") + "-" + kind
+	manifest := BackupManifest{Encrypted: b.backupKey != nil}
+	manifest.Meta, err = json.Marshal(m)
+	if err != nil {
+		return info, ErrBackup.Wrap(err)
+	}
+
+	w, err := target.Create(ctx, info.Name)
+	if err != nil {
+		return info, ErrBackup.Wrap(err)
+	}
+	segments := &segmentWriter{w: w, aead: b.backupKey, name: info.Name, buf: make([]byte, 0, backupSegmentSize)}
+	info.Version, err = dump(ctxWriter{ctx: ctx, w: segments})
+	if err == nil {
+		err = segments.Close()
+	}
+	if err != nil {
+		_ = w.Close()
+		return info, ErrBackup.Wrap(errs.Combine(err, target.Delete(ctx, info.Name)))
+	}
+	if err := w.Close(); err != nil {
+		return info, ErrBackup.Wrap(err)
+	}
+	// nothing changed since the version
+	if info.Version < since {
+		info.Version = since
+	}
+
+	manifest.BackupInfo = info
+	manifest.Segments = segments.segments
+	if err := writeManifest(ctx, target, manifest); err != nil {
+		return info, ErrBackup.Wrap(errs.Combine(err, target.Delete(ctx, info.Name)))
+	}
+	mon.Counter("backups").Inc(1)
+	return info, nil
+}
+
+func writeManifest(ctx context.Context, target BackupTarget, manifest BackupManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	w, err := target.Create(ctx, manifest.Name+manifestSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return errs.Combine(err, w.Close())
+}
+
+// Backups returns the manifests of the complete backups of the target in
+// the order to restore them in.
+func Backups(ctx context.Context, target BackupTarget) (manifests []BackupManifest, err error) {
+	names, err := target.List(ctx)
+	if err != nil {
+		return nil, ErrBackup.Wrap(err)
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, manifestSuffix) {
+			continue
+		}
+		manifest, err := readManifest(ctx, target, strings.TrimSuffix(name, manifestSuffix))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+func readManifest(ctx context.Context, target BackupTarget, name string) (manifest BackupManifest, err error) {
+	r, err := target.Open(ctx, name+manifestSuffix)
+	if err != nil {
+		return manifest, ErrBackup.New("manifest of %s: %v", name, err)
+	}
+	defer func() { err = errs.Combine(err, r.Close()) }()
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return manifest, ErrBackup.New("manifest of %s: %v", name, err)
+	}
+	if manifest.Name != name {
+		return manifest, ErrBackup.New("manifest of %s is for %s", name, manifest.Name)
+	}
+	return manifest, nil
+}
+
+// Restore loads the backups of the target in order, a full backup followed
+// by the incremental ones. It's meant for new, empty stores. The manifests
+// and the checksums of all the backups are verified before anything is
+// loaded.
+func (b *BlobStore) Restore(ctx context.Context, target BackupTarget, names []string) error {
+	manifests := make([]BackupManifest, 0, len(names))
+	for i, name := range names {
+		manifest, err := readManifest(ctx, target, name)
+		if err != nil {
+			return err
+		}
+		if err := b.checkManifest(manifest); err != nil {
+			return err
+		}
+		switch {
+		case i == 0 && manifest.Since != 0:
+			return ErrBackup.New("%s is not a full backup", name)
+		case i > 0 && manifest.Since != manifests[i-1].Version:
+			return ErrBackup.New("%s doesn't continue %s", name, names[i-1])
+		}
+		manifests = append(manifests, manifest)
+	}
+	for _, manifest := range manifests {
+		if err := b.readBackup(ctx, target, manifest, func(r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	for _, manifest := range manifests {
+		if err := b.readBackup(ctx, target, manifest, func(r io.Reader) error {
+			return b.db.Load(r)
+		}); err != nil {
+			return err
+		}
+	}
+	namespaces, err := listNamespaces(b.db)
+	if err != nil {
+		return err
+	}
+	b.setNamespaces(namespaces)
+	if b.cache != nil {
+		b.cache.clear()
+	}
+	return nil
+}
+
+// checkManifest checks whether the backup can be restored to the store.
+func (b *BlobStore) checkManifest(manifest BackupManifest) error {
+	if manifest.Encrypted && b.backupKey == nil {
+		return ErrBackup.New("%s is encrypted, but no backup key is configured", manifest.Name)
+	}
+	var m meta
+	if err := json.Unmarshal(manifest.Meta, &m); err != nil {
+		return ErrBackup.New("meta of %s: %v", manifest.Name, err)
+	}
+	if m.LayoutVersion > layoutVersion {
+		return ErrBackup.New("%s has layout version %d, newer than the supported %d", manifest.Name, m.LayoutVersion, layoutVersion)
+	}
+	return nil
+}
+
+// readBackup calls fn with the verified and decrypted data of the backup.
+func (b *BlobStore) readBackup(ctx context.Context, target BackupTarget, manifest BackupManifest, fn func(io.Reader) error) error {
+	r, err := target.Open(ctx, manifest.Name)
+	if err != nil {
+		return ErrBackup.Wrap(err)
+	}
+	segments := &segmentReader{r: r, manifest: manifest}
+	if manifest.Encrypted {
+		segments.aead = b.backupKey
+	}
+	err = fn(ctxReader{ctx: ctx, r: segments})
+	if err := errs.Combine(err, r.Close()); err != nil {
+		return ErrBackup.New("restoring %s: %v", manifest.Name, err)
+	}
+	return nil
+}
+
+// parseBackupKey returns the cipher of the hex encoded key, nil for no key.
+func parseBackupKey(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, ErrBackup.New("invalid backup key: %v", err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, ErrBackup.New("invalid backup key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, ErrBackup.Wrap(err)
+}
+
+// segmentWriter splits the backup into segments, encrypted if aead is set.
+// A segment is stored as its size followed by the nonce and the sealed data.
+// The index of the segment and whether it's the last one are authenticated
+// too, so segments can't be reordered or dropped.
+type segmentWriter struct {
+	w        io.Writer
+	aead     cipher.AEAD
+	name     string
+	buf      []byte
+	segments []BackupSegment
+}
+
+func (s *segmentWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(s.buf) == cap(s.buf) {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		chunk := cap(s.buf) - len(s.buf)
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		s.buf = append(s.buf, p[:chunk]...)
+		p, n = p[chunk:], n+chunk
+	}
+	return n, nil
+}
+
+// Close writes the last segment, which may be empty.
+func (s *segmentWriter) Close() error {
+	return s.flush(true)
+}
+
+func (s *segmentWriter) flush(last bool) error {
+	segment := s.buf
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return errs.Wrap(err)
+		}
+		segment = s.aead.Seal(nonce, nonce, s.buf, segmentAD(s.name, len(s.segments), last))
+	}
+	hash := sha256.Sum256(segment)
+	s.segments = append(s.segments, BackupSegment{Size: int64(len(segment)), SHA256: hash[:]})
+	if _, err := s.w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(segment)))); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(segment); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func segmentAD(name string, index int, last bool) []byte {
+	ad := binary.BigEndian.AppendUint64([]byte(name), uint64(index))
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// segmentReader reads the data of the segments, checking them against the
+// manifest.
+type segmentReader struct {
+	r        io.Reader
+	aead     cipher.AEAD
+	manifest BackupManifest
+	index    int
+	buf      []byte
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.index == len(s.manifest.Segments) {
+			if n, _ := s.r.Read(make([]byte, 1)); n > 0 {
+				return 0, ErrBackup.New("data after the last segment")
+			}
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *segmentReader) next() error {
+	expected := s.manifest.Segments[s.index]
+	var size [4]byte
+	if _, err := io.ReadFull(s.r, size[:]); err != nil {
+		return ErrBackup.New("segment %d: %v", s.index, err)
+	}
+	if int64(binary.BigEndian.Uint32(size[:])) != expected.Size {
+		return ErrBackup.New("segment %d has %d bytes instead of %d", s.index, binary.BigEndian.Uint32(size[:]), expected.Size)
+	}
+	segment := make([]byte, expected.Size)
+	if _, err := io.ReadFull(s.r, segment); err != nil {
+		return ErrBackup.New("segment %d: %v", s.index, err)
+	}
+	if hash := sha256.Sum256(segment); !bytes.Equal(hash[:], expected.SHA256) {
+		return ErrBackup.New("checksum mismatch of segment %d", s.index)
+	}
+	s.buf = segment
+	if s.aead != nil {
+		if len(segment) < s.aead.NonceSize() {
+			return ErrBackup.New("segment %d is too short", s.index)
+		}
+		nonce := segment[:s.aead.NonceSize()]
+		last := s.index == len(s.manifest.Segments)-1
+		data, err := s.aead.Open(nil, nonce, segment[len(nonce):], segmentAD(s.manifest.Name, s.index, last))
+		if err != nil {
+			return ErrBackup.New("decrypting segment %d: %v", s.index, err)
+		}
+		s.buf = data
+	}
+	s.index++
+	return nil
+}
+
+// ctxWriter stops writing once the context is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// ctxReader stops reading once the context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// DirTarget stores the backups as files of a directory.
+type DirTarget struct {
+	Dir string
+}
+
+var _ BackupTarget = DirTarget{}
+
+// Create writes the backup to a temporary file, renamed once it's closed.
+func (t DirTarget) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(t.Dir, 0700); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	file, err := os.CreateTemp(t.Dir, ".tmp-*")
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	return &dirTargetFile{File: file, path: filepath.Join(t.Dir, name)}, nil
+}
+
+func (t DirTarget) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(t.Dir, name))
+	return file, errs.Wrap(err)
+}
+
+func (t DirTarget) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(t.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".tmp-") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t DirTarget) Delete(ctx context.Context, name string) error {
+	return errs.Wrap(os.Remove(filepath.Join(t.Dir, name)))
+}
+
+type dirTargetFile struct {
+	*os.File
+	path string
+}
+
+func (f *dirTargetFile) Close() error {
+	err := errs.Combine(f.File.Sync(), f.File.Close())
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		return errs.Combine(err, os.Remove(f.File.Name()))
+	}
+	return nil
+}
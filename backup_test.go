@@ -0,0 +1,135 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"storj.io/common/testcontext"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+		target := DirTarget{Dir: ctx.Dir("backups")}
+
+		require.NoError(t, save(ctx, store, ref("ns", "key1"), "first"))
+		require.NoError(t, save(ctx, store, ref("ns", "key2"), "second"))
+		full, err := store.Backup(ctx, target, 0)
+		require.NoError(t, err)
+		require.NotZero(t, full.Version)
+
+		require.NoError(t, save(ctx, store, ref("ns", "key3"), "third"))
+		require.NoError(t, store.Delete(ctx, ref("ns", "key1")))
+		incremental, err := store.Backup(ctx, target, full.Version)
+		require.NoError(t, err)
+		require.Greater(t, incremental.Version, full.Version)
+
+		manifests, err := Backups(ctx, target)
+		require.NoError(t, err)
+		require.Len(t, manifests, 2)
+		require.Equal(t, full, manifests[0].BackupInfo)
+		require.Equal(t, incremental, manifests[1].BackupInfo)
+		require.False(t, manifests[0].Encrypted)
+		require.NotEmpty(t, manifests[0].Segments)
+		require.Contains(t, string(manifests[0].Meta), "layout_version")
+
+		// incremental backups can't be restored without the full one
+		restored, err := NewBlobStoreWithConfig(ctx.Dir("restored"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(restored.Close)
+		require.Error(t, restored.Restore(ctx, target, []string{incremental.Name}))
+
+		require.NoError(t, restored.Restore(ctx, target, []string{full.Name, incremental.Name}))
+		requireRestored(t, ctx, restored)
+
+		// the restored store accepts new writes
+		require.NoError(t, save(ctx, restored, ref("ns", "key4"), "fourth"))
+
+		require.NoError(t, target.Delete(ctx, full.Name))
+		require.NoError(t, target.Delete(ctx, full.Name+manifestSuffix))
+		names, err := target.List(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{incremental.Name, incremental.Name + manifestSuffix}, names)
+	})
+}
+
+func TestBackupEncryption(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		defer func(size int) { backupSegmentSize = size }(backupSegmentSize)
+		backupSegmentSize = 64
+
+		key := strings.Repeat("ab", 32)
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine, BackupKey: key})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+		target := DirTarget{Dir: ctx.Dir("backups")}
+
+		require.NoError(t, save(ctx, store, ref("ns", "key2"), "second"))
+		require.NoError(t, save(ctx, store, ref("ns", "key3"), "third"))
+		info, err := store.Backup(ctx, target, 0)
+		require.NoError(t, err)
+
+		manifests, err := Backups(ctx, target)
+		require.NoError(t, err)
+		require.Len(t, manifests, 1)
+		require.True(t, manifests[0].Encrypted)
+		require.Greater(t, len(manifests[0].Segments), 1)
+
+		data, err := os.ReadFile(filepath.Join(target.Dir, info.Name))
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "second")
+
+		// the key is needed to restore
+		plain, err := NewBlobStoreWithConfig(ctx.Dir("plain"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(plain.Close)
+		require.Error(t, plain.Restore(ctx, target, []string{info.Name}))
+
+		_, err = NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{Engine: engine, BackupKey: "abcd"})
+		require.True(t, ErrBackup.Has(err))
+
+		// corrupted backups are rejected before anything is loaded
+		corrupted := append([]byte(nil), data...)
+		corrupted[len(corrupted)-1] ^= 1
+		require.NoError(t, os.WriteFile(filepath.Join(target.Dir, info.Name), corrupted, 0600))
+		restored, err := NewBlobStoreWithConfig(ctx.Dir("restored"), Config{Engine: engine, BackupKey: key})
+		require.NoError(t, err)
+		defer ctx.Check(restored.Close)
+		err = restored.Restore(ctx, target, []string{info.Name})
+		require.ErrorContains(t, err, "checksum mismatch")
+		used, err := restored.SpaceUsedForBlobs(ctx)
+		require.NoError(t, err)
+		require.Zero(t, used)
+
+		require.NoError(t, os.WriteFile(filepath.Join(target.Dir, info.Name), data, 0600))
+		require.NoError(t, restored.Restore(ctx, target, []string{info.Name}))
+		requireRestored(t, ctx, restored)
+	})
+}
+
+func requireRestored(t *testing.T, ctx *testcontext.Context, store *BlobStore) {
+	for key, expected := range map[string]string{"key2": "second", "key3": "third"} {
+		reader, err := store.Open(ctx, ref("ns", key))
+		require.NoError(t, err)
+		content, err := rall(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, expected, string(content))
+	}
+	_, err := store.Open(ctx, ref("ns", "key1"))
+	require.True(t, ErrNotExist.Has(err))
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("second")+len("third")), used)
+}
@@ -0,0 +1,117 @@
+package badger
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// SetBackupTarget replaces the target of the scheduled backups, a directory
+// target of BackupDir by default.
+func (b *BlobStore) SetBackupTarget(target BackupTarget) {
+	b.backupMu.Lock()
+	defer b.backupMu.Unlock()
+	b.backupTarget = target
+}
+
+// LastBackup returns the time of the last successful scheduled backup, zero
+// before the first one.
+func (b *BlobStore) LastBackup() time.Time {
+	b.backupMu.Lock()
+	defer b.backupMu.Unlock()
+	return b.lastBackup
+}
+
+func (b *BlobStore) startScheduledBackups() {
+	interval := b.config.BackupInterval
+	if interval <= 0 {
+		return
+	}
+	if b.config.BackupDir != "" {
+		b.backupTarget = DirTarget{Dir: b.config.BackupDir}
+	}
+	b.startJob(ChoreBackup, interval, func(ctx context.Context) error {
+		b.backupMu.Lock()
+		target := b.backupTarget
+		b.backupMu.Unlock()
+		if target == nil {
+			b.log.Warn("scheduled backups are enabled without a target")
+			return nil
+		}
+		info, err := b.scheduledBackup(ctx, target)
+		if err != nil {
+			if ctx.Err() == nil {
+				mon.Counter("scheduled_backup_failures").Inc(1)
+			}
+			return err
+		}
+		b.backupMu.Lock()
+		b.lastBackup = info.Time
+		b.backupMu.Unlock()
+		mon.IntVal("backup_last_success").Observe(info.Time.Unix())
+		b.log.Info("backed up the store", zap.String("backup", info.Name), zap.Uint64("version", info.Version))
+		return nil
+	})
+}
+
+// scheduledBackup continues the latest backup chain with an incremental
+// backup, or starts a new chain with a full one after BackupIncrementals
+// incremental backups. The chains beyond BackupRetention are pruned.
+func (b *BlobStore) scheduledBackup(ctx context.Context, target BackupTarget) (info BackupInfo, err error) {
+	manifests, err := Backups(ctx, target)
+	if err != nil {
+		return info, err
+	}
+	var since uint64
+	if chain := latestChain(manifests); len(chain) > 0 && len(chain) <= b.config.BackupIncrementals {
+		since = chain[len(chain)-1].Version
+	}
+	info, err = b.Backup(ctx, target, since)
+	if err != nil {
+		return info, err
+	}
+	if b.config.BackupRetention > 0 {
+		manifests, err = Backups(ctx, target)
+		if err != nil {
+			return info, err
+		}
+		return info, pruneBackups(ctx, target, manifests, b.config.BackupRetention)
+	}
+	return info, nil
+}
+
+// latestChain returns the last full backup with the incremental backups
+// made on top of it.
+func latestChain(manifests []BackupManifest) []BackupManifest {
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if manifests[i].Since == 0 {
+			return manifests[i:]
+		}
+	}
+	return nil
+}
+
+// pruneBackups deletes the backups before the last keep full backups. The
+// manifests are deleted first, so interrupted deletions leave incomplete
+// backups only.
+func pruneBackups(ctx context.Context, target BackupTarget, manifests []BackupManifest, keep int) error {
+	var fulls []int
+	for i, manifest := range manifests {
+		if manifest.Since == 0 {
+			fulls = append(fulls, i)
+		}
+	}
+	if len(fulls) <= keep {
+		return nil
+	}
+	for _, manifest := range manifests[:fulls[len(fulls)-keep]] {
+		if err := target.Delete(ctx, manifest.Name+manifestSuffix); err != nil {
+			return ErrBackup.Wrap(err)
+		}
+		if err := target.Delete(ctx, manifest.Name); err != nil {
+			return ErrBackup.Wrap(err)
+		}
+		mon.Counter("backups_pruned").Inc(1)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestScheduledBackupRetention(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{BackupIncrementals: 1, BackupRetention: 2})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	target := DirTarget{Dir: ctx.Dir("backups")}
+
+	var infos []BackupInfo
+	for i := 0; i < 5; i++ {
+		require.NoError(t, save(ctx, store, ref("ns", "key"+string(rune('0'+i))), "data"))
+		info, err := store.scheduledBackup(ctx, target)
+		require.NoError(t, err)
+		infos = append(infos, info)
+	}
+	for i, info := range infos {
+		require.Equal(t, i%2 == 0, info.Since == 0, i)
+	}
+
+	// the first chain is pruned, the last one has only the full backup yet
+	manifests, err := Backups(ctx, target)
+	require.NoError(t, err)
+	var names []string
+	for _, manifest := range manifests {
+		names = append(names, manifest.Name)
+	}
+	require.Equal(t, []string{infos[2].Name, infos[3].Name, infos[4].Name}, names)
+	listed, err := target.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 6)
+
+	restored, err := NewBlobStore(ctx.Dir("restored"))
+	require.NoError(t, err)
+	defer ctx.Check(restored.Close)
+	require.NoError(t, restored.Restore(ctx, target, []string{infos[4].Name}))
+	used, err := restored.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5*len("data")), used)
+}
+
+func TestScheduledBackups(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{
+		BackupInterval: 10 * time.Millisecond,
+		BackupDir:      ctx.Dir("backups"),
+	})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+
+	require.Eventually(t, func() bool { return !store.LastBackup().IsZero() }, 5*time.Second, 10*time.Millisecond)
+	manifests, err := Backups(ctx, DirTarget{Dir: ctx.Dir("backups")})
+	require.NoError(t, err)
+	require.NotEmpty(t, manifests)
+}
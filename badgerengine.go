@@ -0,0 +1,240 @@
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
+	"io"
+	"math"
+	"storj.io/common/memory"
+	"strconv"
+	"strings"
+)
+
+// badgerEngine is the engine of the stores kept in badger.
+type badgerEngine struct {
+	db *badger.DB
+}
+
+// openBadger opens the badger database of the store after validating the
+// badger settings of the config.
+func openBadger(dir string, config Config, verifyValues bool) (kvEngine, error) {
+	options, err := badgerOptions(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCompaction(options); err != nil {
+		return nil, err
+	}
+	if err := checkMemory(config, options); err != nil {
+		return nil, err
+	}
+	if err := checkValueLog(options); err != nil {
+		return nil, err
+	}
+	options.VerifyValueChecksum = verifyValues
+	db, err := openDB(options, config.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return badgerEngine{db: db}, nil
+}
+
+// badgerDB returns the badger database of the engine for the features
+// specific to badger, like the value log GC and the LSM tree stats, or nil
+// for the other engines.
+func badgerDB(engine kvEngine) *badger.DB {
+	if e, ok := engine.(badgerEngine); ok {
+		return e.db
+	}
+	return nil
+}
+
+// levels returns the levels of the LSM tree, none for the engines without one.
+func levels(engine kvEngine) []badger.LevelInfo {
+	if db := badgerDB(engine); db != nil {
+		return db.Levels()
+	}
+	return nil
+}
+
+// level0Tables returns the number of tables in level 0 of the LSM tree, 0
+// for the engines without one.
+func level0Tables(engine kvEngine) int {
+	if db := badgerDB(engine); db != nil {
+		return db.Levels()[0].NumTables
+	}
+	return 0
+}
+
+func (e badgerEngine) View(fn func(txn kvTxn) error) error {
+	return e.db.View(func(txn *badger.Txn) error { return fn(badgerTxn{txn}) })
+}
+
+func (e badgerEngine) Update(fn func(txn kvTxn) error) error {
+	return e.db.Update(func(txn *badger.Txn) error { return fn(badgerTxn{txn}) })
+}
+
+func (e badgerEngine) NewTransaction(update bool) kvTxn {
+	return badgerTxn{e.db.NewTransaction(update)}
+}
+
+func (e badgerEngine) MaxBatchCount() int64 { return e.db.MaxBatchCount() }
+
+// MaxValueSize is the size of a value log file, which holds whole values.
+func (e badgerEngine) MaxValueSize() int64 { return e.db.Opts().ValueLogFileSize }
+
+func (e badgerEngine) Size() (keys, values int64) { return e.db.Size() }
+
+func (e badgerEngine) Backup(w io.Writer, since uint64) (uint64, error) {
+	return e.db.Backup(w, since)
+}
+
+func (e badgerEngine) Load(r io.Reader) error { return e.db.Load(r, 256) }
+
+func (e badgerEngine) Close() error { return e.db.Close() }
+
+// badgerTxn, badgerItem and badgerIterator adapt the badger types to the
+// engine interfaces.
+type badgerTxn struct{ *badger.Txn }
+
+type badgerItem struct{ *badger.Item }
+
+type badgerIterator struct{ *badger.Iterator }
+
+func (t badgerTxn) Get(key []byte) (kvItem, error) {
+	item, err := t.Txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return badgerItem{item}, nil
+}
+
+func (t badgerTxn) SetWithMeta(key, value []byte, userMeta byte) error {
+	return t.Txn.SetEntry(badger.NewEntry(key, value).WithMeta(userMeta))
+}
+
+func (t badgerTxn) NewIterator(options kvIteratorOptions) kvIterator {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = options.Prefix
+	opts.Reverse = options.Reverse
+	opts.PrefetchValues = options.PrefetchValues
+	if options.PrefetchSize > 0 {
+		opts.PrefetchSize = options.PrefetchSize
+	}
+	return badgerIterator{t.Txn.NewIterator(opts)}
+}
+
+func (it badgerIterator) Item() kvItem { return badgerItem{it.Iterator.Item()} }
+
+// badgerOptions returns the badger options of the config.
+func badgerOptions(dir string, config Config) (badger.Options, error) {
+	options := badger.DefaultOptions(dir)
+	options.ValueThreshold = 10
+	if config.ValueLogFileSize > 0 {
+		options.ValueLogFileSize = config.ValueLogFileSize.Int64()
+	}
+	if config.ValueLogMaxEntries > 0 {
+		options.ValueLogMaxEntries = uint32(config.ValueLogMaxEntries)
+	}
+	if config.Compactors > 0 {
+		options.NumCompactors = config.Compactors
+	}
+	if config.LevelBaseSize > 0 {
+		options.BaseLevelSize = config.LevelBaseSize.Int64()
+	}
+	if config.LevelSizeMultiplier > 0 {
+		options.LevelSizeMultiplier = config.LevelSizeMultiplier
+	}
+	if config.Level0Tables > 0 {
+		options.NumLevelZeroTables = config.Level0Tables
+	}
+	if config.Level0TablesStall > 0 {
+		options.NumLevelZeroTablesStall = config.Level0TablesStall
+	}
+	if config.MemTables > 0 {
+		options.NumMemtables = config.MemTables
+	}
+	if config.MemoryBudget > 0 {
+		options.MemTableSize = config.MemoryBudget.Int64() / 2 / int64(options.NumMemtables)
+		options.BlockCacheSize = config.MemoryBudget.Int64() / 2
+	}
+	if config.MemTableSize > 0 {
+		options.MemTableSize = config.MemTableSize.Int64()
+	}
+	if config.BloomBitsPerKey > 0 {
+		options.BloomFalsePositive = bloomFalsePositive(config.BloomBitsPerKey)
+	}
+	if config.TableCompression != "" {
+		compression, level, err := parseTableCompression(config.TableCompression)
+		if err != nil {
+			return options, err
+		}
+		options.Compression, options.ZSTDCompressionLevel = compression, level
+	}
+	return options, nil
+}
+
+// parseTableCompression parses the compression of the LSM table blocks:
+// none, snappy, zstd or zstd:<level>.
+func parseTableCompression(spec string) (compression options.CompressionType, level int, err error) {
+	name, rawLevel, hasLevel := strings.Cut(spec, ":")
+	switch name {
+	case "none":
+		compression = options.None
+	case "snappy":
+		compression = options.Snappy
+	case "zstd":
+		compression, level = options.ZSTD, 1
+	default:
+		return 0, 0, ErrConfig.New("unknown table compression %q", spec)
+	}
+	if !hasLevel {
+		return compression, level, nil
+	}
+	if compression != options.ZSTD {
+		return 0, 0, ErrConfig.New("only zstd has compression levels, got %q", spec)
+	}
+	level, err = strconv.Atoi(rawLevel)
+	if err != nil || level < 1 || level > 22 {
+		return 0, 0, ErrConfig.New("zstd level must be between 1 and 22, got %q", spec)
+	}
+	return compression, level, nil
+}
+
+// bloomFalsePositive returns the false positive rate of bloom filters with
+// the bits per key, which is how badger sizes them.
+func bloomFalsePositive(bitsPerKey int) float64 {
+	return math.Exp(-float64(bitsPerKey) * math.Ln2 * math.Ln2)
+}
+
+// minMemTableSize is the smallest memtable accepted, so the batches of the
+// commits still fit in them.
+const minMemTableSize = memory.MiB
+
+// checkMemory validates the memtable settings against the memory budget.
+func checkMemory(config Config, options badger.Options) error {
+	switch {
+	case options.NumMemtables < 2:
+		return ErrConfig.New("at least 2 memtables are needed, got %d", options.NumMemtables)
+	case options.MemTableSize < minMemTableSize.Int64():
+		return ErrConfig.New("memtable size %s is below %s", memory.Size(options.MemTableSize), minMemTableSize)
+	case config.MemoryBudget > 0 && options.MemTableSize*int64(options.NumMemtables) > config.MemoryBudget.Int64():
+		return ErrConfig.New("%d memtables of %s don't fit the memory budget of %s",
+			options.NumMemtables, memory.Size(options.MemTableSize), config.MemoryBudget)
+	}
+	return nil
+}
+
+// checkCompaction validates the compaction settings, which badger would
+// only reject by panicking or stalling forever.
+func checkCompaction(options badger.Options) error {
+	switch {
+	case options.NumCompactors < 2:
+		return ErrConfig.New("at least 2 compactors are needed, got %d", options.NumCompactors)
+	case options.LevelSizeMultiplier < 2:
+		return ErrConfig.New("level size multiplier must be at least 2, got %d", options.LevelSizeMultiplier)
+	case options.NumLevelZeroTablesStall <= options.NumLevelZeroTables:
+		return ErrConfig.New("level 0 stall at %d tables must be above the compaction start at %d", options.NumLevelZeroTablesStall, options.NumLevelZeroTables)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"sort"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/bandwidth"
+	"sync/atomic"
+	"time"
+)
+
+// bandwidthPrefix keys hold the hourly bandwidth counters, as hour (8 bytes
+// of Unix nanoseconds), satellite ID, action (4 bytes) and shard. Every
+// counter has usageShards shards, so concurrent transfers rarely update the
+// same key.
+var bandwidthPrefix = []byte("bwhou")
+
+// bandwidthRollupPrefix keys hold the daily counters the hourly ones of the
+// past days are compacted into, as day, satellite ID and action.
+var bandwidthRollupPrefix = []byte("bwday")
+
+// bandwidthBatchSize is the number of hourly counters rolled up in one
+// transaction.
+var bandwidthBatchSize = 1000
+
+// ErrBandwidth is returned for the corrupted bandwidth counters.
+var ErrBandwidth = errs.Class("bandwidth")
+
+// BandwidthDB keeps the bandwidth usage of the storagenode in the badger
+// store, as counters per satellite, action and hour. Rollup compacts the
+// hours of the past days into daily counters, after which the summaries of
+// those days have the precision of a day. It's the bandwidth.DB of the node,
+// and like the storagenode's bandwidth database, it's meant to be rolled up
+// periodically by its caller.
+type BandwidthDB struct {
+	db    kvEngine
+	clock Clock
+	shard atomic.Uint32
+
+	iterators iteratorProfiles
+}
+
+var _ bandwidth.DB = (*BandwidthDB)(nil)
+
+// Bandwidth returns the bandwidth usage kept in the store.
+func (b *BlobStore) Bandwidth() *BandwidthDB {
+	return &BandwidthDB{db: b.db, clock: b.clock, iterators: b.iterators}
+}
+
+// bandwidthCounter is the counter of a satellite and action in an hour or day.
+type bandwidthCounter struct {
+	start     int64
+	satellite storj.NodeID
+	action    pb.PieceAction
+}
+
+func (c bandwidthCounter) key(prefix []byte) []byte {
+	raw := binary.BigEndian.AppendUint64(nil, uint64(c.start))
+	raw = append(raw, c.satellite.Bytes()...)
+	raw = binary.BigEndian.AppendUint32(raw, uint32(c.action))
+	return concat(prefix, raw)
+}
+
+func bandwidthTime(prefix []byte, t time.Time) []byte {
+	return concat(prefix, binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())))
+}
+
+// bandwidthDay returns the start of the UTC day of t.
+func bandwidthDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func parseBandwidthKey(prefix []byte, key []byte) (c bandwidthCounter, err error) {
+	raw := key[len(prefix):]
+	size := 8 + len(storj.NodeID{}) + 4
+	if bytesEq(prefix, bandwidthPrefix) {
+		size++
+	}
+	if len(raw) != size {
+		return c, ErrBandwidth.New("invalid key %x", key)
+	}
+	c.start = int64(binary.BigEndian.Uint64(raw))
+	copy(c.satellite[:], raw[8:])
+	c.action = pb.PieceAction(binary.BigEndian.Uint32(raw[8+len(storj.NodeID{}):]))
+	return c, nil
+}
+
+func readBandwidth(txn kvTxn, key []byte) (amount int64, err error) {
+	item, err := txn.Get(key)
+	if errs.Is(err, errKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		amount, err = decodeBandwidth(val)
+		return err
+	})
+	return amount, err
+}
+
+func decodeBandwidth(val []byte) (int64, error) {
+	if len(val) != 8 {
+		return 0, ErrBandwidth.New("counter has %d bytes", len(val))
+	}
+	return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+// Add adds the amount of bandwidth used by the action of the satellite at
+// created.
+func (d *BandwidthDB) Add(ctx context.Context, satelliteID storj.NodeID, action pb.PieceAction, amount int64, created time.Time) error {
+	return update(d.db, func(txn kvTxn) error {
+		return d.add(txn, satelliteID, action, amount, created)
+	})
+}
+
+// AddBatch adds the bandwidth used by the satellites at the times of the
+// keys, in one transaction.
+func (d *BandwidthDB) AddBatch(ctx context.Context, usages map[bandwidth.CacheKey]*bandwidth.Usage) error {
+	return update(d.db, func(txn kvTxn) error {
+		for key, usage := range usages {
+			amounts := map[pb.PieceAction]int64{
+				pb.PieceAction_INVALID:    usage.Invalid,
+				pb.PieceAction_PUT:        usage.Put,
+				pb.PieceAction_GET:        usage.Get,
+				pb.PieceAction_GET_AUDIT:  usage.GetAudit,
+				pb.PieceAction_GET_REPAIR: usage.GetRepair,
+				pb.PieceAction_PUT_REPAIR: usage.PutRepair,
+				pb.PieceAction_DELETE:     usage.Delete,
+			}
+			for action, amount := range amounts {
+				if amount == 0 {
+					continue
+				}
+				if err := d.add(txn, key.SatelliteID, action, amount, key.CreatedAt); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (d *BandwidthDB) add(txn kvTxn, satelliteID storj.NodeID, action pb.PieceAction, amount int64, created time.Time) error {
+	counter := bandwidthCounter{start: created.Truncate(time.Hour).UnixNano(), satellite: satelliteID, action: action}
+	key := concat(counter.key(bandwidthPrefix), []byte{byte(d.shard.Add(1) % usageShards)})
+	current, err := readBandwidth(txn, key)
+	if err != nil {
+		return err
+	}
+	return txn.Set(key, binary.BigEndian.AppendUint64(nil, uint64(current+amount)))
+}
+
+// Summary returns the bandwidth used between from and to, including the
+// counters whose hour, or day once rolled up, starts in [from, to).
+func (d *BandwidthDB) Summary(ctx context.Context, from, to time.Time) (*bandwidth.Usage, error) {
+	usage := &bandwidth.Usage{}
+	err := d.walk(ctx, from, to, func(c bandwidthCounter, amount int64) {
+		usage.Include(c.action, amount)
+	})
+	return usage, err
+}
+
+// SatelliteSummary returns the bandwidth used for the satellite between from
+// and to, see Summary.
+func (d *BandwidthDB) SatelliteSummary(ctx context.Context, satelliteID storj.NodeID, from, to time.Time) (*bandwidth.Usage, error) {
+	usage := &bandwidth.Usage{}
+	err := d.walk(ctx, from, to, func(c bandwidthCounter, amount int64) {
+		if c.satellite == satelliteID {
+			usage.Include(c.action, amount)
+		}
+	})
+	return usage, err
+}
+
+// SummaryBySatellite returns the bandwidth used between from and to by
+// satellite, see Summary.
+func (d *BandwidthDB) SummaryBySatellite(ctx context.Context, from, to time.Time) (map[storj.NodeID]*bandwidth.Usage, error) {
+	usages := map[storj.NodeID]*bandwidth.Usage{}
+	err := d.walk(ctx, from, to, func(c bandwidthCounter, amount int64) {
+		usage, ok := usages[c.satellite]
+		if !ok {
+			usage = &bandwidth.Usage{}
+			usages[c.satellite] = usage
+		}
+		usage.Include(c.action, amount)
+	})
+	return usages, err
+}
+
+// EgressSummary returns the bandwidth sent between from and to, see Summary.
+func (d *BandwidthDB) EgressSummary(ctx context.Context, from, to time.Time) (*bandwidth.Usage, error) {
+	usage, err := d.Summary(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return usage.Egress(), nil
+}
+
+// IngressSummary returns the bandwidth received between from and to, see
+// Summary.
+func (d *BandwidthDB) IngressSummary(ctx context.Context, from, to time.Time) (*bandwidth.Usage, error) {
+	usage, err := d.Summary(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return usage.Ingress(), nil
+}
+
+// SatelliteEgressSummary returns the bandwidth sent for the satellite
+// between from and to, see Summary.
+func (d *BandwidthDB) SatelliteEgressSummary(ctx context.Context, satelliteID storj.NodeID, from, to time.Time) (*bandwidth.Usage, error) {
+	usage, err := d.SatelliteSummary(ctx, satelliteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return usage.Egress(), nil
+}
+
+// SatelliteIngressSummary returns the bandwidth received for the satellite
+// between from and to, see Summary.
+func (d *BandwidthDB) SatelliteIngressSummary(ctx context.Context, satelliteID storj.NodeID, from, to time.Time) (*bandwidth.Usage, error) {
+	usage, err := d.SatelliteSummary(ctx, satelliteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return usage.Ingress(), nil
+}
+
+// GetDailyRollups returns the bandwidth used in the UTC days from the day of
+// from to the day of to, in the order of the days. The days without
+// bandwidth used are left out.
+func (d *BandwidthDB) GetDailyRollups(ctx context.Context, from, to time.Time) ([]bandwidth.UsageRollup, error) {
+	return d.dailyRollups(ctx, from, to, func(storj.NodeID) bool { return true })
+}
+
+// GetDailySatelliteRollups returns the bandwidth used for the satellite in the
+// UTC days from the day of from to the day of to, see GetDailyRollups.
+func (d *BandwidthDB) GetDailySatelliteRollups(ctx context.Context, satelliteID storj.NodeID, from, to time.Time) ([]bandwidth.UsageRollup, error) {
+	return d.dailyRollups(ctx, from, to, func(satellite storj.NodeID) bool { return satellite == satelliteID })
+}
+
+func (d *BandwidthDB) dailyRollups(ctx context.Context, from, to time.Time, include func(storj.NodeID) bool) ([]bandwidth.UsageRollup, error) {
+	days := map[int64]*bandwidth.Usage{}
+	err := d.walk(ctx, bandwidthDay(from), bandwidthDay(to).AddDate(0, 0, 1), func(c bandwidthCounter, amount int64) {
+		if !include(c.satellite) {
+			return
+		}
+		day := bandwidthDay(time.Unix(0, c.start)).UnixNano()
+		usage, ok := days[day]
+		if !ok {
+			usage = &bandwidth.Usage{}
+			days[day] = usage
+		}
+		usage.Include(c.action, amount)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rollups := make([]bandwidth.UsageRollup, 0, len(days))
+	for day, usage := range days {
+		rollups = append(rollups, *usage.Rollup(time.Unix(0, day).UTC()))
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].IntervalStart.Before(rollups[j].IntervalStart)
+	})
+	return rollups, nil
+}
+
+// MonthSummary returns the bandwidth used in the UTC month of now.
+func (d *BandwidthDB) MonthSummary(ctx context.Context, now time.Time) (int64, error) {
+	year, month, _ := now.UTC().Date()
+	from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	usage, err := d.Summary(ctx, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		return 0, err
+	}
+	return usage.Total(), nil
+}
+
+// walk calls fn for the hourly and daily counters starting in [from, to).
+func (d *BandwidthDB) walk(ctx context.Context, from, to time.Time, fn func(c bandwidthCounter, amount int64)) error {
+	return view(d.db, func(txn kvTxn) error {
+		for _, prefix := range [][]byte{bandwidthRollupPrefix, bandwidthPrefix} {
+			end := bandwidthTime(prefix, to)
+			it := txn.NewIterator(d.iterators.options(IteratorRecords, prefix))
+			for it.Seek(bandwidthTime(prefix, from)); it.Valid(); it.Next() {
+				if err := ctx.Err(); err != nil {
+					it.Close()
+					return err
+				}
+				key := it.Item().Key()
+				if string(key) >= string(end) {
+					break
+				}
+				c, err := parseBandwidthKey(prefix, key)
+				if err != nil {
+					it.Close()
+					return err
+				}
+				var amount int64
+				err = it.Item().Value(func(val []byte) error {
+					amount, err = decodeBandwidth(val)
+					return err
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+				fn(c, amount)
+			}
+			it.Close()
+		}
+		return nil
+	})
+}
+
+// Rollup compacts the hourly counters of the days before the current UTC day
+// into daily counters, and returns the number of hourly counters removed.
+func (d *BandwidthDB) Rollup(ctx context.Context) (numberOfRollups int64, err error) {
+	end := bandwidthTime(bandwidthPrefix, bandwidthDay(d.clock.Now()))
+	for {
+		if err := ctx.Err(); err != nil {
+			return numberOfRollups, err
+		}
+		rolled := 0
+		err := update(d.db, func(txn kvTxn) error {
+			rolled = 0
+			daily := map[bandwidthCounter]int64{}
+			it := txn.NewIterator(d.iterators.options(IteratorRecords, bandwidthPrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && rolled < bandwidthBatchSize; it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if string(key) >= string(end) {
+					break
+				}
+				c, err := parseBandwidthKey(bandwidthPrefix, key)
+				if err != nil {
+					return err
+				}
+				amount, err := readBandwidth(txn, key)
+				if err != nil {
+					return err
+				}
+				c.start = bandwidthDay(time.Unix(0, c.start)).UnixNano()
+				daily[c] += amount
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				rolled++
+			}
+			for c, amount := range daily {
+				key := c.key(bandwidthRollupPrefix)
+				current, err := readBandwidth(txn, key)
+				if err != nil {
+					return err
+				}
+				if err := txn.Set(key, binary.BigEndian.AppendUint64(nil, uint64(current+amount))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return numberOfRollups, err
+		}
+		numberOfRollups += int64(rolled)
+		if rolled < bandwidthBatchSize {
+			return numberOfRollups, nil
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/bandwidth"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidth(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { bandwidthBatchSize = size }(bandwidthBatchSize)
+	bandwidthBatchSize = 2
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	now := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	store.SetClock(fixedClock(now))
+	db := store.Bandwidth()
+
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	yesterday := now.AddDate(0, 0, -1)
+
+	// concurrent additions to the same counter are all counted
+	var group sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			require.NoError(t, db.Add(ctx, satellite1, pb.PieceAction_GET, 100, now))
+		}()
+	}
+	group.Wait()
+	require.NoError(t, db.Add(ctx, satellite1, pb.PieceAction_PUT, 50, now.Add(-time.Hour)))
+	require.NoError(t, db.Add(ctx, satellite2, pb.PieceAction_GET_AUDIT, 7, yesterday))
+	require.NoError(t, db.Add(ctx, satellite2, pb.PieceAction_GET_AUDIT, 3, yesterday.Add(-2*time.Hour)))
+	require.NoError(t, db.Add(ctx, satellite1, pb.PieceAction_DELETE, 1, now.AddDate(0, -1, 0)))
+
+	check := func() {
+		usage, err := db.Summary(ctx, bandwidthDay(yesterday), now.Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, &bandwidth.Usage{Put: 50, Get: 1000, GetAudit: 10}, usage)
+
+		usage, err = db.SatelliteSummary(ctx, satellite2, bandwidthDay(yesterday), now)
+		require.NoError(t, err)
+		require.Equal(t, &bandwidth.Usage{GetAudit: 10}, usage)
+
+		usages, err := db.SummaryBySatellite(ctx, bandwidthDay(now), now.Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, map[storj.NodeID]*bandwidth.Usage{satellite1: {Put: 50, Get: 1000}}, usages)
+
+		total, err := db.MonthSummary(ctx, now)
+		require.NoError(t, err)
+		require.EqualValues(t, 1060, total)
+	}
+	check()
+
+	// the hours of the past days are compacted, the ones of today are kept
+	rollups, err := db.Rollup(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, rollups)
+	check()
+
+	rollups, err = db.Rollup(ctx)
+	require.NoError(t, err)
+	require.Zero(t, rollups)
+
+	// additions to a rolled up day are compacted into the same counter
+	require.NoError(t, db.Add(ctx, satellite2, pb.PieceAction_GET_AUDIT, 5, yesterday))
+	rollups, err = db.Rollup(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, rollups)
+	usage, err := db.SatelliteSummary(ctx, satellite2, bandwidthDay(yesterday), now)
+	require.NoError(t, err)
+	require.Equal(t, &bandwidth.Usage{GetAudit: 15}, usage)
+
+	// the batches of the bandwidth cache are added to the counters
+	require.NoError(t, db.AddBatch(ctx, map[bandwidth.CacheKey]*bandwidth.Usage{
+		{SatelliteID: satellite1, CreatedAt: now}:       {Put: 20, GetRepair: 4},
+		{SatelliteID: satellite2, CreatedAt: yesterday}: {PutRepair: 6, Delete: 2},
+	}))
+	usage, err = db.EgressSummary(ctx, bandwidthDay(yesterday), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, &bandwidth.Usage{Get: 1000, GetAudit: 15, GetRepair: 4}, usage)
+	usage, err = db.SatelliteIngressSummary(ctx, satellite2, bandwidthDay(yesterday), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, &bandwidth.Usage{PutRepair: 6}, usage)
+
+	daily, err := db.GetDailyRollups(ctx, yesterday, now)
+	require.NoError(t, err)
+	require.Equal(t, []bandwidth.UsageRollup{
+		{Egress: bandwidth.Egress{Audit: 15}, Ingress: bandwidth.Ingress{Repair: 6}, Delete: 2, IntervalStart: bandwidthDay(yesterday)},
+		{Egress: bandwidth.Egress{Usage: 1000, Repair: 4}, Ingress: bandwidth.Ingress{Usage: 70}, IntervalStart: bandwidthDay(now)},
+	}, daily)
+	daily, err = db.GetDailySatelliteRollups(ctx, satellite2, now, now)
+	require.NoError(t, err)
+	require.Empty(t, daily)
+}
@@ -0,0 +1,280 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"github.com/zeebo/errs"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrBenchmark is returned for invalid benchmark workloads and the failures
+// of the benchmarked stores.
+var ErrBenchmark = errs.Class("benchmark")
+
+// BenchmarkWorkload is a synthetic piece workload, run the same way against
+// every store compared.
+type BenchmarkWorkload struct {
+	// Pieces of PieceSize bytes are uploaded by Concurrency uploaders.
+	Pieces      int
+	PieceSize   int
+	Concurrency int
+	// Reads is the number of downloads of random pieces.
+	Reads int
+	// Seed makes the pieces and the reads the same in every run.
+	Seed int64
+}
+
+// BenchmarkOperation are the measurements of an operation of a benchmark.
+type BenchmarkOperation struct {
+	Count    int
+	Bytes    int64
+	Duration time.Duration
+	// P50, P90, P99 and Max are the latency percentiles.
+	P50, P90, P99, Max time.Duration
+}
+
+// OpsPerSecond returns the throughput in operations.
+func (o BenchmarkOperation) OpsPerSecond() float64 {
+	if o.Duration <= 0 {
+		return 0
+	}
+	return float64(o.Count) / o.Duration.Seconds()
+}
+
+// BytesPerSecond returns the throughput in bytes.
+func (o BenchmarkOperation) BytesPerSecond() float64 {
+	if o.Duration <= 0 {
+		return 0
+	}
+	return float64(o.Bytes) / o.Duration.Seconds()
+}
+
+// BenchmarkResult is the result of a workload on a store.
+type BenchmarkResult struct {
+	Name     string
+	Upload   BenchmarkOperation
+	Stat     BenchmarkOperation
+	Download BenchmarkOperation
+	Walk     BenchmarkOperation
+	Delete   BenchmarkOperation
+	// DataBytes are the bytes of the pieces, DiskBytes the space allocated
+	// in the directory of the store after uploading them.
+	DataBytes int64
+	DiskBytes int64
+}
+
+// SpaceOverhead returns the disk space used per byte of piece data.
+func (r BenchmarkResult) SpaceOverhead() float64 {
+	if r.DataBytes == 0 {
+		return 0
+	}
+	return float64(r.DiskBytes) / float64(r.DataBytes)
+}
+
+// RunBenchmark runs the workload against the store kept in dir: it uploads
+// the pieces, stats them, downloads random ones, walks them and deletes them
+// all, so the store is left as it was.
+func RunBenchmark(ctx context.Context, name string, store blobstore.Blobs, dir string, workload BenchmarkWorkload) (result BenchmarkResult, err error) {
+	if workload.Pieces <= 0 || workload.PieceSize <= 0 {
+		return result, ErrBenchmark.New("no pieces to upload")
+	}
+	if workload.Concurrency <= 0 {
+		workload.Concurrency = 1
+	}
+	result.Name = name
+	random := rand.New(rand.NewSource(workload.Seed))
+	namespace := make([]byte, 32)
+	_, _ = random.Read(namespace)
+	refs := make([]blobstore.BlobRef, workload.Pieces)
+	for i := range refs {
+		key := make([]byte, 32)
+		_, _ = random.Read(key)
+		refs[i] = blobstore.BlobRef{Namespace: namespace, Key: key}
+	}
+	data := make([]byte, workload.PieceSize)
+	_, _ = random.Read(data)
+
+	result.Upload, err = benchmarkOperation(ctx, workload.Concurrency, len(refs), func(i int) (int64, error) {
+		w, err := store.Create(ctx, refs[i])
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return 0, errs.Combine(err, w.Cancel(ctx))
+		}
+		return int64(len(data)), w.Commit(ctx)
+	})
+	if err != nil {
+		return result, ErrBenchmark.New("%s upload: %v", name, err)
+	}
+	result.DataBytes = result.Upload.Bytes
+	result.DiskBytes, err = diskUsage(dir)
+	if err != nil {
+		return result, ErrBenchmark.Wrap(err)
+	}
+
+	result.Stat, err = benchmarkOperation(ctx, workload.Concurrency, len(refs), func(i int) (int64, error) {
+		_, err := store.Stat(ctx, refs[i])
+		return 0, err
+	})
+	if err != nil {
+		return result, ErrBenchmark.New("%s stat: %v", name, err)
+	}
+
+	reads := make([]int, workload.Reads)
+	for i := range reads {
+		reads[i] = random.Intn(len(refs))
+	}
+	result.Download, err = benchmarkOperation(ctx, workload.Concurrency, len(reads), func(i int) (int64, error) {
+		r, err := store.Open(ctx, refs[reads[i]])
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.Copy(io.Discard, r)
+		return n, errs.Combine(err, r.Close())
+	})
+	if err != nil {
+		return result, ErrBenchmark.New("%s download: %v", name, err)
+	}
+
+	result.Walk, err = benchmarkOperation(ctx, 1, 1, func(int) (int64, error) {
+		walked := 0
+		err := store.WalkNamespace(ctx, namespace, "", func(blobstore.BlobInfo) error {
+			walked++
+			return nil
+		})
+		if err == nil && walked != len(refs) {
+			err = errs.New("walked %d pieces instead of %d", walked, len(refs))
+		}
+		return 0, err
+	})
+	if err != nil {
+		return result, ErrBenchmark.New("%s walk: %v", name, err)
+	}
+	result.Walk.Count = len(refs)
+
+	result.Delete, err = benchmarkOperation(ctx, workload.Concurrency, len(refs), func(i int) (int64, error) {
+		return 0, store.Delete(ctx, refs[i])
+	})
+	if err != nil {
+		return result, ErrBenchmark.New("%s delete: %v", name, err)
+	}
+	return result, nil
+}
+
+// benchmarkOperation runs op count times with the concurrency and measures
+// it. op returns the bytes it transferred.
+func benchmarkOperation(ctx context.Context, concurrency, count int, op func(i int) (int64, error)) (BenchmarkOperation, error) {
+	var mu sync.Mutex
+	var firstErr error
+	var total int64
+	latencies := make([]time.Duration, count)
+	next := make(chan int)
+	var group sync.WaitGroup
+	started := time.Now()
+	for worker := 0; worker < concurrency; worker++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for i := range next {
+				opStarted := time.Now()
+				n, err := op(i)
+				latencies[i] = time.Since(opStarted)
+				mu.Lock()
+				total += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	group.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	result := BenchmarkOperation{Count: count, Bytes: total, Duration: time.Since(started)}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if count > 0 {
+		percentile := func(p float64) time.Duration { return latencies[int(p*float64(count-1))] }
+		result.P50, result.P90, result.P99, result.Max = percentile(0.5), percentile(0.9), percentile(0.99), latencies[count-1]
+	}
+	return result, firstErr
+}
+
+// diskUsage returns the space allocated to the files in dir, which is less
+// than their size for sparse files like the preallocated badger files.
+func diskUsage(dir string) (total int64, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			total += stat.Blocks * 512
+		} else {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// WriteBenchmarkReport writes the results side by side.
+func WriteBenchmarkReport(w io.Writer, results ...BenchmarkResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	row := func(label string, value func(r BenchmarkResult) string) {
+		fmt.Fprintf(tw, "%s\t", label)
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t", value(r))
+		}
+		fmt.Fprintln(tw)
+	}
+	row("", func(r BenchmarkResult) string { return r.Name })
+	operations := []struct {
+		name string
+		get  func(r BenchmarkResult) BenchmarkOperation
+	}{
+		{"upload", func(r BenchmarkResult) BenchmarkOperation { return r.Upload }},
+		{"stat", func(r BenchmarkResult) BenchmarkOperation { return r.Stat }},
+		{"download", func(r BenchmarkResult) BenchmarkOperation { return r.Download }},
+		{"walk", func(r BenchmarkResult) BenchmarkOperation { return r.Walk }},
+		{"delete", func(r BenchmarkResult) BenchmarkOperation { return r.Delete }},
+	}
+	for _, op := range operations {
+		op := op
+		row(op.name+" ops/s", func(r BenchmarkResult) string { return fmt.Sprintf("%.0f", op.get(r).OpsPerSecond()) })
+		if op.name == "upload" || op.name == "download" {
+			row(op.name+" MB/s", func(r BenchmarkResult) string { return fmt.Sprintf("%.1f", op.get(r).BytesPerSecond()/1e6) })
+		}
+		if op.name == "walk" {
+			continue
+		}
+		row(op.name+" p50", func(r BenchmarkResult) string { return op.get(r).P50.Round(time.Microsecond).String() })
+		row(op.name+" p90", func(r BenchmarkResult) string { return op.get(r).P90.Round(time.Microsecond).String() })
+		row(op.name+" p99", func(r BenchmarkResult) string { return op.get(r).P99.Round(time.Microsecond).String() })
+		row(op.name+" max", func(r BenchmarkResult) string { return op.get(r).Max.Round(time.Microsecond).String() })
+	}
+	row("data bytes", func(r BenchmarkResult) string { return fmt.Sprint(r.DataBytes) })
+	row("disk bytes", func(r BenchmarkResult) string { return fmt.Sprint(r.DiskBytes) })
+	row("space overhead", func(r BenchmarkResult) string { return fmt.Sprintf("%.2fx", r.SpaceOverhead()) })
+	return tw.Flush()
+}
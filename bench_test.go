@@ -0,0 +1,50 @@
+package badger
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	workload := BenchmarkWorkload{Pieces: 20, PieceSize: 1000, Concurrency: 3, Reads: 10, Seed: 1}
+
+	files, err := filestore.NewAt(zap.NewNop(), ctx.Dir("files"), filestore.DefaultConfig)
+	require.NoError(t, err)
+	defer ctx.Check(files.Close)
+	fileResult, err := RunBenchmark(ctx, "filestore", files, ctx.Dir("files"), workload)
+	require.NoError(t, err)
+
+	store, err := NewBlobStore(ctx.Dir("badger"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	badgerResult, err := RunBenchmark(ctx, "badger", store, ctx.Dir("badger"), workload)
+	require.NoError(t, err)
+
+	for _, result := range []BenchmarkResult{fileResult, badgerResult} {
+		require.Equal(t, 20, result.Upload.Count)
+		require.EqualValues(t, 20000, result.DataBytes)
+		require.EqualValues(t, 10000, result.Download.Bytes)
+		require.Equal(t, 20, result.Delete.Count)
+		require.Positive(t, result.DiskBytes)
+		require.LessOrEqual(t, result.Upload.P50, result.Upload.Max)
+	}
+	// the pieces are deleted at the end
+	pieces, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Zero(t, pieces)
+
+	var report bytes.Buffer
+	require.NoError(t, WriteBenchmarkReport(&report, fileResult, badgerResult))
+	require.Contains(t, report.String(), "filestore")
+	require.Contains(t, report.String(), "space overhead")
+
+	_, err = RunBenchmark(ctx, "empty", store, ctx.Dir("badger"), BenchmarkWorkload{})
+	require.True(t, ErrBenchmark.Has(err))
+}
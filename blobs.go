@@ -3,304 +3,802 @@ package badger
 import (
 	"bytes"
 	"context"
+	"crypto/cipher"
 	"fmt"
-	"github.com/dgraph-io/badger/v4"
 	"github.com/pkg/errors"
 	"github.com/zeebo/errs"
+	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 	"os"
 	"path/filepath"
 	"storj.io/common/storj"
 	"storj.io/storj/storagenode/blobstore"
 	"storj.io/storj/storagenode/blobstore/filestore"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var verificationFileName = "storage-badger-verification"
 
+// spillDir holds the temporary files of uploads too large to buffer in memory.
+var spillDir = "uploads"
+
 type BlobStore struct {
-	db         *badger.DB
-	namespaces [][]byte
-	dir        string
+	log          *zap.Logger
+	db           kvEngine
+	namespaces   [][]byte
+	dir          string
+	config       Config
+	cache        *readCache
+	clock        Clock
+	backpressure *backpressure
+	breaker      *breaker
+	diskHealth   *diskHealth
+	spaceAlert   *spaceAlert
+	io           *ioStats
+	deletes      *deleteQueue
+	auditLog     *auditLog
+	retry        *RetryPolicy
+	hooks        hooks
+	readOnly     *readOnly
+	inflight     *inflightUploads
+	quotas       *quotas
+	rateLimits   *rateLimits
+	access       *accessTracker
+	iterators    iteratorProfiles
+	backupKey    cipher.AEAD
+
+	// nsMu serializes the changes of namespaces
+	nsMu sync.Mutex
+
+	// cold is the store the cold blobs are moved to, see SetColdStore
+	coldMu sync.Mutex
+	cold   blobstore.Blobs
+
+	backupMu     sync.Mutex
+	backupTarget BackupTarget
+	lastBackup   time.Time
+
+	// snapshots are the snapshots retained
+	snapshots snapshots
+
+	// chores are the last runs of the background chores
+	chores choreRuns
+
+	// jobs runs the background jobs and tasks
+	jobs *jobManager
+
+	// usageDone is closed when the usage counters are accurate, or computing
+	// them failed with usageErr
+	usageDone chan struct{}
+	usageErr  error
+
+	// approximateUsage is set until the usage counters of the namespaces
+	// changed before an unclean shutdown are reconciled
+	approximateUsage atomic.Bool
 }
 
 func (b *BlobStore) CheckWritability(ctx context.Context) error {
 	return nil
 }
 
-func (b *BlobStore) DeleteTrashNamespace(ctx context.Context, namespace []byte) (err error) {
-	//TODO: this would help to forget satellite
-	return nil
-}
-
-func (b *BlobStore) TryRestoreTrashBlob(ctx context.Context, ref blobstore.BlobRef) error {
-	// TODO: restore blob
-	return nil
-}
-
+// DiskInfo returns the capacity of the file system and the space available
+// for the node, see SpaceInfo.
 func (b *BlobStore) DiskInfo(ctx context.Context) (blobstore.DiskInfo, error) {
-	// TODO: use real numbers
-	return blobstore.DiskInfo{
-		TotalSpace:     1000000000000000,
-		AvailableSpace: 1000000000000000,
-	}, nil
+	space, err := b.SpaceInfo(ctx)
+	if err != nil {
+		return blobstore.DiskInfo{}, err
+	}
+	return blobstore.DiskInfo{TotalSpace: space.TotalSpace, AvailableSpace: space.AvailableSpace}, nil
 }
 
 var _ blobstore.Blobs = &BlobStore{}
 
 func NewBlobStore(dir string) (*BlobStore, error) {
-	options := badger.DefaultOptions(dir)
-	options.ValueThreshold = 10
-	options.WithValueLogFileSize(10_000_000_000)
-	db, err := badger.Open(options)
+	return NewBlobStoreWithConfig(dir, Config{})
+}
+
+func NewBlobStoreWithConfig(dir string, config Config) (*BlobStore, error) {
+	return NewBlobStoreWithLogger(zap.NewNop(), dir, config)
+}
+
+func NewBlobStoreWithLogger(log *zap.Logger, dir string, config Config) (*BlobStore, error) {
+	windows, err := parseMaintenanceWindows(config.MaintenanceWindows)
 	if err != nil {
-		return nil, errs.Wrap(err)
+		return nil, err
 	}
-	namespaces := make([][]byte, 0)
-	err = db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		for it.Seek(namespacePrefix); it.ValidForPrefix(namespacePrefix); it.Next() {
-			namespaces = append(namespaces, it.Item().Key()[len(namespacePrefix):])
+	if config.StorageFormat != 0 {
+		if err := checkFormat(blobstore.FormatVersion(config.StorageFormat)); err != nil {
+			return nil, err
 		}
-		it.Close()
-		return nil
-	})
-	return &BlobStore{
+	}
+	if err := checkSpaceAlert(config); err != nil {
+		return nil, err
+	}
+	backupKey, err := parseBackupKey(config.BackupKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTiering(config); err != nil {
+		return nil, err
+	}
+	iterators, err := parseIteratorProfiles(config.IteratorProfiles)
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := parseNamespaceQuotas(config.NamespaceQuotas)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openEngine(dir, config, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLayout(db); err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+	if err := checkChunks(config, db.MaxValueSize()); err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+	dirty, err := openSession(db)
+	if err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+	// spill files of uploads interrupted by a crash
+	if err := os.RemoveAll(filepath.Join(dir, spillDir)); err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+	if !config.BackgroundUsageScan {
+		if err := initUsage(context.Background(), db); err != nil {
+			return nil, errs.Combine(err, db.Close())
+		}
+	}
+	namespaces, err := listNamespaces(db)
+	if err != nil {
+		return nil, errs.Combine(err, db.Close())
+	}
+	store := &BlobStore{
+		log:        log,
 		dir:        dir,
 		db:         db,
 		namespaces: namespaces,
-	}, nil
+		config:     config,
+		clock:      systemClock{},
+		breaker:    newBreaker(config),
+		backupKey:  backupKey,
+		io:         newIOStats(),
+		deletes:    &deleteQueue{wake: make(chan struct{}, 1)},
+		retry:      newRetryPolicy(config),
+		readOnly:   &readOnly{dir: dir},
+		inflight:   &inflightUploads{},
+		quotas:     quotas,
+		rateLimits: newRateLimits(config),
+		access:     newAccessTracker(config.AccessTracking),
+		iterators:  iterators,
+	}
+	if config.AuditLog != "" {
+		store.auditLog = &auditLog{path: config.AuditLog}
+	}
+	if config.ReadCache > 0 {
+		store.cache = newReadCache(config.ReadCache.Int64())
+	}
+	if config.QuickCheck {
+		if err := store.quickCheck(); err != nil {
+			return nil, errs.Combine(err, db.Close())
+		}
+	}
+	store.jobs = newJobManager(log, config.ConcurrentJobs)
+	store.startReadOnly()
+	store.startUsageScan()
+	store.startDirtyReconciliation(dirty)
+	store.startUsageRecalculation()
+	store.startMaintenance(windows)
+	store.startBackpressure()
+	store.startDiskHealth()
+	store.startSpaceAlert()
+	store.startScheduledBackups()
+	store.startNamespaceSweep()
+	store.startTiering()
+	store.startValueLogStats()
+	if err := store.startDeleteQueue(); err != nil {
+		store.jobs.stop()
+		return nil, errs.Combine(err, db.Close())
+	}
+	if err := store.resumeForget(); err != nil {
+		store.jobs.stop()
+		return nil, errs.Combine(err, db.Close())
+	}
+	if err := store.startLazyFilewalker(); err != nil {
+		store.jobs.stop()
+		return nil, errs.Combine(err, db.Close())
+	}
+	return store, nil
 }
-func (b *BlobStore) Create(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
-	err := b.ensureNamespace(ref)
-	return NewWriter(b.db, ref), err
+
+func listNamespaces(db kvEngine) (namespaces [][]byte, err error) {
+	err = view(db, func(txn kvTxn) error {
+		namespaces = readNamespaces(txn)
+		return nil
+	})
+	return namespaces, errs.Wrap(err)
 }
 
-func (b *BlobStore) Open(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobReader, error) {
-	return NewReader(b.db, ref)
+// readNamespaces returns the namespaces with a marker, and the ones with
+// trash by the trash usage counters, so namespaces with trash only are found
+// even when their marker is lost.
+func readNamespaces(txn kvTxn) [][]byte {
+	namespaces := make([][]byte, 0)
+	it := txn.NewIterator(iteratorOptions(IteratorWalk, namespacePrefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		namespaces = append(namespaces, it.Item().KeyCopy(nil)[len(namespacePrefix):])
+	}
+	return append(namespaces, trashNamespaces(txn, namespaces)...)
 }
 
-func (b *BlobStore) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (blobstore.BlobReader, error) {
-	if formatVer != filestore.FormatV1 {
-		return nil, errs.New("Unsupported format")
+// trashNamespaces returns the namespaces with trash which are not known yet.
+func trashNamespaces(txn kvTxn, known [][]byte) (namespaces [][]byte) {
+	it := txn.NewIterator(iteratorOptions(IteratorRecords, trashUsagePrefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().Key()
+		namespace := key[len(trashUsagePrefix) : len(key)-1]
+		if containsNamespace(known, namespace) || containsNamespace(namespaces, namespace) {
+			continue
+		}
+		var u usage
+		err := it.Item().Value(func(val []byte) (err error) {
+			u, err = decodeUsage(val)
+			return err
+		})
+		if err == nil && u.Pieces > 0 {
+			namespaces = append(namespaces, append([]byte{}, namespace...))
+		}
 	}
-	return b.Open(ctx, ref)
+	return namespaces
 }
 
-func (b *BlobStore) Delete(ctx context.Context, ref blobstore.BlobRef) error {
-	return b.db.Update(func(txn *badger.Txn) error {
-		pref := keyPrefix(ref)
-		it := txn.NewIterator(badger.IteratorOptions{Prefix: pref})
-		defer it.Close()
+func containsNamespace(namespaces [][]byte, namespace []byte) bool {
+	for _, ns := range namespaces {
+		if bytesEq(ns, namespace) {
+			return true
+		}
+	}
+	return false
+}
 
-		for it.Seek(pref); it.ValidForPrefix(pref); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			if err := txn.Delete(key); err != nil {
-				return fmt.Errorf("error deleting key %s: %w", string(key), err)
+func (b *BlobStore) Create(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
+	return b.CreateWithStorageFormat(ctx, ref, b.defaultFormat())
+}
+
+// defaultFormat returns the storage format of the blobs created with Create.
+func (b *BlobStore) defaultFormat() blobstore.FormatVersion {
+	if b.config.StorageFormat != 0 {
+		return blobstore.FormatVersion(b.config.StorageFormat)
+	}
+	return filestore.FormatV1
+}
+
+// CreateWithStorageFormat creates a blob stored in the given format, like
+// FormatV0 for pieces imported from old filestores. Committing it replaces
+// the blob in any other format.
+func (b *BlobStore) CreateWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, format blobstore.FormatVersion) (_ blobstore.BlobWriter, err error) {
+	defer wrapRef("create", ref, &err)
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	if err := b.readOnly.check(); err != nil {
+		return nil, err
+	}
+	if b.Backpressure() {
+		return nil, ErrOverloaded.Wrap(ErrBackpressure.New("commits are too slow"))
+	}
+	if b.breaker != nil {
+		if err := b.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+	w := NewWriter(b.db, ref)
+	w.dedup = b.config.Dedup
+	w.chunkSize = b.config.ChunkSize.Int64()
+	w.cache = b.cache
+	w.clock = b.clock
+	w.memoryLimit = b.config.WriteBuffer.Int()
+	w.spillDir = filepath.Join(b.dir, spillDir)
+	w.backpressure = b.backpressure
+	w.breaker = b.breaker
+	w.diskHealth = b.diskHealth
+	w.committed = b.committed
+	w.io = b.io
+	w.retry = b.retry
+	w.readOnly = b.readOnly
+	w.quotas = b.quotas
+	w.rateLimits = b.rateLimits
+	w.inflight = b.inflight
+	w.own, w.first = b.inflight.start(ref)
+	w.format = format
+	return w, nil
+}
+
+func (b *BlobStore) Open(ctx context.Context, ref blobstore.BlobRef) (_ blobstore.BlobReader, err error) {
+	defer wrapRef("open", ref, &err)
+	if b.cache != nil {
+		if data, format, ok := b.cache.get(ref); ok {
+			if err := b.rateLimits.waitRead(ctx, ref.Namespace, 1, int64(len(data))); err != nil {
+				return nil, err
 			}
+			b.io.read(ref.Namespace)
+			b.accessed(ref)
+			return &reader{buffer: data, length: len(data), loaded: true, format: format, ref: ref, io: b.io}, nil
 		}
-		return nil
+	}
+	var r *reader
+	err = b.guard(func() (err error) {
+		var generation uint64
+		if b.cache != nil {
+			generation = b.cache.currentGeneration()
+		}
+		err = b.retry.do(ctx, "open", func() (err error) {
+			r, err = newReader(b.db, ref)
+			if err == nil && r.cold {
+				if err := b.fetchCold(ctx, ref); err != nil {
+					return err
+				}
+				r, err = newReader(b.db, ref)
+			}
+			return err
+		})
+		if err == nil && b.cache != nil {
+			r.cache, r.generation = b.cache, generation
+		}
+		if err == nil {
+			r.diskHealth = b.diskHealth
+		}
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+	r.io = b.io
+	r.retry = b.retry
+	if b.config.ChunkReadAhead > 0 {
+		r.readAhead = b.config.ChunkReadAhead
+	}
+	if err := b.rateLimits.waitRead(ctx, ref.Namespace, 1, int64(r.length)); err != nil {
+		return nil, errs.Combine(err, r.Close())
+	}
+	b.io.read(ref.Namespace)
+	b.accessed(ref)
+	return r, nil
 }
 
-func (b *BlobStore) DeleteWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) error {
-	if formatVer != filestore.FormatV1 {
-		return errs.New("Unsupported format")
+// TestCreateV0 creates a FormatV0 blob, the way the upstream tests expect
+// from stores supporting it.
+func (b *BlobStore) TestCreateV0(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
+	return b.CreateWithStorageFormat(ctx, ref, filestore.FormatV0)
+}
+
+// OpenWithStorageFormat opens the blob if it's stored in the format.
+// FormatV1 and FormatV2 blobs read the same, so either of them is accepted
+// for both.
+func (b *BlobStore) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (_ blobstore.BlobReader, err error) {
+	defer wrapRef("open", ref, &err)
+	if err := checkFormat(formatVer); err != nil {
+		return nil, err
+	}
+	r, err := b.Open(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !readsAs(r.StorageFormatVersion(), formatVer) {
+		return nil, errs.Combine(errNotExist("%s in format %d", ref.Key, formatVer), r.Close())
+	}
+	return r, nil
+}
+
+// Delete removes the blob. With Config.DeferredDeletes the removal is
+// queued, and the blob is readable until the queue gets to it.
+func (b *BlobStore) Delete(ctx context.Context, ref blobstore.BlobRef) error {
+	_, err := b.DeleteWithResult(ctx, ref)
+	return err
+}
+
+// DeleteResult tells what a delete removed.
+type DeleteResult struct {
+	// Removed is false if the blob didn't exist.
+	Removed bool
+	// Bytes is the size of the removed versions.
+	Bytes int64
+}
+
+// DeleteWithResult deletes the blob like Delete, telling whether it existed.
+// With deferred deletes the result is about the versions queued for removal.
+func (b *BlobStore) DeleteWithResult(ctx context.Context, ref blobstore.BlobRef) (result DeleteResult, err error) {
+	defer wrapRef("delete", ref, &err)
+	if err := b.readOnly.check(); err != nil {
+		return result, err
+	}
+	var removed []blobVersion
+	if b.config.DeferredDeletes {
+		removed, err = b.enqueueDelete(ref, false, trashTime{})
+	} else {
+		removed, err = b.delete(ref)
+	}
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	deleted := make([]deletion, 0, len(removed))
+	for _, version := range removed {
+		result.Removed = true
+		result.Bytes += version.size
+		deleted = append(deleted, deletionOf(ref, version.key))
+	}
+	b.audit(ctx, auditDelete, deleted)
+	return result, nil
+}
+
+// delete removes the versions of the blob, and returns them.
+func (b *BlobStore) delete(ref blobstore.BlobRef) (versions []blobVersion, err error) {
+	defer b.invalidate(ref)
+	var deleted []deletion
+	err = b.guard(func() error {
+		return update(b.db, func(txn kvTxn) (err error) {
+			versions = blobVersions(txn, keyPrefix(ref))
+			deleted, err = deleteVersions(txn, ref, versions)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.deleted(ref, deleted)
+	return versions, nil
+}
+
+// deleteVersions removes the blob versions.
+func deleteVersions(txn kvTxn, ref blobstore.BlobRef, versions []blobVersion) (deleted []deletion, err error) {
+	var delta usage
+	for _, version := range versions {
+		if err := removeEntry(txn, version.key); err != nil {
+			return nil, fmt.Errorf("error deleting key %s: %w", string(version.key), err)
+		}
+		delta = delta.add(usage{Bytes: -version.size, Pieces: -1})
+		deleted = append(deleted, deletionOf(ref, version.key))
+	}
+	return deleted, addUsage(txn, ref, delta)
+}
+
+// committed is called by the writers after a successful commit.
+func (b *BlobStore) committed(ref blobstore.BlobRef, size int64) {
+	b.addNamespace(ref.Namespace)
+	b.hooks.call(&b.hooks.commit, ref, size)
+}
+
+// deleted records the deleted versions of the blob.
+func (b *BlobStore) deleted(ref blobstore.BlobRef, deleted []deletion) {
+	if len(deleted) > 0 {
+		b.io.delete(ref.Namespace)
+	}
+	b.hooks.callEach(&b.hooks.delete, deleted)
+}
+
+func (b *BlobStore) DeleteWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (err error) {
+	defer wrapRef("delete", ref, &err)
+	if err := checkFormat(formatVer); err != nil {
+		return err
+	}
+	info, err := b.Stat(ctx, ref)
+	if ErrNotExist.Has(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !readsAs(info.StorageFormatVersion(), formatVer) {
+		return nil
 	}
 	return b.Delete(ctx, ref)
 }
 
+// DeleteNamespace removes the marker of the namespace once it has no blobs
+// or trash left. ForgetNamespace deletes the blobs of a namespace too.
 func (b *BlobStore) DeleteNamespace(ctx context.Context, ref []byte) (err error) {
-	ns := append(namespacePrefix, ref...)
-	//TODO: remove namespaces from b.namespaces
-	return b.db.Update(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(ns); it.ValidForPrefix(ns); it.Next() {
-			err = txn.Delete(it.Item().Key())
-			if err != nil {
-				return err
-			}
+	defer wrapRef("delete namespace", blobstore.BlobRef{Namespace: ref}, &err)
+	removed, err := b.removeEmptyNamespace(ref, false)
+	if err == nil && removed {
+		b.auditNamespace(ctx, auditDeleteNamespace, ref, 0, 0)
+	}
+	return err
+}
+
+func (b *BlobStore) Trash(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time) (err error) {
+	defer wrapRef("trash", ref, &err)
+	if err := b.readOnly.check(); err != nil {
+		return err
+	}
+	return b.trashBlob(ctx, ref, trashTime{trashedAt: timestamp})
+}
+
+func (b *BlobStore) trashBlob(ctx context.Context, ref blobstore.BlobRef, t trashTime) error {
+	if t.trashedAt.IsZero() {
+		t.trashedAt = b.clock.Now()
+	}
+	if b.config.DeferredDeletes {
+		queued, err := b.enqueueDelete(ref, true, t)
+		if err != nil {
+			return err
+		}
+		trashed := make([]deletion, 0, len(queued))
+		for _, version := range queued {
+			trashed = append(trashed, deletionOf(ref, version.key))
 		}
+		b.audit(ctx, auditTrash, trashed)
 		return nil
+	}
+	defer b.invalidate(ref)
+	var trashed []deletion
+	err := b.guard(func() (err error) {
+		trashed, err = b.trash(ref, t)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	b.reportDeletions(reportTrash, trashed)
+	b.audit(ctx, auditTrash, trashed)
+	return nil
 }
 
-func (b *BlobStore) Trash(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time) error {
-	return b.db.Update(func(txn *badger.Txn) error {
-		pref := keyPrefix(ref)
-		it := txn.NewIterator(badger.IteratorOptions{Prefix: pref})
-		defer it.Close()
+// trash moves the blob to the trash, recording the trash time unless it's zero.
+func (b *BlobStore) trash(ref blobstore.BlobRef, t trashTime) (trashed []deletion, err error) {
+	err = update(b.db, func(txn kvTxn) (err error) {
+		trashed, err = b.trashVersions(txn, ref, blobVersions(txn, keyPrefix(ref)), t)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.hooks.callEach(&b.hooks.trash, trashed)
+	return trashed, nil
+}
 
-		for it.Seek(pref); it.ValidForPrefix(pref); it.Next() {
-			key := it.Item().Key()
-			err := it.Item().Value(func(val []byte) error {
-				// we replace the prefix blobs with prefix trash
-				return txn.Set(append(trashPrefix, key[5:]...), val)
-			})
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			if err := txn.Delete(key); err != nil {
-				return fmt.Errorf("error deleting key %s: %w", string(key), err)
+// trashVersions moves the blob versions to the trash.
+func (b *BlobStore) trashVersions(txn kvTxn, ref blobstore.BlobRef, versions []blobVersion, t trashTime) (trashed []deletion, err error) {
+	var delta usage
+	for _, version := range versions {
+		// we replace the prefix blobs with prefix trash
+		trashKey := concat(trashPrefix, version.key[len(blobPrefix):])
+		err := b.move(txn, version.key, trashKey)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !t.trashedAt.IsZero() {
+			if err := writeTrashTime(txn, trashKey, t); err != nil {
+				return nil, err
 			}
 		}
-		return nil
-	})
+		delta = delta.add(usage{Bytes: version.size, Pieces: 1})
+		trashed = append(trashed, deletionOf(ref, version.key))
+	}
+	if err := addUsage(txn, ref, delta.negate()); err != nil {
+		return nil, err
+	}
+	return trashed, addTrashUsage(txn, ref, delta)
 }
 
-func (b *BlobStore) move(txn *badger.Txn, from []byte, to []byte) error {
+// invalidate drops the blob from the read cache, if there is one.
+func (b *BlobStore) invalidate(ref blobstore.BlobRef) {
+	if b.cache != nil {
+		b.cache.invalidate(ref)
+	}
+}
+
+func (b *BlobStore) move(txn kvTxn, from []byte, to []byte) error {
 	item, err := txn.Get(from)
 	if err != nil {
 		return err
 	}
-	err = item.Value(func(val []byte) error {
-		return txn.Set(to, val)
-	})
+	val, err := item.ValueCopy(nil)
 	if err != nil {
 		return err
 	}
+	// the user meta marks deduplicated entries, so it has to move with the value
+	if err := txn.SetWithMeta(to, val, item.UserMeta()); err != nil {
+		return err
+	}
 	return txn.Delete(from)
 }
 
-func (b *BlobStore) RestoreTrash(ctx context.Context, namespace []byte) ([][]byte, error) {
-	var keys [][]byte
-	err := b.db.Update(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(trashPrefix); it.ValidForPrefix(trashPrefix); it.Next() {
-			key := it.Item().Key()
-			keys = append(keys, key)
-			origKey := append(blobPrefix, key[len(trashPrefix):]...)
-			err := b.move(txn, key, origKey)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+// RestoreTrash moves the trashed blobs of the namespace back, and returns
+// their keys.
+func (b *BlobStore) RestoreTrash(ctx context.Context, namespace []byte) (_ [][]byte, err error) {
+	defer wrapRef("restore trash", blobstore.BlobRef{Namespace: namespace}, &err)
+	keys, _, err := b.restoreTrash(ctx, namespace, trashFilter{}, false)
 	return keys, err
 }
 
-func (b *BlobStore) EmptyTrash(ctx context.Context, namespace []byte, trashedBefore time.Time) (int64, [][]byte, error) {
-	var keys [][]byte
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(trashPrefix); it.ValidForPrefix(trashPrefix); it.Next() {
-			key := it.Item().Key()
-			keys = append(keys, key)
-			err := txn.Delete(key)
-			if err != nil {
-				return err
-			}
+// EmptyTrash deletes the trashed blobs of the namespace which were trashed
+// at or before trashedBefore, or whose own expiry passed, and returns the
+// freed bytes and their keys. Blobs without a known trash time are deleted.
+func (b *BlobStore) EmptyTrash(ctx context.Context, namespace []byte, trashedBefore time.Time) (_ int64, _ [][]byte, err error) {
+	defer wrapRef("empty trash", blobstore.BlobRef{Namespace: namespace}, &err)
+	now := b.clock.Now()
+	keys, emptied, err := b.emptyTrash(ctx, namespace, func(t trashTime) bool { return t.expired(now, trashedBefore) }, false)
+	var freed int64
+	for _, d := range emptied {
+		freed += d.size
+	}
+	return freed, keys, err
+}
+
+// emptyTrash deletes the trashed blobs of the namespace for which expired
+// returns true, and returns the keys and the deleted versions. A dry run only
+// finds them.
+func (b *BlobStore) emptyTrash(ctx context.Context, namespace []byte, expired func(t trashTime) bool, dryRun bool) ([][]byte, []deletion, error) {
+	if !dryRun {
+		if err := b.readOnly.check(); err != nil {
+			return nil, nil, err
 		}
-		return nil
+	}
+	var emptied []deletion
+	err := b.trashBatches(ctx, namespace, nil, dryRun, func(txn kvTxn, key []byte, ref blobstore.BlobRef) (bool, error) {
+		t, err := readTrashTime(txn, key)
+		if err != nil || !expired(t) {
+			return false, err
+		}
+		if dryRun {
+			return true, nil
+		}
+		if err := removeEntry(txn, key); err != nil {
+			return false, err
+		}
+		_, size := stat(key)
+		return true, addTrashUsage(txn, ref, usage{Bytes: -int64(size), Pieces: -1})
+	}, func(batch []deletion) {
+		emptied = append(emptied, batch...)
+		if dryRun {
+			return
+		}
+		b.reportDeletions(reportEmptyTrash, batch)
+		b.hooks.callEach(&b.hooks.emptyTrash, batch)
+		b.audit(ctx, auditEmptyTrash, batch)
 	})
-	return 0, keys, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return trashKeys(emptied), emptied, nil
 }
 
-func (b *BlobStore) Stat(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobInfo, error) {
-	info := BlobInfo{}
-	pref := keyPrefix(ref)
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.IteratorOptions{Prefix: pref})
-		defer it.Close()
-
-		for it.Seek(pref); it.ValidForPrefix(pref); {
-
-			break
-
+func (b *BlobStore) Stat(ctx context.Context, ref blobstore.BlobRef) (_ blobstore.BlobInfo, err error) {
+	defer wrapRef("stat", ref, &err)
+	var info BlobInfo
+	var found bool
+	err = view(b.db, func(txn kvTxn) error {
+		versions := blobVersions(txn, keyPrefix(ref))
+		if len(versions) == 0 {
+			return nil
 		}
-		return nil
+		item, err := txn.Get(versions[0].key)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		info, found = newBlobInfo(b.dir, ref, versions[0].key, item.UserMeta()), true
+		info.lastAccess, err = b.access.lastAccess(txn, ref)
+		return err
 	})
-	return info, err
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNotExist("%s", ref.Key)
+	}
+	return info, nil
 }
 
-func (b *BlobStore) StatWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (blobstore.BlobInfo, error) {
-	if formatVer != filestore.FormatV1 {
-		return nil, errs.New("Unsupported format")
+// StatWithStorageFormat stats the blob if it's stored in the format, like
+// OpenWithStorageFormat.
+func (b *BlobStore) StatWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (_ blobstore.BlobInfo, err error) {
+	defer wrapRef("stat", ref, &err)
+	if err := checkFormat(formatVer); err != nil {
+		return nil, err
 	}
-	return b.Stat(ctx, ref)
+	info, err := b.Stat(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !readsAs(info.StorageFormatVersion(), formatVer) {
+		return nil, errNotExist("%s in format %d", ref.Key, formatVer)
+	}
+	return info, nil
 }
 
 func (b *BlobStore) SpaceUsedForTrash(ctx context.Context) (int64, error) {
-	s := int64(0)
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(trashPrefix); it.ValidForPrefix(trashPrefix); it.Next() {
-			item := it.Item()
-			s += item.ValueSize()
-		}
-		return nil
+	if err := b.waitUsage(ctx); err != nil {
+		return 0, err
+	}
+	var total usage
+	err := view(b.db, func(txn kvTxn) (err error) {
+		total, err = totalTrashUsage(txn)
+		return err
 	})
-	return s, err
+	return total.Bytes, err
 }
 
 func (b *BlobStore) SpaceUsedForBlobs(ctx context.Context) (int64, error) {
-	s := int64(0)
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(namespacePrefix); it.ValidForPrefix(namespacePrefix); it.Next() {
-			item := it.Item()
-			s += item.ValueSize()
-		}
-		return nil
+	if err := b.waitUsage(ctx); err != nil {
+		return 0, err
+	}
+	var total usage
+	err := view(b.db, func(txn kvTxn) (err error) {
+		total, err = totalUsage(txn)
+		return err
 	})
-	return s, err
+	return total.Bytes, err
 }
 
-func (b *BlobStore) SpaceUsedForBlobsInNamespace(ctx context.Context, namespace []byte) (int64, error) {
-	s := int64(0)
-	ns := append(namespacePrefix, namespace...)
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(ns); it.ValidForPrefix(ns); it.Next() {
-			item := it.Item()
-			s += item.ValueSize()
-		}
-		return nil
+func (b *BlobStore) SpaceUsedForBlobsInNamespace(ctx context.Context, namespace []byte) (_ int64, err error) {
+	defer wrapRef("space used", blobstore.BlobRef{Namespace: namespace}, &err)
+	if err := b.waitUsage(ctx); err != nil {
+		return 0, err
+	}
+	var total usage
+	err = view(b.db, func(txn kvTxn) (err error) {
+		total, err = namespaceUsage(txn, namespace)
+		return err
 	})
-	return s, err
+	return total.Bytes, err
 }
 
 func (b *BlobStore) ListNamespaces(ctx context.Context) ([][]byte, error) {
-	return b.namespaces, nil
+	return b.knownNamespaces(), nil
 }
 
+// WalkNamespace calls walkFunc for the blobs of the namespace. The blobs are
+// read from a single snapshot of the store, see walk for the details. The
+// errors of walkFunc are returned as they are, so they aren't wrapped. A
+// non-empty startFromPrefix skips the blobs the file store keeps in the
+// prefix directories before it, see walkStart.
 func (b *BlobStore) WalkNamespace(ctx context.Context, namespace []byte, startFromPrefix string, walkFunc func(blobstore.BlobInfo) error) error {
-	err := b.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
+	start, err := walkStart(startFromPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = b.walk(ctx, concat(blobPrefix, namespace), namespace, start, walkFunc)
+	return err
+}
+
+// WalkTrash calls walkFunc for the trashed blobs of the namespace, like
+// WalkNamespace does for the live ones.
+func (b *BlobStore) WalkTrash(ctx context.Context, namespace []byte, startFromPrefix string, walkFunc func(blobstore.BlobInfo) error) error {
+	start, err := walkStart(startFromPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = b.walk(ctx, concat(trashPrefix, namespace), namespace, start, walkFunc)
+	return err
+}
+
+// WalkAllNamespaces calls walkFunc for the blobs of every namespace. Unlike
+// walking the namespaces one by one, all the blobs are read from the same
+// snapshot of the store.
+func (b *BlobStore) WalkAllNamespaces(ctx context.Context, walkFunc func(blobstore.BlobInfo) error) error {
+	return view(b.db, func(txn kvTxn) error {
+		namespaces := readNamespaces(txn)
+		it := txn.NewIterator(b.iteratorOptions(IteratorWalk, blobPrefix))
 		defer it.Close()
-		for it.Seek(ns(namespace)); it.ValidForPrefix(ns(namespace)); it.Next() {
-			item := it.Item()
-			key := item.KeyCopy(nil)
-			blobKey := key[len(ns(namespace)) : len(key)-16]
-			t, s := stat(key)
-			err := walkFunc(BlobInfo{
-				ref: blobstore.BlobRef{
-					Namespace: namespace,
-					Key:       blobKey,
-				},
-				name: string(blobKey),
-				// This is just estimation!!!!
-				size:    int64(s),
-				modTime: t,
-			})
-			if err != nil {
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			ref, ok := splitKey(namespaces, key[len(blobPrefix):])
+			if !ok {
+				continue
+			}
+			if err := walkFunc(newBlobInfo(b.dir, ref, key, it.Item().UserMeta())); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
-	return err
-}
-
-func ns(namespace []byte) []byte {
-	return append(blobPrefix, namespace...)
 }
 
 func (b *BlobStore) CreateVerificationFile(ctx context.Context, id storj.NodeID) error {
@@ -328,29 +826,29 @@ func (b *BlobStore) VerifyStorageDir(ctx context.Context, id storj.NodeID) error
 		}
 		return errs.New("node ID in file (%s) does not match running node's ID (%s)", verifyID, id.String())
 	}
-	return nil
-}
-
-func (b *BlobStore) Close() error {
-	return b.db.Close()
-}
-
-func (b *BlobStore) ensureNamespace(ref blobstore.BlobRef) error {
-	for _, ns := range b.namespaces {
-		if bytesEq(ns, ref.Namespace) {
-			return nil
-		}
-	}
-	err := b.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(append(namespacePrefix, ref.Namespace...), []byte{1})
+	var bound string
+	err = view(b.db, func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		bound = m.NodeID
+		return err
 	})
 	if err != nil {
 		return err
 	}
-	b.namespaces = append(b.namespaces, ref.Namespace)
+	if bound != "" && bound != id.String() {
+		return errs.New("store was set up for node %s, not the running node %s", bound, id.String())
+	}
 	return nil
 }
 
+func (b *BlobStore) Close() error {
+	b.jobs.stop()
+	b.flushAccess()
+	b.releaseSnapshots()
+	b.closeSession()
+	return errs.Combine(classify(b.db.Close()), b.auditLog.close())
+}
+
 func bytesEq(ns []byte, namespace []byte) bool {
 	if len(ns) != len(namespace) {
 		return false
@@ -367,18 +865,20 @@ func diskInfoFromPath(path string) (info DiskInfo, err error) {
 	var stat unix.Statfs_t
 	err = unix.Statfs(path, &stat)
 	if err != nil {
-		return DiskInfo{"", -1}, err
+		return DiskInfo{ID: "", TotalSpace: -1, AvailableSpace: -1}, err
 	}
 
 	// the Bsize size depends on the OS and unconvert gives a false-positive
+	totalSpace := int64(stat.Blocks) * int64(stat.Bsize)     //nolint: unconvert
 	availableSpace := int64(stat.Bavail) * int64(stat.Bsize) //nolint: unconvert
 	filesystemID := fmt.Sprintf("%08x%08x", stat.Fsid.Val[0], stat.Fsid.Val[1])
 
-	return DiskInfo{filesystemID, availableSpace}, nil
+	return DiskInfo{ID: filesystemID, TotalSpace: totalSpace, AvailableSpace: availableSpace}, nil
 }
 
 // DiskInfo contains statistics about this dir.
 type DiskInfo struct {
 	ID             string
+	TotalSpace     int64
 	AvailableSpace int64
 }
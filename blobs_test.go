@@ -7,7 +7,9 @@ import (
 	"github.com/zeebo/errs"
 	"io"
 	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
 	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
 	"testing"
 	"time"
 )
@@ -87,6 +89,48 @@ func TestWalkNamespace(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestWalkFromPrefix(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir(t.TempDir()))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// the keys are in different prefix directories of the file store, from
+	// "aa" to "74"
+	var keys [][]byte
+	for _, first := range []byte{0x00, 0x10, 0x40, 0x44, 0x80, 0xff} {
+		key := testrand.BytesInt(32)
+		key[0], key[1] = first, 0
+		keys = append(keys, key)
+		blob := blobstore.BlobRef{Namespace: []byte("ns"), Key: key}
+		require.NoError(t, save(ctx, store, blob, "data"))
+		trashed := blobstore.BlobRef{Namespace: []byte("trash"), Key: key}
+		require.NoError(t, save(ctx, store, trashed, "data"))
+		require.NoError(t, store.Trash(ctx, trashed, time.Now()))
+	}
+
+	walked := func(walk func(context.Context, []byte, string, func(blobstore.BlobInfo) error) error, namespace string, startFromPrefix string) (walked [][]byte) {
+		require.NoError(t, walk(ctx, []byte(namespace), startFromPrefix, func(info blobstore.BlobInfo) error {
+			walked = append(walked, info.BlobRef().Key)
+			return nil
+		}))
+		return walked
+	}
+	for i, key := range keys {
+		prefix := filestore.PathEncoding.EncodeToString(key)[:2]
+		require.Equal(t, keys[i:], walked(store.WalkNamespace, "ns", prefix), prefix)
+		require.Equal(t, keys[i:], walked(store.WalkTrash, "trash", prefix), prefix)
+	}
+	require.Equal(t, keys, walked(store.WalkNamespace, "ns", ""))
+	require.Equal(t, keys[1:], walked(store.WalkNamespace, "ns", "ab"))
+	require.Empty(t, walked(store.WalkNamespace, "ns", "77"))
+
+	require.Error(t, store.WalkNamespace(ctx, []byte("ns"), "a1", func(blobstore.BlobInfo) error { return nil }))
+	require.Error(t, store.WalkTrash(ctx, []byte("trash"), "abc", func(blobstore.BlobInfo) error { return nil }))
+}
+
 func TestDelete(t *testing.T) {
 	ctx := testcontext.New(t)
 	defer ctx.Cleanup()
@@ -129,6 +173,29 @@ func TestDelete(t *testing.T) {
 
 }
 
+func TestDeleteWithResult(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	for _, deferred := range []bool{false, true} {
+		store, err := NewBlobStoreWithConfig(ctx.Dir(fmt.Sprint(deferred)), Config{DeferredDeletes: deferred})
+		require.NoError(t, err)
+		require.NoError(t, save(ctx, store, ref("ns", "key"), "1234567890"))
+
+		result, err := store.DeleteWithResult(ctx, ref("ns", "key"))
+		require.NoError(t, err)
+		require.Equal(t, DeleteResult{Removed: true, Bytes: 10}, result)
+		require.NoError(t, store.FlushDeletes(ctx))
+
+		// deleting again still succeeds, but tells that nothing was there
+		result, err = store.DeleteWithResult(ctx, ref("ns", "key"))
+		require.NoError(t, err)
+		require.Equal(t, DeleteResult{}, result)
+		require.NoError(t, store.Delete(ctx, ref("ns", "key")))
+		require.NoError(t, store.Close())
+	}
+}
+
 func TestMoveToTrash(t *testing.T) {
 	ctx := testcontext.New(t)
 	defer ctx.Cleanup()
@@ -155,7 +222,7 @@ func TestMoveToTrash(t *testing.T) {
 	trash, err := store.RestoreTrash(ctx, []byte("ns"))
 	require.NoError(t, err)
 
-	require.Equal(t, len(trash), 1)
+	require.Equal(t, [][]byte{[]byte("key1")}, trash)
 
 	a, err := store.Open(ctx, ref1)
 	require.NoError(t, err)
@@ -166,6 +233,33 @@ func TestMoveToTrash(t *testing.T) {
 	require.Equal(t, []byte("1234567890"), all)
 }
 
+func TestTrashKeysPerNamespace(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	for _, blob := range []blobstore.BlobRef{ref("ns", "key1"), ref("ns", "key2"), ref("ns1", "key1")} {
+		require.NoError(t, save(ctx, store, blob, "data"))
+		require.NoError(t, store.Trash(ctx, blob, time.Now()))
+	}
+
+	restored, err := store.RestoreTrash(ctx, []byte("ns1"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("key1")}, restored)
+
+	freed, emptied, err := store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(8), freed)
+	require.Equal(t, [][]byte{[]byte("key1"), []byte("key2")}, emptied)
+
+	reader, err := store.Open(ctx, ref("ns1", "key1"))
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+}
+
 func TestWriteWithSeek(t *testing.T) {
 	ctx := testcontext.New(t)
 	defer ctx.Cleanup()
@@ -303,3 +397,78 @@ func rall(r io.Reader) ([]byte, error) {
 		}
 	}
 }
+
+func TestCancelMidWrite(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir(t.TempDir()))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	ref1 := ref("ns", "key1")
+	out, err := store.Create(ctx, ref1)
+	require.NoError(t, err)
+	_, err = out.Write([]byte("1234567890"))
+	require.NoError(t, err)
+
+	require.NoError(t, out.Cancel(ctx))
+	require.NoError(t, out.Cancel(ctx))
+	require.Error(t, out.Commit(ctx))
+
+	_, err = store.Open(ctx, ref1)
+	require.Error(t, err)
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), used)
+
+	err = store.db.View(func(txn kvTxn) error {
+		require.Empty(t, blobVersions(txn, keyPrefix(ref1)))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBloomFalsePositive(t *testing.T) {
+	require.InDelta(t, 0.0082, bloomFalsePositive(10), 0.0001)
+	require.InDelta(t, 0.00007, bloomFalsePositive(20), 0.00001)
+}
+
+// BenchmarkOpenMissing measures the lookups of missing blobs in a store with
+// many blobs in its tables.
+func BenchmarkOpenMissing(b *testing.B) {
+	for _, bits := range []int{0, 20} {
+		b.Run(fmt.Sprintf("bloom-bits-%d", bits), func(b *testing.B) {
+			ctx := testcontext.New(b)
+			defer ctx.Cleanup()
+
+			dir := ctx.Dir("store")
+			config := Config{BloomBitsPerKey: bits}
+			store, err := NewBlobStoreWithConfig(dir, config)
+			require.NoError(b, err)
+			satellite := testrand.NodeID()
+			for i := 0; i < 20000; i++ {
+				blob := blobstore.BlobRef{Namespace: satellite.Bytes(), Key: testrand.PieceID().Bytes()}
+				require.NoError(b, save(ctx, store, blob, "data"))
+			}
+			// reopening flushes the memtables to tables
+			require.NoError(b, store.Close())
+			store, err = NewBlobStoreWithConfig(dir, config)
+			require.NoError(b, err)
+			defer ctx.Check(store.Close)
+
+			missing := make([]blobstore.BlobRef, 1024)
+			for i := range missing {
+				missing[i] = blobstore.BlobRef{Namespace: satellite.Bytes(), Key: testrand.PieceID().Bytes()}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.Open(ctx, missing[i%len(missing)]); !ErrNotExist.Has(err) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,533 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/zeebo/errs"
+	"go.etcd.io/bbolt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// boltFile is the file of the stores kept in bolt, in the store directory.
+const boltFile = "blobs.bolt"
+
+// boltMaxBatchCount is the number of writes of the batches of the bolt
+// engine, about what fits in badger's transactions with the default config.
+const boltMaxBatchCount = 100000
+
+// boltHeaderSize is the size of the header bolt values start with: the user
+// meta byte and the version of the transaction which set the value.
+const boltHeaderSize = 9
+
+// boltDeleteBit marks the deleted entries in the backups, like in the ones
+// of badger.
+const boltDeleteBit = 1
+
+// boltMinMmapHeadroom is the least room for growth the memory map of the
+// bolt file leaves beyond the size of the file when it's opened; the map is
+// at least twice the size of the file. Bolt remaps the file when it grows
+// beyond the map, waiting for the open read transactions, so a commit would
+// wait for the walks and snapshots, which may wait for the commit. The map
+// only reserves address space, so the remaps are left to the stores growing
+// by more than the headroom while they are open.
+const boltMinMmapHeadroom = 4 << 30
+
+// The buckets of the bolt file: the entries, the deleted keys kept for the
+// incremental backups, and the state of the engine.
+var (
+	boltEntries = []byte("entries")
+	boltDeleted = []byte("deleted")
+	boltState   = []byte("state")
+)
+
+// boltLastFull is the key of the version of the last full backup in the
+// state bucket. The deleted keys are only kept once it's set.
+var boltLastFull = []byte("last-full-backup")
+
+// boltEngine is the engine of the stores kept in a bolt file: a single
+// B+tree with the keys and the values, without compactions or value log GC.
+// Writes are serialized, so its transactions never conflict.
+type boltEngine struct {
+	db *bbolt.DB
+}
+
+// openBolt opens the bolt file of the store in dir.
+func openBolt(dir string, config Config) (kvEngine, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	path := filepath.Join(dir, boltFile)
+	mmapSize, err := boltMmapSize(path)
+	if err != nil {
+		return nil, err
+	}
+	// bolt waits forever for the lock without a timeout
+	timeout := config.LockTimeout
+	if timeout <= 0 {
+		timeout = time.Nanosecond
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout:         timeout,
+		InitialMmapSize: mmapSize,
+		FreelistType:    bbolt.FreelistMapType,
+	})
+	if errs.Is(err, bbolt.ErrTimeout) {
+		return nil, ErrLocked.New("another process holds the store at %s", dir)
+	}
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{boltEntries, boltDeleted, boltState} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Combine(errs.Wrap(err), db.Close())
+	}
+	return boltEngine{db: db}, nil
+}
+
+// boltMmapSize returns the size of the memory map of the bolt file at path,
+// from the size of the file.
+func boltMmapSize(path string) (int, error) {
+	var size int64
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		size = info.Size()
+	case !os.IsNotExist(err):
+		return 0, errs.Wrap(err)
+	}
+	if strconv.IntSize == 32 {
+		return 1 << 30, nil
+	}
+	headroom := size
+	if headroom < boltMinMmapHeadroom {
+		headroom = boltMinMmapHeadroom
+	}
+	return int(size + headroom), nil
+}
+
+func (e boltEngine) View(fn func(txn kvTxn) error) error {
+	return boltError(e.db.View(func(tx *bbolt.Tx) error { return fn(newBoltTxn(tx)) }))
+}
+
+func (e boltEngine) Update(fn func(txn kvTxn) error) error {
+	return boltError(e.db.Update(func(tx *bbolt.Tx) error { return fn(newBoltTxn(tx)) }))
+}
+
+func (e boltEngine) NewTransaction(update bool) kvTxn {
+	tx, err := e.db.Begin(update)
+	if err != nil {
+		return &boltTxn{err: boltError(err)}
+	}
+	return newBoltTxn(tx)
+}
+
+// MaxBatchCount bounds the transactions, which bolt keeps in memory until
+// they commit without a limit of its own.
+func (e boltEngine) MaxBatchCount() int64 { return boltMaxBatchCount }
+
+func (e boltEngine) MaxValueSize() int64 { return bbolt.MaxValueSize - boltHeaderSize }
+
+// Size returns the size of the bolt file, which holds the keys and the
+// values together.
+func (e boltEngine) Size() (keys, values int64) {
+	info, err := os.Stat(e.db.Path())
+	if err != nil {
+		return 0, 0
+	}
+	return 0, info.Size()
+}
+
+// Backup writes the entries set at or after the since version, and the
+// keys deleted since then for the incremental backups, in the format of
+// badger's backups, so they can be loaded by either engine. The deleted
+// keys are kept from the first full backup on, and dropped once the backups
+// moved past them: an incremental backup drops the ones before its since
+// version, a full backup the ones before the previous full backup.
+func (e boltEngine) Backup(w io.Writer, since uint64) (version uint64, err error) {
+	var previousFull uint64
+	err = e.db.View(func(tx *bbolt.Tx) error {
+		version = uint64(tx.ID())
+		if raw := tx.Bucket(boltState).Get(boltLastFull); len(raw) == 8 {
+			previousFull = binary.BigEndian.Uint64(raw)
+		}
+		list := &pb.KVList{}
+		flush := func() error {
+			if len(list.Kv) == 0 {
+				return nil
+			}
+			err := writeKVList(w, list)
+			list.Kv = list.Kv[:0]
+			return err
+		}
+		c := tx.Bucket(boltEntries).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) < boltHeaderSize {
+				return errCorruptBoltValue(k)
+			}
+			itemVersion := binary.BigEndian.Uint64(v[1:boltHeaderSize])
+			if itemVersion < since {
+				continue
+			}
+			list.Kv = append(list.Kv, &pb.KV{
+				Key:      append([]byte(nil), k...),
+				Value:    append([]byte(nil), v[boltHeaderSize:]...),
+				UserMeta: []byte{v[0]},
+				Version:  itemVersion,
+			})
+			if len(list.Kv) >= snapshotBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if since > 0 {
+			c := tx.Bucket(boltDeleted).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				deletedAt := binary.BigEndian.Uint64(v)
+				if deletedAt < since {
+					continue
+				}
+				list.Kv = append(list.Kv, &pb.KV{
+					Key:     append([]byte(nil), k...),
+					Version: deletedAt,
+					Meta:    []byte{boltDeleteBit},
+				})
+				if len(list.Kv) >= snapshotBatchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return flush()
+	})
+	if err != nil {
+		return 0, boltError(err)
+	}
+	err = e.db.Update(func(tx *bbolt.Tx) error {
+		until := since
+		if since == 0 {
+			until = previousFull
+			var raw [8]byte
+			binary.BigEndian.PutUint64(raw[:], version)
+			if err := tx.Bucket(boltState).Put(boltLastFull, raw[:]); err != nil {
+				return err
+			}
+		}
+		deleted := tx.Bucket(boltDeleted)
+		var expired [][]byte
+		c := deleted.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if binary.BigEndian.Uint64(v) < until {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, key := range expired {
+			if err := deleted.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return version, boltError(err)
+}
+
+// Load loads a backup of either engine. Badger's backups may list several
+// versions of a key, the latest first, of which only the latest is loaded.
+func (e boltEngine) Load(r io.Reader) error {
+	var length [8]byte
+	for {
+		if _, err := io.ReadFull(r, length[:]); errs.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return errs.Wrap(err)
+		}
+		size := binary.LittleEndian.Uint64(length[:])
+		if size > math.MaxInt32 {
+			return errs.New("backup list of %d bytes", size)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return errs.Wrap(err)
+		}
+		list := &pb.KVList{}
+		if err := list.Unmarshal(raw); err != nil {
+			return errs.Wrap(err)
+		}
+		err := e.Update(func(txn kvTxn) error {
+			var last []byte
+			for _, kv := range list.Kv {
+				if kv.StreamDone || bytes.Equal(kv.Key, last) {
+					continue
+				}
+				last = kv.Key
+				if len(kv.Meta) > 0 && kv.Meta[0]&boltDeleteBit != 0 {
+					if err := txn.Delete(kv.Key); err != nil {
+						return err
+					}
+					continue
+				}
+				var userMeta byte
+				if len(kv.UserMeta) > 0 {
+					userMeta = kv.UserMeta[0]
+				}
+				if err := txn.SetWithMeta(kv.Key, kv.Value, userMeta); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e boltEngine) Close() error { return boltError(e.db.Close()) }
+
+// writeKVList writes a list of a backup, prefixed with its length.
+func writeKVList(w io.Writer, list *pb.KVList) error {
+	raw, err := list.Marshal()
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(raw)))
+	if _, err := w.Write(length[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	_, err = w.Write(raw)
+	return errs.Wrap(err)
+}
+
+// boltError returns the error of badger for the errors of bolt the store
+// handles, see classify.
+func boltError(err error) error {
+	switch {
+	case errs.Is(err, bbolt.ErrDatabaseNotOpen), errs.Is(err, bbolt.ErrTxClosed):
+		return badger.ErrDBClosed
+	case errs.Is(err, bbolt.ErrTxNotWritable), errs.Is(err, bbolt.ErrDatabaseReadOnly):
+		return badger.ErrReadOnlyTxn
+	case errs.Is(err, bbolt.ErrValueTooLarge), errs.Is(err, bbolt.ErrKeyTooLarge):
+		return badger.ErrTxnTooBig
+	}
+	return err
+}
+
+func errCorruptBoltValue(key []byte) error {
+	return ErrCorrupt.New("bolt value of %x has no header", key)
+}
+
+// boltTxn is a transaction of the bolt engine. writes counts its changes,
+// so the iterators know when to find their position again.
+type boltTxn struct {
+	tx      *bbolt.Tx
+	entries *bbolt.Bucket
+	deleted *bbolt.Bucket
+	writes  int
+	// err is the error of starting the transaction
+	err error
+}
+
+func newBoltTxn(tx *bbolt.Tx) *boltTxn {
+	return &boltTxn{tx: tx, entries: tx.Bucket(boltEntries), deleted: tx.Bucket(boltDeleted)}
+}
+
+func (t *boltTxn) Get(key []byte) (kvItem, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	value := t.entries.Get(key)
+	if value == nil {
+		return nil, errKeyNotFound
+	}
+	if len(value) < boltHeaderSize {
+		return nil, errCorruptBoltValue(key)
+	}
+	return boltItem{key: key, value: value}, nil
+}
+
+func (t *boltTxn) Set(key, value []byte) error {
+	return t.SetWithMeta(key, value, 0)
+}
+
+func (t *boltTxn) SetWithMeta(key, value []byte, userMeta byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	raw := make([]byte, boltHeaderSize+len(value))
+	raw[0] = userMeta
+	binary.BigEndian.PutUint64(raw[1:boltHeaderSize], uint64(t.tx.ID()))
+	copy(raw[boltHeaderSize:], value)
+	if err := t.entries.Put(key, raw); err != nil {
+		return boltError(err)
+	}
+	t.writes++
+	if t.deleted.Get(key) != nil {
+		return boltError(t.deleted.Delete(key))
+	}
+	return nil
+}
+
+func (t *boltTxn) Delete(key []byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.entries.Get(key) == nil {
+		return nil
+	}
+	if err := t.entries.Delete(key); err != nil {
+		return boltError(err)
+	}
+	t.writes++
+	if t.tx.Bucket(boltState).Get(boltLastFull) == nil {
+		return nil
+	}
+	var version [8]byte
+	binary.BigEndian.PutUint64(version[:], uint64(t.tx.ID()))
+	return boltError(t.deleted.Put(append([]byte(nil), key...), version[:]))
+}
+
+func (t *boltTxn) NewIterator(options kvIteratorOptions) kvIterator {
+	it := &boltIterator{txn: t, options: options}
+	if t.err == nil {
+		it.cursor = t.entries.Cursor()
+	}
+	return it
+}
+
+func (t *boltTxn) ReadTs() uint64 {
+	if t.err != nil {
+		return 0
+	}
+	return uint64(t.tx.ID())
+}
+
+// Commit commits the transaction, and only ends it if it's read-only.
+func (t *boltTxn) Commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	if !t.tx.Writable() {
+		return boltError(t.tx.Rollback())
+	}
+	return boltError(t.tx.Commit())
+}
+
+func (t *boltTxn) Discard() {
+	if t.err == nil {
+		_ = t.tx.Rollback()
+	}
+}
+
+// boltItem is an entry of the bolt engine, with the header in its value.
+type boltItem struct {
+	key   []byte
+	value []byte
+}
+
+func (i boltItem) Key() []byte { return i.key }
+
+func (i boltItem) KeyCopy(dst []byte) []byte { return append(dst[:0], i.key...) }
+
+func (i boltItem) Value(fn func(val []byte) error) error { return fn(i.value[boltHeaderSize:]) }
+
+func (i boltItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], i.value[boltHeaderSize:]...), nil
+}
+
+func (i boltItem) ValueSize() int64 { return int64(len(i.value) - boltHeaderSize) }
+
+func (i boltItem) UserMeta() byte { return i.value[0] }
+
+func (i boltItem) Version() uint64 { return binary.BigEndian.Uint64(i.value[1:boltHeaderSize]) }
+
+func (i boltItem) ExpiresAt() uint64 { return 0 }
+
+// boltIterator iterates the entries with a cursor. Bolt cursors may skip
+// entries after changes of their bucket, so after the changes of the
+// transaction the iterator finds its position again by seeking its key.
+type boltIterator struct {
+	txn     *boltTxn
+	options kvIteratorOptions
+	cursor  *bbolt.Cursor
+	key     []byte
+	value   []byte
+	// writes is the number of changes of the transaction at the position
+	writes int
+}
+
+func (it *boltIterator) Rewind() { it.Seek(nil) }
+
+func (it *boltIterator) Seek(key []byte) {
+	if it.cursor == nil {
+		return
+	}
+	if len(key) == 0 {
+		key = it.options.Prefix
+	}
+	it.writes = it.txn.writes
+	switch {
+	case len(key) == 0 && it.options.Reverse:
+		it.key, it.value = it.cursor.Last()
+	case len(key) == 0:
+		it.key, it.value = it.cursor.First()
+	case it.options.Reverse:
+		it.key, it.value = it.cursor.Seek(key)
+		if it.key == nil {
+			it.key, it.value = it.cursor.Last()
+		}
+		if it.key != nil && bytes.Compare(it.key, key) > 0 {
+			it.key, it.value = it.cursor.Prev()
+		}
+	default:
+		it.key, it.value = it.cursor.Seek(key)
+	}
+}
+
+func (it *boltIterator) Valid() bool {
+	return it.key != nil && bytes.HasPrefix(it.key, it.options.Prefix)
+}
+
+func (it *boltIterator) ValidForPrefix(prefix []byte) bool {
+	return it.Valid() && bytes.HasPrefix(it.key, prefix)
+}
+
+func (it *boltIterator) Next() {
+	if it.key == nil {
+		return
+	}
+	if it.writes != it.txn.writes {
+		current := append([]byte(nil), it.key...)
+		it.Seek(current)
+		if it.key == nil || !bytes.Equal(it.key, current) {
+			// the current entry was deleted, so the seek moved past it
+			return
+		}
+	}
+	if it.options.Reverse {
+		it.key, it.value = it.cursor.Prev()
+	} else {
+		it.key, it.value = it.cursor.Next()
+	}
+}
+
+func (it *boltIterator) Item() kvItem {
+	return boltItem{key: it.key, value: it.value}
+}
+
+func (it *boltIterator) Close() {}
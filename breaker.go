@@ -0,0 +1,88 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/zeebo/errs"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned without trying the operation while the circuit
+// breaker is open, and for transactions still conflicting after the retries.
+var ErrOverloaded = errs.Class("store overloaded")
+
+// breakerWindow is the number of recent operations the breaker evaluates.
+var breakerWindow = 100
+
+// breaker fails operations fast for a cool-down period when too many of the
+// recent operations failed or the recent operations were too slow.
+type breaker struct {
+	maxErrorRate float64
+	maxLatency   time.Duration
+	cooldown     time.Duration
+
+	mu        sync.Mutex
+	count     int
+	failures  int
+	latency   time.Duration
+	openUntil time.Time
+}
+
+func newBreaker(config Config) *breaker {
+	if config.BreakerErrorRate <= 0 && config.BreakerLatency <= 0 {
+		return nil
+	}
+	return &breaker{
+		maxErrorRate: config.BreakerErrorRate,
+		maxLatency:   config.BreakerLatency,
+		cooldown:     config.BreakerCooldown,
+	}
+}
+
+// allow returns ErrOverloaded while the breaker is open.
+func (c *breaker) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until := c.openUntil; time.Now().Before(until) {
+		mon.Counter("breaker_rejected").Inc(1)
+		return ErrOverloaded.New("retry after %s", until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// record adds the result of an operation, opening the breaker when the
+// window of operations is over the thresholds.
+func (c *breaker) record(latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	c.latency += latency
+	if err != nil && !ErrNotExist.Has(err) && !ErrRange.Has(err) && !errors.Is(err, context.Canceled) {
+		c.failures++
+	}
+	if c.count < breakerWindow {
+		return
+	}
+	errorRate := float64(c.failures) / float64(c.count)
+	average := c.latency / time.Duration(c.count)
+	if (c.maxErrorRate > 0 && errorRate > c.maxErrorRate) || (c.maxLatency > 0 && average > c.maxLatency) {
+		mon.Counter("breaker_opened").Inc(1)
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+	c.count, c.failures, c.latency = 0, 0, 0
+}
+
+// guard runs op through the circuit breaker, if it's enabled.
+func (b *BlobStore) guard(op func() error) error {
+	if b.breaker == nil {
+		return op()
+	}
+	if err := b.breaker.allow(); err != nil {
+		return err
+	}
+	started := time.Now()
+	err := op()
+	b.breaker.record(time.Since(started), err)
+	return err
+}
@@ -0,0 +1,69 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestBreakerConditions(t *testing.T) {
+	defer func(window int) { breakerWindow = window }(breakerWindow)
+	breakerWindow = 4
+
+	c := &breaker{maxErrorRate: 0.5, maxLatency: time.Second, cooldown: time.Hour}
+
+	// not found and canceled operations are not failures
+	for i := 0; i < 4; i++ {
+		c.record(0, errNotExist("key"))
+	}
+	c.record(0, context.Canceled)
+	c.record(0, errors.New("failure"))
+	c.record(0, nil)
+	c.record(0, nil)
+	require.NoError(t, c.allow())
+
+	// more than half failed
+	for i := 0; i < 3; i++ {
+		c.record(0, errors.New("failure"))
+	}
+	c.record(0, nil)
+	require.True(t, ErrOverloaded.Has(c.allow()))
+
+	c.openUntil = time.Time{}
+	for i := 0; i < 4; i++ {
+		c.record(2*time.Second, nil)
+	}
+	require.True(t, ErrOverloaded.Has(c.allow()))
+
+	c.cooldown = 0
+	for i := 0; i < 4; i++ {
+		c.record(2*time.Second, nil)
+	}
+	require.NoError(t, c.allow())
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(window int) { breakerWindow = window }(breakerWindow)
+	breakerWindow = 2
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{BreakerLatency: time.Nanosecond, BreakerCooldown: time.Hour})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// every operation is slower than a nanosecond
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "data"))
+	_, err = store.Open(ctx, ref("ns", "missing"))
+	require.True(t, ErrNotExist.Has(err))
+
+	_, err = store.Open(ctx, ref("ns", "key1"))
+	require.True(t, ErrOverloaded.Has(err))
+	_, err = store.Create(ctx, ref("ns", "key2"))
+	require.True(t, ErrOverloaded.Has(err))
+	require.True(t, ErrOverloaded.Has(store.Delete(ctx, ref("ns", "key1"))))
+}
@@ -0,0 +1,113 @@
+package badger
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// readCache is an LRU cache of blob contents bounded by the total size of
+// the cached blobs.
+type readCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+
+	// generation changes with every invalidation. Values read before an
+	// invalidation are not cached, as they may be stale already.
+	generation uint64
+}
+
+type cacheEntry struct {
+	key    string
+	data   []byte
+	format blobstore.FormatVersion
+}
+
+func newReadCache(capacity int64) *readCache {
+	return &readCache{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func cacheKey(ref blobstore.BlobRef) string {
+	return string(binary.AppendUvarint(nil, uint64(len(ref.Namespace)))) + string(ref.Namespace) + string(ref.Key)
+}
+
+// get returns the cached data and storage format of the blob. The data must
+// not be modified.
+func (c *readCache) get(ref blobstore.BlobRef) ([]byte, blobstore.FormatVersion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[cacheKey(ref)]
+	if !ok {
+		mon.Counter("read_cache_misses").Inc(1)
+		return nil, 0, false
+	}
+	mon.Counter("read_cache_hits").Inc(1)
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	return entry.data, entry.format, true
+}
+
+// currentGeneration returns the generation to pass to put for data read afterwards.
+func (c *readCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches data read while the cache was at the given generation. It
+// returns whether the data was stored, in which case it must not be modified.
+func (c *readCache) put(ref blobstore.BlobRef, data []byte, format blobstore.FormatVersion, generation uint64) bool {
+	if int64(len(data)) > c.capacity {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return false
+	}
+	key := cacheKey(ref)
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data, format: format})
+	c.size += int64(len(data))
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return true
+}
+
+// invalidate drops the blob from the cache.
+func (c *readCache) invalidate(ref blobstore.BlobRef) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if elem, ok := c.entries[cacheKey(ref)]; ok {
+		c.remove(elem)
+	}
+}
+
+// clear drops all the cached blobs.
+func (c *readCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *readCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data))
+}
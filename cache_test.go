@@ -0,0 +1,65 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestReadCache(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{ReadCache: 10 * memory.B})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	read := func(key string) string {
+		reader, err := store.Open(ctx, ref("ns", key))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		return string(content)
+	}
+
+	hits, misses := counterValue("read_cache_hits"), counterValue("read_cache_misses")
+	requireCounters := func(newHits, newMisses int64) {
+		require.Equal(t, hits+newHits, counterValue("read_cache_hits"))
+		require.Equal(t, misses+newMisses, counterValue("read_cache_misses"))
+	}
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "12345"))
+	require.Equal(t, "12345", read("key1"))
+	require.Equal(t, "12345", read("key1"))
+	require.Equal(t, "12345", read("key1"))
+	requireCounters(2, 1)
+
+	// commits replace the cached version
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "abcde"))
+	require.Equal(t, "abcde", read("key1"))
+	require.Equal(t, "abcde", read("key1"))
+	requireCounters(3, 2)
+
+	// the least recently used blob is evicted when the cache is full
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "123"))
+	require.NoError(t, save(ctx, store, ref("ns", "key3"), "1234"))
+	require.Equal(t, "123", read("key2"))
+	require.Equal(t, "abcde", read("key1"))
+	require.Equal(t, "1234", read("key3"))
+	requireCounters(4, 4)
+	require.Equal(t, "abcde", read("key1"))
+	require.Equal(t, "123", read("key2"))
+	requireCounters(5, 5)
+
+	// deleted and trashed blobs are not served from the cache
+	require.NoError(t, store.Delete(ctx, ref("ns", "key1")))
+	_, err = store.Open(ctx, ref("ns", "key1"))
+	require.Error(t, err)
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	_, err = store.Open(ctx, ref("ns", "key2"))
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package badger
+
+import (
+	"github.com/zeebo/errs"
+	"math/rand"
+)
+
+// ErrCorrupt is returned when the store contains data which can't be decoded.
+var ErrCorrupt = errs.Class("corrupt store")
+
+// quickCheckSamples is the number of blobs checked per namespace by quickCheck.
+const quickCheckSamples = 8
+
+// quickCheck reads the meta record and a few randomly chosen blobs of each
+// namespace, verifying that the keys can be decoded and the value size matches
+// the size recorded in the key.
+func (b *BlobStore) quickCheck() error {
+	return b.db.View(func(txn kvTxn) error {
+		if _, _, err := readMeta(txn); err != nil {
+			return ErrCorrupt.Wrap(err)
+		}
+		for _, namespace := range b.knownNamespaces() {
+			if err := checkNamespaceSample(txn, namespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func checkNamespaceSample(txn kvTxn, namespace []byte) error {
+	prefix := concat(blobPrefix, namespace)
+	it := txn.NewIterator(iteratorOptions(IteratorWalk, prefix))
+	defer it.Close()
+
+	seek := make([]byte, len(prefix)+8)
+	copy(seek, prefix)
+	for i := 0; i < quickCheckSamples; i++ {
+		_, _ = rand.Read(seek[len(prefix):])
+		it.Seek(seek)
+		if !it.ValidForPrefix(prefix) {
+			it.Rewind()
+		}
+		if !it.ValidForPrefix(prefix) {
+			return nil
+		}
+		if err := checkItem(txn, it.Item(), len(prefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkItem(txn kvTxn, item kvItem, prefixLen int) error {
+	key := item.KeyCopy(nil)
+	if len(key) <= prefixLen+16 {
+		return ErrCorrupt.New("key %x is too short", key)
+	}
+	_, size := stat(key)
+	if item.UserMeta()&userMetaCold != 0 {
+		return nil
+	}
+	return blobValue(txn, item, func(val []byte) error {
+		if len(val) != size {
+			return ErrCorrupt.New("blob %x has %d bytes, key records %d", key, len(val), size)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,45 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestQuickCheck(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	config := Config{QuickCheck: true}
+
+	store, err := NewBlobStoreWithConfig(dir, config)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "1234567890"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "1234"))
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStoreWithConfig(dir, config)
+	require.NoError(t, err)
+
+	// a blob whose value doesn't match the size recorded in the key
+	corrupted := ref("ns3", "key1")
+	err = store.db.Update(func(txn kvTxn) error {
+		if err := setNamespaceMarker(txn, corrupted.Namespace); err != nil {
+			return err
+		}
+		return txn.Set(key(corrupted, time.Now(), 100), []byte("123"))
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	_, err = NewBlobStoreWithConfig(dir, config)
+	require.Error(t, err)
+	require.True(t, ErrCorrupt.Has(err))
+
+	// without the check the store still opens
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+}
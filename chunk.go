@@ -0,0 +1,279 @@
+package badger
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"storj.io/common/memory"
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// chunkPrefix keys hold the chunks of the blobs stored in chunks, addressed
+// by the ID of the chunks and their index.
+var chunkPrefix = []byte("chunk")
+
+// userMetaChunked marks blob entries whose value points to their chunks.
+const userMetaChunked byte = 0b10000
+
+// minChunkSize is the smallest Config.ChunkSize: smaller chunks cost more
+// lookups than the prefetching saves.
+const minChunkSize = 64 * memory.KiB
+
+// defaultChunkReadAhead is the number of chunks read ahead without
+// Config.ChunkReadAhead.
+const defaultChunkReadAhead = 4
+
+// checkChunks checks the chunk settings of the config against the largest
+// value of the engine.
+func checkChunks(config Config, maxValueSize int64) error {
+	switch {
+	case config.ChunkSize < 0 || (config.ChunkSize > 0 && config.ChunkSize < minChunkSize):
+		return ErrConfig.New("chunk size %s is below %s", config.ChunkSize, minChunkSize)
+	case config.ChunkSize.Int64() > maxValueSize:
+		return ErrConfig.New("chunk size %s is above the %s the engine stores", config.ChunkSize, memory.Size(maxValueSize))
+	case config.ChunkReadAhead < 0:
+		return ErrConfig.New("chunk read ahead %d is negative", config.ChunkReadAhead)
+	}
+	return nil
+}
+
+// chunkPointer is the value of the chunked entries: the ID of their chunks,
+// the size of the stored value and the size of the chunks, all full but the
+// last one. The chunks go with the pointer when the entry is moved, like to
+// the trash.
+type chunkPointer struct {
+	id        [16]byte
+	size      int64
+	chunkSize int64
+}
+
+const chunkPointerSize = 32
+
+func (p chunkPointer) encode() []byte {
+	raw := append(make([]byte, 0, chunkPointerSize), p.id[:]...)
+	raw = binary.BigEndian.AppendUint64(raw, uint64(p.size))
+	return binary.BigEndian.AppendUint64(raw, uint64(p.chunkSize))
+}
+
+func decodeChunkPointer(raw []byte) (p chunkPointer, err error) {
+	if len(raw) != chunkPointerSize {
+		return p, ErrCorrupt.New("chunk pointer has %d bytes", len(raw))
+	}
+	copy(p.id[:], raw)
+	p.size = int64(binary.BigEndian.Uint64(raw[16:]))
+	p.chunkSize = int64(binary.BigEndian.Uint64(raw[24:]))
+	if p.size < 0 || p.chunkSize <= 0 {
+		return p, ErrCorrupt.New("chunk pointer of %d bytes in %d byte chunks", p.size, p.chunkSize)
+	}
+	return p, nil
+}
+
+// chunks returns the number of chunks.
+func (p chunkPointer) chunks() int {
+	return int((p.size + p.chunkSize - 1) / p.chunkSize)
+}
+
+// chunkLength returns the size of the chunk.
+func (p chunkPointer) chunkLength(index int) int64 {
+	start := int64(index) * p.chunkSize
+	if p.size-start < p.chunkSize {
+		return p.size - start
+	}
+	return p.chunkSize
+}
+
+func (p chunkPointer) chunkKey(index int) []byte {
+	return binary.BigEndian.AppendUint32(concat(chunkPrefix, p.id[:]), uint32(index))
+}
+
+// keys returns the keys of all the chunks.
+func (p chunkPointer) keys() [][]byte {
+	keys := make([][]byte, p.chunks())
+	for i := range keys {
+		keys[i] = p.chunkKey(i)
+	}
+	return keys
+}
+
+// readChunkPointer returns the pointer of a chunked entry.
+func readChunkPointer(item kvItem) (chunkPointer, error) {
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return chunkPointer{}, errs.Wrap(err)
+	}
+	return decodeChunkPointer(raw)
+}
+
+// setBlob stores the value of a blob entry, deduplicated, or split into
+// chunks of chunkSize if it's larger, or as a single value. The entry gets
+// the userMeta bits besides the ones of the way it's stored.
+func setBlob(txn kvTxn, key, value []byte, userMeta byte, dedup bool, chunkSize int64) error {
+	userMeta &^= userMetaDedup | userMetaChunked
+	switch {
+	case dedup:
+		return setDeduplicated(txn, key, value, userMeta)
+	case chunkSize > 0 && int64(len(value)) > chunkSize:
+		return setChunked(txn, key, value, userMeta, chunkSize)
+	}
+	return txn.SetWithMeta(key, value, userMeta)
+}
+
+// setChunked stores the value in chunks and points blobKey to them.
+func setChunked(txn kvTxn, blobKey, value []byte, userMeta byte, chunkSize int64) error {
+	p := chunkPointer{size: int64(len(value)), chunkSize: chunkSize}
+	if _, err := rand.Read(p.id[:]); err != nil {
+		return errs.Wrap(err)
+	}
+	for i := 0; i < p.chunks(); i++ {
+		start := int64(i) * chunkSize
+		if err := txn.Set(p.chunkKey(i), value[start:start+p.chunkLength(i)]); err != nil {
+			return err
+		}
+	}
+	return txn.SetWithMeta(blobKey, p.encode(), userMeta|userMetaChunked)
+}
+
+// releaseChunks deletes the chunks of a chunked entry which is removed or
+// replaced.
+func releaseChunks(txn kvTxn, item kvItem) error {
+	if item.UserMeta()&userMetaChunked == 0 {
+		return nil
+	}
+	p, err := readChunkPointer(item)
+	if err != nil {
+		return err
+	}
+	for _, key := range p.keys() {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readChunk calls fn with the data of the chunk. Missing chunks return
+// errKeyNotFound, as the entry may have been removed since its pointer was
+// read.
+func readChunk(txn kvTxn, p chunkPointer, index int, fn func(val []byte) error) error {
+	item, err := txn.Get(p.chunkKey(index))
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return item.Value(func(val []byte) error {
+		if int64(len(val)) != p.chunkLength(index) {
+			return ErrCorrupt.New("chunk %d of %x has %d bytes instead of %d", index, p.id, len(val), p.chunkLength(index))
+		}
+		return fn(val)
+	})
+}
+
+// chunkedValue calls fn with the stored value of a chunked entry, joining
+// its chunks.
+func chunkedValue(txn kvTxn, item kvItem, fn func(val []byte) error) error {
+	p, err := readChunkPointer(item)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 0, p.size)
+	for i := 0; i < p.chunks(); i++ {
+		err := readChunk(txn, p, i, func(val []byte) error {
+			value = append(value, val...)
+			return nil
+		})
+		if errs.Is(err, errKeyNotFound) {
+			return ErrCorrupt.New("missing chunk %d of %x", i, p.id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return fn(value)
+}
+
+// chunkedRange returns length bytes of the blob data of a chunked entry at
+// offset, reading only the chunks holding them.
+func chunkedRange(txn kvTxn, item kvItem, offset, length int64) ([]byte, error) {
+	p, err := readChunkPointer(item)
+	if err != nil {
+		return nil, err
+	}
+	chunk := func(index int) (data []byte, err error) {
+		err = readChunk(txn, p, index, func(val []byte) error {
+			data = append([]byte(nil), val...)
+			return nil
+		})
+		if errs.Is(err, errKeyNotFound) {
+			return nil, ErrCorrupt.New("missing chunk %d of %x", index, p.id)
+		}
+		return data, err
+	}
+	header, err := chunkedHeader(storedFormat(item.UserMeta()), p, chunk)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	return data, readBlobAt(header, p, data, offset, chunk)
+}
+
+// readStoredAt reads the stored value of a chunked entry at off into buf,
+// calling chunk for the data of the chunks it needs. It returns the number
+// of bytes read, fewer than len(buf) only at the end of the value.
+func readStoredAt(p chunkPointer, buf []byte, off int64, chunk func(index int) ([]byte, error)) (n int, err error) {
+	for n < len(buf) && off < p.size {
+		index := int(off / p.chunkSize)
+		data, err := chunk(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(buf[n:], data[off-int64(index)*p.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// chunkedHeader returns the framed piece header at the start of the stored
+// value of a chunked FormatV2 entry, nil for the other formats.
+func chunkedHeader(format blobstore.FormatVersion, p chunkPointer, chunk func(index int) ([]byte, error)) ([]byte, error) {
+	if format != FormatV2 {
+		return nil, nil
+	}
+	first, err := chunk(0)
+	if err != nil {
+		return nil, err
+	}
+	header, ok := framedHeader(first)
+	if !ok {
+		return nil, ErrCorrupt.New("invalid v2 value in chunks of %x", p.id)
+	}
+	return append([]byte(nil), header...), nil
+}
+
+// readBlobAt reads the blob data of a chunked entry at off into buf, which
+// has to fit in the blob. The stored value of FormatV2 blobs has their
+// header without its padding, so the reads of the header area are served
+// from the header, and the ones after it are shifted by the dropped padding.
+func readBlobAt(header []byte, p chunkPointer, buf []byte, off int64, chunk func(index int) ([]byte, error)) error {
+	for len(buf) > 0 && header != nil && off < pieceHeaderArea {
+		n := len(buf)
+		if int64(n) > pieceHeaderArea-off {
+			n = int(pieceHeaderArea - off)
+		}
+		copied := 0
+		if off < int64(len(header)) {
+			copied = copy(buf[:n], header[off:])
+		}
+		for i := range buf[copied:n] {
+			buf[copied+i] = 0
+		}
+		buf, off = buf[n:], off+int64(n)
+	}
+	if header != nil {
+		off += int64(len(header)) - pieceHeaderArea
+	}
+	n, err := readStoredAt(p, buf, off, chunk)
+	if err == nil && n < len(buf) {
+		err = ErrCorrupt.New("chunks of %x end at %d", p.id, off+int64(n))
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkedBlobs(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine, ChunkSize: minChunkSize})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		data := string(testrand.BytesInt(5*minChunkSize.Int() + 100))
+		require.NoError(t, save(ctx, store, ref("ns", "large"), data))
+		require.NoError(t, save(ctx, store, ref("ns", "small"), "small"))
+		require.Equal(t, 6, chunkKeys(t, store))
+		requireRead(t, ctx, store, ref("ns", "large"), data)
+		requireRead(t, ctx, store, ref("ns", "small"), "small")
+
+		// the ranges read only their chunks
+		offset := minChunkSize.Int64() - 10
+		part, err := store.ReadRange(ctx, ref("ns", "large"), offset, minChunkSize.Int64())
+		require.NoError(t, err)
+		require.Equal(t, data[offset:offset+minChunkSize.Int64()], string(part))
+
+		// the chunks go to the trash and back with the blob
+		require.NoError(t, store.Trash(ctx, ref("ns", "large"), time.Now()))
+		_, err = store.RestoreTrash(ctx, []byte("ns"))
+		require.NoError(t, err)
+		requireRead(t, ctx, store, ref("ns", "large"), data)
+
+		// and are removed with it, also when it's replaced
+		require.NoError(t, save(ctx, store, ref("ns", "large"), data[:minChunkSize.Int()+1]))
+		require.Equal(t, 2, chunkKeys(t, store))
+		require.NoError(t, store.Delete(ctx, ref("ns", "large")))
+		require.Zero(t, chunkKeys(t, store))
+
+		_, err = NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{Engine: engine, ChunkSize: memory.KiB})
+		require.True(t, ErrConfig.Has(err))
+	})
+}
+
+func TestChunkedReadAhead(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine, ChunkSize: minChunkSize, ChunkReadAhead: 2})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		data := testrand.BytesInt(6 * minChunkSize.Int())
+		require.NoError(t, save(ctx, store, ref("ns", "key"), string(data)))
+		opened, err := store.Open(ctx, ref("ns", "key"))
+		require.NoError(t, err)
+		defer ctx.Check(opened.Close)
+		r := opened.(*reader)
+
+		// reading from the start reads the next chunks ahead
+		buf := make([]byte, 1000)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, data[:1000], buf)
+		require.Len(t, r.fetches, 2)
+		require.Contains(t, r.fetches, 1)
+		require.Contains(t, r.fetches, 2)
+
+		// and keeps ahead while the reads are sequential
+		buf = make([]byte, minChunkSize.Int())
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, data[1000:1000+minChunkSize.Int()], buf)
+		require.Len(t, r.fetches, 2)
+		require.Contains(t, r.fetches, 3)
+
+		// jumps elsewhere drop them
+		n, err := r.ReadAt(buf, 5*minChunkSize.Int64())
+		require.NoError(t, err)
+		require.Equal(t, minChunkSize.Int(), n)
+		require.Equal(t, data[5*minChunkSize.Int():], buf)
+		require.Empty(t, r.fetches)
+
+		_, err = r.Seek(0, 0)
+		require.NoError(t, err)
+		all, err := rall(r)
+		require.NoError(t, err)
+		require.Equal(t, data, all)
+
+		// closing waits for the reads ahead
+		opened, err = store.Open(ctx, ref("ns", "key"))
+		require.NoError(t, err)
+		r = opened.(*reader)
+		_, err = r.Read(buf[:1000])
+		require.NoError(t, err)
+		fetches := r.fetches
+		require.Len(t, fetches, 2)
+		require.NoError(t, r.Close())
+		for _, fetch := range fetches {
+			select {
+			case <-fetch.done:
+			default:
+				t.Fatal("read ahead running after Close")
+			}
+		}
+	})
+}
+
+func TestChunkedFormats(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine, ChunkSize: minChunkSize, StorageFormat: int(FormatV2)})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		// the header of FormatV2 blobs is read without its padding
+		data := pieceData("header", strings.Repeat("content", minChunkSize.Int()/3))
+		require.NoError(t, save(ctx, store, ref("ns", "v2"), data))
+		requireRead(t, ctx, store, ref("ns", "v2"), data)
+		for _, offset := range []int64{0, 5, pieceHeaderArea - 3, pieceHeaderArea, minChunkSize.Int64()} {
+			part, err := store.ReadRange(ctx, ref("ns", "v2"), offset, 100)
+			require.NoError(t, err)
+			require.Equal(t, data[offset:offset+100], string(part))
+		}
+
+		// migrated blobs stay chunked
+		v1 := string(testrand.BytesInt(2 * minChunkSize.Int()))
+		w, err := store.CreateWithStorageFormat(ctx, ref("ns", "v1"), filestore.FormatV1)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(v1))
+		require.NoError(t, err)
+		require.NoError(t, w.Commit(ctx))
+		chunks := chunkKeys(t, store)
+		_, err = store.MigrateFormat(ctx, FormatV2, filestore.FormatV1)
+		require.NoError(t, err)
+		requireRead(t, ctx, store, ref("ns", "v2"), data)
+		requireRead(t, ctx, store, ref("ns", "v1"), v1)
+		require.Equal(t, chunks, chunkKeys(t, store))
+		err = store.ViewRecords(ctx, func(view *RecordView) error {
+			return view.Blobs(ctx, []byte("ns"), func(record BlobRecord) error {
+				require.True(t, record.Chunked)
+				require.Equal(t, filestore.FormatV1, record.Format)
+				return nil
+			})
+		})
+		require.NoError(t, err)
+	})
+}
+
+func requireRead(t *testing.T, ctx *testcontext.Context, store *BlobStore, ref blobstore.BlobRef, expected string) {
+	r, err := store.Open(ctx, ref)
+	require.NoError(t, err)
+	data, err := rall(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, expected, string(data))
+}
+
+func chunkKeys(t *testing.T, store *BlobStore) (count int) {
+	require.NoError(t, store.db.View(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: chunkPrefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	}))
+	return count
+}
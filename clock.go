@@ -0,0 +1,30 @@
+package badger
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the modification time of committed blobs.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SetClock replaces the clock used for the blobs committed by writers created
+// afterwards, and for the trash times and expiries. It's meant for tests
+// needing deterministic times.
+func (b *BlobStore) SetClock(clock Clock) {
+	b.clock = clock
+}
+
+// ModTimeCommitter is implemented by the writers of BlobStore. Migrations use
+// it to keep the original modification time of the blobs they copy.
+type ModTimeCommitter interface {
+	CommitWithModTime(ctx context.Context, modTime time.Time) error
+}
+
+var _ ModTimeCommitter = &writer{}
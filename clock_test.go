@@ -0,0 +1,45 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestModTime(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	requireModTime := func(key string, expected time.Time) {
+		err := store.db.View(func(txn kvTxn) error {
+			versions := blobVersions(txn, keyPrefix(ref("ns", key)))
+			require.Len(t, versions, 1)
+			modTime, _ := stat(versions[0].key)
+			require.Equal(t, expected.Unix(), modTime.Unix())
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store.SetClock(fixedClock(now))
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "data"))
+	requireModTime("key1", now)
+
+	original := now.Add(-24 * time.Hour)
+	w, err := store.Create(ctx, ref("ns", "key2"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.(ModTimeCommitter).CommitWithModTime(ctx, original))
+	requireModTime("key2", original)
+}
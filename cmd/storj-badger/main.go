@@ -0,0 +1,404 @@
+// storj-badger provides the commands to run on a badger blob store outside of
+// the storagenode process.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	badger "github.com/elek/storj-badger-storage"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"storj.io/common/identity"
+	"storj.io/common/memory"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"storj.io/storj/storagenode/pieces/lazyfilewalker"
+	"strings"
+	"time"
+)
+
+const (
+	migrateFormatCmdName = "migrate-format"
+	inventoryCmdName     = "inventory"
+	syncServeCmdName     = "sync-serve"
+	syncCmdName          = "sync"
+	readOnlyCmdName      = "read-only"
+	benchmarkCmdName     = "benchmark"
+	soakCmdName          = "soak"
+	setupCmdName         = "setup"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var err error
+	switch command := os.Args[1]; command {
+	case lazyfilewalker.UsedSpaceFilewalkerCmdName, lazyfilewalker.GCFilewalkerCmdName, lazyfilewalker.TrashCleanupFilewalkerCmdName:
+		err = runLazyFilewalker(ctx, command, os.Args[2:])
+	case migrateFormatCmdName:
+		err = runMigrateFormat(ctx, os.Args[2:])
+	case inventoryCmdName:
+		err = runInventory(ctx, os.Args[2:])
+	case syncServeCmdName:
+		err = runSyncServe(ctx, os.Args[2:])
+	case syncCmdName:
+		err = runSync(ctx, os.Args[2:])
+	case readOnlyCmdName:
+		err = runReadOnly(os.Args[2:])
+	case benchmarkCmdName:
+		err = runBenchmark(ctx, os.Args[2:])
+	case soakCmdName:
+		err = runSoak(ctx, os.Args[2:])
+	case setupCmdName:
+		err = runSetup(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, command := range []string{
+		lazyfilewalker.UsedSpaceFilewalkerCmdName,
+		lazyfilewalker.GCFilewalkerCmdName,
+		lazyfilewalker.TrashCleanupFilewalkerCmdName,
+		migrateFormatCmdName,
+		inventoryCmdName,
+		syncServeCmdName,
+		syncCmdName,
+		readOnlyCmdName,
+		benchmarkCmdName,
+		soakCmdName,
+		setupCmdName,
+	} {
+		fmt.Fprintf(os.Stderr, "  %s\n", command)
+	}
+}
+
+// runLazyFilewalker serves a lazy filewalker subprocess request. It accepts
+// the flags the storagenode passes to the subprocess, but only uses the
+// pieces directory: the request is forwarded to the storagenode holding the
+// store, which serves it with LazyFilewalker enabled.
+func runLazyFilewalker(ctx context.Context, command string, args []string) (err error) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	for _, ignored := range []string{"storage", "info", "info2", "driver", "cache", "filestore.write-buffer-size", "log.output", "log.encoding"} {
+		flags.String(ignored, "", "ignored")
+	}
+	for _, ignored := range []string{"filestore.force-sync", "lower-io-priority"} {
+		flags.Bool(ignored, false, "ignored")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" {
+		return errs.New("--pieces is required")
+	}
+
+	return badger.ForwardLazyFilewalker(ctx, badger.LazyFilewalkerSocket(*pieces), command, os.Stdin, os.Stdout)
+}
+
+// runMigrateFormat migrates the blobs of the store to another storage format.
+// Interrupting it pauses the migration, running it again resumes it.
+func runMigrateFormat(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(migrateFormatCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	from := flags.Int("from", 1, "storage format to migrate the blobs from")
+	to := flags.Int("to", 2, "storage format to migrate the blobs to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" {
+		return errs.New("--pieces is required")
+	}
+
+	store, err := badger.NewBlobStore(*pieces)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, store.Close()) }()
+
+	progress, err := store.MigrateFormat(ctx, blobstore.FormatVersion(*from), blobstore.FormatVersion(*to))
+	fmt.Printf("migrated %d blobs, skipped %d\n", progress.Migrated, progress.Skipped)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("paused, run the command again to resume")
+	}
+	return err
+}
+
+// runInventory writes the inventory of the store to the standard output.
+func runInventory(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(inventoryCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	format := flags.String("format", string(badger.InventoryCSV), "output format, csv or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" {
+		return errs.New("--pieces is required")
+	}
+
+	store, err := badger.NewBlobStore(*pieces)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, store.Close()) }()
+
+	return store.WriteInventory(ctx, os.Stdout, badger.InventoryFormat(*format))
+}
+
+// runSyncServe serves the blobs of the store to sync commands until it's
+// interrupted. Only the sync commands with an allowed node identity are
+// served.
+func runSyncServe(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(syncServeCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	address := flags.String("listen", "127.0.0.1:7778", "address to listen on")
+	identityDir := flags.String("identity-dir", "", "directory of the node identity")
+	allow := flags.String("allow", "", "comma separated node IDs of the sync commands to serve")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" || *identityDir == "" || *allow == "" {
+		return errs.New("--pieces, --identity-dir and --allow are required")
+	}
+
+	ident, err := loadIdentity(*identityDir)
+	if err != nil {
+		return err
+	}
+	var clients []storj.NodeID
+	for _, id := range strings.Split(*allow, ",") {
+		client, err := storj.NodeIDFromString(strings.TrimSpace(id))
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+	}
+	config, err := badger.SyncServerTLS(ident, clients...)
+	if err != nil {
+		return err
+	}
+
+	store, err := badger.NewBlobStore(*pieces)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, store.Close()) }()
+
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		return err
+	}
+	return store.ServeSync(ctx, listener, config)
+}
+
+// runSync copies the missing and changed blobs of a store served with
+// sync-serve into the local store.
+func runSync(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(syncCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	remote := flags.String("remote", "", "address of the sync-serve command")
+	remoteID := flags.String("remote-id", "", "node ID of the sync-serve command")
+	identityDir := flags.String("identity-dir", "", "directory of the node identity")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" || *remote == "" || *remoteID == "" || *identityDir == "" {
+		return errs.New("--pieces, --remote, --remote-id and --identity-dir are required")
+	}
+
+	ident, err := loadIdentity(*identityDir)
+	if err != nil {
+		return err
+	}
+	server, err := storj.NodeIDFromString(*remoteID)
+	if err != nil {
+		return err
+	}
+	config, err := badger.SyncClientTLS(ident, server)
+	if err != nil {
+		return err
+	}
+
+	store, err := badger.NewBlobStore(*pieces)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, store.Close()) }()
+
+	stats, err := store.Sync(ctx, *remote, config)
+	fmt.Printf("copied %d blobs (%d bytes) from %d differing key ranges in %d namespaces\n", stats.Blobs, stats.Bytes, stats.Buckets, stats.Namespaces)
+	return err
+}
+
+// loadIdentity loads the node identity of the storagenode directory layout.
+func loadIdentity(dir string) (*identity.FullIdentity, error) {
+	return identity.Config{
+		CertPath: filepath.Join(dir, "identity.cert"),
+		KeyPath:  filepath.Join(dir, "identity.key"),
+	}.Load()
+}
+
+// runReadOnly puts the store in read-only mode, or ends it with --off. It
+// doesn't open the store, so it works while the storagenode is running,
+// which picks the change up within a few seconds.
+func runReadOnly(args []string) error {
+	flags := flag.NewFlagSet(readOnlyCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store")
+	reason := flags.String("reason", "", "why the store is read-only, shown in the errors and logs")
+	off := flags.Bool("off", false, "make the store writable again")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" {
+		return errs.New("--pieces is required")
+	}
+	if *off {
+		return badger.SetWritable(*pieces)
+	}
+	return badger.SetReadOnly(*pieces, *reason)
+}
+
+// runBenchmark runs the same synthetic piece workload against a filestore
+// and a badger store, and prints the results side by side. The directories
+// should be on the same disk, so the results are comparable.
+func runBenchmark(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(benchmarkCmdName, flag.ContinueOnError)
+	filestoreDir := flags.String("filestore", "", "directory of the filestore")
+	badgerDir := flags.String("badger", "", "directory of the badger store")
+	pieces := flags.Int("pieces", 10000, "number of pieces uploaded")
+	size := flags.String("size", "256KiB", "size of the pieces")
+	concurrency := flags.Int("concurrency", 8, "number of concurrent operations")
+	reads := flags.Int("reads", 10000, "number of downloads of random pieces")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *filestoreDir == "" || *badgerDir == "" {
+		return errs.New("--filestore and --badger are required")
+	}
+	pieceSize, err := memory.ParseString(*size)
+	if err != nil {
+		return err
+	}
+	workload := badger.BenchmarkWorkload{
+		Pieces:      *pieces,
+		PieceSize:   int(pieceSize),
+		Concurrency: *concurrency,
+		Reads:       *reads,
+		Seed:        1,
+	}
+
+	files, err := filestore.NewAt(zap.NewNop(), *filestoreDir, filestore.DefaultConfig)
+	if err != nil {
+		return err
+	}
+	fileResult, err := badger.RunBenchmark(ctx, "filestore", files, *filestoreDir, workload)
+	err = errs.Combine(err, files.Close())
+	if err != nil {
+		return err
+	}
+
+	store, err := badger.NewBlobStore(*badgerDir)
+	if err != nil {
+		return err
+	}
+	badgerResult, err := badger.RunBenchmark(ctx, "badger", store, *badgerDir, workload)
+	err = errs.Combine(err, store.Close())
+	if err != nil {
+		return err
+	}
+	return badger.WriteBenchmarkReport(os.Stdout, fileResult, badgerResult)
+}
+
+// runSoak runs the soak workload on a new store until the duration passes or
+// it's interrupted, and prints what it did. It fails on the first
+// inconsistency found.
+func runSoak(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(soakCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store, which should be used only for the soak")
+	duration := flags.Duration("duration", time.Hour, "duration of the run, 0 runs until interrupted")
+	count := flags.Int("count", 10000, "number of live and trashed pieces kept")
+	size := flags.String("max-size", "256KiB", "size of the largest piece")
+	checkEvery := flags.Int64("check-every", 1000, "operations between the invariant checks")
+	gcEvery := flags.Int64("gc-every", 5000, "operations between the garbage collections")
+	compactEvery := flags.Int64("compact-every", 20000, "operations between the value log GC and compaction runs")
+	restartEvery := flags.Int64("restart-every", 50000, "operations between the restarts of the store")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the operations, to repeat a run")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" {
+		return errs.New("--pieces is required")
+	}
+	maxSize, err := memory.ParseString(*size)
+	if err != nil {
+		return err
+	}
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		return err
+	}
+	log.Info("soak started", zap.Int64("seed", *seed))
+	report, err := badger.RunSoak(ctx, log, *pieces, badger.SoakConfig{
+		Duration:     *duration,
+		Pieces:       *count,
+		MaxPieceSize: int(maxSize),
+		CheckEvery:   *checkEvery,
+		GCEvery:      *gcEvery,
+		CompactEvery: *compactEvery,
+		RestartEvery: *restartEvery,
+		Seed:         *seed,
+	})
+	fmt.Printf("%+v\n", report)
+	return err
+}
+
+// runSetup creates the badger store of a new node, bound to its identity,
+// and prints the result of its first health check.
+func runSetup(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet(setupCmdName, flag.ContinueOnError)
+	pieces := flags.String("pieces", "", "path of the badger store to create, missing or empty")
+	nodeID := flags.String("node-id", "", "ID of the node")
+	profile := flags.String("profile", badger.ProfileHDD, "configuration profile of the disk, hdd or ssd")
+	engine := flags.String("engine", badger.EngineBadger, "key-value engine of the store, badger or bolt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pieces == "" || *nodeID == "" {
+		return errs.New("--pieces and --node-id are required")
+	}
+	id, err := storj.NodeIDFromString(*nodeID)
+	if err != nil {
+		return err
+	}
+	config, err := badger.ConfigProfile(*profile)
+	if err != nil {
+		return err
+	}
+	config.Engine = *engine
+	store, health, err := badger.SetupBlobStore(ctx, zap.NewNop(), *pieces, id, config)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, store.Close()) }()
+	fmt.Printf("%+v\n", health)
+	return nil
+}
@@ -0,0 +1,72 @@
+package badger
+
+import (
+	"github.com/zeebo/errs"
+	"storj.io/common/memory"
+	"time"
+)
+
+// ErrConfig is returned for invalid configurations.
+var ErrConfig = errs.Class("invalid config")
+
+// Config is configuration for the blob store.
+type Config struct {
+	QuickCheck                bool          `help:"sample blobs of every namespace at startup to detect a corrupted store early" default:"false"`
+	LockTimeout               time.Duration `help:"how long to wait for another process to release the store directory lock" default:"0s"`
+	Dedup                     bool          `help:"store identical blobs only once, referenced by the hash of their content" default:"false"`
+	ReadCache                 memory.Size   `help:"size of the in-memory cache of recently read blobs, 0 disables it" default:"0B"`
+	WriteBuffer               memory.Size   `help:"uploads larger than this are buffered in a temporary file instead of memory, 0 disables it" default:"4MiB"`
+	BackgroundUsageScan       bool          `help:"compute missing usage counters after opening the store, reporting estimates until it's done" default:"false"`
+	UsageRecalculation        time.Duration `help:"how often to recompute the usage counters from the blob keys to correct drift, 0 disables it" default:"0s"`
+	MaintenanceWindows        string        `help:"comma separated daily time ranges (local time) to run value log GC, compaction and usage reconciliation in, like 02:00-05:00; empty disables them" default:""`
+	BackpressureLevel0Tables  int           `help:"reject new uploads while level 0 has this many tables waiting for compaction (badger stalls writes at Level0TablesStall), 0 disables it" default:"0"`
+	BackpressureCommitLatency time.Duration `help:"reject new uploads while the average commit latency is above this, 0 disables it" default:"0s"`
+	BreakerErrorRate          float64       `help:"fail operations fast for a cool-down period when more than this rate of the recent ones failed, 0 disables it" default:"0"`
+	BreakerLatency            time.Duration `help:"fail operations fast for a cool-down period when the recent ones took longer than this on average, 0 disables it" default:"0s"`
+	BreakerCooldown           time.Duration `help:"how long operations fail fast after the circuit breaker opened" default:"30s"`
+	SlowDiskReadLatency       time.Duration `help:"report the disk degraded when the p99 latency of the blob reads is over this, 0 disables it" default:"0s"`
+	SlowDiskCommitLatency     time.Duration `help:"report the disk degraded when the p99 latency of the commits is over this, 0 disables it" default:"0s"`
+	DeletionReports           string        `help:"directory to write per-namespace JSON lines reports of the trashed, emptied and forgotten blobs to, empty disables them" default:""`
+	AuditLog                  string        `help:"JSON lines file to append the deletes, trashing, restores, trash emptying and namespace removals to, with their time, blob and initiator; empty disables it" default:""`
+	StorageFormat             int           `help:"storage format of new blobs: 1 stores them as written, 2 stores the piece header without the padding of its reserved area" default:"1"`
+	BackupKey                 string        `help:"hex encoded 16, 24 or 32 byte AES key to encrypt the backups with, empty leaves them unencrypted" default:""`
+	BackupInterval            time.Duration `help:"how often to back up the store automatically, 0 disables it" default:"0s"`
+	BackupDir                 string        `help:"directory to store the scheduled backups in" default:""`
+	BackupIncrementals        int           `help:"number of incremental backups to make after every full one" default:"0"`
+	BackupRetention           int           `help:"number of full backups to keep with their incremental ones, 0 keeps all" default:"0"`
+	NamespaceSweep            time.Duration `help:"how often to remove the markers of namespaces without blobs or trash, 0 disables it" default:"0s"`
+	Compactors                int           `help:"number of concurrent badger compactions, at least 2; fewer suit small devices on HDDs, more fast NVMe servers; 0 uses badger's default of 4" default:"0"`
+	LevelBaseSize             memory.Size   `help:"target size of the base LSM level, 0 uses badger's default of 10MiB" default:"0B"`
+	LevelSizeMultiplier       int           `help:"ratio between the target sizes of consecutive LSM levels, 0 uses badger's default of 10" default:"0"`
+	Level0Tables              int           `help:"number of level 0 tables which start a compaction, 0 uses badger's default of 5" default:"0"`
+	Level0TablesStall         int           `help:"number of level 0 tables which stall the writes until compacted, 0 uses badger's default of 15" default:"0"`
+	MemoryBudget              memory.Size   `help:"memory for badger's memtables and block cache, half each; 0 uses badger's defaults" default:"0B"`
+	MemTableSize              memory.Size   `help:"size of a badger memtable, 0 derives it from the memory budget or uses badger's default of 64MiB" default:"0B"`
+	MemTables                 int           `help:"number of badger memtables absorbing write bursts until flushed, 0 uses badger's default of 5" default:"0"`
+	BloomBitsPerKey           int           `help:"bits per key of the bloom filters of the tables; more make the lookups of missing keys cheaper for more memory, 0 uses badger's default of 1% false positives (about 10)" default:"0"`
+	DeferredDeletes           bool          `help:"queue the deletes and trashing of blobs and apply them in the background, so they compete less with the live traffic" default:"false"`
+	DeleteQueueRate           int           `help:"maximum number of queued deletes applied per second, 0 applies them as fast as possible" default:"0"`
+	TableCompression          string        `help:"compression of the LSM table blocks: none, snappy, zstd or zstd:<level>; the blob data is kept in the value log and never compressed, so none saves CPU for the encrypted pieces of storagenodes; empty uses badger's default of snappy" default:""`
+	ValueLogFileSize          memory.Size   `help:"size at which a new value log file is started, between 1MiB and 2GiB; smaller files are rewritten by value log GC sooner after deletes, 0 uses badger's default of 1GiB" default:"0B"`
+	ValueLogMaxEntries        int           `help:"number of entries at which a new value log file is started, 0 uses badger's default of 1000000" default:"0"`
+	WalkSnapshotRefresh       time.Duration `help:"continue walks on a new snapshot after this long, 0 walks a single snapshot" default:"0s"`
+	Allocation                memory.Size   `help:"space allocated to the node; the space reported available is the smaller of the free space of the file system and the allocation minus the blobs and the trash, 0 reports the free space of the file system" default:"0B"`
+	SpaceWarning              float64       `help:"log a warning and raise the space_alert metric when this part of the allocation, or of the file system if it's fuller, is used, like 0.9; 0 disables it" default:"0"`
+	SpaceCritical             float64       `help:"log an error and raise the space_alert metric further when this part of the allocation or the file system is used, like 0.95; 0 disables it" default:"0"`
+	IORetries                 int           `help:"number of times a commit or read failing with a transient I/O error (EIO, timeout) is retried, 0 disables retries" default:"0"`
+	IORetryBackoff            time.Duration `help:"delay before the first retry of a transient I/O error, doubled for every further one" default:"100ms"`
+	NamespaceQuotas           string        `help:"comma separated byte quotas of satellites, like <satellite ID>=2TB; commits making a satellite use more fail" default:""`
+	AccessTracking            time.Duration `help:"record the last read of every blob, updating it only when older than this, like 24h; 0 disables the tracking" default:"0s"`
+	ColdAfter                 time.Duration `help:"move the blobs not read for this long to the cold store set with SetColdStore, requires AccessTracking; 0 disables tiering" default:"0s"`
+	TieringInterval           time.Duration `help:"how often the blobs not read for ColdAfter are moved to the cold store" default:"1h"`
+	IteratorProfiles          string        `help:"comma separated iterator settings of the operations walk (key walks), copy (reading all the blobs) and records (node records), as keys or values[:<prefetch size>], like walk=keys,copy=values:200; unset ones use walk=keys,copy=values,records=values" default:""`
+	NamespaceReadOps          int           `help:"reads per second allowed to every satellite, 0 doesn't limit them" default:"0"`
+	NamespaceReadBytes        memory.Size   `help:"bytes read per second allowed to every satellite, 0 doesn't limit them" default:"0B"`
+	NamespaceWriteOps         int           `help:"commits per second allowed to every satellite, 0 doesn't limit them" default:"0"`
+	NamespaceWriteBytes       memory.Size   `help:"bytes committed per second allowed to every satellite, 0 doesn't limit them" default:"0B"`
+	ConcurrentJobs            int           `help:"number of background jobs (GC, usage reconciliation, maintenance, backups, namespace sweeps) running at the same time, 0 doesn't limit them" default:"1"`
+	ChunkSize                 memory.Size   `help:"blobs larger than this are stored in chunks of this size, at least 64KiB, and read a chunk at a time; 0 stores every blob as a single value" default:"0B"`
+	ChunkReadAhead            int           `help:"chunks of a chunked blob read concurrently ahead of a sequential reader; 0 uses 4" default:"0"`
+	LazyFilewalker            bool          `help:"serve the walks of the lazy filewalker subprocesses on a unix socket in the store directory, as they can't open the store while the node holds it" default:"false"`
+	Engine                    string        `help:"key-value engine the store is kept in: badger, with an LSM tree and a value log for fast writes, or bolt, with a single B+tree file for fast reads; empty uses the engine of the store, and badger for new stores" default:""`
+}
@@ -0,0 +1,118 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The names of the chores in DashboardStats.Chores.
+const (
+	ChoreGC                  = "gc"
+	ChoreUsageReconciliation = "usage reconciliation"
+	ChoreNamespaceSweep      = "namespace sweep"
+	ChoreBackup              = "backup"
+	ChoreTiering             = "tiering"
+)
+
+// choreRuns are the times the background chores finished last.
+type choreRuns struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (c *choreRuns) done(chore string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		c.last = map[string]time.Time{}
+	}
+	c.last[chore] = time.Now()
+}
+
+func (c *choreRuns) lastRun(chore string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last[chore]
+}
+
+func (c *choreRuns) snapshot() map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]time.Time, len(c.last))
+	for chore, last := range c.last {
+		snapshot[chore] = last
+	}
+	return snapshot
+}
+
+// DashboardStats is what the storagenode dashboard shows about the store,
+// collected in one pass.
+type DashboardStats struct {
+	Namespaces []NamespaceStats
+	// Pieces, Bytes, TrashPieces and TrashBytes are the totals of the
+	// namespaces.
+	Pieces      int64
+	Bytes       int64
+	TrashPieces int64
+	TrashBytes  int64
+	// Chores are the times the chores finished last since the store was
+	// opened, by their names like ChoreGC. The ones which didn't run are
+	// missing.
+	Chores map[string]time.Time
+}
+
+// NamespaceStats are the pieces of a namespace.
+type NamespaceStats struct {
+	Namespace   []byte
+	Pieces      int64
+	Bytes       int64
+	TrashPieces int64
+	TrashBytes  int64
+}
+
+// DashboardStats returns the pieces of every namespace from the usage
+// counters of a single snapshot, and the last runs of the chores.
+func (b *BlobStore) DashboardStats(ctx context.Context) (stats DashboardStats, err error) {
+	if err := b.waitUsage(ctx); err != nil {
+		return stats, err
+	}
+	err = view(b.db, func(txn kvTxn) error {
+		stats = DashboardStats{}
+		for _, namespace := range readNamespaces(txn) {
+			blobs, err := namespaceUsage(txn, namespace)
+			if err != nil {
+				return err
+			}
+			trash, err := namespaceTrashUsage(txn, namespace)
+			if err != nil {
+				return err
+			}
+			stats.Namespaces = append(stats.Namespaces, NamespaceStats{
+				Namespace:   namespace,
+				Pieces:      blobs.Pieces,
+				Bytes:       blobs.Bytes,
+				TrashPieces: trash.Pieces,
+				TrashBytes:  trash.Bytes,
+			})
+			stats.Pieces += blobs.Pieces
+			stats.Bytes += blobs.Bytes
+			stats.TrashPieces += trash.Pieces
+			stats.TrashBytes += trash.Bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	sort.Slice(stats.Namespaces, func(i, j int) bool {
+		return bytes.Compare(stats.Namespaces[i].Namespace, stats.Namespaces[j].Namespace) < 0
+	})
+	stats.Chores = b.chores.snapshot()
+	if last := b.LastBackup(); !last.IsZero() {
+		stats.Chores[ChoreBackup] = last
+	}
+	return stats, nil
+}
@@ -0,0 +1,42 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestDashboardStats(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "123"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key2"), "12"))
+	require.NoError(t, store.Trash(ctx, ref("ns1", "key2"), time.Now()))
+
+	stats, err := store.DashboardStats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []NamespaceStats{
+		{Namespace: []byte("ns1"), Pieces: 1, Bytes: 3, TrashPieces: 1, TrashBytes: 2},
+		{Namespace: []byte("ns2"), Pieces: 1, Bytes: 4},
+	}, stats.Namespaces)
+	require.Equal(t, int64(2), stats.Pieces)
+	require.Equal(t, int64(7), stats.Bytes)
+	require.Equal(t, int64(1), stats.TrashPieces)
+	require.Equal(t, int64(2), stats.TrashBytes)
+	require.Empty(t, stats.Chores)
+
+	require.NoError(t, store.reclaimSpace(ctx, time.Time{}))
+	require.NoError(t, store.sweepNamespaces(ctx))
+	stats, err = store.DashboardStats(ctx)
+	require.NoError(t, err)
+	require.Len(t, stats.Chores, 2)
+	require.NotZero(t, stats.Chores[ChoreGC])
+	require.NotZero(t, stats.Chores[ChoreNamespaceSweep])
+}
@@ -0,0 +1,146 @@
+package badger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+)
+
+// contentPrefix keys hold the data of deduplicated blobs, addressed by the
+// sha256 of the data. refcountPrefix keys count the blob keys pointing to them.
+var contentPrefix = []byte("cntnt")
+var refcountPrefix = []byte("refcn")
+
+// userMetaDedup marks blob entries whose value is the hash of a content entry.
+const userMetaDedup byte = 1
+
+// setDeduplicated stores data under its hash and points blobKey to it. The
+// entry gets the userMeta bits besides userMetaDedup.
+func setDeduplicated(txn kvTxn, blobKey []byte, data []byte, userMeta byte) error {
+	hash := sha256.Sum256(data)
+	count, err := readRefcount(txn, hash[:])
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := txn.Set(concat(contentPrefix, hash[:]), data); err != nil {
+			return err
+		}
+	}
+	if err := writeRefcount(txn, hash[:], count+1); err != nil {
+		return err
+	}
+	return txn.SetWithMeta(blobKey, hash[:], userMetaDedup|userMeta)
+}
+
+// releaseBlob is called when a blob or trash entry is removed. It drops the
+// reference of deduplicated entries, deleting the content with the last one.
+func releaseBlob(txn kvTxn, item kvItem) error {
+	if item.UserMeta()&userMetaDedup == 0 {
+		return nil
+	}
+	hash, err := item.ValueCopy(nil)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	count, err := readRefcount(txn, hash)
+	if err != nil {
+		return err
+	}
+	if count > 1 {
+		return writeRefcount(txn, hash, count-1)
+	}
+	if err := txn.Delete(concat(contentPrefix, hash)); err != nil {
+		return err
+	}
+	return txn.Delete(concat(refcountPrefix, hash))
+}
+
+// removeEntry deletes a blob or trash entry, releasing its deduplicated
+// content or its chunks. The trash time goes with trash entries.
+func removeEntry(txn kvTxn, key []byte) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if err := releaseBlob(txn, item); err != nil {
+		return err
+	}
+	if err := releaseChunks(txn, item); err != nil {
+		return err
+	}
+	if err := releaseCold(txn, item); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(key, trashPrefix) {
+		if err := txn.Delete(trashTimeKey(key)); err != nil {
+			return err
+		}
+	}
+	if err := removeAccess(txn, key); err != nil {
+		return err
+	}
+	return txn.Delete(key)
+}
+
+// blobValue calls fn with the data of a blob or trash entry, following the
+// pointer of deduplicated entries and decoding the storage format.
+func blobValue(txn kvTxn, item kvItem, fn func(val []byte) error) error {
+	format := storedFormat(item.UserMeta())
+	_, size := stat(item.Key())
+	return storedValue(txn, item, func(val []byte) error {
+		data, err := decodeValue(format, val, size)
+		if err != nil {
+			return err
+		}
+		return fn(data)
+	})
+}
+
+// storedValue calls fn with the value of a blob or trash entry as stored,
+// following the pointer of deduplicated and chunked entries, without
+// decoding it.
+func storedValue(txn kvTxn, item kvItem, fn func(val []byte) error) error {
+	if item.UserMeta()&userMetaCold != 0 {
+		return ErrTiering.New("blob %x is in the cold store", item.Key())
+	}
+	if item.UserMeta()&userMetaChunked != 0 {
+		return chunkedValue(txn, item, fn)
+	}
+	if item.UserMeta()&userMetaDedup == 0 {
+		return item.Value(fn)
+	}
+	hash, err := item.ValueCopy(nil)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	content, err := txn.Get(concat(contentPrefix, hash))
+	if err != nil {
+		return ErrCorrupt.New("missing content %x of blob %x: %v", hash, item.Key(), err)
+	}
+	return content.Value(fn)
+}
+
+func readRefcount(txn kvTxn, hash []byte) (uint64, error) {
+	item, err := txn.Get(concat(refcountPrefix, hash))
+	if errs.Is(err, errKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	var count uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return ErrCorrupt.New("refcount of %x has %d bytes", hash, len(val))
+		}
+		count = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return count, err
+}
+
+func writeRefcount(txn kvTxn, hash []byte, count uint64) error {
+	return txn.Set(concat(refcountPrefix, hash), binary.BigEndian.AppendUint64(nil, count))
+}
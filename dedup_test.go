@@ -0,0 +1,93 @@
+package badger
+
+import (
+	"crypto/sha256"
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		dir := ctx.Dir("store")
+
+		// blobs written without deduplication stay readable
+		store, err := NewBlobStoreWithConfig(dir, Config{Engine: engine})
+		require.NoError(t, err)
+		require.NoError(t, save(ctx, store, ref("ns", "plain"), "plain data"))
+		require.NoError(t, store.Close())
+
+		store, err = NewBlobStoreWithConfig(dir, Config{Engine: engine, Dedup: true, QuickCheck: true})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		data := "deduplicated data"
+		hash := sha256.Sum256([]byte(data))
+		requireRefcount := func(expected uint64) {
+			err := store.db.View(func(txn kvTxn) error {
+				count, err := readRefcount(txn, hash[:])
+				require.NoError(t, err)
+				require.Equal(t, expected, count)
+				_, err = txn.Get(concat(contentPrefix, hash[:]))
+				if expected == 0 {
+					require.ErrorIs(t, err, errKeyNotFound)
+				} else {
+					require.NoError(t, err)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, save(ctx, store, ref("ns", "key1"), data))
+		require.NoError(t, save(ctx, store, ref("ns", "key2"), data))
+		require.NoError(t, save(ctx, store, ref("ns", "key3"), data))
+		requireRefcount(3)
+
+		for _, key := range []string{"plain", "key1", "key2", "key3"} {
+			reader, err := store.Open(ctx, ref("ns", key))
+			require.NoError(t, err)
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			if key == "plain" {
+				require.Equal(t, "plain data", string(content))
+			} else {
+				require.Equal(t, data, string(content))
+			}
+		}
+
+		used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+		require.NoError(t, err)
+		require.Equal(t, int64(3*len(data)+len("plain data")), used)
+
+		// overwriting a blob releases its old content
+		require.NoError(t, save(ctx, store, ref("ns", "key3"), "other data"))
+		requireRefcount(2)
+
+		require.NoError(t, store.Delete(ctx, ref("ns", "key1")))
+		requireRefcount(1)
+
+		// trashed blobs keep their reference until the trash is emptied
+		require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+		requireRefcount(1)
+
+		_, err = store.RestoreTrash(ctx, []byte("ns"))
+		require.NoError(t, err)
+		reader, err := store.Open(ctx, ref("ns", "key2"))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, data, string(content))
+
+		require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+		_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+		require.NoError(t, err)
+		requireRefcount(0)
+	})
+}
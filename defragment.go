@@ -0,0 +1,146 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"time"
+)
+
+// defragmentBatchSize and defragmentBatchBytes limit the entries rewritten
+// in one transaction, as their values are kept in memory.
+var (
+	defragmentBatchSize  = 1000
+	defragmentBatchBytes = 64 << 20
+)
+
+// defragmentFlushKey is written and dropped to flush the memtables.
+var defragmentFlushKey = []byte("dfrag")
+
+// DefragmentReport is the result of a defragmentation.
+type DefragmentReport struct {
+	// Rewritten is the number of entries copied to new value log files,
+	// RewrittenBytes the size of their values.
+	Rewritten      int64
+	RewrittenBytes int64
+	// Reclaimed is how much the value log shrank, which includes the
+	// changes of the concurrent writes. It's never negative.
+	Reclaimed int64
+}
+
+// Defragment copies every value of the value log to the end of it, leaving
+// only garbage in the old files, then compacts the LSM tree and lets value
+// log GC delete them. It runs online at low priority: after each batch it
+// waits as long as the batch took. Cancelling ctx stops it, and the copied
+// values stay where they are. The engines without a value log have nothing
+// to defragment.
+func (b *BlobStore) Defragment(ctx context.Context) (report DefragmentReport, err error) {
+	if badgerDB(b.db) == nil {
+		return report, nil
+	}
+	before, err := b.valueLogSize()
+	if err != nil {
+		return report, err
+	}
+	b.log.Info("defragmentation started", zap.Int64("value log size", before))
+	var after []byte
+	for more := true; more; {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		started := time.Now()
+		more, err = b.defragmentBatch(&after, &report)
+		if err != nil {
+			return report, err
+		}
+		timer := time.NewTimer(time.Since(started))
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	// the compaction of reclaimSpace drops the old versions, telling badger
+	// which value log files are garbage, but only the ones in tables
+	if err := b.flushMemTables(); err != nil {
+		return report, err
+	}
+	if err := b.reclaimSpace(ctx, time.Time{}); err != nil {
+		return report, err
+	}
+	current, err := b.valueLogSize()
+	if err != nil {
+		return report, err
+	}
+	if current < before {
+		report.Reclaimed = before - current
+	}
+	mon.Counter("defragment_reclaimed_bytes").Inc(report.Reclaimed)
+	b.log.Info("defragmentation finished", zap.Int64("rewritten", report.Rewritten),
+		zap.Int64("rewritten bytes", report.RewrittenBytes), zap.Int64("reclaimed", report.Reclaimed))
+	return report, nil
+}
+
+// defragmentBatch rewrites a batch of the entries after the key with their
+// values in the value log, and returns whether there may be more.
+func (b *BlobStore) defragmentBatch(after *[]byte, report *DefragmentReport) (more bool, err error) {
+	threshold := badgerDB(b.db).Opts().ValueThreshold
+	var next []byte
+	var rewritten, rewrittenBytes int64
+	err = update(b.db, func(txn kvTxn) error {
+		more, next, rewritten, rewrittenBytes = false, *after, 0, 0
+		it := txn.NewIterator(b.iteratorOptions(IteratorWalk, nil))
+		defer it.Close()
+		for it.Seek(next); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytesEq(item.Key(), *after) || item.ValueSize() <= threshold {
+				continue
+			}
+			if rewritten == int64(defragmentBatchSize) || rewrittenBytes >= int64(defragmentBatchBytes) {
+				more = true
+				break
+			}
+			key := item.KeyCopy(nil)
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if err := txn.SetWithMeta(key, value, item.UserMeta()); err != nil {
+				return err
+			}
+			next = key
+			rewritten++
+			rewrittenBytes += int64(len(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	*after = next
+	report.Rewritten += rewritten
+	report.RewrittenBytes += rewrittenBytes
+	return more, nil
+}
+
+// flushMemTables writes the memtables to level 0 tables. Badger has no API
+// for it, but dropping a prefix flushes them first, so a key is written to be
+// dropped. The writes are blocked meanwhile.
+func (b *BlobStore) flushMemTables() error {
+	err := update(b.db, func(txn kvTxn) error {
+		return txn.Set(defragmentFlushKey, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return errs.Wrap(badgerDB(b.db).DropPrefix(defragmentFlushKey))
+}
+
+// valueLogSize returns the size of the value log files on disk.
+func (b *BlobStore) valueLogSize() (size int64, err error) {
+	files, err := b.valueLogFiles()
+	for _, file := range files {
+		size += file.Size
+	}
+	return size, err
+}
@@ -0,0 +1,44 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+)
+
+func TestDefragment(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{ValueLogFileSize: memory.MiB})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	kept := string(testrand.BytesInt(100 * memory.KiB.Int()))
+	for i := 0; i < 40; i++ {
+		data := string(testrand.BytesInt(100 * memory.KiB.Int()))
+		if i%10 == 0 {
+			data = kept
+		}
+		require.NoError(t, save(ctx, store, ref("ns", fmt.Sprintf("key%d", i)), data))
+	}
+	for i := 0; i < 40; i++ {
+		if i%10 != 0 {
+			require.NoError(t, store.Delete(ctx, ref("ns", fmt.Sprintf("key%d", i))))
+		}
+	}
+
+	report, err := store.Defragment(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, report.Rewritten, int64(4))
+	require.Positive(t, report.Reclaimed)
+
+	for i := 0; i < 40; i += 10 {
+		data, err := store.ReadRange(ctx, ref("ns", fmt.Sprintf("key%d", i)), 0, int64(len(kept)))
+		require.NoError(t, err)
+		require.Equal(t, kept, string(data))
+	}
+}
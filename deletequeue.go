@@ -0,0 +1,234 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pendingDeletePrefix keys hold the queued deletes and trashing, ordered by
+// a sequence number, so they survive restarts.
+var pendingDeletePrefix = []byte("pndel")
+
+// deleteQueueBatchSize is the number of queued operations applied in one
+// transaction.
+var deleteQueueBatchSize = 100
+
+// deleteQueueRetry is how long the worker waits after a failed batch.
+var deleteQueueRetry = 10 * time.Second
+
+// queuedDelete is a queued delete or trashing of the blob versions existing
+// when it was queued. Versions committed later are kept.
+type queuedDelete struct {
+	Trash     bool      `json:"trash,omitempty"`
+	Namespace []byte    `json:"namespace"`
+	Key       []byte    `json:"key"`
+	Versions  [][]byte  `json:"versions"`
+	TrashedAt time.Time `json:"trashed_at"`
+	Expires   time.Time `json:"expires"`
+}
+
+// deleteQueue orders the queued operations and wakes up the worker.
+type deleteQueue struct {
+	next uint64
+	wake chan struct{}
+
+	// mu serializes applying the batches between the worker and the flushes
+	mu sync.Mutex
+}
+
+func pendingDeleteKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(concat(pendingDeletePrefix), seq)
+}
+
+// enqueueDelete queues the removal or trashing of the current versions of
+// the blob, and returns them.
+func (b *BlobStore) enqueueDelete(ref blobstore.BlobRef, trash bool, t trashTime) (versions []blobVersion, err error) {
+	seq := atomic.AddUint64(&b.deletes.next, 1)
+	err = b.guard(func() error {
+		return update(b.db, func(txn kvTxn) error {
+			versions = blobVersions(txn, keyPrefix(ref))
+			if len(versions) == 0 {
+				return nil
+			}
+			op := queuedDelete{
+				Trash:     trash,
+				Namespace: ref.Namespace,
+				Key:       ref.Key,
+				TrashedAt: t.trashedAt,
+				Expires:   t.expires,
+			}
+			for _, version := range versions {
+				op.Versions = append(op.Versions, version.key)
+			}
+			raw, err := json.Marshal(op)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			return txn.Set(pendingDeleteKey(seq), raw)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	mon.Counter("deletes_queued").Inc(1)
+	select {
+	case b.deletes.wake <- struct{}{}:
+	default:
+	}
+	return versions, nil
+}
+
+// FlushDeletes applies all the queued deletes and trashing, without the
+// rate limit of the background worker.
+func (b *BlobStore) FlushDeletes(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		applied, err := b.applyDeletes()
+		if err != nil || applied == 0 {
+			return err
+		}
+	}
+}
+
+// applyDeletes applies the next batch of queued operations, and returns
+// their number.
+func (b *BlobStore) applyDeletes() (applied int, err error) {
+	b.deletes.mu.Lock()
+	defer b.deletes.mu.Unlock()
+
+	var trashed, deleted []deletion
+	var invalidated []blobstore.BlobRef
+	err = b.guard(func() error {
+		return update(b.db, func(txn kvTxn) error {
+			applied, trashed, deleted, invalidated = 0, nil, nil, nil
+			it := txn.NewIterator(b.iteratorOptions(IteratorRecords, pendingDeletePrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && applied < deleteQueueBatchSize; it.Next() {
+				var op queuedDelete
+				err := it.Item().Value(func(val []byte) error {
+					return json.Unmarshal(val, &op)
+				})
+				if err != nil {
+					return ErrCorrupt.New("invalid queued delete %x: %v", it.Item().Key(), err)
+				}
+				ref := blobstore.BlobRef{Namespace: op.Namespace, Key: op.Key}
+				versions := queuedVersions(blobVersions(txn, keyPrefix(ref)), op.Versions)
+				if op.Trash {
+					moved, err := b.trashVersions(txn, ref, versions, trashTime{trashedAt: op.TrashedAt, expires: op.Expires})
+					if err != nil {
+						return err
+					}
+					trashed = append(trashed, moved...)
+				} else {
+					removed, err := deleteVersions(txn, ref, versions)
+					if err != nil {
+						return err
+					}
+					deleted = append(deleted, removed...)
+				}
+				if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+					return err
+				}
+				invalidated = append(invalidated, ref)
+				applied++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range invalidated {
+		b.invalidate(ref)
+	}
+	for _, d := range deleted {
+		b.deleted(d.ref, []deletion{d})
+	}
+	b.reportDeletions(reportTrash, trashed)
+	b.hooks.callEach(&b.hooks.trash, trashed)
+	mon.Counter("deletes_applied").Inc(int64(applied))
+	return applied, nil
+}
+
+// queuedVersions returns the versions which were queued.
+func queuedVersions(versions []blobVersion, queued [][]byte) (found []blobVersion) {
+	for _, version := range versions {
+		for _, key := range queued {
+			if bytes.Equal(version.key, key) {
+				found = append(found, version)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// startDeleteQueue continues the sequence of the queued operations, and
+// applies them in the background when deletes are deferred or some were
+// left queued by an earlier run.
+func (b *BlobStore) startDeleteQueue() error {
+	var pending bool
+	err := b.db.View(func(txn kvTxn) error {
+		options := b.iteratorOptions(IteratorWalk, pendingDeletePrefix)
+		options.Reverse = true
+		it := txn.NewIterator(options)
+		defer it.Close()
+		it.Seek(concat(pendingDeletePrefix, bytes.Repeat([]byte{0xff}, 8)))
+		if it.Valid() {
+			pending = true
+			b.deletes.next = binary.BigEndian.Uint64(it.Item().Key()[len(pendingDeletePrefix):])
+		}
+		return nil
+	})
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if !b.config.DeferredDeletes && !pending {
+		return nil
+	}
+	b.runBackground("delete queue", func(ctx context.Context) {
+		for ctx.Err() == nil {
+			applied, err := b.applyDeletes()
+			if err == nil && applied == 0 {
+				if !b.config.DeferredDeletes {
+					// the ones left by an earlier run are done
+					return
+				}
+				select {
+				case <-ctx.Done():
+				case <-b.deletes.wake:
+				}
+				continue
+			}
+			var delay time.Duration
+			if err != nil {
+				b.log.Warn("applying queued deletes failed", zap.Error(err))
+				delay = deleteQueueRetry
+			} else if b.config.DeleteQueueRate > 0 {
+				delay = time.Duration(applied) * time.Second / time.Duration(b.config.DeleteQueueRate)
+			}
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+				case <-timer.C:
+				}
+				timer.Stop()
+			}
+		}
+	})
+	return nil
+}
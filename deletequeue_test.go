@@ -0,0 +1,63 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestDeferredDeletes(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStoreWithConfig(dir, Config{DeferredDeletes: true, DeleteQueueRate: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, save(ctx, store, ref("ns", "deleted"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "trashed"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns", "replaced"), "123"))
+
+	// the worker applies the first delete, then waits for the rate limit
+	require.NoError(t, store.Delete(ctx, ref("ns", "deleted")))
+	require.Eventually(t, func() bool {
+		_, err := store.Stat(ctx, ref("ns", "deleted"))
+		return ErrNotExist.Has(err)
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, store.Trash(ctx, ref("ns", "trashed"), time.Now()))
+	require.NoError(t, store.Delete(ctx, ref("ns", "replaced")))
+	// versions committed after queueing are kept
+	require.NoError(t, save(ctx, store, ref("ns", "replaced"), "1234567"))
+	_, err = store.Stat(ctx, ref("ns", "trashed"))
+	require.NoError(t, err)
+
+	// queued operations survive restarts
+	require.NoError(t, store.Close())
+	store, err = NewBlobStoreWithConfig(dir, Config{DeferredDeletes: true, DeleteQueueRate: 1})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, store.FlushDeletes(ctx))
+	_, err = store.Stat(ctx, ref("ns", "trashed"))
+	require.True(t, ErrNotExist.Has(err))
+	info, err := store.Stat(ctx, ref("ns", "replaced"))
+	require.NoError(t, err)
+	stat, err := info.Stat(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), stat.Size())
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), used)
+	used, err = store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), used)
+
+	// restoring applies the queued trashing first
+	require.NoError(t, store.Trash(ctx, ref("ns", "replaced"), time.Now()))
+	keys, err := store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{[]byte("trashed"), []byte("replaced")}, keys)
+}
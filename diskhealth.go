@@ -0,0 +1,154 @@
+package badger
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskHealthCheckInterval is how often the latency percentiles are evaluated.
+var diskHealthCheckInterval = time.Minute
+
+// diskHealthSamples is the number of recent latencies kept per operation.
+var diskHealthSamples = 1000
+
+// DiskHealth is the state of the disk as seen from the latencies of the
+// recent reads and commits.
+type DiskHealth struct {
+	Degraded  bool
+	ReadP99   time.Duration
+	CommitP99 time.Duration
+}
+
+// latencies is a ring of the most recent latencies of an operation.
+type latencies struct {
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(latency time.Duration) {
+	if len(l.samples) < diskHealthSamples {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// p99 returns the 99th percentile of the samples, or 0 without samples.
+func (l *latencies) p99() time.Duration {
+	if len(l.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), l.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)*99/100]
+}
+
+// diskHealth reports the disk degraded when the p99 latency of the reads or
+// the commits is over the configured limit.
+type diskHealth struct {
+	maxReadLatency   time.Duration
+	maxCommitLatency time.Duration
+
+	mu       sync.Mutex
+	reads    latencies
+	commits  latencies
+	state    DiskHealth
+	callback func(DiskHealth)
+}
+
+// observeRead records the latency of reading a blob value.
+func (h *diskHealth) observeRead(latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reads.add(latency)
+}
+
+// observeCommit records the latency of a commit.
+func (h *diskHealth) observeCommit(latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.commits.add(latency)
+}
+
+// update evaluates the percentiles, reporting changes of the health.
+func (h *diskHealth) update(log *zap.Logger) {
+	h.mu.Lock()
+	state := DiskHealth{ReadP99: h.reads.p99(), CommitP99: h.commits.p99()}
+	state.Degraded = (h.maxReadLatency > 0 && state.ReadP99 > h.maxReadLatency) ||
+		(h.maxCommitLatency > 0 && state.CommitP99 > h.maxCommitLatency)
+	changed := state.Degraded != h.state.Degraded
+	h.state = state
+	callback := h.callback
+	h.mu.Unlock()
+
+	mon.DurationVal("disk_read_latency_p99").Observe(state.ReadP99)
+	mon.DurationVal("disk_commit_latency_p99").Observe(state.CommitP99)
+	if !changed {
+		return
+	}
+	if state.Degraded {
+		mon.Event("disk_degraded")
+		log.Warn("disk latency degraded", zap.Duration("read p99", state.ReadP99), zap.Duration("commit p99", state.CommitP99))
+	} else {
+		mon.Event("disk_recovered")
+		log.Info("disk latency recovered", zap.Duration("read p99", state.ReadP99), zap.Duration("commit p99", state.CommitP99))
+	}
+	if callback != nil {
+		callback(state)
+	}
+}
+
+// startDiskHealth monitors the read and commit latencies, when enabled by the config.
+func (b *BlobStore) startDiskHealth() {
+	if b.config.SlowDiskReadLatency <= 0 && b.config.SlowDiskCommitLatency <= 0 {
+		return
+	}
+	b.diskHealth = &diskHealth{
+		maxReadLatency:   b.config.SlowDiskReadLatency,
+		maxCommitLatency: b.config.SlowDiskCommitLatency,
+	}
+	b.runBackground("disk health", func(ctx context.Context) {
+		ticker := time.NewTicker(diskHealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			b.diskHealth.update(b.log)
+		}
+	})
+}
+
+// DiskHealth returns the disk health as of the last check. It's never
+// degraded when slow disk detection is disabled.
+func (b *BlobStore) DiskHealth() DiskHealth {
+	if b.diskHealth == nil {
+		return DiskHealth{}
+	}
+	b.diskHealth.mu.Lock()
+	defer b.diskHealth.mu.Unlock()
+	return b.diskHealth.state
+}
+
+// OnDiskHealth sets the callback called when the disk becomes degraded or
+// recovers. It's called from a background goroutine.
+func (b *BlobStore) OnDiskHealth(callback func(DiskHealth)) {
+	if b.diskHealth == nil {
+		return
+	}
+	b.diskHealth.mu.Lock()
+	defer b.diskHealth.mu.Unlock()
+	b.diskHealth.callback = callback
+}
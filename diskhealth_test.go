@@ -0,0 +1,84 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestLatencies(t *testing.T) {
+	defer func(samples int) { diskHealthSamples = samples }(diskHealthSamples)
+	diskHealthSamples = 200
+
+	var l latencies
+	require.Zero(t, l.p99())
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i))
+	}
+	require.Equal(t, time.Duration(100), l.p99())
+
+	// old samples are replaced by the new ones
+	for i := 0; i < 200; i++ {
+		l.add(time.Duration(1))
+	}
+	require.Equal(t, time.Duration(1), l.p99())
+}
+
+func TestDiskHealthConditions(t *testing.T) {
+	h := &diskHealth{maxReadLatency: time.Second}
+	var changes []DiskHealth
+	h.callback = func(state DiskHealth) { changes = append(changes, state) }
+
+	h.observeCommit(time.Hour)
+	h.observeRead(time.Millisecond)
+	h.update(zap.NewNop())
+	require.Empty(t, changes)
+
+	for i := 0; i < 100; i++ {
+		h.observeRead(2 * time.Second)
+	}
+	h.update(zap.NewNop())
+	h.update(zap.NewNop())
+	require.Len(t, changes, 1)
+	require.True(t, changes[0].Degraded)
+	require.Equal(t, 2*time.Second, changes[0].ReadP99)
+
+	for i := 0; i < diskHealthSamples; i++ {
+		h.observeRead(time.Millisecond)
+	}
+	h.update(zap.NewNop())
+	require.Len(t, changes, 2)
+	require.False(t, changes[1].Degraded)
+}
+
+func TestDiskHealth(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(interval time.Duration) { diskHealthCheckInterval = interval }(diskHealthCheckInterval)
+	diskHealthCheckInterval = 10 * time.Millisecond
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{SlowDiskReadLatency: time.Nanosecond})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	degraded := make(chan DiskHealth, 1)
+	store.OnDiskHealth(func(state DiskHealth) { degraded <- state })
+	require.False(t, store.DiskHealth().Degraded)
+
+	// every read is slower than a nanosecond
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	reader, err := store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	state := <-degraded
+	require.True(t, state.Degraded)
+	require.Positive(t, state.ReadP99)
+	require.True(t, store.DiskHealth().Degraded)
+}
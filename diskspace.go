@@ -0,0 +1,48 @@
+package badger
+
+import "context"
+
+// SpaceInfo is the space of the file system and of the node's allocation.
+type SpaceInfo struct {
+	// TotalSpace is the capacity of the file system, FreeSpace what's free of it.
+	TotalSpace int64
+	FreeSpace  int64
+	// Allocation is Config.Allocation, zero if it's not set.
+	Allocation int64
+	// UsedSpace is the size of the blobs and the trash.
+	UsedSpace int64
+	// AvailableSpace is what the node can still store: the free space of the
+	// file system, but at most the rest of the allocation.
+	AvailableSpace int64
+}
+
+// SpaceInfo returns the space of the file system and the space left for the
+// node.
+func (b *BlobStore) SpaceInfo(ctx context.Context) (space SpaceInfo, err error) {
+	info, err := diskInfoFromPath(b.dir)
+	if err != nil {
+		return space, err
+	}
+	blobs, err := b.SpaceUsedForBlobs(ctx)
+	if err != nil {
+		return space, err
+	}
+	trash, err := b.SpaceUsedForTrash(ctx)
+	if err != nil {
+		return space, err
+	}
+	space = SpaceInfo{
+		TotalSpace:     info.TotalSpace,
+		FreeSpace:      info.AvailableSpace,
+		Allocation:     b.config.Allocation.Int64(),
+		UsedSpace:      blobs + trash,
+		AvailableSpace: info.AvailableSpace,
+	}
+	if space.Allocation > 0 && space.Allocation-space.UsedSpace < space.AvailableSpace {
+		space.AvailableSpace = space.Allocation - space.UsedSpace
+		if space.AvailableSpace < 0 {
+			space.AvailableSpace = 0
+		}
+	}
+	return space, nil
+}
@@ -0,0 +1,52 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestSpaceInfo(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "12"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+
+	// without an allocation the free space of the file system is available
+	space, err := store.SpaceInfo(ctx)
+	require.NoError(t, err)
+	require.Positive(t, space.TotalSpace)
+	require.Positive(t, space.FreeSpace)
+	require.Equal(t, int64(6), space.UsedSpace)
+	require.Equal(t, space.FreeSpace, space.AvailableSpace)
+	info, err := store.DiskInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, space.TotalSpace, info.TotalSpace)
+	require.NoError(t, store.Close())
+
+	// a small allocation limits it
+	store, err = NewBlobStoreWithConfig(ctx.Dir("store"), Config{Allocation: memory.KiB})
+	require.NoError(t, err)
+	space, err = store.SpaceInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, memory.KiB.Int64(), space.Allocation)
+	require.Equal(t, memory.KiB.Int64()-6, space.AvailableSpace)
+	info, err = store.DiskInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, memory.KiB.Int64()-6, info.AvailableSpace)
+	require.NoError(t, store.Close())
+
+	// and it's never negative
+	store, err = NewBlobStoreWithConfig(ctx.Dir("store"), Config{Allocation: 4})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	space, err = store.SpaceInfo(ctx)
+	require.NoError(t, err)
+	require.Zero(t, space.AvailableSpace)
+}
@@ -0,0 +1,53 @@
+package badger
+
+import (
+	"context"
+	"time"
+)
+
+// DryRunReport is what a bulk operation would change.
+type DryRunReport struct {
+	// Blobs is the number of blob versions affected, Bytes their size.
+	Blobs int64
+	Bytes int64
+	// Keys are the keys of the affected blobs.
+	Keys [][]byte
+}
+
+func dryRunReport(keys [][]byte, changed []deletion) DryRunReport {
+	report := DryRunReport{Blobs: int64(len(changed)), Keys: keys}
+	for _, d := range changed {
+		report.Bytes += d.size
+	}
+	return report
+}
+
+// updateOrView runs fn in a read-write transaction like update, or in a
+// read-only one for a dry run, which stops before the changes. The dry runs
+// don't take part in conflict detection and have no size limit that way.
+func updateOrView(db kvEngine, dryRun bool, fn func(txn kvTxn) error) error {
+	if dryRun {
+		return view(db, fn)
+	}
+	return update(db, fn)
+}
+
+// EmptyTrashDryRun reports what EmptyTrash would delete, without deleting it.
+func (b *BlobStore) EmptyTrashDryRun(ctx context.Context, namespace []byte, trashedBefore time.Time) (DryRunReport, error) {
+	now := b.clock.Now()
+	keys, emptied, err := b.emptyTrash(ctx, namespace, func(t trashTime) bool { return t.expired(now, trashedBefore) }, true)
+	return dryRunReport(keys, emptied), err
+}
+
+// RestoreTrashDryRun reports what RestoreTrash would restore, without
+// restoring it. Trashing queued by Config.DeferredDeletes is not included.
+func (b *BlobStore) RestoreTrashDryRun(ctx context.Context, namespace []byte) (DryRunReport, error) {
+	keys, restored, err := b.restoreTrash(ctx, namespace, trashFilter{}, true)
+	return dryRunReport(keys, restored), err
+}
+
+// DeleteNamespaceDryRun reports whether DeleteNamespace would remove the
+// marker of the namespace. It never deletes blobs.
+func (b *BlobStore) DeleteNamespaceDryRun(ctx context.Context, namespace []byte) (removed bool, err error) {
+	return b.removeEmptyNamespace(namespace, true)
+}
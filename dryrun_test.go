@@ -0,0 +1,83 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	var hooked int
+	store.OnRestore(func(ref blobstore.BlobRef, size int64) { hooked++ })
+	store.OnEmptyTrash(func(ref blobstore.BlobRef, size int64) { hooked++ })
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "123"))
+	require.NoError(t, save(ctx, store, ref("empty", "key"), "1"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key1"), time.Now()))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	require.NoError(t, store.Delete(ctx, ref("empty", "key")))
+
+	report, err := store.EmptyTrashDryRun(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), report.Blobs)
+	require.Equal(t, int64(7), report.Bytes)
+	require.ElementsMatch(t, [][]byte{[]byte("key1"), []byte("key2")}, report.Keys)
+
+	report, err = store.RestoreTrashDryRun(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), report.Blobs)
+	require.Equal(t, int64(7), report.Bytes)
+
+	removed, err := store.DeleteNamespaceDryRun(ctx, []byte("empty"))
+	require.NoError(t, err)
+	require.True(t, removed)
+	removed, err = store.DeleteNamespaceDryRun(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	// nothing changed
+	require.Zero(t, hooked)
+	used, err := store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), used)
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Len(t, namespaces, 2)
+	freed, _, err := store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(7), freed)
+}
+
+func TestDryRunLargeTrash(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// the dry runs only read, so they aren't limited by the transaction size
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MemTableSize: memory.MiB})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	count := int(store.db.MaxBatchCount())
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		require.NoError(t, save(ctx, store, ref("ns", key), "x"))
+		require.NoError(t, store.Trash(ctx, ref("ns", key), time.Now()))
+	}
+	report, err := store.EmptyTrashDryRun(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, count, report.Blobs)
+	report, err = store.RestoreTrashDryRun(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.EqualValues(t, count, report.Bytes)
+}
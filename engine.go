@@ -0,0 +1,140 @@
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The key-value engines of the store, see Config.Engine.
+const (
+	// EngineBadger keeps the keys in an LSM tree and the blobs in a separate
+	// value log, for fast writes.
+	EngineBadger = "badger"
+	// EngineBolt keeps the keys and the blobs together in the B+tree of a
+	// single file, without compactions or value log GC to tune.
+	EngineBolt = "bolt"
+)
+
+// errKeyNotFound and errConflict are what the engines return for missing
+// keys and for transactions conflicting with a concurrent one. The other
+// engines use the errors of badger too, so classify and the callers don't
+// depend on the engine.
+var (
+	errKeyNotFound = badger.ErrKeyNotFound
+	errConflict    = badger.ErrConflict
+)
+
+// kvEngine is the ordered, transactional key-value store the keys and the
+// blobs of the store are kept in. The key layout, the usage counters and
+// the rest of the store only use it, so the engine is pluggable; the
+// features of badger without an equivalent, like the value log GC, are
+// skipped with the other engines, see badgerDB.
+type kvEngine interface {
+	// View and Update run fn in a read-only or read-write transaction,
+	// committing the read-write one if fn returns nil.
+	View(fn func(txn kvTxn) error) error
+	Update(fn func(txn kvTxn) error) error
+	// NewTransaction starts a transaction the caller has to commit or
+	// discard, like the one held open by a snapshot.
+	NewTransaction(update bool) kvTxn
+	// MaxBatchCount is the number of writes fitting in one transaction.
+	MaxBatchCount() int64
+	// MaxValueSize is the size of the largest value the engine stores.
+	MaxValueSize() int64
+	// Size returns the bytes of the keys and of the values on disk; engines
+	// keeping them together report them all as values.
+	Size() (keys, values int64)
+	// Backup writes the entries changed at or after the since version to w
+	// as length prefixed protobuf encoded lists, like badger does, and
+	// returns the version to continue from. Load reads them back.
+	Backup(w io.Writer, since uint64) (uint64, error)
+	Load(r io.Reader) error
+	Close() error
+}
+
+// kvTxn is a transaction of an engine. It sees the writes made in it, and
+// isn't safe for concurrent use.
+type kvTxn interface {
+	// Get returns errKeyNotFound for missing keys.
+	Get(key []byte) (kvItem, error)
+	Set(key, value []byte) error
+	// SetWithMeta sets the value with the user meta bits of the store, like
+	// the storage format of a blob.
+	SetWithMeta(key, value []byte, userMeta byte) error
+	Delete(key []byte) error
+	NewIterator(options kvIteratorOptions) kvIterator
+	// ReadTs is the version the transaction reads at.
+	ReadTs() uint64
+	Commit() error
+	Discard()
+}
+
+// kvItem is an entry read from an engine, valid until its transaction
+// ends, and for iterators until they move on.
+type kvItem interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	Value(fn func(val []byte) error) error
+	ValueCopy(dst []byte) ([]byte, error)
+	ValueSize() int64
+	UserMeta() byte
+	// Version is the version of the transaction which set the entry.
+	Version() uint64
+	ExpiresAt() uint64
+}
+
+// kvIterator iterates the keys with the prefix of its options in order, or
+// in reverse order.
+type kvIterator interface {
+	Rewind()
+	// Seek moves to the first key at or after key, or at or before it when
+	// iterating in reverse.
+	Seek(key []byte)
+	Valid() bool
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	Item() kvItem
+	Close()
+}
+
+// kvIteratorOptions are the options of an iterator. The engines without
+// prefetching ignore the prefetch settings.
+type kvIteratorOptions struct {
+	Prefix         []byte
+	Reverse        bool
+	PrefetchValues bool
+	PrefetchSize   int
+}
+
+// openEngine opens the engine of the config in dir, retrying up to the lock
+// timeout while another process holds it. Without an engine in the config it
+// opens the engine of the store in dir, and badger for new stores. With
+// verifyValues the engine verifies the checksums of the values it reads, if
+// it has them.
+func openEngine(dir string, config Config, verifyValues bool) (kvEngine, error) {
+	engine := config.Engine
+	if engine == "" && fileExists(filepath.Join(dir, boltFile)) {
+		engine = EngineBolt
+	}
+	switch engine {
+	case "", EngineBadger:
+		if fileExists(filepath.Join(dir, boltFile)) {
+			return nil, ErrConfig.New("%s holds a store of the %s engine", dir, EngineBolt)
+		}
+		return openBadger(dir, config, verifyValues)
+	case EngineBolt:
+		if fileExists(filepath.Join(dir, badger.ManifestFilename)) {
+			return nil, ErrConfig.New("%s holds a store of the %s engine", dir, EngineBadger)
+		}
+		return openBolt(dir, config)
+	default:
+		return nil, ErrConfig.New("unknown engine %q", config.Engine)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,165 @@
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+// testEngines runs the test on a store of every engine.
+func testEngines(t *testing.T, test func(t *testing.T, engine string)) {
+	for _, engine := range []string{EngineBadger, EngineBolt} {
+		t.Run(engine, func(t *testing.T) { test(t, engine) })
+	}
+}
+
+func TestBoltEngine(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	config := Config{Engine: EngineBolt}
+	store, err := NewBlobStoreWithConfig(dir, config)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "first"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "second"))
+	require.NoError(t, save(ctx, store, ref("other", "key3"), "third"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	require.NoError(t, store.Close())
+
+	// without an engine in the config, the store is opened with its own
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	requireBlob(t, ctx, store, ref("ns", "key1"), "first")
+	_, err = store.Stat(ctx, ref("ns", "key2"))
+	require.Error(t, err)
+	used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.EqualValues(t, 5, used)
+	keys, err := store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	requireBlob(t, ctx, store, ref("ns", "key2"), "second")
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Len(t, namespaces, 2)
+
+	// the stores of one engine aren't opened with the other
+	_, err = NewBlobStoreWithConfig(dir, Config{Engine: EngineBadger})
+	require.True(t, ErrConfig.Has(err))
+	_, err = NewBlobStoreWithConfig(ctx.Dir("badger"), Config{Engine: "lmdb"})
+	require.True(t, ErrConfig.Has(err))
+}
+
+func TestBoltMmapSize(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// the memory map leaves room for the file to grow
+	path := filepath.Join(ctx.Dir("store"), boltFile)
+	size, err := boltMmapSize(path)
+	require.NoError(t, err)
+	require.Equal(t, boltMinMmapHeadroom, size)
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	require.NoError(t, os.Truncate(path, 10<<30))
+	size, err = boltMmapSize(path)
+	require.NoError(t, err)
+	require.Equal(t, 20<<30, size)
+}
+
+func TestBoltIterator(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	db, err := openEngine(ctx.Dir("store"), Config{Engine: EngineBolt}, false)
+	require.NoError(t, err)
+	defer ctx.Check(db.Close)
+	require.NoError(t, db.Update(func(txn kvTxn) error {
+		for i := 0; i < 10; i++ {
+			if err := txn.Set([]byte(fmt.Sprintf("a%d", i)), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte("b"), nil)
+	}))
+
+	keys := func(options kvIteratorOptions, seek []byte) (keys []string) {
+		require.NoError(t, db.View(func(txn kvTxn) error {
+			it := txn.NewIterator(options)
+			defer it.Close()
+			for it.Seek(seek); it.Valid(); it.Next() {
+				keys = append(keys, string(it.Item().Key()))
+			}
+			return nil
+		}))
+		return keys
+	}
+	require.Equal(t, []string{"a8", "a9"}, keys(kvIteratorOptions{Prefix: []byte("a")}, []byte("a8")))
+	require.Equal(t, []string{"a1", "a0"}, keys(kvIteratorOptions{Prefix: []byte("a"), Reverse: true}, []byte("a1")))
+	require.Equal(t, []string{"b", "a9"}, keys(kvIteratorOptions{Reverse: true}, []byte("c"))[:2])
+	require.Len(t, keys(kvIteratorOptions{Prefix: []byte("a"), Reverse: true}, []byte("a\xff")), 10)
+
+	// the entries can be deleted while iterating
+	require.NoError(t, db.Update(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: []byte("a")})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Empty(t, keys(kvIteratorOptions{Prefix: []byte("a")}, nil))
+}
+
+func TestBoltBackup(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	db, err := openEngine(ctx.Dir("bolt"), Config{Engine: EngineBolt}, false)
+	require.NoError(t, err)
+	defer ctx.Check(db.Close)
+	set := func(key, value string) {
+		require.NoError(t, db.Update(func(txn kvTxn) error { return txn.SetWithMeta([]byte(key), []byte(value), 3) }))
+	}
+	set("key1", "first")
+	set("key2", "second")
+	var full bytes.Buffer
+	version, err := db.Backup(&full, 0)
+	require.NoError(t, err)
+
+	set("key3", "third")
+	require.NoError(t, db.Update(func(txn kvTxn) error { return txn.Delete([]byte("key1")) }))
+	var incremental bytes.Buffer
+	_, err = db.Backup(&incremental, version)
+	require.NoError(t, err)
+
+	// the backups of bolt are loaded by both engines
+	for _, engine := range []string{EngineBolt, EngineBadger} {
+		restored, err := openEngine(ctx.Dir("restored-"+engine), Config{Engine: engine}, false)
+		require.NoError(t, err)
+		require.NoError(t, restored.Load(bytes.NewReader(full.Bytes())))
+		require.NoError(t, restored.Load(bytes.NewReader(incremental.Bytes())))
+		require.NoError(t, restored.View(func(txn kvTxn) error {
+			_, err := txn.Get([]byte("key1"))
+			require.ErrorIs(t, err, errKeyNotFound)
+			for key, value := range map[string]string{"key2": "second", "key3": "third"} {
+				item, err := txn.Get([]byte(key))
+				require.NoError(t, err)
+				data, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, value, string(data))
+				require.EqualValues(t, 3, item.UserMeta())
+			}
+			return nil
+		}))
+		require.NoError(t, restored.Close())
+	}
+}
@@ -0,0 +1,94 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/zeebo/errs"
+	"os"
+	"storj.io/storj/storagenode/blobstore"
+	"syscall"
+)
+
+// The error classes callers can branch on, besides ErrCorrupt,
+// ErrOverloaded and ErrQuotaExceeded. The errors of badger and the file
+// system are put in them by classify.
+var (
+	// ErrNotExist is returned for blobs which don't exist. The errors match
+	// os.ErrNotExist too, like the ones of the file store.
+	ErrNotExist = errs.Class("blob not found")
+	// ErrClosed is returned when the store or the blob is closed already.
+	ErrClosed = errs.Class("closed")
+	// ErrNoSpace is returned when the disk is full.
+	ErrNoSpace = errs.Class("no space left")
+	// ErrTooLarge is returned for blobs and transactions badger can't store.
+	ErrTooLarge = errs.Class("too large")
+	// ErrReadOnly is returned for writes to a read-only store or file system.
+	ErrReadOnly = errs.Class("read-only")
+)
+
+// notExist is the message of an ErrNotExist error, which unwraps to
+// os.ErrNotExist.
+type notExist string
+
+func (e notExist) Error() string { return string(e) }
+
+func (e notExist) Unwrap() error { return os.ErrNotExist }
+
+// errNotExist returns an ErrNotExist error with the formatted message.
+func errNotExist(format string, args ...interface{}) error {
+	return ErrNotExist.Wrap(notExist(fmt.Sprintf(format, args...)))
+}
+
+// classify puts the error of badger or the file system in its class, unless
+// it has one already.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, class := range []*errs.Class{&ErrNotExist, &ErrClosed, &ErrNoSpace, &ErrTooLarge, &ErrReadOnly, &ErrCorrupt, &ErrOverloaded, &ErrQuotaExceeded} {
+		if class.Has(err) {
+			return err
+		}
+	}
+	switch {
+	case errors.Is(err, badger.ErrDBClosed):
+		return ErrClosed.Wrap(err)
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrNoSpace.Wrap(err)
+	case errors.Is(err, errConflict):
+		return ErrOverloaded.Wrap(err)
+	case errors.Is(err, badger.ErrTxnTooBig):
+		return ErrTooLarge.Wrap(err)
+	case errors.Is(err, badger.ErrReadOnlyTxn), errors.Is(err, syscall.EROFS):
+		return ErrReadOnly.Wrap(err)
+	}
+	return err
+}
+
+// refError is an error of an operation on a blob or a namespace.
+type refError struct {
+	op  string
+	ref blobstore.BlobRef
+	err error
+}
+
+func (e *refError) Error() string {
+	if len(e.ref.Key) == 0 {
+		return fmt.Sprintf("%s %x: %v", e.op, e.ref.Namespace, e.err)
+	}
+	return fmt.Sprintf("%s %x/%x: %v", e.op, e.ref.Namespace, e.ref.Key, e.err)
+}
+
+func (e *refError) Unwrap() error { return e.err }
+
+// wrapRef adds the operation and the hex encoded blob to the error, unless
+// it's nil or an inner operation added its own already. It's deferred by the
+// methods with a named error result.
+func wrapRef(op string, ref blobstore.BlobRef, err *error) {
+	var wrapped *refError
+	if *err == nil || errors.As(*err, &wrapped) {
+		return
+	}
+	*err = &refError{op: op, ref: ref, err: *err}
+}
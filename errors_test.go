@@ -0,0 +1,89 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"os"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorClasses(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{ValueLogFileSize: memory.MiB})
+	require.NoError(t, err)
+
+	_, err = store.Stat(ctx, ref("ns", "missing"))
+	require.True(t, ErrNotExist.Has(err))
+	require.True(t, errs.Is(err, os.ErrNotExist))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	w, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.True(t, ErrClosed.Has(w.Commit(ctx)))
+	_, err = w.Write([]byte("data"))
+	require.True(t, ErrClosed.Has(err))
+
+	w, err = store.Create(ctx, ref("ns", "large"))
+	require.NoError(t, err)
+	_, err = w.Write(testrand.BytesInt(2 * memory.MiB.Int()))
+	require.NoError(t, err)
+	require.True(t, ErrTooLarge.Has(w.Commit(ctx)))
+
+	require.NoError(t, store.Close())
+	_, err = store.Stat(ctx, ref("ns", "key"))
+	require.True(t, ErrClosed.Has(err))
+}
+
+func TestClassify(t *testing.T) {
+	require.NoError(t, classify(nil))
+	require.True(t, ErrNoSpace.Has(classify(&os.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC})))
+	require.True(t, ErrReadOnly.Has(classify(&os.PathError{Op: "open", Path: "file", Err: syscall.EROFS})))
+	require.True(t, ErrReadOnly.Has(classify(badger.ErrReadOnlyTxn)))
+	require.True(t, ErrTooLarge.Has(classify(fmt.Errorf("commit: %w", badger.ErrTxnTooBig))))
+
+	// classified errors are kept as they are
+	corrupt := ErrCorrupt.Wrap(syscall.ENOSPC)
+	require.Equal(t, corrupt, classify(corrupt))
+	other := errs.New("other")
+	require.Equal(t, other, classify(other))
+}
+
+func TestRefErrors(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	_, err = store.Stat(ctx, ref("ns", "key"))
+	require.ErrorContains(t, err, "stat 6e73/6b6579: ")
+	require.True(t, ErrNotExist.Has(err))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	// the ref is added once, by the outermost operation
+	_, err = store.OpenWithStorageFormat(ctx, ref("ns", "key"), 1)
+	require.Equal(t, 1, strings.Count(err.Error(), "6e73/6b6579"))
+
+	w, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	_, err = w.Write([]byte("data"))
+	require.ErrorContains(t, err, "write 6e73/6b6579: ")
+	require.True(t, ErrClosed.Has(err))
+
+	_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+}
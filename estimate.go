@@ -0,0 +1,82 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// EstimateKeyCount estimates the number of keys with the prefix from the
+// metadata of the LSM tables, in milliseconds even for large stores. It's
+// meant for dashboards: the keys still in the memtables are missed, the old
+// versions and deletes are counted until compaction drops them, and the
+// tables reaching beyond the prefix are counted by the share of their key
+// range inside it. The usage counters give the exact blob count of a
+// namespace. The engines without LSM tables count the keys instead.
+func (b *BlobStore) EstimateKeyCount(prefix []byte) uint64 {
+	db := badgerDB(b.db)
+	if db == nil {
+		return b.countKeys(prefix)
+	}
+	end := prefixEnd(prefix)
+	var estimate float64
+	for _, table := range db.Tables() {
+		left, right := userKey(table.Left), userKey(table.Right)
+		if bytes.Compare(right, prefix) < 0 || (end != nil && bytes.Compare(left, end) >= 0) {
+			continue
+		}
+		estimate += float64(table.KeyCount) * rangeShare(left, right, prefix, end)
+	}
+	return uint64(math.Round(estimate))
+}
+
+// countKeys counts the keys with the prefix.
+func (b *BlobStore) countKeys(prefix []byte) (count uint64) {
+	_ = view(b.db, func(txn kvTxn) error {
+		it := txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// userKey strips the version badger appends to the keys of the tables.
+func userKey(key []byte) []byte {
+	if len(key) < 8 {
+		return key
+	}
+	return key[:len(key)-8]
+}
+
+// rangeShare returns the share of the key range from left to right which is
+// between from and to, with to nil for no upper limit. The keys are compared
+// as numbers by the 8 bytes after their common prefix.
+func rangeShare(left, right, from, to []byte) float64 {
+	common := 0
+	for common < len(left) && common < len(right) && left[common] == right[common] {
+		common++
+	}
+	position := func(key []byte) float64 {
+		var raw [8]byte
+		if len(key) > common {
+			copy(raw[:], key[common:])
+		}
+		return float64(binary.BigEndian.Uint64(raw[:]))
+	}
+	width := position(right) - position(left)
+	if width <= 0 {
+		return 1
+	}
+	lo, hi := left, right
+	if bytes.Compare(from, lo) > 0 {
+		lo = from
+	}
+	if to != nil && bytes.Compare(to, hi) < 0 {
+		hi = to
+	}
+	share := (position(hi) - position(lo)) / width
+	return math.Max(0, math.Min(1, share))
+}
@@ -0,0 +1,41 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+	"time"
+)
+
+func TestEstimateKeyCount(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// small memtables flush the keys to many tables
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MemTableSize: memory.MiB})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// written directly for speed
+	batch := badgerDB(store.db).NewWriteBatch()
+	for i := 0; i < 100000; i++ {
+		require.NoError(t, batch.Set(key(ref("ns1", string(testrand.BytesInt(32))), time.Now(), 1000), []byte("data pointer")))
+	}
+	for i := 0; i < 25000; i++ {
+		require.NoError(t, batch.Set(key(ref("ns2", string(testrand.BytesInt(32))), time.Now(), 1000), []byte("data pointer")))
+	}
+	require.NoError(t, batch.Flush())
+	require.NoError(t, store.flushMemTables())
+	require.InEpsilon(t, 100000, store.EstimateKeyCount(concat(blobPrefix, []byte("ns1"))), 0.2)
+	require.InEpsilon(t, 25000, store.EstimateKeyCount(concat(blobPrefix, []byte("ns2"))), 0.2)
+	require.Zero(t, store.EstimateKeyCount(concat(blobPrefix, []byte("ns3"))))
+}
+
+func TestRangeShare(t *testing.T) {
+	require.Equal(t, 1.0, rangeShare([]byte("a1"), []byte("a9"), []byte("a"), []byte("b")))
+	require.InDelta(t, 0.5, rangeShare([]byte{0, 0}, []byte{0, 200}, []byte{0, 100}, nil), 0.01)
+	require.InDelta(t, 0.25, rangeShare([]byte{0, 0}, []byte{0, 200}, []byte{0, 50}, []byte{0, 100}), 0.01)
+	require.Equal(t, 1.0, rangeShare([]byte("same"), []byte("same"), []byte("s"), []byte("t")))
+}
@@ -0,0 +1,178 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/pieces"
+)
+
+// expirationPrefix keys are the piece expirations ordered by time, as
+// expiration time (8 bytes of Unix nanoseconds), satellite ID and piece ID.
+var expirationPrefix = []byte("expir")
+
+// expirationIndexPrefix keys map the satellite ID and piece ID to the
+// expiration time, so a new expiration of a piece replaces the old one.
+var expirationIndexPrefix = []byte("expix")
+
+// expirationBatchSize is the number of expirations read or deleted in one
+// transaction.
+var expirationBatchSize = 1000
+
+// ErrExpiration is returned for the corrupted piece expirations.
+var ErrExpiration = errs.Class("piece expiration")
+
+// PieceExpirations keeps the expiration times of the pieces in the badger
+// store, next to the pieces, instead of the SQLite database of the
+// storagenode. It's the pieces.PieceExpirationDB of the node.
+type PieceExpirations struct {
+	db        kvEngine
+	iterators iteratorProfiles
+}
+
+var _ pieces.PieceExpirationDB = (*PieceExpirations)(nil)
+
+// PieceExpirations returns the piece expirations kept in the store.
+func (b *BlobStore) PieceExpirations() *PieceExpirations {
+	return &PieceExpirations{db: b.db, iterators: b.iterators}
+}
+
+func expirationTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func expirationKey(expiresAt []byte, satellite storj.NodeID, pieceID storj.PieceID) []byte {
+	return concat(expirationPrefix, expiresAt, satellite.Bytes(), pieceID.Bytes())
+}
+
+func expirationIndexKey(satellite storj.NodeID, pieceID storj.PieceID) []byte {
+	return concat(expirationIndexPrefix, satellite.Bytes(), pieceID.Bytes())
+}
+
+// SetExpiration sets the expiration time of the piece, replacing the earlier
+// one.
+func (e *PieceExpirations) SetExpiration(ctx context.Context, satellite storj.NodeID, pieceID storj.PieceID, expiresAt time.Time) error {
+	return update(e.db, func(txn kvTxn) error {
+		indexKey := expirationIndexKey(satellite, pieceID)
+		item, err := txn.Get(indexKey)
+		switch {
+		case err == nil:
+			previous, err := item.ValueCopy(nil)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if err := txn.Delete(expirationKey(previous, satellite, pieceID)); err != nil {
+				return err
+			}
+		case !errs.Is(err, errKeyNotFound):
+			return errs.Wrap(err)
+		}
+		raw := expirationTime(expiresAt)
+		if err := txn.Set(indexKey, raw); err != nil {
+			return err
+		}
+		return txn.Set(expirationKey(raw, satellite, pieceID), nil)
+	})
+}
+
+// GetExpired calls cb for the pieces expiring before expiresBefore, in the
+// order of their expiration, until it returns false. The expirations are
+// read in batches, so cb may change them.
+func (e *PieceExpirations) GetExpired(ctx context.Context, expiresBefore time.Time, cb func(context.Context, pieces.ExpiredInfo) bool) error {
+	end := concat(expirationPrefix, expirationTime(expiresBefore))
+	after := expirationPrefix
+	for {
+		var batch []pieces.ExpiredInfo
+		var last []byte
+		err := view(e.db, func(txn kvTxn) error {
+			it := txn.NewIterator(e.iterators.options(IteratorRecords, expirationPrefix))
+			defer it.Close()
+			for it.Seek(after); it.Valid() && len(batch) < expirationBatchSize; it.Next() {
+				key := it.Item().Key()
+				if bytesEq(key, after) {
+					continue
+				}
+				if string(key) >= string(end) {
+					break
+				}
+				expired, err := parseExpirationKey(key)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, expired)
+				last = it.Item().KeyCopy(nil)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, expired := range batch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !cb(ctx, expired) {
+				return nil
+			}
+		}
+		if len(batch) < expirationBatchSize {
+			return nil
+		}
+		after = last
+	}
+}
+
+func parseExpirationKey(key []byte) (expired pieces.ExpiredInfo, err error) {
+	raw := key[len(expirationPrefix):]
+	if len(raw) != 8+len(storj.NodeID{})+len(storj.PieceID{}) {
+		return expired, ErrExpiration.New("invalid key %x", key)
+	}
+	raw = raw[8:]
+	copy(expired.SatelliteID[:], raw)
+	copy(expired.PieceID[:], raw[len(storj.NodeID{}):])
+	return expired, nil
+}
+
+// DeleteExpirations deletes the expirations before expiresAt.
+func (e *PieceExpirations) DeleteExpirations(ctx context.Context, expiresAt time.Time) error {
+	end := concat(expirationPrefix, expirationTime(expiresAt))
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		deleted := 0
+		err := update(e.db, func(txn kvTxn) error {
+			deleted = 0
+			it := txn.NewIterator(e.iterators.options(IteratorRecords, expirationPrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && deleted < expirationBatchSize; it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if string(key) >= string(end) {
+					break
+				}
+				expired, err := parseExpirationKey(key)
+				if err != nil {
+					return err
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				if err := txn.Delete(expirationIndexKey(expired.SatelliteID, expired.PieceID)); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if deleted < expirationBatchSize {
+			return nil
+		}
+	}
+}
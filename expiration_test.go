@@ -0,0 +1,63 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/pieces"
+	"testing"
+	"time"
+)
+
+func TestPieceExpirations(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { expirationBatchSize = size }(expirationBatchSize)
+	expirationBatchSize = 2
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	expirations := store.PieceExpirations()
+
+	now := time.Now()
+	satellite := testrand.NodeID()
+	var expiring []pieces.ExpiredInfo
+	for i := 0; i < 5; i++ {
+		piece := pieces.ExpiredInfo{SatelliteID: satellite, PieceID: testrand.PieceID()}
+		expiring = append(expiring, piece)
+		require.NoError(t, expirations.SetExpiration(ctx, satellite, piece.PieceID, now.Add(time.Duration(i)*time.Hour)))
+	}
+	// a new expiration replaces the old one
+	require.NoError(t, expirations.SetExpiration(ctx, satellite, expiring[0].PieceID, now.Add(10*time.Hour)))
+
+	expired := func(before time.Time) (found []pieces.ExpiredInfo) {
+		require.NoError(t, expirations.GetExpired(ctx, before, func(ctx context.Context, piece pieces.ExpiredInfo) bool {
+			found = append(found, piece)
+			return true
+		}))
+		return found
+	}
+	require.Equal(t, expiring[1:4], expired(now.Add(3*time.Hour+time.Minute)))
+	require.Equal(t, append(expiring[1:5:5], expiring[0]), expired(now.Add(11*time.Hour)))
+
+	// the walk stops when the callback returns false
+	count := 0
+	require.NoError(t, expirations.GetExpired(ctx, now.Add(11*time.Hour), func(ctx context.Context, piece pieces.ExpiredInfo) bool {
+		count++
+		return count < 3
+	}))
+	require.Equal(t, 3, count)
+
+	require.NoError(t, expirations.DeleteExpirations(ctx, now.Add(3*time.Hour+time.Minute)))
+	require.Equal(t, []pieces.ExpiredInfo{expiring[4], expiring[0]}, expired(now.Add(11*time.Hour)))
+
+	// the expirations are kept over restarts
+	require.NoError(t, store.Close())
+	store, err = NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	expirations = store.PieceExpirations()
+	require.Equal(t, []pieces.ExpiredInfo{expiring[4], expiring[0]}, expired(now.Add(11*time.Hour)))
+}
@@ -0,0 +1,384 @@
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"io"
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// ErrNamespaceExport is returned for namespace exports which can't be read
+// or imported.
+var ErrNamespaceExport = errs.Class("namespace export")
+
+// namespaceExportMagic starts the namespace export streams.
+var namespaceExportMagic = []byte("sbnx1")
+
+// The kinds of the entries of a namespace export stream.
+const (
+	exportBlob  = 'b'
+	exportTrash = 't'
+	exportEnd   = 'e'
+)
+
+// namespaceImportBatchSize and namespaceImportBatchBytes limit the entries
+// imported in one transaction.
+var (
+	namespaceImportBatchSize  = 1000
+	namespaceImportBatchBytes = 16 << 20
+)
+
+// NamespaceExport counts the entries written by ExportNamespace.
+type NamespaceExport struct {
+	Blobs int64
+	Trash int64
+	Bytes int64
+}
+
+// NamespaceImport counts the entries stored by ImportNamespace.
+type NamespaceImport struct {
+	Namespace []byte
+	Blobs     int64
+	Trash     int64
+	Bytes     int64
+	// Skipped is the number of entries the store had already, like the ones
+	// imported before an interrupted import.
+	Skipped int64
+
+	// SourceBytes, SourcePieces, SourceTrashBytes and SourceTrashPieces are
+	// the usage counters of the namespace in the exporting store. The
+	// counters of this store are updated with every entry imported.
+	SourceBytes       int64
+	SourcePieces      int64
+	SourceTrashBytes  int64
+	SourceTrashPieces int64
+
+	// Resume is the position after the last entry imported, to continue an
+	// interrupted import from with ExportNamespace. It's nil when the import
+	// finished.
+	Resume ResumeToken
+}
+
+// ExportNamespace writes the blobs and the trash of the namespace, with
+// their trash times and usage counters, to a stream read by ImportNamespace,
+// so a satellite's data can be moved to another store independently of the
+// others. The entries are read from a single snapshot of the store. The
+// export starts after the position of token, which is the Resume of an
+// interrupted import, or from the beginning for a nil token.
+func (b *BlobStore) ExportNamespace(ctx context.Context, w io.Writer, namespace []byte, token ResumeToken) (stats NamespaceExport, err error) {
+	var after []byte
+	if token != nil {
+		if len(token) < 1+len(blobPrefix)+len(namespace)+16 || token[0] != resumeTokenVersion {
+			return stats, ErrResumeToken.New("%x", []byte(token))
+		}
+		after = token[1:]
+	}
+	out := bufio.NewWriter(w)
+	header := binary.AppendUvarint(concat(namespaceExportMagic), uint64(len(namespace)))
+	if _, err := out.Write(append(header, namespace...)); err != nil {
+		return stats, ErrNamespaceExport.Wrap(err)
+	}
+	err = b.db.View(func(txn kvTxn) error {
+		namespaces := readNamespaces(txn)
+		if err := b.exportSection(ctx, txn, out, namespaces, namespace, blobPrefix, after, &stats); err != nil {
+			return err
+		}
+		if err := b.exportSection(ctx, txn, out, namespaces, namespace, trashPrefix, after, &stats); err != nil {
+			return err
+		}
+		used, err := namespaceUsage(txn, namespace)
+		if err != nil {
+			return err
+		}
+		trash, err := namespaceTrashUsage(txn, namespace)
+		if err != nil {
+			return err
+		}
+		end := append([]byte{exportEnd}, used.encode()...)
+		_, err = out.Write(append(end, trash.encode()...))
+		return ErrNamespaceExport.Wrap(err)
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, ErrNamespaceExport.Wrap(out.Flush())
+}
+
+// exportSection writes the blob or trash entries of the namespace after the
+// key after.
+func (b *BlobStore) exportSection(ctx context.Context, txn kvTxn, out io.Writer, namespaces [][]byte, namespace []byte, keyPrefix []byte, after []byte, stats *NamespaceExport) error {
+	prefix := concat(keyPrefix, namespace)
+	start := prefix
+	if bytes.Compare(after, start) > 0 {
+		start = after
+	}
+	kind := byte(exportBlob)
+	if bytes.Equal(keyPrefix, trashPrefix) {
+		kind = exportTrash
+	}
+	it := txn.NewIterator(b.iteratorOptions(IteratorCopy, prefix))
+	defer it.Close()
+	for it.Seek(start); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := it.Item()
+		key := item.KeyCopy(nil)
+		ref, ok := splitKey(namespaces, key[len(keyPrefix):])
+		// namespaces having this one as prefix share the key prefix
+		if bytes.Equal(key, after) || !ok || !bytesEq(ref.Namespace, namespace) {
+			continue
+		}
+		entry := binary.AppendUvarint([]byte{kind}, uint64(len(key)-len(prefix)))
+		entry = append(entry, key[len(prefix):]...)
+		entry = append(entry, item.UserMeta()&^(userMetaDedup|userMetaChunked))
+		if kind == exportTrash {
+			trashTime, err := rawTrashTime(txn, key)
+			if err != nil {
+				return err
+			}
+			entry = binary.AppendUvarint(entry, uint64(len(trashTime)))
+			entry = append(entry, trashTime...)
+		}
+		err := storedValue(txn, item, func(val []byte) error {
+			entry = binary.AppendUvarint(entry, uint64(len(val)))
+			_, err := out.Write(append(entry, val...))
+			return ErrNamespaceExport.Wrap(err)
+		})
+		if err != nil {
+			return err
+		}
+		_, size := stat(key)
+		if kind == exportTrash {
+			stats.Trash++
+		} else {
+			stats.Blobs++
+		}
+		stats.Bytes += int64(size)
+	}
+	return nil
+}
+
+// rawTrashTime returns the encoded trash time of the trash entry, nil if it
+// has none.
+func rawTrashTime(txn kvTxn, trashKey []byte) ([]byte, error) {
+	item, err := txn.Get(trashTimeKey(trashKey))
+	if errs.Is(err, errKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	value, err := item.ValueCopy(nil)
+	return value, errs.Wrap(err)
+}
+
+// exportEntry is a blob or trash entry of a namespace export stream.
+type exportEntry struct {
+	key       []byte
+	ref       blobstore.BlobRef
+	trash     bool
+	userMeta  byte
+	trashTime []byte
+	value     []byte
+}
+
+// ImportNamespace stores the blobs and the trash of a stream written by
+// ExportNamespace, in batches. The entries the store has already are
+// skipped, so an interrupted import can be resumed, with an export from the
+// returned Resume position, or simply repeated.
+func (b *BlobStore) ImportNamespace(ctx context.Context, r io.Reader) (stats NamespaceImport, err error) {
+	if err := b.readOnly.check(); err != nil {
+		return stats, err
+	}
+	in := bufio.NewReader(r)
+	magic := make([]byte, len(namespaceExportMagic))
+	if _, err := io.ReadFull(in, magic); err != nil || !bytes.Equal(magic, namespaceExportMagic) {
+		return stats, ErrNamespaceExport.New("not a namespace export")
+	}
+	// no value can be larger than the engine stores
+	limit := b.db.MaxValueSize()
+	namespace, err := readExportBytes(in, limit)
+	if err != nil {
+		return stats, err
+	}
+	if len(namespace) == 0 {
+		return stats, ErrNamespaceExport.New("empty namespace")
+	}
+	stats.Namespace = namespace
+
+	var batch []exportEntry
+	batchBytes := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := b.importEntries(namespace, batch, &stats); err != nil {
+			return err
+		}
+		stats.Resume = ResumeToken(concat([]byte{resumeTokenVersion}, batch[len(batch)-1].key))
+		batch, batchBytes = batch[:0], 0
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, errs.Combine(err, flush())
+		}
+		entry, end, err := readExportEntry(in, namespace, limit)
+		if err != nil {
+			return stats, errs.Combine(err, flush())
+		}
+		if end != nil {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+			used, trash := end[0], end[1]
+			stats.SourceBytes, stats.SourcePieces = used.Bytes, used.Pieces
+			stats.SourceTrashBytes, stats.SourceTrashPieces = trash.Bytes, trash.Pieces
+			stats.Resume = nil
+			return stats, nil
+		}
+		batch = append(batch, entry)
+		batchBytes += len(entry.value)
+		if len(batch) >= namespaceImportBatchSize || batchBytes >= namespaceImportBatchBytes {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+}
+
+// readExportEntry reads the next entry of the stream, or the usage counters
+// of its end.
+func readExportEntry(in *bufio.Reader, namespace []byte, limit int64) (entry exportEntry, end []usage, err error) {
+	kind, err := in.ReadByte()
+	if err != nil {
+		return entry, nil, ErrNamespaceExport.New("stream ended before its end: %v", err)
+	}
+	switch kind {
+	case exportEnd:
+		raw := make([]byte, 32)
+		if _, err := io.ReadFull(in, raw); err != nil {
+			return entry, nil, ErrNamespaceExport.Wrap(err)
+		}
+		used, err := decodeUsage(raw[:16])
+		if err != nil {
+			return entry, nil, err
+		}
+		trash, err := decodeUsage(raw[16:])
+		return entry, []usage{used, trash}, err
+	case exportBlob, exportTrash:
+	default:
+		return entry, nil, ErrNamespaceExport.New("unknown entry kind %q", kind)
+	}
+	entry.trash = kind == exportTrash
+	suffix, err := readExportBytes(in, limit)
+	if err != nil {
+		return entry, nil, err
+	}
+	if len(suffix) <= 16 {
+		return entry, nil, ErrNamespaceExport.New("invalid key %x", suffix)
+	}
+	prefix := blobPrefix
+	if entry.trash {
+		prefix = trashPrefix
+	}
+	entry.key = concat(prefix, namespace, suffix)
+	entry.ref = blobstore.BlobRef{Namespace: namespace, Key: suffix[:len(suffix)-16]}
+	if entry.userMeta, err = in.ReadByte(); err != nil {
+		return entry, nil, ErrNamespaceExport.Wrap(err)
+	}
+	if entry.trash {
+		if entry.trashTime, err = readExportBytes(in, limit); err != nil {
+			return entry, nil, err
+		}
+		if n := len(entry.trashTime); n != 0 && n != 8 && n != 16 {
+			return entry, nil, ErrNamespaceExport.New("trash time of %x has %d bytes", suffix, n)
+		}
+	}
+	entry.value, err = readExportBytes(in, limit)
+	return entry, nil, err
+}
+
+// readExportBytes reads a length prefixed field of the stream, of at most
+// limit bytes.
+func readExportBytes(in *bufio.Reader, limit int64) ([]byte, error) {
+	size, err := binary.ReadUvarint(in)
+	if err != nil {
+		return nil, ErrNamespaceExport.Wrap(err)
+	}
+	if size > uint64(limit) {
+		return nil, ErrNamespaceExport.New("field of %d bytes", size)
+	}
+	raw := make([]byte, size)
+	_, err = io.ReadFull(in, raw)
+	return raw, ErrNamespaceExport.Wrap(err)
+}
+
+// importEntries stores the entries in one transaction, like commits and
+// trash moves do, with their usage counters.
+func (b *BlobStore) importEntries(namespace []byte, entries []exportEntry, stats *NamespaceImport) error {
+	var imported NamespaceImport
+	err := update(b.db, func(txn kvTxn) error {
+		imported = NamespaceImport{}
+		for _, entry := range entries {
+			_, err := txn.Get(entry.key)
+			if err == nil {
+				imported.Skipped++
+				continue
+			}
+			if !errs.Is(err, errKeyNotFound) {
+				return errs.Wrap(err)
+			}
+			_, size := stat(entry.key)
+			delta := usage{Bytes: int64(size), Pieces: 1}
+			if !entry.trash {
+				for _, stale := range blobVersions(txn, keyPrefix(entry.ref)) {
+					if err := removeEntry(txn, stale.key); err != nil {
+						return err
+					}
+					delta = delta.add(usage{Bytes: -stale.size, Pieces: -1})
+				}
+			}
+			if err := setBlob(txn, entry.key, entry.value, entry.userMeta, b.config.Dedup, b.config.ChunkSize.Int64()); err != nil {
+				return err
+			}
+			if err := setNamespaceMarker(txn, namespace); err != nil {
+				return err
+			}
+			if entry.trash {
+				if len(entry.trashTime) > 0 {
+					if err := txn.Set(trashTimeKey(entry.key), entry.trashTime); err != nil {
+						return err
+					}
+				}
+				if err := addTrashUsage(txn, entry.ref, delta); err != nil {
+					return err
+				}
+				imported.Trash++
+			} else {
+				if err := addUsage(txn, entry.ref, delta); err != nil {
+					return err
+				}
+				imported.Blobs++
+			}
+			imported.Bytes += int64(size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.addNamespace(namespace)
+	for _, entry := range entries {
+		b.invalidate(entry.ref)
+	}
+	stats.Blobs += imported.Blobs
+	stats.Trash += imported.Trash
+	stats.Bytes += imported.Bytes
+	stats.Skipped += imported.Skipped
+	return nil
+}
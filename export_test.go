@@ -0,0 +1,88 @@
+package badger
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestExportImportNamespace(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { namespaceImportBatchSize = size }(namespaceImportBatchSize)
+	namespaceImportBatchSize = 1
+
+	source, err := NewBlobStoreWithConfig(ctx.Dir("source"), Config{Dedup: true})
+	require.NoError(t, err)
+	defer ctx.Check(source.Close)
+
+	trashedAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, save(ctx, source, ref("ns", "key1"), "first"))
+	require.NoError(t, save(ctx, source, ref("ns", "key2"), "first"))
+	require.NoError(t, save(ctx, source, ref("ns", "key3"), "third"))
+	require.NoError(t, source.Trash(ctx, ref("ns", "key3"), trashedAt))
+	// the namespaces sharing the prefix aren't exported
+	require.NoError(t, save(ctx, source, ref("ns2", "key"), "other"))
+
+	var stream bytes.Buffer
+	exported, err := source.ExportNamespace(ctx, &stream, []byte("ns"), nil)
+	require.NoError(t, err)
+	require.Equal(t, NamespaceExport{Blobs: 2, Trash: 1, Bytes: 15}, exported)
+
+	target, err := NewBlobStore(ctx.Dir("target"))
+	require.NoError(t, err)
+	defer ctx.Check(target.Close)
+
+	// an interrupted import continues from its position
+	truncated := stream.Bytes()[:stream.Len()/2]
+	imported, err := target.ImportNamespace(ctx, bytes.NewReader(truncated))
+	require.True(t, ErrNamespaceExport.Has(err))
+	require.NotNil(t, imported.Resume)
+	require.NotZero(t, imported.Blobs)
+	first := imported
+
+	var rest bytes.Buffer
+	_, err = source.ExportNamespace(ctx, &rest, []byte("ns"), imported.Resume)
+	require.NoError(t, err)
+	imported, err = target.ImportNamespace(ctx, &rest)
+	require.NoError(t, err)
+	require.Nil(t, imported.Resume)
+	require.Equal(t, []byte("ns"), imported.Namespace)
+	require.EqualValues(t, 2, first.Blobs+imported.Blobs)
+	require.EqualValues(t, 1, first.Trash+imported.Trash)
+	require.EqualValues(t, 10, imported.SourceBytes)
+	require.EqualValues(t, 5, imported.SourceTrashBytes)
+
+	reader, err := target.Open(ctx, ref("ns", "key2"))
+	require.NoError(t, err)
+	data, err := rall(reader)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(data))
+	require.NoError(t, reader.Close())
+	_, err = target.Stat(ctx, ref("ns2", "key"))
+	require.Error(t, err)
+
+	used, err := target.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.EqualValues(t, 10, used)
+	trash, err := target.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, trash)
+	days, err := target.TrashDays(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{trashedAt.Truncate(24 * time.Hour)}, days)
+
+	// importing again skips the entries stored already
+	imported, err = target.ImportNamespace(ctx, bytes.NewReader(stream.Bytes()))
+	require.NoError(t, err)
+	require.EqualValues(t, 3, imported.Skipped)
+	used, err = target.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.EqualValues(t, 10, used)
+
+	_, err = target.ImportNamespace(ctx, bytes.NewReader([]byte("garbage")))
+	require.True(t, ErrNamespaceExport.Has(err))
+}
@@ -0,0 +1,48 @@
+package badger
+
+import (
+	"expvar"
+	"github.com/spacemonkeygo/monkit/v3"
+	"strings"
+)
+
+// badgerExpvarPrefix is the prefix of the metrics badger publishes with expvar.
+const badgerExpvarPrefix = "badger_"
+
+func init() {
+	mon.Chain(monkit.StatSourceFunc(badgerExpvarStats))
+}
+
+// badgerExpvarStats republishes the badger expvar metrics as monkit stats,
+// sampled whenever the stats are collected. Metrics kept per directory or
+// level are tagged with the map key.
+func badgerExpvarStats(cb func(key monkit.SeriesKey, field string, val float64)) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !strings.HasPrefix(kv.Key, badgerExpvarPrefix) {
+			return
+		}
+		key := monkit.NewSeriesKey(kv.Key)
+		if m, ok := kv.Value.(*expvar.Map); ok {
+			m.Do(func(entry expvar.KeyValue) {
+				if val, ok := expvarNumber(entry.Value); ok {
+					cb(key.WithTag("key", entry.Key), "value", val)
+				}
+			})
+			return
+		}
+		if val, ok := expvarNumber(kv.Value); ok {
+			cb(key, "value", val)
+		}
+	})
+}
+
+func expvarNumber(v expvar.Var) (float64, bool) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		return float64(v.Value()), true
+	case *expvar.Float:
+		return v.Value(), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,30 @@
+package badger
+
+import (
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestBadgerExpvarStats(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	before := counterValue("badger_put_num_user")
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.Greater(t, counterValue("badger_put_num_user"), before)
+
+	var lsmSizeTagged bool
+	mon.Stats(func(key monkit.SeriesKey, field string, val float64) {
+		if key.Measurement == "badger_size_bytes_lsm" && key.Tags.Get("key") == dir {
+			lsmSizeTagged = true
+		}
+	})
+	require.True(t, lsmSizeTagged)
+}
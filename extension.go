@@ -0,0 +1,254 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// ErrExtension is returned for the misuse of the extension API, like
+// changes outside of UpdateRecords.
+var ErrExtension = errs.Class("extension")
+
+// BlobRecord is the decoded record of a blob or trash entry, for the custom
+// queries of embedders.
+type BlobRecord struct {
+	Ref     blobstore.BlobRef
+	Trash   bool
+	ModTime time.Time
+	Size    int64
+	Format  blobstore.FormatVersion
+	// TrashedAt is when the entry was trashed, for trash entries.
+	TrashedAt time.Time
+	// LastAccess is the last read recorded with Config.AccessTracking.
+	LastAccess time.Time
+	// Deduplicated blobs share their data with other blobs, Cold ones are
+	// in the cold store, Chunked ones are stored in chunks.
+	Deduplicated bool
+	Cold         bool
+	Chunked      bool
+}
+
+// RecordView is a read-only view of the records of the store at a single
+// version. It's valid only in the function given to ViewRecords or
+// UpdateRecords.
+type RecordView struct {
+	store      *BlobStore
+	txn        kvTxn
+	namespaces [][]byte
+}
+
+// ViewRecords calls fn with a read-only view of the blob records of the
+// store, for custom queries over them without access to the badger keys.
+func (b *BlobStore) ViewRecords(ctx context.Context, fn func(v *RecordView) error) error {
+	return view(b.db, func(txn kvTxn) error {
+		v := &RecordView{store: b, txn: txn, namespaces: readNamespaces(txn)}
+		defer func() { v.txn = nil }()
+		return fn(v)
+	})
+}
+
+func (v *RecordView) check() error {
+	if v.txn == nil {
+		return ErrExtension.New("view used after its function returned")
+	}
+	return nil
+}
+
+// Namespaces returns the namespaces of the store.
+func (v *RecordView) Namespaces() ([][]byte, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+	return append([][]byte{}, v.namespaces...), nil
+}
+
+// Usage returns the usage counters of the blobs and the trash of the
+// namespace.
+func (v *RecordView) Usage(namespace []byte) (NamespaceStats, error) {
+	if err := v.check(); err != nil {
+		return NamespaceStats{}, err
+	}
+	live, err := namespaceUsage(v.txn, namespace)
+	if err != nil {
+		return NamespaceStats{}, err
+	}
+	trashed, err := namespaceTrashUsage(v.txn, namespace)
+	if err != nil {
+		return NamespaceStats{}, err
+	}
+	return NamespaceStats{
+		Namespace:   namespace,
+		Pieces:      live.Pieces,
+		Bytes:       live.Bytes,
+		TrashPieces: trashed.Pieces,
+		TrashBytes:  trashed.Bytes,
+	}, nil
+}
+
+// Blob returns the record of the blob, false if it doesn't exist.
+func (v *RecordView) Blob(ref blobstore.BlobRef) (BlobRecord, bool, error) {
+	if err := v.check(); err != nil {
+		return BlobRecord{}, false, err
+	}
+	versions := blobVersions(v.txn, keyPrefix(ref))
+	if len(versions) == 0 {
+		return BlobRecord{}, false, nil
+	}
+	item, err := v.txn.Get(versions[0].key)
+	if err != nil {
+		return BlobRecord{}, false, errs.Wrap(err)
+	}
+	record, err := v.record(ref, item)
+	return record, err == nil, err
+}
+
+// Blobs calls fn with the records of the blobs of the namespace, in the
+// order of WalkNamespace.
+func (v *RecordView) Blobs(ctx context.Context, namespace []byte, fn func(BlobRecord) error) error {
+	return v.each(ctx, blobPrefix, namespace, fn)
+}
+
+// TrashEntries calls fn with the records of the trash entries of the
+// namespace.
+func (v *RecordView) TrashEntries(ctx context.Context, namespace []byte, fn func(BlobRecord) error) error {
+	return v.each(ctx, trashPrefix, namespace, fn)
+}
+
+func (v *RecordView) each(ctx context.Context, prefix []byte, namespace []byte, fn func(BlobRecord) error) error {
+	if err := v.check(); err != nil {
+		return err
+	}
+	it := v.txn.NewIterator(v.store.iteratorOptions(IteratorWalk, concat(prefix, namespace)))
+	defer it.Close()
+	var last []byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := it.Item()
+		raw := item.Key()[len(prefix):]
+		if last != nil && sameBlob(raw, last) {
+			continue
+		}
+		ref, ok := splitKey(v.namespaces, raw)
+		// namespaces having this one as prefix share the key prefix
+		if !ok || !bytes.Equal(ref.Namespace, namespace) {
+			continue
+		}
+		last = append(last[:0], raw...)
+		record, err := v.record(ref, item)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record decodes the entry of the blob.
+func (v *RecordView) record(ref blobstore.BlobRef, item kvItem) (record BlobRecord, err error) {
+	key := item.Key()
+	modTime, size := stat(key)
+	record = BlobRecord{
+		Ref:          ref,
+		Trash:        bytes.HasPrefix(key, trashPrefix),
+		ModTime:      modTime,
+		Size:         int64(size),
+		Format:       storedFormat(item.UserMeta()),
+		Deduplicated: item.UserMeta()&userMetaDedup != 0,
+		Cold:         item.UserMeta()&userMetaCold != 0,
+		Chunked:      item.UserMeta()&userMetaChunked != 0,
+	}
+	if record.Trash {
+		t, err := readTrashTime(v.txn, key)
+		if err != nil {
+			return record, err
+		}
+		record.TrashedAt = t.trashedAt
+	}
+	record.LastAccess, err = v.store.access.lastAccess(v.txn, ref)
+	return record, err
+}
+
+// RecordBatch collects the changes of UpdateRecords, next to the view of the
+// records they are decided on.
+type RecordBatch struct {
+	*RecordView
+	changes []recordChange
+}
+
+type recordChange struct {
+	ref blobstore.BlobRef
+	// trashedAt is the trash time of the blob, zero deletes it
+	trashedAt time.Time
+}
+
+// Delete deletes the blob when the batch is applied.
+func (m *RecordBatch) Delete(ref blobstore.BlobRef) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	m.changes = append(m.changes, recordChange{ref: ref})
+	return nil
+}
+
+// Trash moves the blob to the trash with the timestamp when the batch is
+// applied.
+func (m *RecordBatch) Trash(ref blobstore.BlobRef, timestamp time.Time) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if timestamp.IsZero() {
+		return ErrExtension.New("trash of %x without timestamp", ref.Key)
+	}
+	m.changes = append(m.changes, recordChange{ref: ref, trashedAt: timestamp})
+	return nil
+}
+
+// UpdateRecords calls fn with a view of the records and a batch collecting
+// the deletes and trashing of blobs decided on them, like custom cleanups.
+// The changes are applied after fn returns without error, in order, with
+// the operations of the store, so the usage counters, the deduplicated data,
+// the caches and the hooks stay consistent. The blobs changed since the view
+// are changed as they are then, and missing blobs are skipped. It returns
+// the number of changes applied; a failing change stops the batch.
+func (b *BlobStore) UpdateRecords(ctx context.Context, fn func(m *RecordBatch) error) (applied int, err error) {
+	if err := b.readOnly.check(); err != nil {
+		return 0, err
+	}
+	var changes []recordChange
+	err = b.ViewRecords(ctx, func(v *RecordView) error {
+		batch := &RecordBatch{RecordView: v}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		changes = batch.changes
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, change := range changes {
+		if err := ctx.Err(); err != nil {
+			return applied, err
+		}
+		if change.trashedAt.IsZero() {
+			err = b.Delete(ctx, change.ref)
+		} else {
+			err = b.Trash(ctx, change.ref, change.trashedAt)
+		}
+		if ErrNotExist.Has(err) {
+			continue
+		}
+		if err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
@@ -0,0 +1,87 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestRecords(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	now := time.Unix(time.Now().Unix(), 0)
+	store.SetClock(fixedClock(now))
+	require.NoError(t, save(ctx, store, ref("ns", "a"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns", "b"), "123"))
+	require.NoError(t, save(ctx, store, ref("ns", "c"), "1"))
+	// a namespace having the other one as prefix
+	require.NoError(t, save(ctx, store, ref("nsx", "d"), "1"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "c"), now))
+
+	var escaped *RecordView
+	err = store.ViewRecords(ctx, func(v *RecordView) error {
+		escaped = v
+		record, found, err := v.Blob(ref("ns", "a"))
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, BlobRecord{Ref: ref("ns", "a"), ModTime: now, Size: 5, Format: store.defaultFormat()}, record)
+		_, found, err = v.Blob(ref("ns", "c"))
+		require.NoError(t, err)
+		require.False(t, found)
+
+		var keys []string
+		require.NoError(t, v.Blobs(ctx, []byte("ns"), func(record BlobRecord) error {
+			keys = append(keys, string(record.Ref.Key))
+			return nil
+		}))
+		require.Equal(t, []string{"a", "b"}, keys)
+
+		var trash []BlobRecord
+		require.NoError(t, v.TrashEntries(ctx, []byte("ns"), func(record BlobRecord) error {
+			trash = append(trash, record)
+			return nil
+		}))
+		require.Len(t, trash, 1)
+		require.True(t, trash[0].Trash)
+		require.Equal(t, now, trash[0].TrashedAt)
+
+		usage, err := v.Usage([]byte("ns"))
+		require.NoError(t, err)
+		require.EqualValues(t, 8, usage.Bytes)
+		require.EqualValues(t, 1, usage.TrashPieces)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = escaped.Namespaces()
+	require.True(t, ErrExtension.Has(err))
+
+	// a custom cleanup of the blobs larger than 4 bytes
+	applied, err := store.UpdateRecords(ctx, func(m *RecordBatch) error {
+		require.NoError(t, m.Delete(ref("ns", "missing")))
+		require.True(t, ErrExtension.Has(m.Trash(ref("ns", "b"), time.Time{})))
+		return m.Blobs(ctx, []byte("ns"), func(record BlobRecord) error {
+			if record.Size > 4 {
+				return m.Trash(record.Ref, now)
+			}
+			return m.Delete(record.Ref)
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, applied)
+
+	for _, key := range []string{"a", "b"} {
+		_, err := store.Stat(ctx, ref("ns", key))
+		require.True(t, ErrNotExist.Has(err), key)
+	}
+	_, err = store.Stat(ctx, ref("nsx", "d"))
+	require.NoError(t, err)
+	total, err := store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, total)
+}
@@ -0,0 +1,195 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"math/rand"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of the faults injected by FaultyBlobStore without
+// an error of their own.
+var ErrInjected = errs.Class("injected fault")
+
+// The operations of the readers and writers of FaultyBlobStore with faults
+// of their own. The other operations are the methods of blobstore.Blobs, like
+// "Create" or "Stat".
+const (
+	// FaultWrite is the Write of the blob writers.
+	FaultWrite = "Write"
+	// FaultCommit is the Commit of the blob writers.
+	FaultCommit = "Commit"
+	// FaultRead is the Read and ReadAt of the blob readers.
+	FaultRead = "Read"
+)
+
+// Fault is the failure injected into the calls of an operation.
+type Fault struct {
+	// Rate is the share of the calls failing, from 0 to 1.
+	Rate float64
+	// Err is returned by the failing calls, an ErrInjected error if nil.
+	Err error
+	// Latency delays every call of the operation, failing or not.
+	Latency time.Duration
+	// PartialWrite is the share of the data a failing Write writes before
+	// returning the error, like when the disk fills up.
+	PartialWrite float64
+}
+
+// FaultyBlobStore wraps a blob store, injecting errors, latency and partial
+// writes into its operations, to test the error handling of the storagenode
+// against realistic failures of the store. The faults are random, with the
+// seed of the store, and can be changed while it's used.
+type FaultyBlobStore struct {
+	blobstore.Blobs
+
+	mu       sync.Mutex
+	random   *rand.Rand
+	faults   map[string]Fault
+	injected map[string]int64
+}
+
+var _ blobstore.Blobs = &FaultyBlobStore{}
+
+// NewFaultyBlobStore returns the blob store without faults, until they are
+// set with SetFault.
+func NewFaultyBlobStore(blobs blobstore.Blobs, seed int64) *FaultyBlobStore {
+	f := &FaultyBlobStore{
+		random:   rand.New(rand.NewSource(seed)),
+		faults:   map[string]Fault{},
+		injected: map[string]int64{},
+	}
+	f.Blobs = Intercept(blobs, func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		if _, err := f.inject(ctx, call.Method); err != nil {
+			return err
+		}
+		return next(ctx)
+	})
+	return f
+}
+
+// SetFault sets the fault of the operation, replacing the earlier one.
+func (f *FaultyBlobStore) SetFault(operation string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[operation] = fault
+}
+
+// ClearFaults removes the faults of every operation.
+func (f *FaultyBlobStore) ClearFaults() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = map[string]Fault{}
+}
+
+// Injected returns the number of calls of the operation failed so far.
+func (f *FaultyBlobStore) Injected(operation string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected[operation]
+}
+
+// inject delays the call of the operation by the latency of its fault, and
+// returns the error if the call fails.
+func (f *FaultyBlobStore) inject(ctx context.Context, operation string) (Fault, error) {
+	f.mu.Lock()
+	fault, ok := f.faults[operation]
+	failing := ok && fault.Rate > 0 && f.random.Float64() < fault.Rate
+	if failing {
+		f.injected[operation]++
+	}
+	f.mu.Unlock()
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fault, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if !failing {
+		return fault, nil
+	}
+	if fault.Err != nil {
+		return fault, fault.Err
+	}
+	return fault, ErrInjected.New("%s", operation)
+}
+
+// Create creates the blob with a writer injecting the faults of FaultWrite
+// and FaultCommit.
+func (f *FaultyBlobStore) Create(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
+	w, err := f.Blobs.Create(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyWriter{BlobWriter: w, store: f}, nil
+}
+
+// Open opens the blob with a reader injecting the faults of FaultRead.
+func (f *FaultyBlobStore) Open(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobReader, error) {
+	r, err := f.Blobs.Open(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyReader{BlobReader: r, store: f}, nil
+}
+
+// OpenWithStorageFormat opens the blob like Open.
+func (f *FaultyBlobStore) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (blobstore.BlobReader, error) {
+	r, err := f.Blobs.OpenWithStorageFormat(ctx, ref, formatVer)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyReader{BlobReader: r, store: f}, nil
+}
+
+type faultyWriter struct {
+	blobstore.BlobWriter
+	store *FaultyBlobStore
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	fault, err := w.store.inject(context.Background(), FaultWrite)
+	if err == nil {
+		return w.BlobWriter.Write(p)
+	}
+	partial := int(float64(len(p)) * fault.PartialWrite)
+	if partial <= 0 {
+		return 0, err
+	}
+	if partial > len(p) {
+		partial = len(p)
+	}
+	n, writeErr := w.BlobWriter.Write(p[:partial])
+	return n, errs.Combine(err, writeErr)
+}
+
+func (w *faultyWriter) Commit(ctx context.Context) error {
+	if _, err := w.store.inject(ctx, FaultCommit); err != nil {
+		return err
+	}
+	return w.BlobWriter.Commit(ctx)
+}
+
+type faultyReader struct {
+	blobstore.BlobReader
+	store *FaultyBlobStore
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	if _, err := r.store.inject(context.Background(), FaultRead); err != nil {
+		return 0, err
+	}
+	return r.BlobReader.Read(p)
+}
+
+func (r *faultyReader) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.store.inject(context.Background(), FaultRead); err != nil {
+		return 0, err
+	}
+	return r.BlobReader.ReadAt(p, off)
+}
@@ -0,0 +1,74 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"io"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestFaultyBlobStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	faulty := NewFaultyBlobStore(store, 1)
+	defer ctx.Check(faulty.Close)
+
+	require.NoError(t, save(ctx, faulty, ref("ns", "key"), "data"))
+
+	// errors of the store methods, with the default or the given error
+	faulty.SetFault("Stat", Fault{Rate: 1})
+	_, err = faulty.Stat(ctx, ref("ns", "key"))
+	require.True(t, ErrInjected.Has(err), err)
+	custom := errs.New("disk on fire")
+	faulty.SetFault("Delete", Fault{Rate: 1, Err: custom})
+	require.ErrorIs(t, faulty.Delete(ctx, ref("ns", "key")), custom)
+	require.EqualValues(t, 1, faulty.Injected("Stat"))
+	require.EqualValues(t, 1, faulty.Injected("Delete"))
+
+	// a share of the calls fails
+	faulty.SetFault("Stat", Fault{Rate: 0.5})
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := faulty.Stat(ctx, ref("ns", "key")); err != nil {
+			failed++
+		}
+	}
+	require.Greater(t, failed, 20)
+	require.Less(t, failed, 80)
+
+	// latency without failures
+	faulty.ClearFaults()
+	faulty.SetFault("Stat", Fault{Latency: 20 * time.Millisecond})
+	started := time.Now()
+	_, err = faulty.Stat(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond)
+
+	// a partial write
+	faulty.SetFault(FaultWrite, Fault{Rate: 1, PartialWrite: 0.5})
+	w, err := faulty.Create(ctx, ref("ns", "partial"))
+	require.NoError(t, err)
+	n, err := w.Write([]byte("12345678"))
+	require.True(t, ErrInjected.Has(err), err)
+	require.Equal(t, 4, n)
+	faulty.SetFault(FaultCommit, Fault{Rate: 1})
+	require.True(t, ErrInjected.Has(w.Commit(ctx)))
+	faulty.ClearFaults()
+	require.NoError(t, w.Commit(ctx))
+	data, err := store.ReadRange(ctx, ref("ns", "partial"), 0, 4)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(data))
+
+	// read errors
+	faulty.SetFault(FaultRead, Fault{Rate: 1})
+	r, err := faulty.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.True(t, ErrInjected.Has(err), err)
+	require.NoError(t, r.Close())
+}
@@ -0,0 +1,195 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"time"
+)
+
+// forgetPrefix keys mark the namespaces being forgotten, with the progress
+// made so far as value. Stores opened with pending markers finish the work.
+var forgetPrefix = []byte("frget")
+
+// forgetBatchSize is the number of keys removed in one transaction, keeping
+// the transactions of large namespaces under the badger limits.
+var forgetBatchSize = 1000
+
+// ForgetProgress is the work done by a namespace removal.
+type ForgetProgress struct {
+	Blobs      int64 `json:"blobs"`
+	TrashBlobs int64 `json:"trash_blobs"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// ForgetNamespace removes all the blobs, trash, usage counters and the
+// marker of the namespace, then reclaims the space on disk. It can be called
+// again after an interruption, continuing where it stopped.
+func (b *BlobStore) ForgetNamespace(ctx context.Context, namespace []byte) error {
+	var progress ForgetProgress
+	err := update(b.db, func(txn kvTxn) (err error) {
+		var found bool
+		progress, found, err = readForget(txn, namespace)
+		if err != nil || found {
+			return err
+		}
+		return writeForget(txn, namespace, progress)
+	})
+	if err != nil {
+		return err
+	}
+	b.log.Info("forgetting namespace", zap.Binary("namespace", namespace))
+	for _, prefix := range [][]byte{blobPrefix, trashPrefix} {
+		for more := true; more; {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			more, err = b.forgetBatch(namespace, prefix, &progress)
+			if err != nil {
+				return err
+			}
+			b.log.Debug("forgetting namespace", zap.Binary("namespace", namespace),
+				zap.Int64("blobs", progress.Blobs), zap.Int64("trash blobs", progress.TrashBlobs), zap.Int64("bytes", progress.Bytes))
+		}
+	}
+	err = update(b.db, func(txn kvTxn) error {
+		for shard := 0; shard < usageShards; shard++ {
+			if err := txn.Delete(usageKey(namespace, byte(shard))); err != nil {
+				return err
+			}
+			if err := txn.Delete(trashUsageKey(namespace, byte(shard))); err != nil {
+				return err
+			}
+		}
+		if err := txn.Delete(concat(namespacePrefix, namespace)); err != nil {
+			return err
+		}
+		return txn.Delete(concat(forgetPrefix, namespace))
+	})
+	if err != nil {
+		return err
+	}
+	b.removeNamespace(namespace)
+	b.log.Info("forgot namespace", zap.Binary("namespace", namespace),
+		zap.Int64("blobs", progress.Blobs), zap.Int64("trash blobs", progress.TrashBlobs), zap.Int64("bytes", progress.Bytes))
+	b.auditNamespace(ctx, auditForgetNamespace, namespace, progress.Blobs+progress.TrashBlobs, progress.Bytes)
+	return b.reclaimSpace(ctx, time.Time{})
+}
+
+// forgetBatch removes up to forgetBatchSize keys of the namespace with the
+// prefix, and returns whether there may be more.
+func (b *BlobStore) forgetBatch(namespace []byte, prefix []byte, progress *ForgetProgress) (more bool, err error) {
+	var removed []deletion
+	batch := *progress
+	namespaces := b.knownNamespaces()
+	err = update(b.db, func(txn kvTxn) error {
+		removed, more, batch = nil, false, *progress
+		it := txn.NewIterator(b.iteratorOptions(IteratorWalk, concat(prefix, namespace)))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			// namespaces having this one as prefix share the key prefix
+			ref, ok := splitKey(namespaces, key[len(prefix):])
+			if !ok || !bytesEq(ref.Namespace, namespace) {
+				continue
+			}
+			if len(removed) == forgetBatchSize {
+				more = true
+				break
+			}
+			if err := removeEntry(txn, key); err != nil {
+				return err
+			}
+			_, size := stat(key)
+			delta := usage{Bytes: -int64(size), Pieces: -1}
+			if bytesEq(prefix, trashPrefix) {
+				batch.TrashBlobs++
+				err = addTrashUsage(txn, ref, delta)
+			} else {
+				batch.Blobs++
+				err = addUsage(txn, ref, delta)
+			}
+			if err != nil {
+				return err
+			}
+			batch.Bytes += int64(size)
+			removed = append(removed, deletionOf(ref, key))
+		}
+		return writeForget(txn, namespace, batch)
+	})
+	if err != nil {
+		return false, err
+	}
+	*progress = batch
+	mon.Counter("forget_removed_keys").Inc(int64(len(removed)))
+	for _, d := range removed {
+		b.invalidate(d.ref)
+	}
+	b.reportDeletions(reportForget, removed)
+	if bytesEq(prefix, trashPrefix) {
+		b.hooks.callEach(&b.hooks.emptyTrash, removed)
+	} else {
+		b.hooks.callEach(&b.hooks.delete, removed)
+	}
+	return more, nil
+}
+
+// ForgetStatus returns the progress of the removal of the namespace, and
+// whether one is pending.
+func (b *BlobStore) ForgetStatus(ctx context.Context, namespace []byte) (progress ForgetProgress, pending bool, err error) {
+	err = b.db.View(func(txn kvTxn) (err error) {
+		progress, pending, err = readForget(txn, namespace)
+		return err
+	})
+	return progress, pending, err
+}
+
+// resumeForget finishes the namespace removals interrupted by a restart.
+func (b *BlobStore) resumeForget() error {
+	var pending [][]byte
+	err := b.db.View(func(txn kvTxn) error {
+		it := txn.NewIterator(b.iteratorOptions(IteratorRecords, forgetPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			pending = append(pending, it.Item().KeyCopy(nil)[len(forgetPrefix):])
+		}
+		return nil
+	})
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	b.runBackground("forget namespaces", func(ctx context.Context) {
+		for _, namespace := range pending {
+			if err := b.ForgetNamespace(ctx, namespace); err != nil {
+				b.log.Warn("forgetting namespace failed", zap.Binary("namespace", namespace), zap.Error(err))
+			}
+		}
+	})
+	return nil
+}
+
+func readForget(txn kvTxn, namespace []byte) (progress ForgetProgress, found bool, err error) {
+	item, err := txn.Get(concat(forgetPrefix, namespace))
+	if errs.Is(err, errKeyNotFound) {
+		return progress, false, nil
+	}
+	if err != nil {
+		return progress, false, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &progress)
+	})
+	if err != nil {
+		return progress, false, ErrCorrupt.New("invalid forget progress of namespace %x: %v", namespace, err)
+	}
+	return progress, true, nil
+}
+
+func writeForget(txn kvTxn, namespace []byte, progress ForgetProgress) error {
+	raw, err := json.Marshal(progress)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Set(concat(forgetPrefix, namespace), raw)
+}
@@ -0,0 +1,71 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestForgetNamespace(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { forgetBatchSize = size }(forgetBatchSize)
+	forgetBatchSize = 2
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	for _, key := range []string{"key1", "key2", "key3", "key4", "key5"} {
+		require.NoError(t, save(ctx, store, ref("ns", key), "data"))
+	}
+	// namespaces having the forgotten one as prefix are kept
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "12345"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key5"), time.Now()))
+	require.NoError(t, store.Trash(ctx, ref("ns1", "key1"), time.Now()))
+	require.NoError(t, save(ctx, store, ref("ns1", "key2"), "123"))
+
+	require.NoError(t, store.ForgetNamespace(ctx, []byte("ns")))
+
+	_, err = store.Open(ctx, ref("ns", "key1"))
+	require.True(t, ErrNotExist.Has(err))
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("ns1")}, namespaces)
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), used)
+	trash, err := store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), trash)
+
+	_, pending, err := store.ForgetStatus(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.False(t, pending)
+
+	// interrupted removals are finished after a restart
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "data"))
+	err = store.db.Update(func(txn kvTxn) error {
+		return writeForget(txn, []byte("ns2"), ForgetProgress{Blobs: 7, Bytes: 70})
+	})
+	require.NoError(t, err)
+	progress, pending, err := store.ForgetStatus(ctx, []byte("ns2"))
+	require.NoError(t, err)
+	require.True(t, pending)
+	require.Equal(t, int64(7), progress.Blobs)
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.Eventually(t, func() bool {
+		_, pending, err := store.ForgetStatus(ctx, []byte("ns2"))
+		require.NoError(t, err)
+		return !pending
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err = store.Open(ctx, ref("ns2", "key1"))
+	require.True(t, ErrNotExist.Has(err))
+}
@@ -0,0 +1,127 @@
+package badger
+
+import (
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+)
+
+// FormatV2 stores the piece header of FormatV1 blobs without the zero
+// padding of its reserved area. Readers see the same bytes as for FormatV1.
+const FormatV2 blobstore.FormatVersion = 2
+
+// ErrUnsupportedFormat is returned for storage formats the store can't handle.
+var ErrUnsupportedFormat = errs.Class("unsupported storage format")
+
+// pieceHeaderArea is the space reserved for the piece header at the
+// beginning of the blobs, pieces.V1PieceHeaderReservedArea upstream.
+const pieceHeaderArea = 512
+
+// The storage format is kept in bits of the user meta of the blob entries,
+// next to userMetaDedup. Entries written before it existed have no bits set
+// and are FormatV1.
+const (
+	userMetaFormatMask byte = 0b110
+	userMetaFormatV2   byte = 0b010
+	userMetaFormatV0   byte = 0b100
+)
+
+// formatUserMeta returns the user meta bits of the format.
+func formatUserMeta(format blobstore.FormatVersion) byte {
+	switch format {
+	case FormatV2:
+		return userMetaFormatV2
+	case filestore.FormatV0:
+		return userMetaFormatV0
+	}
+	return 0
+}
+
+// storedFormat returns the format of an entry from its user meta.
+func storedFormat(userMeta byte) blobstore.FormatVersion {
+	switch userMeta & userMetaFormatMask {
+	case userMetaFormatV2:
+		return FormatV2
+	case userMetaFormatV0:
+		return filestore.FormatV0
+	}
+	return filestore.FormatV1
+}
+
+// checkFormat returns an error for the formats the store can't read.
+func checkFormat(format blobstore.FormatVersion) error {
+	switch format {
+	case filestore.FormatV0, filestore.FormatV1, FormatV2:
+		return nil
+	}
+	return ErrUnsupportedFormat.New("%d", format)
+}
+
+// readsAs returns whether blobs stored in the format can be opened as the
+// requested one. FormatV0 blobs have no piece header, the others read the same.
+func readsAs(stored, requested blobstore.FormatVersion) bool {
+	return (stored == filestore.FormatV0) == (requested == filestore.FormatV0)
+}
+
+// encodeValue returns the value to store for the blob data in the format.
+// FormatV2 data which doesn't hold a valid piece header is stored as FormatV1.
+func encodeValue(format blobstore.FormatVersion, data []byte) ([]byte, blobstore.FormatVersion) {
+	if format == filestore.FormatV0 {
+		return data, filestore.FormatV0
+	}
+	if format != FormatV2 {
+		return data, filestore.FormatV1
+	}
+	header, ok := pieceHeader(data)
+	if !ok {
+		return data, filestore.FormatV1
+	}
+	return concat(header, data[pieceHeaderArea:]), FormatV2
+}
+
+// decodeValue returns the blob data of the stored value, which is size
+// bytes long.
+func decodeValue(format blobstore.FormatVersion, val []byte, size int) ([]byte, error) {
+	if format != FormatV2 {
+		return val, nil
+	}
+	header, ok := framedHeader(val)
+	if !ok || size != pieceHeaderArea+len(val)-len(header) {
+		return nil, ErrCorrupt.New("invalid v2 value of %d bytes for %d byte blob", len(val), size)
+	}
+	data := make([]byte, size)
+	copy(data, header)
+	copy(data[pieceHeaderArea:], val[len(header):])
+	return data, nil
+}
+
+// pieceHeader returns the framed piece header at the beginning of data, if
+// the rest of the reserved area is zero padding.
+func pieceHeader(data []byte) ([]byte, bool) {
+	if len(data) < pieceHeaderArea {
+		return nil, false
+	}
+	header, ok := framedHeader(data[:pieceHeaderArea])
+	if !ok {
+		return nil, false
+	}
+	for _, b := range data[len(header):pieceHeaderArea] {
+		if b != 0 {
+			return nil, false
+		}
+	}
+	return header, true
+}
+
+// framedHeader returns the 2 byte length and the header following it.
+func framedHeader(raw []byte) ([]byte, bool) {
+	if len(raw) < 2 {
+		return nil, false
+	}
+	length := 2 + int(binary.BigEndian.Uint16(raw))
+	if length > pieceHeaderArea || length > len(raw) {
+		return nil, false
+	}
+	return raw[:length], true
+}
@@ -0,0 +1,210 @@
+package badger
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+	"time"
+)
+
+// pieceData returns blob data with a framed piece header in the reserved area.
+func pieceData(header, content string) string {
+	area := make([]byte, pieceHeaderArea)
+	binary.BigEndian.PutUint16(area, uint16(len(header)))
+	copy(area[2:], header)
+	return string(area) + content
+}
+
+func TestEncodeValue(t *testing.T) {
+	data := []byte(pieceData("header", "content"))
+	value, format := encodeValue(FormatV2, data)
+	require.Equal(t, FormatV2, format)
+	require.Equal(t, "\x00\x06headercontent", string(value))
+	decoded, err := decodeValue(format, value, len(data))
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+
+	_, err = decodeValue(format, value, len(data)+1)
+	require.True(t, ErrCorrupt.Has(err))
+
+	// data without a piece header is stored as written
+	for _, raw := range []string{"short", pieceData("header", "content")[:pieceHeaderArea-1], "\x00\x01x\x01" + pieceData("", "")[4:]} {
+		value, format := encodeValue(FormatV2, []byte(raw))
+		require.Equal(t, filestore.FormatV1, format)
+		require.Equal(t, raw, string(value))
+	}
+}
+
+func TestFormatV2(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{StorageFormat: 3})
+	require.True(t, ErrUnsupportedFormat.Has(err))
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{StorageFormat: int(FormatV2), Dedup: true})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	w, err := store.Create(ctx, ref("ns", "piece"))
+	require.NoError(t, err)
+	require.Equal(t, FormatV2, w.StorageFormatVersion())
+	_, err = w.Write([]byte(pieceData("header", "content")))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, save(ctx, store, ref("ns", "plain"), "plain"))
+
+	for key, expected := range map[string]struct {
+		data   string
+		format int
+	}{
+		"piece": {pieceData("header", "content"), int(FormatV2)},
+		"plain": {"plain", int(filestore.FormatV1)},
+	} {
+		reader, err := store.OpenWithStorageFormat(ctx, ref("ns", key), filestore.FormatV1)
+		require.NoError(t, err)
+		require.EqualValues(t, expected.format, reader.StorageFormatVersion())
+		size, err := reader.Size()
+		require.NoError(t, err)
+		require.Equal(t, int64(len(expected.data)), size)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, expected.data, string(data))
+		require.NoError(t, reader.Close())
+
+		info, err := store.StatWithStorageFormat(ctx, ref("ns", key), FormatV2)
+		require.NoError(t, err)
+		require.EqualValues(t, expected.format, info.StorageFormatVersion())
+	}
+
+	// only the framed header is stored
+	var stored []int
+	err = store.db.View(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: contentPrefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, len(value))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{len("\x00\x06headercontent"), len("plain")}, stored)
+
+	_, err = store.OpenWithStorageFormat(ctx, ref("ns", "piece"), 3)
+	require.True(t, ErrUnsupportedFormat.Has(err))
+	_, err = store.Stat(ctx, ref("ns", "missing"))
+	require.True(t, ErrNotExist.Has(err))
+}
+
+func TestFormatV0(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	_, err = store.CreateWithStorageFormat(ctx, ref("ns", "key"), 3)
+	require.True(t, ErrUnsupportedFormat.Has(err))
+
+	w, err := store.TestCreateV0(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV0, w.StorageFormatVersion())
+	_, err = w.Write([]byte("legacy"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, save(ctx, store, ref("ns", "v1"), "current"))
+
+	reader, err := store.Open(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV0, reader.StorageFormatVersion())
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "legacy", string(data))
+	require.NoError(t, reader.Close())
+
+	// blobs are only found in the format they are stored in
+	_, err = store.OpenWithStorageFormat(ctx, ref("ns", "v0"), filestore.FormatV1)
+	require.True(t, ErrNotExist.Has(err))
+	_, err = store.StatWithStorageFormat(ctx, ref("ns", "v1"), filestore.FormatV0)
+	require.True(t, ErrNotExist.Has(err))
+	info, err := store.StatWithStorageFormat(ctx, ref("ns", "v0"), filestore.FormatV0)
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV0, info.StorageFormatVersion())
+
+	require.NoError(t, store.DeleteWithStorageFormat(ctx, ref("ns", "v0"), filestore.FormatV1))
+	_, err = store.Stat(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+
+	// rewriting the blob during a migration replaces the V0 version
+	require.NoError(t, save(ctx, store, ref("ns", "v0"), "migrated"))
+	info, err = store.Stat(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV1, info.StorageFormatVersion())
+	_, err = store.OpenWithStorageFormat(ctx, ref("ns", "v0"), filestore.FormatV0)
+	require.True(t, ErrNotExist.Has(err))
+
+	require.NoError(t, store.DeleteWithStorageFormat(ctx, ref("ns", "v0"), filestore.FormatV1))
+	_, err = store.Stat(ctx, ref("ns", "v0"))
+	require.True(t, ErrNotExist.Has(err))
+}
+
+func TestStoredFormat(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStoreWithConfig(dir, Config{StorageFormat: int(FormatV2)})
+	require.NoError(t, err)
+
+	write := func(w blobstore.BlobWriter, data string) blobstore.BlobWriter {
+		_, err := w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Commit(ctx))
+		return w
+	}
+	w, err := store.Create(ctx, ref("ns", "v2"))
+	require.NoError(t, err)
+	require.Equal(t, FormatV2, write(w, pieceData("header", "content")).StorageFormatVersion())
+	// data without a piece header falls back to FormatV1
+	w, err = store.Create(ctx, ref("ns", "v1"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV1, write(w, "content").StorageFormatVersion())
+	w, err = store.TestCreateV0(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV0, write(w, "content").StorageFormatVersion())
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	expected := map[string]blobstore.FormatVersion{"v0": filestore.FormatV0, "v1": filestore.FormatV1, "v2": FormatV2}
+	formats := map[string]blobstore.FormatVersion{}
+	err = store.WalkNamespace(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+		formats[string(info.BlobRef().Key)] = info.StorageFormatVersion()
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected, formats)
+
+	for key, format := range expected {
+		require.NoError(t, store.Trash(ctx, ref("ns", key), time.Now()))
+		info, err := store.Stat(ctx, ref("ns", key))
+		require.True(t, ErrNotExist.Has(err), key)
+		require.Nil(t, info)
+		_, err = store.RestoreTrash(ctx, []byte("ns"))
+		require.NoError(t, err)
+		info, err = store.Stat(ctx, ref("ns", key))
+		require.NoError(t, err)
+		require.Equal(t, format, info.StorageFormatVersion(), key)
+	}
+}
@@ -0,0 +1,87 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"storj.io/common/bloomfilter"
+	"storj.io/common/storj"
+	"time"
+)
+
+// ErrGC is returned for the garbage collection walks which can't be run.
+var ErrGC = errs.Class("gc")
+
+// WalkSatellitePiecesToTrash finds the pieces of the satellite created before
+// createdBefore which aren't in the bloom filter, and calls trashFunc, if it
+// isn't nil, for each of them. It has the signature of the storagenode's
+// FileWalker.WalkSatellitePiecesToTrash, and returns the pieces found, the
+// number of pieces checked and the number skipped.
+//
+// Unlike a walk of WalkNamespace, it iterates only the keys, without
+// fetching the blobs, and reads the piece ID and the modification time from
+// the key itself, so it doesn't allocate for the pieces kept.
+func (b *BlobStore) WalkSatellitePiecesToTrash(ctx context.Context, satelliteID storj.NodeID, createdBefore time.Time, filter *bloomfilter.Filter, trashFunc func(pieceID storj.PieceID) error) (pieceIDs []storj.PieceID, piecesCount, piecesSkipped int64, err error) {
+	if filter == nil {
+		return nil, 0, 0, ErrGC.New("filter not specified")
+	}
+	prefix := concat(blobPrefix, satelliteID.Bytes())
+	keySize := len(prefix) + len(storj.PieceID{}) + 16
+
+	var last storj.PieceID
+	started := false
+	for done := false; !done; {
+		err = view(b.db, func(txn kvTxn) error {
+			walkStarted, walked := time.Now(), 0
+			it := txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix))
+			defer it.Close()
+			it.Rewind()
+			if started {
+				it.Seek(concat(prefix, last.Bytes()))
+			}
+			for ; it.Valid(); it.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				// continue on a new snapshot after the last piece, like walk
+				if refresh := b.config.WalkSnapshotRefresh; walked > 0 && refresh > 0 && time.Since(walkStarted) > refresh {
+					return nil
+				}
+				key := it.Item().Key()
+				if len(key) != keySize {
+					piecesCount++
+					piecesSkipped++
+					continue
+				}
+				var pieceID storj.PieceID
+				copy(pieceID[:], key[len(prefix):])
+				// a blob may have a key for every version while it's recommitted
+				if started && pieceID == last {
+					continue
+				}
+				last, started = pieceID, true
+				walked++
+				piecesCount++
+				if filter.Contains(pieceID) {
+					continue
+				}
+				if modTime, _ := stat(key); !modTime.Before(createdBefore) {
+					continue
+				}
+				pieceIDs = append(pieceIDs, pieceID)
+				if trashFunc != nil {
+					if err := trashFunc(pieceID); err != nil {
+						return err
+					}
+				}
+			}
+			done = true
+			return nil
+		})
+		if err != nil {
+			return pieceIDs, piecesCount, piecesSkipped, err
+		}
+	}
+	mon.IntVal("gc_pieces_checked").Observe(piecesCount)
+	mon.IntVal("gc_pieces_found").Observe(int64(len(pieceIDs)))
+	return pieceIDs, piecesCount, piecesSkipped, nil
+}
@@ -0,0 +1,67 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/bloomfilter"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestWalkSatellitePiecesToTrash(t *testing.T) {
+	for _, refresh := range []time.Duration{0, time.Nanosecond} {
+		ctx := testcontext.New(t)
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{WalkSnapshotRefresh: refresh})
+		require.NoError(t, err)
+
+		now := time.Now()
+		satellite := testrand.NodeID()
+		filter := bloomfilter.NewOptimal(100, 0.01)
+		var garbage []storj.PieceID
+		store.SetClock(fixedClock(now.Add(-time.Hour)))
+		for i := 0; i < 20; i++ {
+			pieceID := testrand.PieceID()
+			switch {
+			case i%3 == 0:
+				filter.Add(pieceID)
+			case i%3 == 1:
+				garbage = append(garbage, pieceID)
+			default:
+				// created after the bloom filter
+				store.SetClock(fixedClock(now.Add(time.Hour)))
+			}
+			require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: pieceID.Bytes()}, "data"))
+			store.SetClock(fixedClock(now.Add(-time.Hour)))
+		}
+		// the keys of other lengths and the other satellites are skipped
+		require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: []byte("short")}, "data"))
+		require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: testrand.NodeID().Bytes(), Key: testrand.PieceID().Bytes()}, "data"))
+
+		var trashed []storj.PieceID
+		pieceIDs, count, skipped, err := store.WalkSatellitePiecesToTrash(ctx, satellite, now, filter, func(pieceID storj.PieceID) error {
+			trashed = append(trashed, pieceID)
+			return store.Trash(ctx, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: pieceID.Bytes()}, now)
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, garbage, pieceIDs)
+		require.Equal(t, pieceIDs, trashed)
+		require.EqualValues(t, 21, count)
+		require.EqualValues(t, 1, skipped)
+
+		// the trashed pieces are gone
+		pieceIDs, count, _, err = store.WalkSatellitePiecesToTrash(ctx, satellite, now, filter, nil)
+		require.NoError(t, err)
+		require.Empty(t, pieceIDs)
+		require.EqualValues(t, 21-len(garbage), count)
+
+		_, _, _, err = store.WalkSatellitePiecesToTrash(ctx, satellite, now, nil, nil)
+		require.True(t, ErrGC.Has(err))
+
+		require.NoError(t, store.Close())
+		ctx.Cleanup()
+	}
+}
@@ -0,0 +1,81 @@
+package badger
+
+import (
+	"context"
+	"time"
+)
+
+// healthCheckKey is overwritten by the health checks to verify the store is
+// writable.
+var healthCheckKey = []byte("hlthc")
+
+// Health is the status of the store for the monitoring of the node. The
+// errors are kept as messages, so it can be reported as is.
+type Health struct {
+	// Writable is whether a test write succeeded, WriteError why it failed.
+	Writable   bool
+	WriteError string
+	// ReadOnly is the reason of the read-only mode, empty if the store takes
+	// writes, see SetReadOnly.
+	ReadOnly string
+	// AvailableSpace is the free space of the disk, -1 if it's unknown.
+	AvailableSpace int64
+	// UsedSpace is the size of the blobs, TrashSpace of the trash.
+	UsedSpace  int64
+	TrashSpace int64
+	// Corruption is what the check of a sample of blobs found, empty if
+	// nothing.
+	Corruption string
+	// Level0Tables and PendingCompactions are the compaction backlog, see
+	// Stats.
+	Level0Tables       int
+	PendingCompactions int
+	// LastGC is when value log GC finished last, zero if it didn't run since
+	// the store was opened.
+	LastGC time.Time
+	// DiskDegraded and Backpressure tell whether the disk is slow and the
+	// uploads are rejected because of it.
+	DiskDegraded bool
+	Backpressure bool
+}
+
+// Healthy returns whether the store can take uploads and serve its blobs.
+func (h Health) Healthy() bool {
+	return h.Writable && h.Corruption == "" && !h.DiskDegraded
+}
+
+// HealthCheck checks the store: it writes a test key, checks a sample of the
+// blobs of every namespace and collects the space and compaction state.
+func (b *BlobStore) HealthCheck(ctx context.Context) (health Health, err error) {
+	err = update(b.db, func(txn kvTxn) error {
+		return txn.Set(healthCheckKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	health.Writable = err == nil
+	if err != nil {
+		health.WriteError = err.Error()
+	}
+	health.ReadOnly, _ = b.ReadOnly()
+	info, _ := diskInfoFromPath(b.dir)
+	health.AvailableSpace = info.AvailableSpace
+	if health.UsedSpace, err = b.SpaceUsedForBlobs(ctx); err != nil {
+		return health, err
+	}
+	if health.TrashSpace, err = b.SpaceUsedForTrash(ctx); err != nil {
+		return health, err
+	}
+	if err := b.quickCheck(); err != nil {
+		health.Corruption = err.Error()
+	}
+	for _, level := range levels(b.db) {
+		if level.Level == 0 {
+			health.Level0Tables = level.NumTables
+		}
+		if level.Score >= 1 {
+			health.PendingCompactions++
+		}
+	}
+	health.LastGC = b.chores.lastRun(ChoreGC)
+	health.DiskDegraded = b.DiskHealth().Degraded
+	health.Backpressure = b.Backpressure()
+	return health, nil
+}
@@ -0,0 +1,44 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "12"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+
+	health, err := store.HealthCheck(ctx)
+	require.NoError(t, err)
+	require.True(t, health.Healthy())
+	require.True(t, health.Writable)
+	require.Positive(t, health.AvailableSpace)
+	require.Equal(t, int64(4), health.UsedSpace)
+	require.Equal(t, int64(2), health.TrashSpace)
+	require.Zero(t, health.LastGC)
+
+	require.NoError(t, store.reclaimSpace(ctx, time.Time{}))
+	health, err = store.HealthCheck(ctx)
+	require.NoError(t, err)
+	require.NotZero(t, health.LastGC)
+
+	// a blob whose value doesn't match the size recorded in the key
+	err = store.db.Update(func(txn kvTxn) error {
+		return txn.Set(key(ref("ns", "corrupted"), time.Now(), 100), []byte("123"))
+	})
+	require.NoError(t, err)
+	health, err = store.HealthCheck(ctx)
+	require.NoError(t, err)
+	require.False(t, health.Healthy())
+	require.Contains(t, health.Corruption, "has 3 bytes, key records 100")
+}
@@ -0,0 +1,71 @@
+package badger
+
+import (
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+)
+
+// BlobHook is called with a blob and its size after a change of it is
+// committed. Hooks run synchronously in the goroutine of the change, so they
+// should be quick; they may use the store.
+type BlobHook func(ref blobstore.BlobRef, size int64)
+
+// hooks are the registered BlobHooks of each lifecycle event.
+type hooks struct {
+	mu         sync.RWMutex
+	commit     []BlobHook
+	delete     []BlobHook
+	trash      []BlobHook
+	restore    []BlobHook
+	emptyTrash []BlobHook
+}
+
+func (h *hooks) add(event *[]BlobHook, hook BlobHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*event = append(*event, hook)
+}
+
+// call calls the hooks of the event with the blob.
+func (h *hooks) call(event *[]BlobHook, ref blobstore.BlobRef, size int64) {
+	h.mu.RLock()
+	registered := *event
+	h.mu.RUnlock()
+	for _, hook := range registered {
+		hook(ref, size)
+	}
+}
+
+// callEach calls the hooks of the event for each changed blob version.
+func (h *hooks) callEach(event *[]BlobHook, changed []deletion) {
+	for _, d := range changed {
+		h.call(event, d.ref, d.size)
+	}
+}
+
+// OnCommit registers a hook called after a blob is committed.
+func (b *BlobStore) OnCommit(hook BlobHook) {
+	b.hooks.add(&b.hooks.commit, hook)
+}
+
+// OnDelete registers a hook called after a blob is deleted, including the
+// blobs of forgotten namespaces.
+func (b *BlobStore) OnDelete(hook BlobHook) {
+	b.hooks.add(&b.hooks.delete, hook)
+}
+
+// OnTrash registers a hook called after a blob is moved to the trash.
+func (b *BlobStore) OnTrash(hook BlobHook) {
+	b.hooks.add(&b.hooks.trash, hook)
+}
+
+// OnRestore registers a hook called after a blob is restored from the trash.
+func (b *BlobStore) OnRestore(hook BlobHook) {
+	b.hooks.add(&b.hooks.restore, hook)
+}
+
+// OnEmptyTrash registers a hook called after a trashed blob is deleted,
+// including the trash of forgotten namespaces.
+func (b *BlobStore) OnEmptyTrash(hook BlobHook) {
+	b.hooks.add(&b.hooks.emptyTrash, hook)
+}
@@ -0,0 +1,53 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	var events []string
+	record := func(event string) BlobHook {
+		return func(ref blobstore.BlobRef, size int64) {
+			events = append(events, fmt.Sprintf("%s %s/%s %d", event, ref.Namespace, ref.Key, size))
+		}
+	}
+	store.OnCommit(record("commit"))
+	store.OnDelete(record("delete"))
+	store.OnTrash(record("trash"))
+	store.OnRestore(record("restore"))
+	store.OnEmptyTrash(record("empty"))
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "12"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key1"), time.Now()))
+	_, err = store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.NoError(t, store.Trash(ctx, ref("ns", "key1"), time.Now()))
+	_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(ctx, ref("ns", "key2")))
+	// deleting a missing blob changes nothing
+	require.NoError(t, store.Delete(ctx, ref("ns", "key2")))
+
+	require.Equal(t, []string{
+		"commit ns/key1 4",
+		"commit ns/key2 2",
+		"trash ns/key1 4",
+		"restore ns/key1 4",
+		"trash ns/key1 4",
+		"empty ns/key1 4",
+		"delete ns/key2 2",
+	}, events)
+}
@@ -0,0 +1,77 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+)
+
+// inflightUploads tracks the first writer of every ref until it's committed
+// or canceled, so the writers created while it's in flight, like the ones
+// of an uplink retrying the same piece, can skip committing the same blob.
+type inflightUploads struct {
+	mu      sync.Mutex
+	uploads map[string]*inflightUpload
+}
+
+// inflightUpload is the outcome of the first writer of a ref.
+type inflightUpload struct {
+	// done is closed when the writer finished, with committed and sum set
+	done      chan struct{}
+	committed bool
+	sum       []byte
+}
+
+// start returns the upload of the new writer of ref if it's the first one,
+// or the one of the writer in flight to wait for.
+func (u *inflightUploads) start(ref blobstore.BlobRef) (own *inflightUpload, first *inflightUpload) {
+	if u == nil {
+		return nil, nil
+	}
+	key := string(keyPrefix(ref))
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if first, ok := u.uploads[key]; ok {
+		return nil, first
+	}
+	if u.uploads == nil {
+		u.uploads = map[string]*inflightUpload{}
+	}
+	own = &inflightUpload{done: make(chan struct{})}
+	u.uploads[key] = own
+	return own, nil
+}
+
+// finish records the outcome of the first writer of ref, with the hash of
+// the committed data, and releases the writers waiting for it.
+func (u *inflightUploads) finish(ref blobstore.BlobRef, own *inflightUpload, committed bool, sum []byte) {
+	if own == nil {
+		return
+	}
+	u.mu.Lock()
+	delete(u.uploads, string(keyPrefix(ref)))
+	u.mu.Unlock()
+	own.committed, own.sum = committed, sum
+	close(own.done)
+}
+
+// duplicate waits for the first writer and returns whether it committed the
+// same data, which makes committing it again unnecessary. Different data is
+// committed after it, so the last writer wins.
+func (f *inflightUpload) duplicate(ctx context.Context, data []byte) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	select {
+	case <-f.done:
+		if !f.committed {
+			return false, nil
+		}
+		sum := sha256.Sum256(data)
+		return bytes.Equal(f.sum, sum[:]), nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,99 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestConcurrentUploads(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	first, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	second, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	_, err = first.Write([]byte("data"))
+	require.NoError(t, err)
+	_, err = second.Write([]byte("data"))
+	require.NoError(t, err)
+
+	// the second commit waits for the first one and skips committing again
+	duplicates := counterValue("duplicate_uploads")
+	committed := make(chan error, 1)
+	go func() { committed <- second.Commit(ctx) }()
+	require.NoError(t, first.Commit(ctx))
+	require.NoError(t, <-committed)
+	require.Equal(t, duplicates+1, counterValue("duplicate_uploads"))
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), used)
+
+	// the writers after the first one finished commit as usual
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "other"))
+	used, err = store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), used)
+}
+
+func TestConcurrentUploadsDiffer(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	first, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	second, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	_, err = first.Write([]byte("data"))
+	require.NoError(t, err)
+	_, err = second.Write([]byte("atad"))
+	require.NoError(t, err)
+
+	// the same size with other data is committed after the first one
+	duplicates := counterValue("duplicate_uploads")
+	committed := make(chan error, 1)
+	go func() { committed <- second.Commit(ctx) }()
+	require.NoError(t, first.Commit(ctx))
+	require.NoError(t, <-committed)
+	require.Equal(t, duplicates, counterValue("duplicate_uploads"))
+	requireBlob(t, ctx, store, ref("ns", "key"), "atad")
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), used)
+}
+
+func TestConcurrentUploadCanceled(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	first, err := store.Create(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+
+	committed := make(chan error, 1)
+	go func() { committed <- save(ctx, store, ref("ns", "key"), "data") }()
+	require.NoError(t, first.Cancel(ctx))
+	// the first writer committed nothing, so the second one commits its blob
+	require.NoError(t, <-committed)
+
+	r, err := store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	data, err := rall(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "data", string(data))
+}
@@ -1,29 +1,57 @@
 package badger
 
 import (
+	"bytes"
 	"context"
 	"storj.io/storj/storagenode/blobstore"
-	"storj.io/storj/storagenode/blobstore/filestore"
 	"time"
 )
 
 type BlobInfo struct {
+	dir     string
+	trash   bool
 	ref     blobstore.BlobRef
 	size    int64
 	name    string
 	modTime time.Time
+	format  blobstore.FormatVersion
+
+	lastAccess time.Time
+}
+
+// newBlobInfo returns the info of a blob or trash key of the store in dir
+// with the user meta of its entry.
+func newBlobInfo(dir string, ref blobstore.BlobRef, key []byte, userMeta byte) BlobInfo {
+	modTime, size := stat(key)
+	return BlobInfo{
+		dir:     dir,
+		trash:   bytes.HasPrefix(key, trashPrefix),
+		ref:     ref,
+		name:    string(ref.Key),
+		size:    int64(size),
+		modTime: modTime,
+		format:  storedFormat(userMeta),
+	}
 }
 
 func (i BlobInfo) BlobRef() blobstore.BlobRef {
 	return i.ref
 }
 
+// LastAccess returns the last read of the blob recorded with
+// Config.AccessTracking, zero if it wasn't read since the tracking was
+// enabled. It may be older than the last read by up to the tracking interval.
+func (i BlobInfo) LastAccess() time.Time {
+	return i.lastAccess
+}
+
 func (i BlobInfo) StorageFormatVersion() blobstore.FormatVersion {
-	return filestore.FormatV1
+	return i.format
 }
 
+// FullPath returns the locator of the blob, see Locator.
 func (i BlobInfo) FullPath(ctx context.Context) (string, error) {
-	return "", nil
+	return Locator{Dir: i.dir, Ref: i.ref, Trash: i.trash}.String(), nil
 }
 
 func (i BlobInfo) Stat(ctx context.Context) (blobstore.FileInfo, error) {
@@ -0,0 +1,304 @@
+package badger
+
+import (
+	"context"
+	"github.com/spacemonkeygo/monkit/v3"
+	"go.uber.org/zap"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"time"
+)
+
+// Call is a call of a blob store method passing through the interceptors.
+// Namespace and Key are set for the methods having them.
+type Call struct {
+	Method    string
+	Namespace []byte
+	Key       []byte
+}
+
+// Interceptor runs around the calls of the blob store methods, like an HTTP
+// middleware. It continues the call with next, or returns without calling it.
+type Interceptor func(ctx context.Context, call Call, next func(ctx context.Context) error) error
+
+// Intercept returns the blob store with the interceptors around its methods,
+// the first one outermost. Only the calls of the blobstore.Blobs methods are
+// intercepted, not those of the returned readers and writers.
+func Intercept(blobs blobstore.Blobs, interceptors ...Interceptor) blobstore.Blobs {
+	intercepted := &interceptedBlobs{blobs: blobs}
+	intercepted.interceptor = func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		return next(ctx)
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		outer, inner := interceptors[i], intercepted.interceptor
+		intercepted.interceptor = func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+			return outer(ctx, call, func(ctx context.Context) error {
+				return inner(ctx, call, next)
+			})
+		}
+	}
+	return intercepted
+}
+
+// LoggingInterceptor logs the calls with their duration and error at debug level.
+func LoggingInterceptor(log *zap.Logger) Interceptor {
+	return func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		started := time.Now()
+		err := next(ctx)
+		log.Debug("blob store call", zap.String("method", call.Method),
+			zap.Binary("namespace", call.Namespace), zap.Binary("key", call.Key),
+			zap.Duration("duration", time.Since(started)), zap.Error(err))
+		return err
+	}
+}
+
+// MetricsInterceptor records the duration and the failures of the calls,
+// tagged with the method.
+func MetricsInterceptor() Interceptor {
+	return func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		started := time.Now()
+		err := next(ctx)
+		tag := monkit.NewSeriesTag("method", call.Method)
+		mon.DurationVal("call_duration", tag).Observe(time.Since(started))
+		if err != nil {
+			mon.Counter("call_failures", tag).Inc(1)
+		}
+		return err
+	}
+}
+
+// RateLimitInterceptor delays the calls to at most perSecond calls per
+// second on average, allowing bursts of burst calls.
+func RateLimitInterceptor(perSecond float64, burst int) Interceptor {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond), burst: burst}
+	return func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		if err := limiter.wait(ctx); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// FaultInjectionInterceptor fails the calls for which inject returns an
+// error, without calling the blob store.
+func FaultInjectionInterceptor(inject func(call Call) error) Interceptor {
+	return func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		if err := inject(call); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// rateLimiter schedules the calls interval apart, letting up to burst
+// calls through at once after an idle period.
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	earliest := now.Add(-time.Duration(l.burst-1) * l.interval)
+	if l.next.Before(earliest) {
+		l.next = earliest
+	}
+	at := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// interceptedBlobs runs the calls of blobs through the interceptor.
+type interceptedBlobs struct {
+	blobs       blobstore.Blobs
+	interceptor Interceptor
+}
+
+var _ blobstore.Blobs = &interceptedBlobs{}
+
+func (i *interceptedBlobs) run(ctx context.Context, method string, ref blobstore.BlobRef, fn func(ctx context.Context) error) error {
+	return i.interceptor(ctx, Call{Method: method, Namespace: ref.Namespace, Key: ref.Key}, fn)
+}
+
+func (i *interceptedBlobs) Create(ctx context.Context, ref blobstore.BlobRef) (w blobstore.BlobWriter, err error) {
+	err = i.run(ctx, "Create", ref, func(ctx context.Context) (err error) {
+		w, err = i.blobs.Create(ctx, ref)
+		return err
+	})
+	return w, err
+}
+
+func (i *interceptedBlobs) Open(ctx context.Context, ref blobstore.BlobRef) (r blobstore.BlobReader, err error) {
+	err = i.run(ctx, "Open", ref, func(ctx context.Context) (err error) {
+		r, err = i.blobs.Open(ctx, ref)
+		return err
+	})
+	return r, err
+}
+
+func (i *interceptedBlobs) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (r blobstore.BlobReader, err error) {
+	err = i.run(ctx, "OpenWithStorageFormat", ref, func(ctx context.Context) (err error) {
+		r, err = i.blobs.OpenWithStorageFormat(ctx, ref, formatVer)
+		return err
+	})
+	return r, err
+}
+
+func (i *interceptedBlobs) Delete(ctx context.Context, ref blobstore.BlobRef) error {
+	return i.run(ctx, "Delete", ref, func(ctx context.Context) error {
+		return i.blobs.Delete(ctx, ref)
+	})
+}
+
+func (i *interceptedBlobs) DeleteWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) error {
+	return i.run(ctx, "DeleteWithStorageFormat", ref, func(ctx context.Context) error {
+		return i.blobs.DeleteWithStorageFormat(ctx, ref, formatVer)
+	})
+}
+
+func (i *interceptedBlobs) DeleteNamespace(ctx context.Context, namespace []byte) error {
+	return i.run(ctx, "DeleteNamespace", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) error {
+		return i.blobs.DeleteNamespace(ctx, namespace)
+	})
+}
+
+func (i *interceptedBlobs) DeleteTrashNamespace(ctx context.Context, namespace []byte) error {
+	return i.run(ctx, "DeleteTrashNamespace", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) error {
+		return i.blobs.DeleteTrashNamespace(ctx, namespace)
+	})
+}
+
+func (i *interceptedBlobs) Trash(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time) error {
+	return i.run(ctx, "Trash", ref, func(ctx context.Context) error {
+		return i.blobs.Trash(ctx, ref, timestamp)
+	})
+}
+
+func (i *interceptedBlobs) RestoreTrash(ctx context.Context, namespace []byte) (keys [][]byte, err error) {
+	err = i.run(ctx, "RestoreTrash", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) (err error) {
+		keys, err = i.blobs.RestoreTrash(ctx, namespace)
+		return err
+	})
+	return keys, err
+}
+
+func (i *interceptedBlobs) EmptyTrash(ctx context.Context, namespace []byte, trashedBefore time.Time) (freed int64, keys [][]byte, err error) {
+	err = i.run(ctx, "EmptyTrash", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) (err error) {
+		freed, keys, err = i.blobs.EmptyTrash(ctx, namespace, trashedBefore)
+		return err
+	})
+	return freed, keys, err
+}
+
+func (i *interceptedBlobs) TryRestoreTrashBlob(ctx context.Context, ref blobstore.BlobRef) error {
+	return i.run(ctx, "TryRestoreTrashBlob", ref, func(ctx context.Context) error {
+		return i.blobs.TryRestoreTrashBlob(ctx, ref)
+	})
+}
+
+func (i *interceptedBlobs) Stat(ctx context.Context, ref blobstore.BlobRef) (info blobstore.BlobInfo, err error) {
+	err = i.run(ctx, "Stat", ref, func(ctx context.Context) (err error) {
+		info, err = i.blobs.Stat(ctx, ref)
+		return err
+	})
+	return info, err
+}
+
+func (i *interceptedBlobs) StatWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (info blobstore.BlobInfo, err error) {
+	err = i.run(ctx, "StatWithStorageFormat", ref, func(ctx context.Context) (err error) {
+		info, err = i.blobs.StatWithStorageFormat(ctx, ref, formatVer)
+		return err
+	})
+	return info, err
+}
+
+func (i *interceptedBlobs) DiskInfo(ctx context.Context) (info blobstore.DiskInfo, err error) {
+	err = i.run(ctx, "DiskInfo", blobstore.BlobRef{}, func(ctx context.Context) (err error) {
+		info, err = i.blobs.DiskInfo(ctx)
+		return err
+	})
+	return info, err
+}
+
+func (i *interceptedBlobs) SpaceUsedForTrash(ctx context.Context) (used int64, err error) {
+	err = i.run(ctx, "SpaceUsedForTrash", blobstore.BlobRef{}, func(ctx context.Context) (err error) {
+		used, err = i.blobs.SpaceUsedForTrash(ctx)
+		return err
+	})
+	return used, err
+}
+
+func (i *interceptedBlobs) SpaceUsedForBlobs(ctx context.Context) (used int64, err error) {
+	err = i.run(ctx, "SpaceUsedForBlobs", blobstore.BlobRef{}, func(ctx context.Context) (err error) {
+		used, err = i.blobs.SpaceUsedForBlobs(ctx)
+		return err
+	})
+	return used, err
+}
+
+func (i *interceptedBlobs) SpaceUsedForBlobsInNamespace(ctx context.Context, namespace []byte) (used int64, err error) {
+	err = i.run(ctx, "SpaceUsedForBlobsInNamespace", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) (err error) {
+		used, err = i.blobs.SpaceUsedForBlobsInNamespace(ctx, namespace)
+		return err
+	})
+	return used, err
+}
+
+func (i *interceptedBlobs) ListNamespaces(ctx context.Context) (namespaces [][]byte, err error) {
+	err = i.run(ctx, "ListNamespaces", blobstore.BlobRef{}, func(ctx context.Context) (err error) {
+		namespaces, err = i.blobs.ListNamespaces(ctx)
+		return err
+	})
+	return namespaces, err
+}
+
+func (i *interceptedBlobs) WalkNamespace(ctx context.Context, namespace []byte, startFromPrefix string, walkFunc func(blobstore.BlobInfo) error) error {
+	return i.run(ctx, "WalkNamespace", blobstore.BlobRef{Namespace: namespace}, func(ctx context.Context) error {
+		return i.blobs.WalkNamespace(ctx, namespace, startFromPrefix, walkFunc)
+	})
+}
+
+func (i *interceptedBlobs) CheckWritability(ctx context.Context) error {
+	return i.run(ctx, "CheckWritability", blobstore.BlobRef{}, func(ctx context.Context) error {
+		return i.blobs.CheckWritability(ctx)
+	})
+}
+
+func (i *interceptedBlobs) CreateVerificationFile(ctx context.Context, id storj.NodeID) error {
+	return i.run(ctx, "CreateVerificationFile", blobstore.BlobRef{}, func(ctx context.Context) error {
+		return i.blobs.CreateVerificationFile(ctx, id)
+	})
+}
+
+func (i *interceptedBlobs) VerifyStorageDir(ctx context.Context, id storj.NodeID) error {
+	return i.run(ctx, "VerifyStorageDir", blobstore.BlobRef{}, func(ctx context.Context) error {
+		return i.blobs.VerifyStorageDir(ctx, id)
+	})
+}
+
+// Close is intercepted with a background context, as it doesn't have one.
+func (i *interceptedBlobs) Close() error {
+	return i.run(context.Background(), "Close", blobstore.BlobRef{}, func(ctx context.Context) error {
+		return i.blobs.Close()
+	})
+}
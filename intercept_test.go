@@ -0,0 +1,78 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestIntercept(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+			calls = append(calls, name+" "+call.Method+" "+string(call.Key))
+			return next(ctx)
+		}
+	}
+	injected := errs.New("injected")
+	blobs := Intercept(store,
+		trace("outer"),
+		FaultInjectionInterceptor(func(call Call) error {
+			if call.Method == "Delete" {
+				return injected
+			}
+			return nil
+		}),
+		trace("inner"),
+		MetricsInterceptor())
+
+	require.NoError(t, save(ctx, blobs, ref("ns", "key"), "data"))
+	require.ErrorIs(t, blobs.Delete(ctx, ref("ns", "key")), injected)
+	_, err = store.Stat(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.NoError(t, blobs.Close())
+
+	require.Equal(t, []string{
+		"outer Create key",
+		"inner Create key",
+		"outer Delete key",
+		"outer Close ",
+		"inner Close ",
+	}, calls)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	limit := RateLimitInterceptor(50, 2)
+	call := func(ctx context.Context) error {
+		return limit(ctx, Call{Method: "Stat"}, func(ctx context.Context) error { return nil })
+	}
+
+	// the burst passes at once, the rest is spaced out
+	started := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, call(ctx))
+	}
+	require.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 5; i++ {
+		if err := call(canceled); err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+			return
+		}
+	}
+	t.Fatal("calls over the limit weren't delayed")
+}
@@ -0,0 +1,114 @@
+package badger
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"io"
+	"storj.io/storj/storagenode/blobstore"
+	"strconv"
+	"time"
+)
+
+// InventoryFormat is the encoding of an inventory.
+type InventoryFormat string
+
+// Supported inventory formats.
+const (
+	InventoryCSV   InventoryFormat = "csv"
+	InventoryJSONL InventoryFormat = "jsonl"
+)
+
+// ErrInventory is returned for inventories which can't be written.
+var ErrInventory = errs.Class("inventory")
+
+// InventoryEntry is a blob or trash entry of the inventory.
+type InventoryEntry struct {
+	Satellite string                  `json:"satellite"`
+	PieceID   string                  `json:"piece_id"`
+	Size      int64                   `json:"size"`
+	ModTime   time.Time               `json:"mod_time"`
+	Trash     bool                    `json:"trash"`
+	Format    blobstore.FormatVersion `json:"format"`
+}
+
+var inventoryHeader = []string{"satellite", "piece_id", "size", "mod_time", "trash", "format"}
+
+// WriteInventory writes an entry for every blob and trashed blob of the store
+// to w, from a single snapshot. Only the keys are read, so it's cheap enough
+// for stores with millions of blobs. The IDs are formatted like in the
+// deletion reports.
+func (b *BlobStore) WriteInventory(ctx context.Context, w io.Writer, format InventoryFormat) error {
+	buffered := bufio.NewWriter(w)
+	var write func(entry InventoryEntry) error
+	var flush func() error
+	switch format {
+	case InventoryCSV:
+		encoder := csv.NewWriter(buffered)
+		if err := encoder.Write(inventoryHeader); err != nil {
+			return ErrInventory.Wrap(err)
+		}
+		write = func(entry InventoryEntry) error {
+			return encoder.Write([]string{
+				entry.Satellite,
+				entry.PieceID,
+				strconv.FormatInt(entry.Size, 10),
+				entry.ModTime.UTC().Format(time.RFC3339),
+				strconv.FormatBool(entry.Trash),
+				strconv.Itoa(int(entry.Format)),
+			})
+		}
+		flush = func() error {
+			encoder.Flush()
+			return encoder.Error()
+		}
+	case InventoryJSONL:
+		encoder := json.NewEncoder(buffered)
+		write = func(entry InventoryEntry) error { return encoder.Encode(entry) }
+		flush = func() error { return nil }
+	default:
+		return ErrInventory.New("unknown format %q", format)
+	}
+
+	err := b.db.View(func(txn kvTxn) error {
+		namespaces := readNamespaces(txn)
+		for _, prefix := range [][]byte{blobPrefix, trashPrefix} {
+			it := txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix))
+			for it.Rewind(); it.Valid(); it.Next() {
+				if err := ctx.Err(); err != nil {
+					it.Close()
+					return err
+				}
+				item := it.Item()
+				ref, ok := splitKey(namespaces, item.Key()[len(prefix):])
+				if !ok {
+					continue
+				}
+				modTime, size := stat(item.Key())
+				err := write(InventoryEntry{
+					Satellite: formatSatellite(ref.Namespace),
+					PieceID:   formatPiece(ref.Key),
+					Size:      int64(size),
+					ModTime:   modTime,
+					Trash:     bytesEq(prefix, trashPrefix),
+					Format:    storedFormat(item.UserMeta()),
+				})
+				if err != nil {
+					it.Close()
+					return ErrInventory.Wrap(err)
+				}
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return ErrInventory.Wrap(err)
+	}
+	return ErrInventory.Wrap(buffered.Flush())
+}
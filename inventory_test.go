@@ -0,0 +1,59 @@
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+	"time"
+)
+
+func TestWriteInventory(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	modTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	store.SetClock(fixedClock(modTime))
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1234"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "12"))
+	w, err := store.TestCreateV0(ctx, ref("other", "key"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("123"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), modTime))
+
+	var out bytes.Buffer
+	require.NoError(t, store.WriteInventory(ctx, &out, InventoryCSV))
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		inventoryHeader,
+		{"6e73", "6b657931", "4", "2024-05-10T12:00:00Z", "false", "1"},
+		{"6f74686572", "6b6579", "3", "2024-05-10T12:00:00Z", "false", "0"},
+		{"6e73", "6b657932", "2", "2024-05-10T12:00:00Z", "true", "1"},
+	}, records)
+
+	out.Reset()
+	require.NoError(t, store.WriteInventory(ctx, &out, InventoryJSONL))
+	var entries []InventoryEntry
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry InventoryEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 3)
+	require.Equal(t, filestore.FormatV0, entries[1].Format)
+	require.True(t, entries[2].Trash)
+
+	require.True(t, ErrInventory.Has(store.WriteInventory(ctx, &out, "xml")))
+}
@@ -0,0 +1,105 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/spacemonkeygo/monkit/v3"
+	"sort"
+	"sync"
+)
+
+// NamespaceIO is the I/O done on the blobs of a namespace since the store
+// was opened.
+type NamespaceIO struct {
+	Namespace []byte
+
+	// Reads counts the opened blobs and ranges read, BytesRead the bytes
+	// returned from them.
+	Reads     int64
+	BytesRead int64
+	// Writes counts the committed blobs, BytesWritten their size.
+	Writes       int64
+	BytesWritten int64
+	Deletes      int64
+}
+
+// ioStats counts the I/O per namespace. The methods of a nil ioStats do
+// nothing, for readers and writers created outside of a store.
+type ioStats struct {
+	mu         sync.Mutex
+	namespaces map[string]*NamespaceIO
+}
+
+func newIOStats() *ioStats {
+	return &ioStats{namespaces: map[string]*NamespaceIO{}}
+}
+
+// update calls fn with the counters of the namespace.
+func (s *ioStats) update(namespace []byte, fn func(counters *NamespaceIO)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters, ok := s.namespaces[string(namespace)]
+	if !ok {
+		counters = &NamespaceIO{Namespace: append([]byte{}, namespace...)}
+		s.namespaces[string(namespace)] = counters
+	}
+	fn(counters)
+}
+
+// read records that a blob of the namespace was opened.
+func (s *ioStats) read(namespace []byte) {
+	if s == nil {
+		return
+	}
+	s.update(namespace, func(counters *NamespaceIO) { counters.Reads++ })
+	mon.Counter("namespace_reads", namespaceTag(namespace)).Inc(1)
+}
+
+// readBytes records the bytes returned from a blob of the namespace.
+func (s *ioStats) readBytes(namespace []byte, n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.update(namespace, func(counters *NamespaceIO) { counters.BytesRead += int64(n) })
+	mon.Counter("namespace_bytes_read", namespaceTag(namespace)).Inc(int64(n))
+}
+
+// write records a committed blob of the namespace.
+func (s *ioStats) write(namespace []byte, n int) {
+	if s == nil {
+		return
+	}
+	s.update(namespace, func(counters *NamespaceIO) {
+		counters.Writes++
+		counters.BytesWritten += int64(n)
+	})
+	mon.Counter("namespace_writes", namespaceTag(namespace)).Inc(1)
+	mon.Counter("namespace_bytes_written", namespaceTag(namespace)).Inc(int64(n))
+}
+
+// delete records a deleted blob of the namespace.
+func (s *ioStats) delete(namespace []byte) {
+	if s == nil {
+		return
+	}
+	s.update(namespace, func(counters *NamespaceIO) { counters.Deletes++ })
+	mon.Counter("namespace_deletes", namespaceTag(namespace)).Inc(1)
+}
+
+// snapshot returns the counters of all namespaces, ordered by namespace.
+func (s *ioStats) snapshot() []NamespaceIO {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make([]NamespaceIO, 0, len(s.namespaces))
+	for _, counters := range s.namespaces {
+		snapshot = append(snapshot, *counters)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return bytes.Compare(snapshot[i].Namespace, snapshot[j].Namespace) < 0
+	})
+	return snapshot
+}
+
+func namespaceTag(namespace []byte) monkit.SeriesTag {
+	return monkit.NewSeriesTag("namespace", hex.EncodeToString(namespace))
+}
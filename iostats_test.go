@@ -0,0 +1,43 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestNamespaceIO(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "1234567890"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key2"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "123"))
+
+	r, err := store.Open(ctx, ref("ns1", "key1"))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "1234567890", string(data))
+	require.NoError(t, r.Close())
+
+	_, err = store.ReadRange(ctx, ref("ns1", "key2"), 1, 2)
+	require.NoError(t, err)
+
+	// missing blobs are not counted
+	_, err = store.Open(ctx, ref("ns2", "missing"))
+	require.True(t, ErrNotExist.Has(err))
+
+	require.NoError(t, store.Delete(ctx, ref("ns2", "key1")))
+	require.NoError(t, store.Delete(ctx, ref("ns2", "missing")))
+
+	require.Equal(t, []NamespaceIO{
+		{Namespace: []byte("ns1"), Reads: 2, BytesRead: 12, Writes: 2, BytesWritten: 15},
+		{Namespace: []byte("ns2"), Writes: 1, BytesWritten: 3, Deletes: 1},
+	}, store.Stats().Namespaces)
+}
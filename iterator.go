@@ -0,0 +1,106 @@
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"strconv"
+	"strings"
+)
+
+// The operations with their own iterator profile, see
+// Config.IteratorProfiles.
+const (
+	// IteratorWalk is for the walks of the blob and trash keys, like the
+	// walks of the storagenode, GC and the usage scans. They read the keys
+	// only, or the values of a few of them.
+	IteratorWalk = "walk"
+	// IteratorCopy is for the operations reading the values of all the
+	// blobs they iterate, like namespace exports, snapshot backups and
+	// migrations.
+	IteratorCopy = "copy"
+	// IteratorRecords is for the small records of the store, like orders,
+	// bandwidth, expirations and the queued deletes, read with their values.
+	IteratorRecords = "records"
+)
+
+// IteratorProfile is the behavior of the badger iterators of an operation.
+type IteratorProfile struct {
+	// PrefetchValues loads the values of the next PrefetchSize entries in
+	// the background while iterating.
+	PrefetchValues bool
+	PrefetchSize   int
+}
+
+// defaultIteratorProfiles are the profiles of the operations not set in
+// Config.IteratorProfiles.
+var defaultIteratorProfiles = map[string]IteratorProfile{
+	IteratorWalk:    {},
+	IteratorCopy:    {PrefetchValues: true, PrefetchSize: badger.DefaultIteratorOptions.PrefetchSize},
+	IteratorRecords: {PrefetchValues: true, PrefetchSize: badger.DefaultIteratorOptions.PrefetchSize},
+}
+
+// iteratorProfiles are the profiles of the operations of a store.
+type iteratorProfiles map[string]IteratorProfile
+
+// parseIteratorProfiles parses Config.IteratorProfiles, like
+// "walk=keys,copy=values:200".
+func parseIteratorProfiles(spec string) (iteratorProfiles, error) {
+	profiles := iteratorProfiles{}
+	for operation, profile := range defaultIteratorProfiles {
+		profiles[operation] = profile
+	}
+	if strings.TrimSpace(spec) == "" {
+		return profiles, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		operation, setting, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if _, known := defaultIteratorProfiles[operation]; !ok || !known {
+			return nil, ErrConfig.New("iterator profile %q", part)
+		}
+		var profile IteratorProfile
+		switch mode, size, sized := strings.Cut(setting, ":"); {
+		case mode == "keys" && !sized:
+		case mode == "values":
+			profile = IteratorProfile{PrefetchValues: true, PrefetchSize: badger.DefaultIteratorOptions.PrefetchSize}
+			if sized {
+				n, err := strconv.Atoi(size)
+				if err != nil || n <= 0 {
+					return nil, ErrConfig.New("iterator profile %q has invalid prefetch size", part)
+				}
+				profile.PrefetchSize = n
+			}
+		default:
+			return nil, ErrConfig.New("iterator profile %q", part)
+		}
+		profiles[operation] = profile
+	}
+	return profiles, nil
+}
+
+// options returns the iterator options of the operation, limited to the
+// prefix, so badger skips the tables without keys of it. Badger's bloom
+// filters hold whole keys, so they only speed up the exact key lookups with
+// Get, not the iterators over the blob keys, which end with the modification
+// time and size. The tables are still skipped by their key ranges.
+func (p iteratorProfiles) options(operation string, prefix []byte) kvIteratorOptions {
+	profile, ok := p[operation]
+	if !ok {
+		profile = defaultIteratorProfiles[operation]
+	}
+	return kvIteratorOptions{
+		Prefix:         prefix,
+		PrefetchValues: profile.PrefetchValues,
+		PrefetchSize:   profile.PrefetchSize,
+	}
+}
+
+// iteratorOptions returns the iterator options of the operation with the
+// default profiles, for the helpers working on a transaction.
+func iteratorOptions(operation string, prefix []byte) kvIteratorOptions {
+	return iteratorProfiles(nil).options(operation, prefix)
+}
+
+// iteratorOptions returns the iterator options of the operation with the
+// profiles of the store.
+func (b *BlobStore) iteratorOptions(operation string, prefix []byte) kvIteratorOptions {
+	return b.iterators.options(operation, prefix)
+}
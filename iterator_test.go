@@ -0,0 +1,39 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestIteratorProfiles(t *testing.T) {
+	profiles, err := parseIteratorProfiles("")
+	require.NoError(t, err)
+	require.False(t, profiles.options(IteratorWalk, nil).PrefetchValues)
+	require.True(t, profiles.options(IteratorCopy, nil).PrefetchValues)
+	require.True(t, iteratorOptions(IteratorRecords, nil).PrefetchValues)
+
+	profiles, err = parseIteratorProfiles("walk=values, copy=values:7,records=keys")
+	require.NoError(t, err)
+	require.True(t, profiles.options(IteratorWalk, nil).PrefetchValues)
+	options := profiles.options(IteratorCopy, blobPrefix)
+	require.True(t, options.PrefetchValues)
+	require.Equal(t, 7, options.PrefetchSize)
+	require.Equal(t, blobPrefix, options.Prefix)
+	require.False(t, profiles.options(IteratorRecords, nil).PrefetchValues)
+
+	for _, spec := range []string{"walk", "scan=keys", "walk=all", "walk=keys:10", "copy=values:0", "copy=values:x"} {
+		_, err := parseIteratorProfiles(spec)
+		require.True(t, ErrConfig.Has(err), spec)
+	}
+
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+	_, err = NewBlobStoreWithConfig(ctx.Dir("store"), Config{IteratorProfiles: "walk=fast"})
+	require.True(t, ErrConfig.Has(err))
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{IteratorProfiles: "walk=values:10"})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.Equal(t, 10, store.iteratorOptions(IteratorWalk, nil).PrefetchSize)
+	require.Equal(t, 10, store.Bandwidth().iterators.options(IteratorWalk, nil).PrefetchSize)
+}
@@ -0,0 +1,264 @@
+package badger
+
+import (
+	"context"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrJob is returned for the job control calls of unknown jobs, and for the
+// runs of jobs which panicked.
+var ErrJob = errs.Class("job")
+
+// JobMaintenance is the name of the job running the maintenance in the
+// maintenance windows. The other jobs are named like the chores, ChoreGC for
+// example.
+const JobMaintenance = "maintenance"
+
+// Job is the state of a background job.
+type Job struct {
+	Name string
+	// Interval is how often the job runs, zero if it runs only when
+	// triggered.
+	Interval time.Duration
+	Paused   bool
+	Running  bool
+	// NextRun is when the job is scheduled to run next, zero if it runs only
+	// when triggered.
+	NextRun time.Time
+	// LastRun is when the job finished last, LastDuration how long it took
+	// and LastError how it failed.
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	// Runs and Failures count the runs since the store was opened.
+	Runs     int64
+	Failures int64
+}
+
+// job is a background job which can be paused, resumed and triggered.
+type job struct {
+	name    string
+	run     func(ctx context.Context) error
+	trigger chan struct{}
+
+	mu    sync.Mutex
+	state Job
+}
+
+func (j *job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// jobManager runs the background work of the store: the jobs, which are
+// scheduled, share the concurrency limit and can be paused and triggered,
+// and the long-running tasks like the monitors. It recovers their panics and
+// waits for all of them on shutdown.
+type jobManager struct {
+	log    *zap.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+	// slots limits the number of jobs running at once, nil if unlimited
+	slots chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobManager(log *zap.Logger, concurrency int) *jobManager {
+	m := &jobManager{log: log, jobs: map[string]*job{}}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	if concurrency > 0 {
+		m.slots = make(chan struct{}, concurrency)
+	}
+	return m
+}
+
+// start runs fn in a new goroutine. The context of fn is canceled on
+// shutdown. A panic of fn is logged and stops only fn.
+func (m *jobManager) start(name string, fn func(ctx context.Context)) {
+	m.group.Add(1)
+	go func() {
+		defer m.group.Done()
+		_ = m.protect(name, func() error {
+			fn(m.ctx)
+			return nil
+		})
+	}()
+}
+
+// protect calls fn, turning its panic into an error.
+func (m *jobManager) protect(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			mon.Counter("background_panics").Inc(1)
+			m.log.Error("background task panicked", zap.String("task", name), zap.Any("panic", r), zap.StackSkip("stack", 1))
+			err = ErrJob.New("%s panicked: %v", name, r)
+		}
+	}()
+	return fn()
+}
+
+// schedule registers the job and runs it every interval once ready returns,
+// unless it's paused. With zero interval it runs only when triggered.
+func (m *jobManager) schedule(name string, interval time.Duration, ready func(ctx context.Context) error, run func(ctx context.Context) error) {
+	j := &job{name: name, run: run, trigger: make(chan struct{}, 1)}
+	j.state = Job{Name: name, Interval: interval}
+	m.mu.Lock()
+	m.jobs[name] = j
+	m.mu.Unlock()
+
+	m.start(name, func(ctx context.Context) {
+		if err := ready(ctx); err != nil {
+			return
+		}
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+			j.mu.Lock()
+			j.state.NextRun = time.Now().Add(interval)
+			j.mu.Unlock()
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+				j.mu.Lock()
+				j.state.NextRun = time.Now().Add(interval)
+				paused := j.state.Paused
+				j.mu.Unlock()
+				if paused {
+					continue
+				}
+			case <-j.trigger:
+			}
+			m.runJob(ctx, j)
+		}
+	})
+}
+
+// runJob runs the job when a slot is free.
+func (m *jobManager) runJob(ctx context.Context, j *job) {
+	if m.slots != nil {
+		select {
+		case m.slots <- struct{}{}:
+			defer func() { <-m.slots }()
+		case <-ctx.Done():
+			return
+		}
+	}
+	j.mu.Lock()
+	j.state.Running = true
+	j.mu.Unlock()
+
+	started := time.Now()
+	err := m.protect(j.name, func() error { return j.run(ctx) })
+
+	j.mu.Lock()
+	j.state.Running = false
+	j.state.LastRun, j.state.LastDuration = time.Now(), time.Since(started)
+	j.state.Runs++
+	j.state.LastError = ""
+	if err != nil {
+		j.state.Failures++
+		j.state.LastError = err.Error()
+	}
+	j.mu.Unlock()
+	mon.DurationVal("job_duration", monkit.NewSeriesTag("job", j.name)).Observe(time.Since(started))
+	if err != nil && ctx.Err() == nil {
+		mon.Counter("job_failures", monkit.NewSeriesTag("job", j.name)).Inc(1)
+		m.log.Error("background job failed", zap.String("job", j.name), zap.Error(err))
+	}
+}
+
+func (m *jobManager) job(name string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil, ErrJob.New("unknown job %q", name)
+	}
+	return j, nil
+}
+
+func (m *jobManager) list() []Job {
+	m.mu.Lock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	m.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Name < jobs[k].Name })
+	return jobs
+}
+
+// stop cancels the jobs and the tasks and waits for them to exit.
+func (m *jobManager) stop() {
+	m.cancel()
+	m.group.Wait()
+}
+
+// startJob registers a job of the store, which runs once the usage counters
+// are accurate.
+func (b *BlobStore) startJob(name string, interval time.Duration, run func(ctx context.Context) error) {
+	b.jobs.schedule(name, interval, b.waitUsage, run)
+}
+
+// runBackground runs fn in a new goroutine, see jobManager.start.
+func (b *BlobStore) runBackground(name string, fn func(ctx context.Context)) {
+	b.jobs.start(name, fn)
+}
+
+// Jobs returns the background jobs, sorted by their names. Only the jobs
+// enabled by the config are there.
+func (b *BlobStore) Jobs() []Job {
+	return b.jobs.list()
+}
+
+// PauseJob stops the scheduled runs of the job until it's resumed. A run in
+// progress is finished.
+func (b *BlobStore) PauseJob(name string) error {
+	return b.setPaused(name, true)
+}
+
+// ResumeJob restarts the scheduled runs of the paused job.
+func (b *BlobStore) ResumeJob(name string) error {
+	return b.setPaused(name, false)
+}
+
+func (b *BlobStore) setPaused(name string, paused bool) error {
+	j, err := b.jobs.job(name)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.state.Paused = paused
+	j.mu.Unlock()
+	b.log.Info("background job paused or resumed", zap.String("job", name), zap.Bool("paused", paused))
+	return nil
+}
+
+// TriggerJob runs the job as soon as it isn't running and a slot is free,
+// even when it's paused. Triggering a job already waiting to run does
+// nothing.
+func (b *BlobStore) TriggerJob(name string) error {
+	j, err := b.jobs.job(name)
+	if err != nil {
+		return err
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"storj.io/common/testcontext"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{NamespaceSweep: time.Hour})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	jobs := store.Jobs()
+	require.Len(t, jobs, 2)
+	require.Equal(t, ChoreGC, jobs[0].Name)
+	require.Zero(t, jobs[0].Interval)
+	require.Equal(t, ChoreNamespaceSweep, jobs[1].Name)
+	require.Equal(t, time.Hour, jobs[1].Interval)
+
+	require.NoError(t, store.PauseJob(ChoreNamespaceSweep))
+	require.True(t, store.Jobs()[1].Paused)
+
+	// paused jobs still run when triggered
+	require.NoError(t, store.TriggerJob(ChoreNamespaceSweep))
+	require.Eventually(t, func() bool {
+		return !store.Jobs()[1].LastRun.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, store.Jobs()[1].LastError)
+
+	require.NoError(t, store.TriggerJob(ChoreGC))
+	require.Eventually(t, func() bool {
+		return !store.Jobs()[0].LastRun.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, store.ResumeJob(ChoreNamespaceSweep))
+	require.False(t, store.Jobs()[1].Paused)
+
+	require.True(t, ErrJob.Has(store.PauseJob("unknown")))
+	require.True(t, ErrJob.Has(store.TriggerJob("unknown")))
+}
+
+func TestPausedJob(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{NamespaceSweep: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, store.PauseJob(ChoreNamespaceSweep))
+	// a run which started before pausing may still finish
+	time.Sleep(50 * time.Millisecond)
+	last := store.Jobs()[1].LastRun
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, last, store.Jobs()[1].LastRun)
+}
+
+func TestJobManager(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	manager := newJobManager(zaptest.NewLogger(t), 1)
+	ready := func(ctx context.Context) error { return nil }
+
+	// the jobs share the single slot
+	var running, overlaps int32
+	run := func(ctx context.Context) error {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+	manager.schedule("first", time.Millisecond, ready, run)
+	manager.schedule("second", time.Millisecond, ready, run)
+
+	// a panic fails the run, but the job keeps being scheduled
+	panics := 0
+	manager.schedule("panicking", 0, ready, func(ctx context.Context) error {
+		panics++
+		panic("boom")
+	})
+	panicking, err := manager.job("panicking")
+	require.NoError(t, err)
+	panicking.trigger <- struct{}{}
+	require.Eventually(t, func() bool { return panicking.snapshot().Failures == 1 }, 5*time.Second, time.Millisecond)
+	require.Contains(t, panicking.snapshot().LastError, "boom")
+	panicking.trigger <- struct{}{}
+	require.Eventually(t, func() bool { return panicking.snapshot().Failures == 2 }, 5*time.Second, time.Millisecond)
+
+	// a panicking task stops only itself
+	manager.start("task", func(ctx context.Context) { panic("boom") })
+
+	require.Eventually(t, func() bool {
+		jobs := manager.list()
+		return jobs[0].Runs >= 3 && jobs[2].Runs >= 3
+	}, 5*time.Second, time.Millisecond)
+	manager.stop()
+	require.Zero(t, atomic.LoadInt32(&overlaps))
+	require.Equal(t, 2, panics)
+}
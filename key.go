@@ -1,6 +1,7 @@
 package badger
 
 import (
+	"bytes"
 	"encoding/binary"
 	"storj.io/storj/storagenode/blobstore"
 	"time"
@@ -14,11 +15,7 @@ func key(ref blobstore.BlobRef, time time.Time, size int) []byte {
 	rawStat := make([]byte, 0, 16)
 	rawStat = binary.BigEndian.AppendUint64(rawStat, uint64(time.Unix()))
 	rawStat = binary.BigEndian.AppendUint64(rawStat, uint64(size))
-	res := blobPrefix
-	res = append(res, ref.Namespace...)
-	res = append(res, ref.Key...)
-	res = append(res, rawStat...)
-	return res
+	return concat(blobPrefix, ref.Namespace, ref.Key, rawStat)
 }
 
 func stat(from []byte) (time.Time, int) {
@@ -30,9 +27,45 @@ func stat(from []byte) (time.Time, int) {
 }
 
 func keyPrefix(ref blobstore.BlobRef) []byte {
-	return append(append(blobPrefix, ref.Namespace...), ref.Key...)
+	return concat(blobPrefix, ref.Namespace, ref.Key)
 }
 
 func trashKey(ref blobstore.BlobRef) []byte {
-	return append(append(trashPrefix, ref.Namespace...), ref.Key...)
+	return concat(trashPrefix, ref.Namespace, ref.Key)
+}
+
+// concat joins the parts into a newly allocated slice. The prefixes are
+// shared package variables, so appending to them directly isn't safe.
+func concat(parts ...[]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	res := make([]byte, 0, size)
+	for _, part := range parts {
+		res = append(res, part...)
+	}
+	return res
+}
+
+// splitKey splits a blob or trash key (without its 5 byte prefix) into the
+// blob reference, using the known namespaces to find the namespace boundary.
+func splitKey(namespaces [][]byte, raw []byte) (blobstore.BlobRef, bool) {
+	if len(raw) < 16 {
+		return blobstore.BlobRef{}, false
+	}
+	body := raw[:len(raw)-16]
+	var found []byte
+	for _, namespace := range namespaces {
+		if len(namespace) > len(found) && len(namespace) < len(body) && bytes.HasPrefix(body, namespace) {
+			found = namespace
+		}
+	}
+	if found == nil {
+		return blobstore.BlobRef{}, false
+	}
+	return blobstore.BlobRef{
+		Namespace: found,
+		Key:       append([]byte{}, body[len(found):]...),
+	}, true
 }
@@ -0,0 +1,254 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"storj.io/common/bloomfilter"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"storj.io/storj/storagenode/pieces/lazyfilewalker"
+	"sync"
+)
+
+// ErrLazyFilewalker is the error class of the lazy filewalker commands.
+var ErrLazyFilewalker = errs.Class("lazyfilewalker")
+
+// lazyFilewalkerSocket is the socket in the store directory serving the lazy
+// filewalker subprocesses, see Config.LazyFilewalker.
+const lazyFilewalkerSocket = "lazyfilewalker.sock"
+
+// LazyFilewalkerSocket returns the path of the socket the store in dir serves
+// the lazy filewalker subprocesses on.
+func LazyFilewalkerSocket(dir string) string {
+	return filepath.Join(dir, lazyFilewalkerSocket)
+}
+
+// lazyFilewalkerRequest is a subcommand forwarded to the store process.
+type lazyFilewalkerRequest struct {
+	Command string          `json:"command"`
+	Request json.RawMessage `json:"request"`
+}
+
+// lazyFilewalkerResult is the response of a forwarded subcommand, or its
+// error.
+type lazyFilewalkerResult struct {
+	Error    string          `json:"error,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// ForwardLazyFilewalker runs a lazy filewalker subcommand in the process
+// holding the store, which serves it on the socket: badger lets only one
+// process open the store. The request is read from in and the response is
+// written to out, like RunLazyFilewalker does.
+func ForwardLazyFilewalker(ctx context.Context, socket string, command string, in io.Reader, out io.Writer) (err error) {
+	var request json.RawMessage
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return ErrLazyFilewalker.New("connecting to the storagenode: %v", err)
+	}
+	defer func() { err = errs.Combine(err, ErrLazyFilewalker.Wrap(conn.Close())) }()
+	defer closeOnDone(ctx, conn)()
+
+	if err := json.NewEncoder(conn).Encode(lazyFilewalkerRequest{Command: command, Request: request}); err != nil {
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	var result lazyFilewalkerResult
+	if err := json.NewDecoder(conn).Decode(&result); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	if result.Error != "" {
+		return ErrLazyFilewalker.New("%s", result.Error)
+	}
+	_, err = out.Write(append(result.Response, '\n'))
+	return ErrLazyFilewalker.Wrap(err)
+}
+
+// startLazyFilewalker serves the lazy filewalker subprocesses on the socket
+// in the store directory.
+func (b *BlobStore) startLazyFilewalker() error {
+	if !b.config.LazyFilewalker {
+		return nil
+	}
+	path := LazyFilewalkerSocket(b.dir)
+	// the socket of an earlier run, the directory lock of badger is held
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	b.runBackground("lazy filewalker", func(ctx context.Context) {
+		if err := b.ServeLazyFilewalker(ctx, listener); err != nil {
+			b.log.Warn("serving the lazy filewalker failed", zap.Error(err))
+		}
+	})
+	return nil
+}
+
+// ServeLazyFilewalker serves the subcommands forwarded by
+// ForwardLazyFilewalker on the listener, until ctx is canceled. A walk is
+// canceled when its subprocess disconnects.
+func (b *BlobStore) ServeLazyFilewalker(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return ErrLazyFilewalker.Wrap(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer closeOnDone(ctx, conn)()
+			if err := b.serveLazyFilewalkerConn(ctx, conn); err != nil && ctx.Err() == nil {
+				b.log.Warn("serving the lazy filewalker failed", zap.Error(err))
+			}
+		}()
+	}
+}
+
+func (b *BlobStore) serveLazyFilewalkerConn(ctx context.Context, conn net.Conn) error {
+	decoder := json.NewDecoder(conn)
+	var request lazyFilewalkerRequest
+	if err := decoder.Decode(&request); err != nil {
+		return ErrLazyFilewalker.Wrap(err)
+	}
+	// the subprocess only reads from now on, so a read returns when it's gone
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_, _ = io.Copy(io.Discard, io.MultiReader(decoder.Buffered(), conn))
+		cancel()
+	}()
+	var response bytes.Buffer
+	var result lazyFilewalkerResult
+	if err := RunLazyFilewalker(ctx, b, request.Command, bytes.NewReader(request.Request), &response); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Response = bytes.TrimSpace(response.Bytes())
+	}
+	return ErrLazyFilewalker.Wrap(json.NewEncoder(conn).Encode(result))
+}
+
+// RunLazyFilewalker runs a storagenode lazy filewalker subcommand on the
+// store. The request is decoded from in and the response is encoded to out,
+// with the JSON messages of the lazyfilewalker package. The subprocesses
+// forward the subcommands to the store with ForwardLazyFilewalker.
+func RunLazyFilewalker(ctx context.Context, store *BlobStore, command string, in io.Reader, out io.Writer) error {
+	switch command {
+	case lazyfilewalker.UsedSpaceFilewalkerCmdName:
+		var req lazyfilewalker.UsedSpaceRequest
+		if err := json.NewDecoder(in).Decode(&req); err != nil {
+			return ErrLazyFilewalker.Wrap(err)
+		}
+		resp, err := usedSpaceWalk(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		return ErrLazyFilewalker.Wrap(json.NewEncoder(out).Encode(resp))
+	case lazyfilewalker.GCFilewalkerCmdName:
+		var req lazyfilewalker.GCFilewalkerRequest
+		if err := json.NewDecoder(in).Decode(&req); err != nil {
+			return ErrLazyFilewalker.Wrap(err)
+		}
+		resp, err := gcWalk(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		return ErrLazyFilewalker.Wrap(json.NewEncoder(out).Encode(resp))
+	case lazyfilewalker.TrashCleanupFilewalkerCmdName:
+		var req lazyfilewalker.TrashCleanupRequest
+		if err := json.NewDecoder(in).Decode(&req); err != nil {
+			return ErrLazyFilewalker.Wrap(err)
+		}
+		resp, err := trashCleanup(ctx, store, req)
+		if err != nil {
+			return err
+		}
+		return ErrLazyFilewalker.Wrap(json.NewEncoder(out).Encode(resp))
+	default:
+		return ErrLazyFilewalker.New("unknown command %q", command)
+	}
+}
+
+func usedSpaceWalk(ctx context.Context, store *BlobStore, req lazyfilewalker.UsedSpaceRequest) (resp lazyfilewalker.UsedSpaceResponse, err error) {
+	if req.SatelliteID.IsZero() {
+		return resp, ErrLazyFilewalker.New("SatelliteID is required")
+	}
+	err = store.WalkNamespace(ctx, req.SatelliteID.Bytes(), "", func(info blobstore.BlobInfo) error {
+		stat, err := info.Stat(ctx)
+		if err != nil {
+			return err
+		}
+		resp.PiecesTotal += stat.Size()
+		resp.PiecesContentSize += stat.Size()
+		if info.StorageFormatVersion() != filestore.FormatV0 {
+			resp.PiecesContentSize -= pieceHeaderArea
+		}
+		return nil
+	})
+	return resp, ErrLazyFilewalker.Wrap(err)
+}
+
+func gcWalk(ctx context.Context, store *BlobStore, req lazyfilewalker.GCFilewalkerRequest) (resp lazyfilewalker.GCFilewalkerResponse, err error) {
+	switch {
+	case req.SatelliteID.IsZero():
+		return resp, ErrLazyFilewalker.New("SatelliteID is required")
+	case req.CreatedBefore.IsZero():
+		return resp, ErrLazyFilewalker.New("CreatedBefore is required")
+	}
+	filter, err := bloomfilter.NewFromBytes(req.BloomFilter)
+	if err != nil {
+		return resp, ErrLazyFilewalker.Wrap(err)
+	}
+	resp.PieceIDs, resp.PiecesCount, resp.PiecesSkippedCount, err = store.WalkSatellitePiecesToTrash(ctx, req.SatelliteID, req.CreatedBefore, filter, nil)
+	if err != nil {
+		return resp, ErrLazyFilewalker.Wrap(err)
+	}
+	resp.Completed = true
+	return resp, nil
+}
+
+func trashCleanup(ctx context.Context, store *BlobStore, req lazyfilewalker.TrashCleanupRequest) (resp lazyfilewalker.TrashCleanupResponse, err error) {
+	if req.SatelliteID.IsZero() {
+		return resp, ErrLazyFilewalker.New("SatelliteID is required")
+	}
+	bytesDeleted, keys, err := store.EmptyTrash(ctx, req.SatelliteID.Bytes(), req.DateBefore)
+	if err != nil {
+		return resp, ErrLazyFilewalker.Wrap(err)
+	}
+	resp.BytesDeleted = bytesDeleted
+	for _, key := range keys {
+		pieceID, err := storj.PieceIDFromBytes(key)
+		if err != nil {
+			continue
+		}
+		resp.KeysDeleted = append(resp.KeysDeleted, pieceID)
+	}
+	return resp, nil
+}
@@ -0,0 +1,122 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/bloomfilter"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/pieces/lazyfilewalker"
+	"testing"
+	"time"
+)
+
+func TestRunLazyFilewalker(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	satellite := testrand.NodeID()
+	kept, garbage := testrand.PieceID(), testrand.PieceID()
+	for _, pieceID := range []storj.PieceID{kept, garbage} {
+		require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: pieceID.Bytes()}, pieceData("header", "12345")))
+	}
+	require.NoError(t, save(ctx, store, ref("other", "key"), "123"))
+
+	run := func(command string, req, resp interface{}) {
+		var in, out bytes.Buffer
+		require.NoError(t, json.NewEncoder(&in).Encode(req))
+		require.NoError(t, RunLazyFilewalker(ctx, store, command, &in, &out))
+		require.NoError(t, json.NewDecoder(&out).Decode(resp))
+	}
+
+	var usedSpace lazyfilewalker.UsedSpaceResponse
+	run(lazyfilewalker.UsedSpaceFilewalkerCmdName, lazyfilewalker.UsedSpaceRequest{SatelliteID: satellite}, &usedSpace)
+	require.Equal(t, lazyfilewalker.UsedSpaceResponse{PiecesTotal: 2 * (pieceHeaderArea + 5), PiecesContentSize: 10}, usedSpace)
+
+	filter := bloomfilter.NewOptimal(10, 0.01)
+	filter.Add(kept)
+	var gc lazyfilewalker.GCFilewalkerResponse
+	run(lazyfilewalker.GCFilewalkerCmdName, lazyfilewalker.GCFilewalkerRequest{
+		SatelliteID:   satellite,
+		BloomFilter:   filter.Bytes(),
+		CreatedBefore: time.Now().Add(time.Hour),
+	}, &gc)
+	require.Equal(t, []storj.PieceID{garbage}, gc.PieceIDs)
+	require.Equal(t, int64(2), gc.PiecesCount)
+	require.True(t, gc.Completed)
+
+	require.NoError(t, store.Trash(ctx, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: garbage.Bytes()}, time.Now().Add(-time.Hour)))
+	var cleanup lazyfilewalker.TrashCleanupResponse
+	run(lazyfilewalker.TrashCleanupFilewalkerCmdName, lazyfilewalker.TrashCleanupRequest{
+		SatelliteID: satellite,
+		DateBefore:  time.Now(),
+	}, &cleanup)
+	require.Equal(t, lazyfilewalker.TrashCleanupResponse{BytesDeleted: pieceHeaderArea + 5, KeysDeleted: []storj.PieceID{garbage}}, cleanup)
+
+	err = RunLazyFilewalker(ctx, store, "unknown", &bytes.Buffer{}, &bytes.Buffer{})
+	require.True(t, ErrLazyFilewalker.Has(err))
+}
+
+func TestLazyFilewalkerUsedSpace(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// the content of FormatV1 blobs starts after the reserved header area,
+	// FormatV0 blobs are all content
+	satellite := testrand.NodeID()
+	require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: testrand.PieceID().Bytes()}, pieceData("header", "12345")))
+	w, err := store.TestCreateV0(ctx, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: testrand.PieceID().Bytes()})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("123"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+
+	resp, err := usedSpaceWalk(ctx, store, lazyfilewalker.UsedSpaceRequest{SatelliteID: satellite})
+	require.NoError(t, err)
+	require.Equal(t, int64(pieceHeaderArea+5+3), resp.PiecesTotal)
+	require.Equal(t, int64(5+3), resp.PiecesContentSize)
+}
+
+func TestForwardLazyFilewalker(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStoreWithConfig(dir, Config{LazyFilewalker: true})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	satellite := testrand.NodeID()
+	require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: testrand.PieceID().Bytes()}, pieceData("header", "12345")))
+
+	// the subprocess walks the store of the running node
+	var in, out bytes.Buffer
+	require.NoError(t, json.NewEncoder(&in).Encode(lazyfilewalker.UsedSpaceRequest{SatelliteID: satellite}))
+	require.NoError(t, ForwardLazyFilewalker(ctx, LazyFilewalkerSocket(dir), lazyfilewalker.UsedSpaceFilewalkerCmdName, &in, &out))
+	var usedSpace lazyfilewalker.UsedSpaceResponse
+	require.NoError(t, json.NewDecoder(&out).Decode(&usedSpace))
+	require.Equal(t, lazyfilewalker.UsedSpaceResponse{PiecesTotal: pieceHeaderArea + 5, PiecesContentSize: 5}, usedSpace)
+
+	// the errors of the store are returned by the subprocess
+	in.Reset()
+	require.NoError(t, json.NewEncoder(&in).Encode(lazyfilewalker.UsedSpaceRequest{}))
+	err = ForwardLazyFilewalker(ctx, LazyFilewalkerSocket(dir), lazyfilewalker.UsedSpaceFilewalkerCmdName, &in, &out)
+	require.True(t, ErrLazyFilewalker.Has(err))
+	require.Contains(t, err.Error(), "SatelliteID is required")
+
+	// without the store serving it
+	in.Reset()
+	require.NoError(t, json.NewEncoder(&in).Encode(lazyfilewalker.UsedSpaceRequest{SatelliteID: satellite}))
+	err = ForwardLazyFilewalker(ctx, LazyFilewalkerSocket(ctx.Dir("other")), lazyfilewalker.UsedSpaceFilewalkerCmdName, &in, &out)
+	require.True(t, ErrLazyFilewalker.Has(err))
+}
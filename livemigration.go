@@ -0,0 +1,545 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"sort"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"sync"
+	"time"
+)
+
+// ErrLiveMigration is returned when blobs can't be moved by a live migration.
+var ErrLiveMigration = errs.Class("live migration")
+
+// liveMigrationKey holds the watermark of the live migration.
+var liveMigrationKey = []byte("lmigr")
+
+// liveMigrationBuckets is the number of buckets of a namespace: the first 10
+// bits of the keys, which are the two characters of the key prefix
+// directories of the filestore.
+const liveMigrationBuckets = 1 << 10
+
+// LiveMigrationProgress is the watermark of a live migration: the blobs of
+// the namespaces before Namespace, and of the buckets before Bucket in it,
+// are moved.
+type LiveMigrationProgress struct {
+	Namespace []byte `json:"namespace,omitempty"`
+	Bucket    int    `json:"bucket"`
+	Done      bool   `json:"done"`
+	// Moved and MovedBytes are the blobs moved so far. They are saved with
+	// the watermark, so the blobs moved after the last bucket are counted
+	// again after a restart.
+	Moved      int64 `json:"moved"`
+	MovedBytes int64 `json:"moved_bytes"`
+}
+
+// LiveMigration serves the blobs of a node while they are moved from a
+// filestore to a BlobStore. The blobs are moved by Run in batches, one key
+// bucket of a namespace after the other, and the watermark of the moved
+// buckets decides which store owns a blob: the blobs below it are looked up
+// in the BlobStore first, the others in the source. New blobs are always
+// created in the BlobStore, and the blobs are deleted and trashed in both.
+//
+// The source has to walk the blobs of a namespace in the order of their keys,
+// like the filestore and BlobStore do.
+type LiveMigration struct {
+	log    *zap.Logger
+	source blobstore.Blobs
+	target *BlobStore
+
+	// moving is held by the mover while it moves a blob, and shared by the
+	// changes of the blobs, so a blob deleted or trashed while it's moved
+	// isn't copied back.
+	moving sync.RWMutex
+
+	mu        sync.Mutex
+	watermark LiveMigrationProgress
+}
+
+var _ blobstore.Blobs = &LiveMigration{}
+
+// NewLiveMigration returns the stores of a node migrating from source to
+// target, resuming from the watermark saved in target.
+func NewLiveMigration(log *zap.Logger, source blobstore.Blobs, target *BlobStore) (*LiveMigration, error) {
+	m := &LiveMigration{log: log, source: source, target: target}
+	err := target.db.View(func(txn kvTxn) (err error) {
+		m.watermark, err = readLiveMigration(txn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Progress returns the watermark of the migration.
+func (m *LiveMigration) Progress() LiveMigrationProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.watermark
+}
+
+// Run moves the blobs of the source to the target, until they are all moved
+// or ctx is cancelled. The node keeps using the migration while it runs: run
+// it in the background, and call it again after a restart to resume from the
+// watermark.
+func (m *LiveMigration) Run(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	if m.Progress().Done {
+		return nil
+	}
+	namespaces, err := m.source.ListNamespaces(ctx)
+	if err != nil {
+		return ErrLiveMigration.Wrap(err)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return bytes.Compare(namespaces[i], namespaces[j]) < 0
+	})
+	for _, namespace := range namespaces {
+		progress := m.Progress()
+		if bytes.Compare(namespace, progress.Namespace) < 0 {
+			continue
+		}
+		if !bytes.Equal(namespace, progress.Namespace) {
+			if err := m.advance(namespace, 0, false); err != nil {
+				return err
+			}
+		}
+		if err := m.migrateNamespace(ctx, namespace); err != nil {
+			return err
+		}
+	}
+	progress := m.Progress()
+	if err := m.advance(progress.Namespace, progress.Bucket, true); err != nil {
+		return err
+	}
+	m.log.Info("live migration done", zap.Int64("blobs", progress.Moved), zap.Int64("bytes", progress.MovedBytes))
+	return nil
+}
+
+// migrateNamespace moves the blobs of the namespace from the bucket of the
+// watermark, a bucket at a time. The buckets are walked in order, so the
+// previous one is complete when the walk gets to the next.
+func (m *LiveMigration) migrateNamespace(ctx context.Context, namespace []byte) error {
+	bucket := m.Progress().Bucket
+	var batch []blobstore.BlobInfo
+	err := m.source.WalkNamespace(ctx, namespace, "", func(info blobstore.BlobInfo) error {
+		next := keyBucket(info.BlobRef().Key)
+		switch {
+		case next < bucket:
+			// moved before, but restored from the trash of the source since
+			return m.move(ctx, info)
+		case next == bucket:
+			batch = append(batch, info)
+			return nil
+		}
+		if err := m.moveBatch(ctx, batch); err != nil {
+			return err
+		}
+		batch, bucket = append(batch[:0], info), next
+		return m.advance(namespace, bucket, false)
+	})
+	if err != nil {
+		return ErrLiveMigration.Wrap(err)
+	}
+	if err := m.moveBatch(ctx, batch); err != nil {
+		return err
+	}
+	return m.advance(namespace, liveMigrationBuckets, false)
+}
+
+func (m *LiveMigration) moveBatch(ctx context.Context, batch []blobstore.BlobInfo) error {
+	for _, info := range batch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.move(ctx, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move copies the blob to the target and deletes it from the source. Blobs
+// deleted or trashed since they were walked are skipped.
+func (m *LiveMigration) move(ctx context.Context, info blobstore.BlobInfo) (err error) {
+	m.moving.Lock()
+	defer m.moving.Unlock()
+	ref, format := info.BlobRef(), info.StorageFormatVersion()
+	size, err := m.copy(ctx, info)
+	if errs.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return ErrLiveMigration.New("moving %x/%x: %v", ref.Namespace, ref.Key, err)
+	}
+	if err := m.source.DeleteWithStorageFormat(ctx, ref, format); err != nil {
+		return ErrLiveMigration.New("deleting %x/%x: %v", ref.Namespace, ref.Key, err)
+	}
+	m.mu.Lock()
+	m.watermark.Moved++
+	m.watermark.MovedBytes += size
+	m.mu.Unlock()
+	mon.Counter("live_migration_blobs").Inc(1)
+	return nil
+}
+
+// copy writes the blob to the target with its modification time, unless it's
+// there already, like when the mover stopped before deleting it.
+func (m *LiveMigration) copy(ctx context.Context, info blobstore.BlobInfo) (size int64, err error) {
+	ref, format := info.BlobRef(), info.StorageFormatVersion()
+	if _, err := m.target.Stat(ctx, ref); err == nil {
+		return 0, nil
+	}
+	stat, err := info.Stat(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r, err := m.source.OpenWithStorageFormat(ctx, ref, format)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = errs.Combine(err, r.Close()) }()
+	if format == filestore.FormatV1 {
+		format = m.target.defaultFormat()
+	}
+	w, err := m.target.CreateWithStorageFormat(ctx, ref, format)
+	if err != nil {
+		return 0, err
+	}
+	size, err = io.Copy(w, r)
+	if err != nil {
+		return 0, errs.Combine(err, w.Cancel(ctx))
+	}
+	return size, w.(ModTimeCommitter).CommitWithModTime(ctx, stat.ModTime())
+}
+
+// advance moves the watermark and saves it.
+func (m *LiveMigration) advance(namespace []byte, bucket int, done bool) error {
+	m.moving.Lock()
+	defer m.moving.Unlock()
+	m.mu.Lock()
+	progress := m.watermark
+	m.mu.Unlock()
+	progress.Namespace, progress.Bucket, progress.Done = namespace, bucket, done
+	err := update(m.target.db, func(txn kvTxn) error {
+		return writeLiveMigration(txn, progress)
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.watermark = progress
+	m.mu.Unlock()
+	return nil
+}
+
+// stores returns the store owning the blob, then the other one.
+func (m *LiveMigration) stores(ref blobstore.BlobRef) []blobstore.Blobs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	moved := m.watermark.Done
+	switch bytes.Compare(ref.Namespace, m.watermark.Namespace) {
+	case -1:
+		moved = true
+	case 0:
+		moved = moved || keyBucket(ref.Key) < m.watermark.Bucket
+	}
+	if moved {
+		return []blobstore.Blobs{m.target, m.source}
+	}
+	// the mover writes the target before deleting from the source, so the
+	// source is looked up first not to miss the blobs moved in between
+	return []blobstore.Blobs{m.source, m.target}
+}
+
+// lookup calls fn with the store owning the blob, and with the other one if
+// the blob isn't there.
+func (m *LiveMigration) lookup(ref blobstore.BlobRef, fn func(store blobstore.Blobs) error) (err error) {
+	for _, store := range m.stores(ref) {
+		err = fn(store)
+		if !errs.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return err
+}
+
+// change calls fn with both stores, as a blob may be in either. It returns an
+// os.ErrNotExist error only if the blob is in neither.
+func (m *LiveMigration) change(fn func(store blobstore.Blobs) error) error {
+	m.moving.RLock()
+	defer m.moving.RUnlock()
+	var group errs.Group
+	var missing error
+	found := false
+	for _, store := range []blobstore.Blobs{m.source, m.target} {
+		err := fn(store)
+		switch {
+		case err == nil:
+			found = true
+		case errs.Is(err, os.ErrNotExist):
+			missing = err
+		default:
+			group.Add(err)
+		}
+	}
+	if err := group.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return missing
+	}
+	return nil
+}
+
+// adopt moves the blob restored from the trash of the source to the target,
+// if its bucket was moved already.
+func (m *LiveMigration) adopt(ctx context.Context, ref blobstore.BlobRef) error {
+	if m.stores(ref)[0] != m.target {
+		return nil
+	}
+	info, err := m.source.Stat(ctx, ref)
+	if errs.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.move(ctx, info)
+}
+
+// Create creates the blob in the target.
+func (m *LiveMigration) Create(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
+	return m.target.Create(ctx, ref)
+}
+
+func (m *LiveMigration) Open(ctx context.Context, ref blobstore.BlobRef) (r blobstore.BlobReader, err error) {
+	err = m.lookup(ref, func(store blobstore.Blobs) error {
+		r, err = store.Open(ctx, ref)
+		return err
+	})
+	return r, err
+}
+
+func (m *LiveMigration) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (r blobstore.BlobReader, err error) {
+	err = m.lookup(ref, func(store blobstore.Blobs) error {
+		r, err = store.OpenWithStorageFormat(ctx, ref, formatVer)
+		return err
+	})
+	return r, err
+}
+
+func (m *LiveMigration) Stat(ctx context.Context, ref blobstore.BlobRef) (info blobstore.BlobInfo, err error) {
+	err = m.lookup(ref, func(store blobstore.Blobs) error {
+		info, err = store.Stat(ctx, ref)
+		return err
+	})
+	return info, err
+}
+
+func (m *LiveMigration) StatWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (info blobstore.BlobInfo, err error) {
+	err = m.lookup(ref, func(store blobstore.Blobs) error {
+		info, err = store.StatWithStorageFormat(ctx, ref, formatVer)
+		return err
+	})
+	return info, err
+}
+
+func (m *LiveMigration) Delete(ctx context.Context, ref blobstore.BlobRef) error {
+	return m.change(func(store blobstore.Blobs) error {
+		return store.Delete(ctx, ref)
+	})
+}
+
+func (m *LiveMigration) DeleteWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) error {
+	return m.change(func(store blobstore.Blobs) error {
+		return store.DeleteWithStorageFormat(ctx, ref, formatVer)
+	})
+}
+
+func (m *LiveMigration) DeleteNamespace(ctx context.Context, ref []byte) error {
+	return m.change(func(store blobstore.Blobs) error {
+		return store.DeleteNamespace(ctx, ref)
+	})
+}
+
+func (m *LiveMigration) DeleteTrashNamespace(ctx context.Context, namespace []byte) error {
+	return m.change(func(store blobstore.Blobs) error {
+		return store.DeleteTrashNamespace(ctx, namespace)
+	})
+}
+
+func (m *LiveMigration) Trash(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time) error {
+	return m.change(func(store blobstore.Blobs) error {
+		return store.Trash(ctx, ref, timestamp)
+	})
+}
+
+// RestoreTrash restores the trash of both stores. The blobs restored in the
+// source after their bucket was moved are moved to the target.
+func (m *LiveMigration) RestoreTrash(ctx context.Context, namespace []byte) (keys [][]byte, err error) {
+	var restored [][]byte
+	err = m.change(func(store blobstore.Blobs) error {
+		storeKeys, err := store.RestoreTrash(ctx, namespace)
+		if store == m.source {
+			restored = storeKeys
+		}
+		keys = append(keys, storeKeys...)
+		return err
+	})
+	if err != nil {
+		return keys, err
+	}
+	for _, key := range restored {
+		if err := m.adopt(ctx, blobstore.BlobRef{Namespace: namespace, Key: key}); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// TryRestoreTrashBlob restores the blob from the trash of the store it's in.
+func (m *LiveMigration) TryRestoreTrashBlob(ctx context.Context, ref blobstore.BlobRef) error {
+	err := m.change(func(store blobstore.Blobs) error {
+		return store.TryRestoreTrashBlob(ctx, ref)
+	})
+	if err != nil {
+		return err
+	}
+	return m.adopt(ctx, ref)
+}
+
+func (m *LiveMigration) EmptyTrash(ctx context.Context, namespace []byte, trashedBefore time.Time) (emptied int64, keys [][]byte, err error) {
+	var group errs.Group
+	for _, store := range []blobstore.Blobs{m.source, m.target} {
+		storeEmptied, storeKeys, err := store.EmptyTrash(ctx, namespace, trashedBefore)
+		emptied += storeEmptied
+		keys = append(keys, storeKeys...)
+		group.Add(err)
+	}
+	return emptied, keys, group.Err()
+}
+
+func (m *LiveMigration) SpaceUsedForTrash(ctx context.Context) (int64, error) {
+	return m.sum(func(store blobstore.Blobs) (int64, error) {
+		return store.SpaceUsedForTrash(ctx)
+	})
+}
+
+func (m *LiveMigration) SpaceUsedForBlobs(ctx context.Context) (int64, error) {
+	return m.sum(func(store blobstore.Blobs) (int64, error) {
+		return store.SpaceUsedForBlobs(ctx)
+	})
+}
+
+func (m *LiveMigration) SpaceUsedForBlobsInNamespace(ctx context.Context, namespace []byte) (int64, error) {
+	return m.sum(func(store blobstore.Blobs) (int64, error) {
+		return store.SpaceUsedForBlobsInNamespace(ctx, namespace)
+	})
+}
+
+func (m *LiveMigration) sum(fn func(store blobstore.Blobs) (int64, error)) (total int64, err error) {
+	for _, store := range []blobstore.Blobs{m.source, m.target} {
+		used, err := fn(store)
+		if err != nil {
+			return 0, err
+		}
+		total += used
+	}
+	return total, nil
+}
+
+// ListNamespaces returns the namespaces of both stores.
+func (m *LiveMigration) ListNamespaces(ctx context.Context) (namespaces [][]byte, err error) {
+	for _, store := range []blobstore.Blobs{m.source, m.target} {
+		storeNamespaces, err := store.ListNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+	next:
+		for _, namespace := range storeNamespaces {
+			for _, known := range namespaces {
+				if bytes.Equal(known, namespace) {
+					continue next
+				}
+			}
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces, nil
+}
+
+// WalkNamespace walks the blobs of the source, then the ones of the target.
+// The blobs moved while the source is walked are walked again in the target.
+func (m *LiveMigration) WalkNamespace(ctx context.Context, namespace []byte, startFromPrefix string, walkFunc func(blobstore.BlobInfo) error) error {
+	if err := m.source.WalkNamespace(ctx, namespace, startFromPrefix, walkFunc); err != nil {
+		return err
+	}
+	return m.target.WalkNamespace(ctx, namespace, startFromPrefix, walkFunc)
+}
+
+func (m *LiveMigration) DiskInfo(ctx context.Context) (blobstore.DiskInfo, error) {
+	return m.target.DiskInfo(ctx)
+}
+
+func (m *LiveMigration) CheckWritability(ctx context.Context) error {
+	return m.target.CheckWritability(ctx)
+}
+
+func (m *LiveMigration) CreateVerificationFile(ctx context.Context, id storj.NodeID) error {
+	return m.target.CreateVerificationFile(ctx, id)
+}
+
+func (m *LiveMigration) VerifyStorageDir(ctx context.Context, id storj.NodeID) error {
+	return m.target.VerifyStorageDir(ctx, id)
+}
+
+// Close closes both stores. Run has to be stopped before.
+func (m *LiveMigration) Close() error {
+	return errs.Combine(m.source.Close(), m.target.Close())
+}
+
+// keyBucket returns the bucket of the key, its first 10 bits.
+func keyBucket(key []byte) int {
+	switch len(key) {
+	case 0:
+		return 0
+	case 1:
+		return int(key[0]) << 2
+	}
+	return int(key[0])<<2 | int(key[1])>>6
+}
+
+func readLiveMigration(txn kvTxn) (progress LiveMigrationProgress, err error) {
+	item, err := txn.Get(liveMigrationKey)
+	if errs.Is(err, errKeyNotFound) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &progress)
+	})
+	if err != nil {
+		return progress, ErrCorrupt.New("invalid live migration watermark: %v", err)
+	}
+	return progress, nil
+}
+
+func writeLiveMigration(txn kvTxn, progress LiveMigrationProgress) error {
+	raw, err := json.Marshal(progress)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Set(liveMigrationKey, raw)
+}
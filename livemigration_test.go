@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+	"time"
+)
+
+func TestLiveMigration(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	files, err := filestore.NewAt(zap.NewNop(), ctx.Dir("files"), filestore.DefaultConfig)
+	require.NoError(t, err)
+	source := NewFaultyBlobStore(files, 1)
+	var refs []blobstore.BlobRef
+	for i := 0; i < 40; i++ {
+		ref := blobstore.BlobRef{Namespace: []byte{byte(i % 2)}, Key: testrand.PieceID().Bytes()}
+		require.NoError(t, save(ctx, source, ref, fmt.Sprintf("data-%d", i)))
+		refs = append(refs, ref)
+	}
+	require.NoError(t, source.Trash(ctx, refs[0], time.Now()))
+
+	target, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	migration, err := NewLiveMigration(zap.NewNop(), source, target)
+	require.NoError(t, err)
+
+	// new blobs go to the target, the others are read from the source
+	created := blobstore.BlobRef{Namespace: []byte{0}, Key: testrand.PieceID().Bytes()}
+	require.NoError(t, save(ctx, migration, created, "new"))
+	_, err = target.Stat(ctx, created)
+	require.NoError(t, err)
+	requireBlob(t, ctx, migration, refs[1], "data-1")
+
+	// the mover stops at the first blob it can't delete from the source
+	source.SetFault("DeleteWithStorageFormat", Fault{Rate: 1})
+	require.Error(t, migration.Run(ctx))
+	require.False(t, migration.Progress().Done)
+	for i, ref := range refs[1:] {
+		requireBlob(t, ctx, migration, ref, fmt.Sprintf("data-%d", i+1))
+	}
+
+	// resumed with the watermark of the target
+	source.ClearFaults()
+	migration, err = NewLiveMigration(zap.NewNop(), source, target)
+	require.NoError(t, err)
+	require.NoError(t, migration.Delete(ctx, refs[2]))
+	require.NoError(t, migration.Run(ctx))
+	progress := migration.Progress()
+	require.True(t, progress.Done)
+	// with the blob copied before the failure
+	require.EqualValues(t, 38, progress.Moved)
+	used, err := files.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Zero(t, used)
+	for i, ref := range refs[3:] {
+		requireBlob(t, ctx, target, ref, fmt.Sprintf("data-%d", i+3))
+	}
+	_, err = migration.Stat(ctx, refs[2])
+	require.Error(t, err)
+
+	// the trash of the source is restored to the target
+	keys, err := migration.RestoreTrash(ctx, refs[0].Namespace)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	requireBlob(t, ctx, target, refs[0], "data-0")
+	_, err = files.Stat(ctx, refs[0])
+	require.Error(t, err)
+
+	// the watermark is kept
+	migration, err = NewLiveMigration(zap.NewNop(), source, target)
+	require.NoError(t, err)
+	require.True(t, migration.Progress().Done)
+	require.NoError(t, migration.Close())
+}
+
+func TestKeyBucket(t *testing.T) {
+	for _, key := range [][]byte{{0, 0}, {0, 0xff}, {0x12, 0x34}, {0xff, 0xff}, {0x80}} {
+		// the buckets are the key prefix directories of the filestore
+		bucket := keyBucket(key)
+		alphabet := "abcdefghijklmnopqrstuvwxyz234567"
+		require.Equal(t, filestore.PathEncoding.EncodeToString(key)[:2], string([]byte{alphabet[bucket>>5], alphabet[bucket&31]}))
+	}
+	require.Equal(t, 0, keyBucket(nil))
+	require.Equal(t, liveMigrationBuckets-1, keyBucket([]byte{0xff, 0xff}))
+}
+
+func requireBlob(t *testing.T, ctx *testcontext.Context, store blobstore.Blobs, ref blobstore.BlobRef, expected string) {
+	r, err := store.Open(ctx, ref)
+	require.NoError(t, err)
+	data, err := rall(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, expected, string(data))
+}
@@ -0,0 +1,65 @@
+package badger
+
+import (
+	"encoding/hex"
+	"github.com/zeebo/errs"
+	"net/url"
+	"path"
+	"storj.io/storj/storagenode/blobstore"
+	"strings"
+)
+
+// ErrLocator is returned for invalid blob locators.
+var ErrLocator = errs.Class("invalid locator")
+
+// locatorScheme is the URL scheme of the blob locators.
+const locatorScheme = "badger"
+
+// Locator tells where a blob lives, for logs and support tooling. It's
+// formatted as badger://<dir>/<namespace-hex>/<key-hex>, with a trash query
+// parameter for trashed blobs.
+type Locator struct {
+	Dir   string
+	Ref   blobstore.BlobRef
+	Trash bool
+}
+
+// String formats the locator, as returned by BlobInfo.FullPath.
+func (l Locator) String() string {
+	u := url.URL{Scheme: locatorScheme, Path: path.Join(l.Dir, hex.EncodeToString(l.Ref.Namespace), hex.EncodeToString(l.Ref.Key))}
+	if l.Trash {
+		u.RawQuery = "trash"
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		// keep relative directories out of the host part
+		u.Path = "/" + u.Path
+		u.Host = "."
+	}
+	return u.String()
+}
+
+// DecodeLocator parses a locator returned by BlobInfo.FullPath.
+func DecodeLocator(locator string) (l Locator, err error) {
+	u, err := url.Parse(locator)
+	if err != nil {
+		return l, ErrLocator.Wrap(err)
+	}
+	if u.Scheme != locatorScheme {
+		return l, ErrLocator.New("scheme %q", u.Scheme)
+	}
+	dir, keyHex := path.Split(u.Path)
+	dir, namespaceHex := path.Split(strings.TrimSuffix(dir, "/"))
+	if l.Ref.Namespace, err = hex.DecodeString(namespaceHex); err != nil {
+		return l, ErrLocator.New("namespace %q: %v", namespaceHex, err)
+	}
+	if l.Ref.Key, err = hex.DecodeString(keyHex); err != nil {
+		return l, ErrLocator.New("key %q: %v", keyHex, err)
+	}
+	dir = strings.TrimSuffix(dir, "/")
+	l.Trash = u.Query().Has("trash")
+	if u.Host == "." {
+		dir = strings.TrimPrefix(dir, "/")
+	}
+	l.Dir = dir
+	return l, nil
+}
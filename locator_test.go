@@ -0,0 +1,62 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestLocator(t *testing.T) {
+	blob := blobstore.BlobRef{Namespace: []byte{0xab, 0x01}, Key: []byte{0xff, 0x00, 0x10}}
+	for _, locator := range []Locator{
+		{Dir: "/var/lib/storj/storage", Ref: blob},
+		{Dir: "/var/lib/storj/storage", Ref: blob, Trash: true},
+		{Dir: "/dir with space/trash", Ref: blob},
+		{Dir: "relative/store", Ref: blob},
+	} {
+		decoded, err := DecodeLocator(locator.String())
+		require.NoError(t, err, locator.String())
+		require.Equal(t, locator, decoded)
+	}
+	require.Equal(t, "badger:///var/lib/storj/storage/ab01/ff0010", Locator{Dir: "/var/lib/storj/storage", Ref: blob}.String())
+	require.Equal(t, "badger:///store/ab01/ff0010?trash", Locator{Dir: "/store", Ref: blob, Trash: true}.String())
+
+	for _, invalid := range []string{"file:///store/ab01/ff0010", "badger:///store/ab01/xyz", "badger:///store/zz/ff"} {
+		_, err := DecodeLocator(invalid)
+		require.True(t, ErrLocator.Has(err), invalid)
+	}
+}
+
+func TestFullPath(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "data"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "data"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+
+	info, err := store.Stat(ctx, ref("ns", "key1"))
+	require.NoError(t, err)
+	fullPath, err := info.FullPath(ctx)
+	require.NoError(t, err)
+	locator, err := DecodeLocator(fullPath)
+	require.NoError(t, err)
+	require.Equal(t, Locator{Dir: dir, Ref: ref("ns", "key1")}, locator)
+
+	err = store.WalkTrash(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+		fullPath, err := info.FullPath(ctx)
+		require.NoError(t, err)
+		locator, err := DecodeLocator(fullPath)
+		require.NoError(t, err)
+		require.Equal(t, Locator{Dir: dir, Ref: ref("ns", "key2"), Trash: true}, locator)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package badger
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/zeebo/errs"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the store directory lock.
+var ErrLocked = errs.Class("store locked")
+
+// lockRetryInterval is the wait between two attempts to acquire the directory lock.
+const lockRetryInterval = 100 * time.Millisecond
+
+// openDB opens the badger database, retrying up to timeout while the
+// directory lock is held by another process.
+func openDB(options badger.Options, timeout time.Duration) (*badger.DB, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		db, err := badger.Open(options)
+		if err == nil {
+			return db, nil
+		}
+		if !isLockError(err) {
+			return nil, errs.Wrap(err)
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLocked.New("another process (pid %s) holds the store at %s", lockHolder(options.Dir), options.Dir)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// isLockError reports whether badger failed to acquire the directory lock.
+// Badger flattens the flock error into the message, so it can't be unwrapped.
+func isLockError(err error) bool {
+	return errors.Is(err, unix.EWOULDBLOCK) || strings.Contains(err.Error(), "Cannot acquire directory lock")
+}
+
+// lockHolder returns the pid badger wrote to the lock file, if available.
+func lockHolder(dir string) string {
+	raw, err := os.ReadFile(filepath.Join(dir, "LOCK"))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(raw))
+}
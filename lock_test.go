@@ -0,0 +1,34 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestLockContention(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	_, err = NewBlobStoreWithConfig(dir, Config{LockTimeout: 200 * time.Millisecond})
+	require.Error(t, err)
+	require.True(t, ErrLocked.Has(err))
+	require.Contains(t, err.Error(), fmt.Sprintf("pid %d", os.Getpid()))
+
+	ctx.Go(func() error {
+		time.Sleep(200 * time.Millisecond)
+		return store.Close()
+	})
+
+	second, err := NewBlobStoreWithConfig(dir, Config{LockTimeout: 10 * time.Second})
+	require.NoError(t, err)
+	require.NoError(t, second.Close())
+}
@@ -0,0 +1,154 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// ErrMaintenanceWindow is returned for invalid Config.MaintenanceWindows.
+var ErrMaintenanceWindow = errs.Class("invalid maintenance window")
+
+// maintenanceCheckInterval is how often the scheduler checks whether a
+// maintenance window started.
+var maintenanceCheckInterval = time.Minute
+
+// maintenanceWindow is a daily time range, as offsets from midnight. Windows
+// with end before start wrap around midnight.
+type maintenanceWindow struct {
+	start, end time.Duration
+}
+
+// parseMaintenanceWindows parses a comma separated list of windows like
+// "02:00-05:00,23:30-00:30".
+func parseMaintenanceWindows(spec string) (windows []maintenanceWindow, err error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, ErrMaintenanceWindow.New("%q", part)
+		}
+		var window maintenanceWindow
+		if window.start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+		if window.end, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+		if window.start == window.end {
+			return nil, ErrMaintenanceWindow.New("%q is empty", part)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, ErrMaintenanceWindow.New("%q is not a time of day", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// currentWindow returns the start and end of the window containing now.
+func currentWindow(windows []maintenanceWindow, now time.Time) (start, end time.Time, ok bool) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, window := range windows {
+		// the window may have started today or, wrapping around midnight, yesterday
+		for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+			start = day.Add(window.start)
+			end = day.Add(window.end)
+			if window.end < window.start {
+				end = end.AddDate(0, 0, 1)
+			}
+			if !now.Before(start) && now.Before(end) {
+				return start, end, true
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// startMaintenance runs the maintenance once in every maintenance window,
+// and registers the GC job, which runs only when triggered.
+func (b *BlobStore) startMaintenance(windows []maintenanceWindow) {
+	b.startJob(ChoreGC, 0, func(ctx context.Context) error {
+		return b.reclaimSpace(ctx, time.Time{})
+	})
+	if len(windows) == 0 {
+		return
+	}
+	var lastStart time.Time
+	b.startJob(JobMaintenance, maintenanceCheckInterval, func(ctx context.Context) error {
+		start, end, ok := currentWindow(windows, time.Now())
+		if !ok || start.Equal(lastStart) {
+			return nil
+		}
+		lastStart = start
+		return b.runMaintenance(ctx, end)
+	})
+	// the store may be opened in a window
+	_ = b.TriggerJob(JobMaintenance)
+}
+
+// runMaintenance runs compaction, value log GC and usage reconciliation,
+// skipping the steps which would start after until.
+func (b *BlobStore) runMaintenance(ctx context.Context, until time.Time) error {
+	mon.Counter("maintenance_runs").Inc(1)
+	b.log.Info("maintenance started", zap.Time("until", until))
+	if err := b.reclaimSpace(ctx, until); err != nil {
+		return err
+	}
+	if time.Now().Before(until) {
+		if err := b.reconcileUsage(ctx); err != nil {
+			return err
+		}
+	}
+	b.log.Info("maintenance finished")
+	return nil
+}
+
+// reclaimSpace compacts the LSM tree and rewrites the value log files, so the
+// space of the removed blobs is released. It stops at until, unless it's zero.
+//
+// The compaction runs first: value log GC looks the entries up at their own
+// version, so it would write the versions of deleted blobs still kept under
+// their tombstones back to level 0, and a later compaction dropping the
+// tombstone would bring the blobs back.
+//
+// The other engines reuse the space of the removed blobs by themselves.
+func (b *BlobStore) reclaimSpace(ctx context.Context, until time.Time) error {
+	db := badgerDB(b.db)
+	if db == nil {
+		b.chores.done(ChoreGC)
+		return nil
+	}
+	inTime := func() bool { return until.IsZero() || time.Now().Before(until) }
+	if err := db.Flatten(1); err != nil {
+		return errs.Wrap(err)
+	}
+	for inTime() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := db.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			break
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	b.chores.done(ChoreGC)
+	return nil
+}
@@ -0,0 +1,117 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	windows, err := parseMaintenanceWindows("02:00-05:00, 23:30-00:30")
+	require.NoError(t, err)
+	require.Equal(t, []maintenanceWindow{
+		{start: 2 * time.Hour, end: 5 * time.Hour},
+		{start: 23*time.Hour + 30*time.Minute, end: 30 * time.Minute},
+	}, windows)
+
+	for _, invalid := range []string{"02:00", "02:00-25:00", "x-05:00", "02:00-02:00"} {
+		_, err := parseMaintenanceWindows(invalid)
+		require.True(t, ErrMaintenanceWindow.Has(err), invalid)
+	}
+
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 10, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		now        time.Time
+		start, end time.Time
+		ok         bool
+	}{
+		{now: day(1, 0)},
+		{now: day(2, 0), start: day(2, 0), end: day(5, 0), ok: true},
+		{now: day(4, 59), start: day(2, 0), end: day(5, 0), ok: true},
+		{now: day(5, 0)},
+		{now: day(23, 45), start: day(23, 30), end: day(24, 30), ok: true},
+		{now: day(0, 15), start: day(-1, 30), end: day(0, 30), ok: true},
+	} {
+		start, end, ok := currentWindow(windows, tc.now)
+		require.Equal(t, tc.ok, ok, tc.now)
+		require.Equal(t, tc.start, start, tc.now)
+		require.Equal(t, tc.end, end, tc.now)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(interval time.Duration) { maintenanceCheckInterval = interval }(maintenanceCheckInterval)
+	maintenanceCheckInterval = 10 * time.Millisecond
+
+	runs := counterValue("maintenance_runs")
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MaintenanceWindows: "00:00-24:00"})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.Eventually(t, func() bool {
+		return counterValue("maintenance_runs") > runs
+	}, 10*time.Second, 10*time.Millisecond)
+	// the maintenance runs once per window
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, runs+1, counterValue("maintenance_runs"))
+
+	_, err = NewBlobStoreWithConfig(ctx.Dir("other"), Config{MaintenanceWindows: "invalid"})
+	require.True(t, ErrMaintenanceWindow.Has(err))
+}
+
+func TestReclaimSpaceKeepsDeletes(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	config := Config{ValueLogFileSize: memory.MiB}
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), config)
+	require.NoError(t, err)
+	defer func() { ctx.Check(store.Close) }()
+
+	key := func(i int) string { return fmt.Sprintf("key%d", i) }
+	for i := 0; i < 30; i++ {
+		require.NoError(t, save(ctx, store, ref("ns", key(i)), string(testrand.BytesInt(100*memory.KiB.Int()))))
+	}
+	require.NoError(t, store.flushMemTables())
+	require.NoError(t, badgerDB(store.db).Flatten(1))
+	// the garbage of these makes value log GC rewrite every file
+	for i := 0; i < 30; i++ {
+		if i%10 < 6 {
+			require.NoError(t, store.Delete(ctx, ref("ns", key(i))))
+		}
+	}
+	require.NoError(t, store.flushMemTables())
+	require.NoError(t, badgerDB(store.db).Flatten(1))
+	// the tombstones of these are flushed to level 0 on close, above the
+	// versions they delete
+	for i := 0; i < 30; i++ {
+		if i%10 == 6 || i%10 == 7 {
+			require.NoError(t, store.Delete(ctx, ref("ns", key(i))))
+		}
+	}
+	require.NoError(t, store.Close())
+	store, err = NewBlobStoreWithConfig(ctx.Dir("store"), config)
+	require.NoError(t, err)
+
+	require.NoError(t, store.reclaimSpace(ctx, time.Time{}))
+	require.NoError(t, store.flushMemTables())
+	require.NoError(t, badgerDB(store.db).Flatten(1))
+	for i := 0; i < 30; i++ {
+		_, err := store.Stat(ctx, ref("ns", key(i)))
+		if i%10 < 8 {
+			require.True(t, ErrNotExist.Has(err), "%s: %v", key(i), err)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
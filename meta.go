@@ -0,0 +1,81 @@
+package badger
+
+import (
+	"encoding/json"
+	"github.com/zeebo/errs"
+)
+
+var metaKey = []byte("meta_")
+
+const (
+	// layoutVersion is the key layout written by this version of the package.
+	layoutVersion = 1
+
+	// minLayoutVersion and maxLayoutVersion define the range of layouts this
+	// version of the package can read.
+	minLayoutVersion = 1
+	maxLayoutVersion = 1
+)
+
+// ErrLayoutVersion is returned when the store was written with a key layout this version can't read.
+var ErrLayoutVersion = errs.Class("unsupported layout version")
+
+// meta is the store-wide record persisted under metaKey.
+type meta struct {
+	LayoutVersion int `json:"layout_version"`
+	// UsageCounters is set once the usage counters are maintained for all blobs.
+	UsageCounters bool `json:"usage_counters,omitempty"`
+	// TrashCounters is set once the trash usage counters are maintained too.
+	TrashCounters bool `json:"trash_counters,omitempty"`
+	// Dirty is set while the store is open, so an unclean shutdown is
+	// detected on the next open.
+	Dirty bool `json:"dirty,omitempty"`
+	// NodeID is the node the store was set up for with SetupBlobStore, so
+	// VerifyStorageDir fails for other nodes even with their verification
+	// file copied in.
+	NodeID string `json:"node_id,omitempty"`
+}
+
+func readMeta(txn kvTxn) (m meta, found bool, err error) {
+	item, err := txn.Get(metaKey)
+	if errs.Is(err, errKeyNotFound) {
+		return m, false, nil
+	}
+	if err != nil {
+		return m, false, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &m)
+	})
+	if err != nil {
+		return m, false, ErrCorrupt.New("invalid meta record: %v", err)
+	}
+	return m, true, nil
+}
+
+func writeMeta(txn kvTxn, m meta) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Set(metaKey, raw)
+}
+
+// checkLayout verifies that the store uses a supported layout. Stores created
+// before the meta record existed use layout 1 and get the record on first open.
+func checkLayout(db kvEngine) error {
+	return db.Update(func(txn kvTxn) error {
+		m, found, err := readMeta(txn)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return writeMeta(txn, meta{LayoutVersion: layoutVersion})
+		}
+		if m.LayoutVersion < minLayoutVersion || m.LayoutVersion > maxLayoutVersion {
+			return ErrLayoutVersion.New("store uses layout %d, this version supports %d-%d",
+				m.LayoutVersion, minLayoutVersion, maxLayoutVersion)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestLayoutVersion(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	db, err := openEngine(dir, Config{}, false)
+	require.NoError(t, err)
+	err = db.Update(func(txn kvTxn) error {
+		m, found, err := readMeta(txn)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, layoutVersion, m.LayoutVersion)
+
+		return writeMeta(txn, meta{LayoutVersion: maxLayoutVersion + 1})
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = NewBlobStore(dir)
+	require.Error(t, err)
+	require.True(t, ErrLayoutVersion.Has(err))
+}
@@ -0,0 +1,204 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+)
+
+// ErrFormatMigration is returned for format migrations which can't be run.
+var ErrFormatMigration = errs.Class("format migration")
+
+// migrationKey holds the checkpoint of the running format migration.
+var migrationKey = []byte("migrt")
+
+// migrationBatchSize and migrationBatchBytes limit the blobs rewritten in
+// one transaction, as the values of the batch are kept in memory.
+var (
+	migrationBatchSize  = 1000
+	migrationBatchBytes = 64 << 20
+)
+
+// FormatMigration is the progress of a format migration.
+type FormatMigration struct {
+	From blobstore.FormatVersion `json:"from"`
+	To   blobstore.FormatVersion `json:"to"`
+	// Migrated is the number of blobs rewritten in the new format.
+	Migrated int64 `json:"migrated"`
+	// Skipped is the number of blobs which can't be stored in the new
+	// format, like FormatV1 blobs without a piece header.
+	Skipped int64 `json:"skipped"`
+}
+
+// migrationCheckpoint is the persisted state of a format migration.
+type migrationCheckpoint struct {
+	FormatMigration
+	// After is the last blob key handled.
+	After []byte `json:"after,omitempty"`
+}
+
+// MigrateFormat rewrites the blobs stored in the from format in the to
+// format, which can be FormatV1 or FormatV2. The blobs are migrated in
+// batches, each committed with a checkpoint: cancelling ctx pauses the
+// migration and calling MigrateFormat again resumes it.
+func (b *BlobStore) MigrateFormat(ctx context.Context, from, to blobstore.FormatVersion) (FormatMigration, error) {
+	if !convertible(from) || !convertible(to) || from == to {
+		return FormatMigration{}, ErrFormatMigration.New("can't migrate from format %d to %d", from, to)
+	}
+	var checkpoint migrationCheckpoint
+	err := update(b.db, func(txn kvTxn) (err error) {
+		var found bool
+		checkpoint, found, err = readMigration(txn)
+		if err != nil {
+			return err
+		}
+		if !found {
+			checkpoint = migrationCheckpoint{FormatMigration: FormatMigration{From: from, To: to}}
+			return writeMigration(txn, checkpoint)
+		}
+		if checkpoint.From != from || checkpoint.To != to {
+			return ErrFormatMigration.New("migration from format %d to %d is in progress", checkpoint.From, checkpoint.To)
+		}
+		return nil
+	})
+	if err != nil {
+		return FormatMigration{}, err
+	}
+	for more := true; more; {
+		if err := ctx.Err(); err != nil {
+			return checkpoint.FormatMigration, err
+		}
+		more, err = b.migrateBatch(&checkpoint)
+		if err != nil {
+			return checkpoint.FormatMigration, err
+		}
+	}
+	err = update(b.db, func(txn kvTxn) error {
+		return txn.Delete(migrationKey)
+	})
+	return checkpoint.FormatMigration, err
+}
+
+// migrateBatch rewrites a batch of blobs after the checkpoint, and returns
+// whether there may be more.
+func (b *BlobStore) migrateBatch(checkpoint *migrationCheckpoint) (more bool, err error) {
+	var migrated []blobstore.BlobRef
+	next := *checkpoint
+	err = update(b.db, func(txn kvTxn) error {
+		migrated, more, next = nil, false, *checkpoint
+		namespaces := readNamespaces(txn)
+		it := txn.NewIterator(b.iteratorOptions(IteratorCopy, blobPrefix))
+		defer it.Close()
+		count, bytes := 0, 0
+		for it.Seek(concat(blobPrefix, next.After)); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			if bytesEq(key[len(blobPrefix):], next.After) || storedFormat(item.UserMeta()) != next.From {
+				continue
+			}
+			if count == migrationBatchSize || bytes >= migrationBatchBytes {
+				more = true
+				break
+			}
+			var data []byte
+			err := blobValue(txn, item, func(val []byte) error {
+				data = append([]byte(nil), val...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			value, format := encodeValue(next.To, data)
+			if format != next.To {
+				next.Skipped++
+			} else {
+				if err := rewriteEntry(txn, item, key, value, formatUserMeta(format)); err != nil {
+					return err
+				}
+				next.Migrated++
+				if ref, ok := splitKey(namespaces, key[len(blobPrefix):]); ok {
+					migrated = append(migrated, ref)
+				}
+			}
+			next.After = key[len(blobPrefix):]
+			count++
+			bytes += len(data)
+		}
+		return writeMigration(txn, next)
+	})
+	if err != nil {
+		return false, err
+	}
+	*checkpoint = next
+	mon.Counter("format_migration_blobs").Inc(int64(len(migrated)))
+	// the cached readers report the previous format
+	for _, ref := range migrated {
+		b.invalidate(ref)
+	}
+	return more, nil
+}
+
+// rewriteEntry replaces the value of the blob entry, keeping it deduplicated
+// or chunked if it was.
+func rewriteEntry(txn kvTxn, item kvItem, key, value []byte, userMeta byte) error {
+	dedup := item.UserMeta()&userMetaDedup != 0
+	var chunkSize int64
+	if item.UserMeta()&userMetaChunked != 0 {
+		p, err := readChunkPointer(item)
+		if err != nil {
+			return err
+		}
+		chunkSize = p.chunkSize
+	}
+	if err := releaseBlob(txn, item); err != nil {
+		return err
+	}
+	if err := releaseChunks(txn, item); err != nil {
+		return err
+	}
+	return setBlob(txn, key, value, userMeta, dedup, chunkSize)
+}
+
+// FormatMigrationStatus returns the progress of the paused or running format
+// migration, if there is one.
+func (b *BlobStore) FormatMigrationStatus(ctx context.Context) (progress FormatMigration, pending bool, err error) {
+	err = b.db.View(func(txn kvTxn) error {
+		checkpoint, found, err := readMigration(txn)
+		progress, pending = checkpoint.FormatMigration, found
+		return err
+	})
+	return progress, pending, err
+}
+
+// convertible returns whether blobs can be migrated from and to the format.
+// FormatV0 blobs have no piece header to move.
+func convertible(format blobstore.FormatVersion) bool {
+	return format == filestore.FormatV1 || format == FormatV2
+}
+
+func readMigration(txn kvTxn) (checkpoint migrationCheckpoint, found bool, err error) {
+	item, err := txn.Get(migrationKey)
+	if errs.Is(err, errKeyNotFound) {
+		return checkpoint, false, nil
+	}
+	if err != nil {
+		return checkpoint, false, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &checkpoint)
+	})
+	if err != nil {
+		return checkpoint, false, ErrCorrupt.New("invalid format migration checkpoint: %v", err)
+	}
+	return checkpoint, true, nil
+}
+
+func writeMigration(txn kvTxn, checkpoint migrationCheckpoint) error {
+	raw, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Set(migrationKey, raw)
+}
@@ -0,0 +1,91 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+)
+
+func TestMigrateFormat(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { migrationBatchSize = size }(migrationBatchSize)
+	migrationBatchSize = 1
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Dedup: true, ReadCache: 1 << 20})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	blobs := map[string]string{
+		"piece1": pieceData("header1", "content"),
+		"piece2": pieceData("header2", "content"),
+		"piece3": pieceData("header1", "content"),
+		"plain":  "plain",
+	}
+	for key, data := range blobs {
+		require.NoError(t, save(ctx, store, ref("ns", key), data))
+	}
+
+	requireBlobs := func(format blobstore.FormatVersion) {
+		for key, data := range blobs {
+			reader, err := store.Open(ctx, ref("ns", key))
+			require.NoError(t, err)
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, data, string(content))
+			if key == "plain" {
+				require.Equal(t, filestore.FormatV1, reader.StorageFormatVersion())
+			} else {
+				require.Equal(t, format, reader.StorageFormatVersion(), key)
+			}
+			require.NoError(t, reader.Close())
+		}
+	}
+	requireBlobs(filestore.FormatV1)
+
+	_, err = store.MigrateFormat(ctx, filestore.FormatV0, FormatV2)
+	require.True(t, ErrFormatMigration.Has(err))
+
+	// a paused migration keeps its checkpoint
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = store.MigrateFormat(canceled, filestore.FormatV1, FormatV2)
+	require.ErrorIs(t, err, context.Canceled)
+	progress, pending, err := store.FormatMigrationStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, pending)
+	require.Equal(t, FormatMigration{From: filestore.FormatV1, To: FormatV2}, progress)
+
+	_, err = store.MigrateFormat(ctx, FormatV2, filestore.FormatV1)
+	require.True(t, ErrFormatMigration.Has(err))
+
+	progress, err = store.MigrateFormat(ctx, filestore.FormatV1, FormatV2)
+	require.NoError(t, err)
+	require.Equal(t, FormatMigration{From: filestore.FormatV1, To: FormatV2, Migrated: 3, Skipped: 1}, progress)
+	_, pending, err = store.FormatMigrationStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, pending)
+	requireBlobs(FormatV2)
+	// the contents of the previous format are released
+	var contents int
+	err = store.db.View(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: contentPrefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			contents++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, contents)
+
+	progress, err = store.MigrateFormat(ctx, FormatV2, filestore.FormatV1)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), progress.Migrated)
+	requireBlobs(filestore.FormatV1)
+}
@@ -0,0 +1,242 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// addNamespace adds the namespace to the known namespaces, if it's missing.
+// The marker must be written already.
+func (b *BlobStore) addNamespace(namespace []byte) {
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	for _, ns := range b.namespaces {
+		if bytesEq(ns, namespace) {
+			return
+		}
+	}
+	b.namespaces = append(b.namespaces, namespace)
+}
+
+// knownNamespaces returns a copy of the known namespaces, safe to use while
+// they change.
+func (b *BlobStore) knownNamespaces() [][]byte {
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	return append([][]byte(nil), b.namespaces...)
+}
+
+// setNamespaces replaces the known namespaces.
+func (b *BlobStore) setNamespaces(namespaces [][]byte) {
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	b.namespaces = namespaces
+}
+
+// removeNamespace drops the namespace from the known namespaces.
+func (b *BlobStore) removeNamespace(namespace []byte) {
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	b.dropNamespace(namespace)
+}
+
+func (b *BlobStore) dropNamespace(namespace []byte) {
+	namespaces := make([][]byte, 0, len(b.namespaces))
+	for _, ns := range b.namespaces {
+		if !bytesEq(ns, namespace) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	b.namespaces = namespaces
+}
+
+// RebuildNamespaces replaces the known namespaces with the ones of the
+// markers, picking up namespaces added or removed by other code paths or
+// external tools. With a positive blobNamespaceSize the blob and trash keys
+// are scanned too, taking their first blobNamespaceSize bytes as the
+// namespace (32 for satellite IDs), and the missing markers are written.
+func (b *BlobStore) RebuildNamespaces(ctx context.Context, blobNamespaceSize int) ([][]byte, error) {
+	var found [][]byte
+	if blobNamespaceSize > 0 {
+		err := b.db.View(func(txn kvTxn) (err error) {
+			found, err = scanNamespaces(ctx, txn, blobNamespaceSize)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	var namespaces [][]byte
+	err := update(b.db, func(txn kvTxn) error {
+		for _, namespace := range found {
+			if err := setNamespaceMarker(txn, namespace); err != nil {
+				return err
+			}
+		}
+		namespaces = readNamespaces(txn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.namespaces = namespaces
+	return namespaces, nil
+}
+
+// scanNamespaces returns the distinct namespaces of the blob and trash keys,
+// taking their first size bytes as the namespace. Only the first key of
+// every namespace is read.
+func scanNamespaces(ctx context.Context, txn kvTxn, size int) (namespaces [][]byte, err error) {
+	seen := map[string]bool{}
+	for _, prefix := range [][]byte{blobPrefix, trashPrefix} {
+		it := txn.NewIterator(iteratorOptions(IteratorWalk, prefix))
+		for it.Rewind(); it.Valid(); {
+			if err := ctx.Err(); err != nil {
+				it.Close()
+				return nil, err
+			}
+			key := it.Item().Key()
+			if len(key) <= len(prefix)+size+16 {
+				it.Next()
+				continue
+			}
+			namespace := append([]byte{}, key[len(prefix):len(prefix)+size]...)
+			if !seen[string(namespace)] {
+				seen[string(namespace)] = true
+				namespaces = append(namespaces, namespace)
+			}
+			end := prefixEnd(concat(prefix, namespace))
+			if end == nil {
+				break
+			}
+			it.Seek(end)
+		}
+		it.Close()
+	}
+	return namespaces, nil
+}
+
+// prefixEnd returns the first key after all the keys with the prefix, nil if
+// there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// setNamespaceMarker writes the marker of the namespace in the commits of
+// the blobs, so a namespace only gets a marker with its first blob. The read
+// of the marker makes the commit conflict with a concurrent removal.
+func setNamespaceMarker(txn kvTxn, namespace []byte) error {
+	_, err := txn.Get(concat(namespacePrefix, namespace))
+	if errs.Is(err, errKeyNotFound) {
+		return txn.Set(concat(namespacePrefix, namespace), []byte{1})
+	}
+	return errs.Wrap(err)
+}
+
+// removeEmptyNamespace removes the marker and the counters of the namespace
+// if it has no blobs or trash. The usage counters are read, so commits adding
+// blobs meanwhile make it conflict and check again. A dry run only checks.
+func (b *BlobStore) removeEmptyNamespace(namespace []byte, dryRun bool) (removed bool, err error) {
+	b.nsMu.Lock()
+	defer b.nsMu.Unlock()
+	err = updateOrView(b.db, dryRun, func(txn kvTxn) error {
+		removed = false
+		if _, err := namespaceUsage(txn, namespace); err != nil {
+			return err
+		}
+		for shard := 0; shard < usageShards; shard++ {
+			if _, err := readCounter(txn, trashUsageKey(namespace, byte(shard))); err != nil {
+				return err
+			}
+		}
+		namespaces := readNamespaces(txn)
+		for _, prefix := range [][]byte{blobPrefix, trashPrefix} {
+			if hasNamespaceKeys(txn, namespaces, prefix, namespace) {
+				return nil
+			}
+		}
+		if _, err := txn.Get(concat(namespacePrefix, namespace)); errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if dryRun {
+			removed = true
+			return nil
+		}
+		for shard := 0; shard < usageShards; shard++ {
+			if err := txn.Delete(usageKey(namespace, byte(shard))); err != nil {
+				return err
+			}
+			if err := txn.Delete(trashUsageKey(namespace, byte(shard))); err != nil {
+				return err
+			}
+		}
+		removed = true
+		return txn.Delete(concat(namespacePrefix, namespace))
+	})
+	if err != nil || dryRun {
+		return removed, err
+	}
+	if removed {
+		b.dropNamespace(namespace)
+		mon.Counter("namespace_markers_removed").Inc(1)
+	}
+	return removed, nil
+}
+
+// hasNamespaceKeys returns whether any key with the prefix belongs to the
+// namespace, not to a longer one starting with it.
+func hasNamespaceKeys(txn kvTxn, namespaces [][]byte, prefix []byte, namespace []byte) bool {
+	start := concat(prefix, namespace)
+	it := txn.NewIterator(iteratorOptions(IteratorWalk, start))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		ref, ok := splitKey(namespaces, it.Item().Key()[len(prefix):])
+		if ok && bytes.Equal(ref.Namespace, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepNamespaces removes the markers of the namespaces without blobs or
+// trash, so they aren't listed anymore.
+func (b *BlobStore) sweepNamespaces(ctx context.Context) error {
+	namespaces, err := listNamespaces(b.db)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		removed, err := b.removeEmptyNamespace(namespace, false)
+		if err != nil {
+			return err
+		}
+		if removed {
+			b.log.Info("removed marker of empty namespace", zap.Binary("namespace", namespace))
+		}
+	}
+	b.chores.done(ChoreNamespaceSweep)
+	return nil
+}
+
+func (b *BlobStore) startNamespaceSweep() {
+	interval := b.config.NamespaceSweep
+	if interval <= 0 {
+		return
+	}
+	b.startJob(ChoreNamespaceSweep, interval, b.sweepNamespaces)
+}
@@ -0,0 +1,200 @@
+package badger
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestNamespaceMarkerCleanup(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	requireNamespaces := func(expected ...string) {
+		namespaces, err := store.ListNamespaces(ctx)
+		require.NoError(t, err)
+		var names []string
+		for _, ns := range namespaces {
+			names = append(names, string(ns))
+		}
+		require.ElementsMatch(t, expected, names)
+		stored, err := listNamespaces(store.db)
+		require.NoError(t, err)
+		require.Len(t, stored, len(expected))
+	}
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key"), "data"))
+	require.NoError(t, save(ctx, store, ref("trashed", "key"), "data"))
+	require.NoError(t, store.Trash(ctx, ref("trashed", "key"), time.Now()))
+
+	// namespaces with blobs or trash are kept
+	require.NoError(t, store.DeleteNamespace(ctx, []byte("ns")))
+	require.NoError(t, store.sweepNamespaces(ctx))
+	requireNamespaces("ns", "ns1", "trashed")
+
+	// the blobs of ns1 don't keep ns, which is a prefix of it
+	require.NoError(t, store.Delete(ctx, ref("ns", "key")))
+	require.NoError(t, store.DeleteNamespace(ctx, []byte("ns")))
+	requireNamespaces("ns1", "trashed")
+
+	// writers created before the sweep restore the marker on commit
+	w, err := store.Create(ctx, ref("ns1", "other"))
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(ctx, ref("ns1", "key")))
+	require.NoError(t, store.sweepNamespaces(ctx))
+	requireNamespaces("trashed")
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	requireNamespaces("ns1", "trashed")
+
+	// the marker is written with the first blob, so cancelled uploads leave none
+	w, err = store.Create(ctx, ref("cancelled", "key"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Cancel(ctx))
+	requireNamespaces("ns1", "trashed")
+
+	_, _, err = store.EmptyTrash(ctx, []byte("trashed"), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, store.sweepNamespaces(ctx))
+	requireNamespaces("ns1")
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), used)
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	requireNamespaces("ns1")
+}
+
+func TestRebuildNamespaces(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	satellite1 := testrand.NodeID()
+	satellite2 := testrand.NodeID()
+	require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite1.Bytes(), Key: []byte("key")}, "data"))
+	require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: satellite2.Bytes(), Key: []byte("key")}, "data"))
+	require.NoError(t, store.Trash(ctx, blobstore.BlobRef{Namespace: satellite2.Bytes(), Key: []byte("key")}, time.Now()))
+
+	// another tool adds a namespace and removes the markers of the others
+	err = store.db.Update(func(txn kvTxn) error {
+		if err := txn.Set(concat(namespacePrefix, []byte("external")), []byte{1}); err != nil {
+			return err
+		}
+		if err := txn.Delete(concat(namespacePrefix, satellite1.Bytes())); err != nil {
+			return err
+		}
+		return txn.Delete(concat(namespacePrefix, satellite2.Bytes()))
+	})
+	require.NoError(t, err)
+
+	// the trash of satellite2 is still found by its counters
+	namespaces, err := store.RebuildNamespaces(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("external"), satellite2.Bytes()}, namespaces)
+	listed, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Equal(t, namespaces, listed)
+
+	// the markers are recovered from the blob and trash keys
+	namespaces, err = store.RebuildNamespaces(ctx, len(satellite1))
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{[]byte("external"), satellite1.Bytes(), satellite2.Bytes()}, namespaces)
+	listed, err = store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, namespaces, listed)
+}
+
+func TestTrashOnlyNamespace(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key"), time.Now()))
+	require.NoError(t, store.sweepNamespaces(ctx))
+
+	// the marker is lost, but the trash keeps the namespace listed
+	err = store.db.Update(func(txn kvTxn) error {
+		return txn.Delete(concat(namespacePrefix, []byte("ns")))
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("ns")}, namespaces)
+
+	restored, err := store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Len(t, restored, 1)
+	reader, err := store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	// the restored blobs got the marker back
+	namespaces, err = listNamespaces(store.db)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("ns")}, namespaces)
+}
+
+func TestListNamespacesConcurrently(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	ctx.Go(func() error {
+		for i := 0; i < 20; i++ {
+			if err := save(ctx, store, ref(fmt.Sprintf("ns%d", i), "key"), "data"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		namespaces, err := store.ListNamespaces(ctx)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(namespaces), 20)
+		_, err = store.TrashDays(ctx, []byte("ns0"))
+		require.NoError(t, err)
+	}
+	ctx.Wait()
+
+	// the listed namespaces are a copy
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Len(t, namespaces, 20)
+	namespaces[0] = nil
+	namespaces, err = store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	for _, namespace := range namespaces {
+		require.NotNil(t, namespace)
+	}
+}
@@ -0,0 +1,380 @@
+package badger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/orders/ordersfile"
+	"storj.io/storj/storagenode/pieces"
+	"storj.io/storj/storagenode/satellites"
+)
+
+// nodeDBMigrationPrefix keys record the SQLite databases of the storagenode
+// already migrated, by name.
+var nodeDBMigrationPrefix = []byte("sqlmg")
+
+// nodeDBBatchSize is the number of rows imported in one transaction.
+var nodeDBBatchSize = 1000
+
+// ErrNodeDB is returned for the failed migrations of the storagenode
+// databases.
+var ErrNodeDB = errs.Class("storagenode db")
+
+// NodeDB bundles the small databases of the storagenode kept in the badger
+// store, next to the pieces, so a node has a single storage engine. Each
+// implements the interface the storagenode uses for the database:
+// pieces.PieceExpirationDB, pieces.PieceSpaceUsedDB, pieces.V0PieceInfoDB,
+// orders.DB with Orders.DB, bandwidth.DB and satellites.DB. The databases
+// not kept here, like reputation, payouts and notifications, stay in SQLite.
+type NodeDB struct {
+	log *zap.Logger
+
+	PieceExpirations *PieceExpirations
+	PieceSpaceUsed   *PieceSpaceUsed
+	V0PieceInfos     *V0PieceInfos
+	Orders           *OrderStore
+	Bandwidth        *BandwidthDB
+	Satellites       *SatelliteDB
+}
+
+// NodeDB returns the storagenode databases kept in the store, with the grace
+// period of the orders.
+func (b *BlobStore) NodeDB(orderGracePeriod time.Duration) *NodeDB {
+	return &NodeDB{
+		log:              b.log,
+		PieceExpirations: b.PieceExpirations(),
+		PieceSpaceUsed:   b.PieceSpaceUsed(),
+		V0PieceInfos:     b.V0PieceInfos(),
+		Orders:           b.Orders(orderGracePeriod),
+		Bandwidth:        b.Bandwidth(),
+		Satellites:       b.Satellites(),
+	}
+}
+
+// sqliteMigration is an SQLite database of the storagenode, by the name of
+// its file in the storage directory, and how it's imported.
+type sqliteMigration struct {
+	name    string
+	migrate func(ctx context.Context, db *sql.DB) error
+}
+
+func (n *NodeDB) sqliteMigrations() []sqliteMigration {
+	return []sqliteMigration{
+		{"piece_expiration", n.migrateExpirations},
+		{"piece_spaced_used", n.migrateSpaceUsed},
+		{"pieceinfo", n.migrateV0PieceInfos},
+		{"orders", n.migrateOrders},
+		{"bandwidth", n.migrateBandwidth},
+		{"satellites", n.migrateSatellites},
+	}
+}
+
+// MigrateSQLite imports the databases from the SQLite files of the
+// storagenode in dir, opened with the database/sql driver driverName. This
+// package doesn't link an SQLite driver, the caller has to register one, like
+// the storagenode's "sqlite3". Every database is imported once, the missing
+// ones are skipped, and the files are left in place. It returns the names of
+// the databases imported.
+func (n *NodeDB) MigrateSQLite(ctx context.Context, driverName string, dir string) (migrated []string, err error) {
+	for _, migration := range n.sqliteMigrations() {
+		path := filepath.Join(dir, migration.name+".db")
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		done, err := n.sqliteMigrated(migration.name)
+		if err != nil {
+			return migrated, err
+		}
+		if done {
+			continue
+		}
+		db, err := sql.Open(driverName, path)
+		if err != nil {
+			return migrated, ErrNodeDB.Wrap(err)
+		}
+		err = migration.migrate(ctx, db)
+		err = errs.Combine(err, db.Close())
+		if err != nil {
+			return migrated, ErrNodeDB.Wrap(fmt.Errorf("migrating %s: %w", migration.name, err))
+		}
+		if err := update(n.PieceExpirations.db, func(txn kvTxn) error {
+			return txn.Set(concat(nodeDBMigrationPrefix, []byte(migration.name)), nil)
+		}); err != nil {
+			return migrated, err
+		}
+		n.log.Info("storagenode database migrated", zap.String("database", migration.name))
+		migrated = append(migrated, migration.name)
+	}
+	return migrated, nil
+}
+
+func (n *NodeDB) sqliteMigrated(name string) (done bool, err error) {
+	err = view(n.PieceExpirations.db, func(txn kvTxn) error {
+		_, err := txn.Get(concat(nodeDBMigrationPrefix, []byte(name)))
+		if errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		done = err == nil
+		return errs.Wrap(err)
+	})
+	return done, err
+}
+
+// queryRows calls scan for the rows of the query.
+func queryRows(ctx context.Context, db *sql.DB, query string, scan func(rows *sql.Rows) error) (err error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, rows.Close()) }()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (n *NodeDB) migrateExpirations(ctx context.Context, db *sql.DB) error {
+	return queryRows(ctx, db, `SELECT satellite_id, piece_id, piece_expiration FROM piece_expirations`, func(rows *sql.Rows) error {
+		var satellite storj.NodeID
+		var pieceID storj.PieceID
+		var expiration time.Time
+		if err := rows.Scan(&satellite, &pieceID, &expiration); err != nil {
+			return err
+		}
+		return n.PieceExpirations.SetExpiration(ctx, satellite, pieceID, expiration)
+	})
+}
+
+func (n *NodeDB) migrateSpaceUsed(ctx context.Context, db *sql.DB) error {
+	satellites := map[storj.NodeID]pieces.SatelliteUsage{}
+	err := queryRows(ctx, db, `SELECT satellite_id, total, content_size FROM piece_space_used`, func(rows *sql.Rows) error {
+		var id []byte
+		var u pieces.SatelliteUsage
+		if err := rows.Scan(&id, &u.Total, &u.ContentSize); err != nil {
+			return err
+		}
+		switch {
+		case id == nil:
+			return n.PieceSpaceUsed.UpdatePieceTotals(ctx, u.Total, u.ContentSize)
+		case string(id) == "trashtotal":
+			return n.PieceSpaceUsed.UpdateTrashTotal(ctx, u.Total)
+		}
+		satellite, err := storj.NodeIDFromBytes(id)
+		if err != nil {
+			return err
+		}
+		satellites[satellite] = u
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return n.PieceSpaceUsed.UpdatePieceTotalsForAllSatellites(ctx, satellites)
+}
+
+func (n *NodeDB) migrateV0PieceInfos(ctx context.Context, db *sql.DB) error {
+	var batch []*pieces.Info
+	err := queryRows(ctx, db, `SELECT satellite_id, piece_id, piece_size, piece_creation, piece_expiration, order_limit, uplink_piece_hash FROM pieceinfo_`, func(rows *sql.Rows) error {
+		info := &pieces.Info{OrderLimit: &pb.OrderLimit{}, UplinkPieceHash: &pb.PieceHash{}}
+		var expiration sql.NullTime
+		var limit, hash []byte
+		if err := rows.Scan(&info.SatelliteID, &info.PieceID, &info.PieceSize, &info.PieceCreation, &expiration, &limit, &hash); err != nil {
+			return err
+		}
+		if err := pb.Unmarshal(limit, info.OrderLimit); err != nil {
+			return err
+		}
+		if err := pb.Unmarshal(hash, info.UplinkPieceHash); err != nil {
+			return err
+		}
+		info.PieceExpiration = expiration.Time
+		batch = append(batch, info)
+		if len(batch) < nodeDBBatchSize {
+			return nil
+		}
+		err := n.V0PieceInfos.Import(ctx, batch)
+		batch = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return n.V0PieceInfos.Import(ctx, batch)
+}
+
+func (n *NodeDB) migrateOrders(ctx context.Context, db *sql.DB) error {
+	var batch []*ordersfile.Info
+	err := queryRows(ctx, db, `SELECT order_limit_serialized, order_serialized FROM unsent_order`, func(rows *sql.Rows) error {
+		var limit, order []byte
+		if err := rows.Scan(&limit, &order); err != nil {
+			return err
+		}
+		info := &ordersfile.Info{Limit: &pb.OrderLimit{}, Order: &pb.Order{}}
+		if err := pb.Unmarshal(limit, info.Limit); err != nil {
+			return err
+		}
+		if err := pb.Unmarshal(order, info.Order); err != nil {
+			return err
+		}
+		batch = append(batch, info)
+		if len(batch) < nodeDBBatchSize {
+			return nil
+		}
+		err := n.Orders.importOrders(batch)
+		batch = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := n.Orders.importOrders(batch); err != nil {
+		return err
+	}
+
+	var archived []*ArchivedOrder
+	err = queryRows(ctx, db, `SELECT order_limit_serialized, order_serialized, status, archived_at FROM order_archive_`, func(rows *sql.Rows) error {
+		var limit, order []byte
+		archivedOrder := &ArchivedOrder{Limit: &pb.OrderLimit{}, Order: &pb.Order{}}
+		if err := rows.Scan(&limit, &order, &archivedOrder.Status, &archivedOrder.ArchivedAt); err != nil {
+			return err
+		}
+		if err := pb.Unmarshal(limit, archivedOrder.Limit); err != nil {
+			return err
+		}
+		if err := pb.Unmarshal(order, archivedOrder.Order); err != nil {
+			return err
+		}
+		archived = append(archived, archivedOrder)
+		if len(archived) < nodeDBBatchSize {
+			return nil
+		}
+		err := n.Orders.importArchived(archived)
+		archived = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return n.Orders.importArchived(archived)
+}
+
+func (n *NodeDB) migrateBandwidth(ctx context.Context, db *sql.DB) error {
+	return queryRows(ctx, db, `SELECT interval_start, satellite_id, put_total, get_total, get_audit_total, get_repair_total, put_repair_total, delete_total FROM bandwidth_usage`, func(rows *sql.Rows) error {
+		var intervalStart time.Time
+		var satellite storj.NodeID
+		var amounts [6]int64
+		if err := rows.Scan(&intervalStart, &satellite, &amounts[0], &amounts[1], &amounts[2], &amounts[3], &amounts[4], &amounts[5]); err != nil {
+			return err
+		}
+		actions := []pb.PieceAction{pb.PieceAction_PUT, pb.PieceAction_GET, pb.PieceAction_GET_AUDIT, pb.PieceAction_GET_REPAIR, pb.PieceAction_PUT_REPAIR, pb.PieceAction_DELETE}
+		for i, action := range actions {
+			if amounts[i] == 0 {
+				continue
+			}
+			if err := n.Bandwidth.Add(ctx, satellite, action, amounts[i], intervalStart); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (n *NodeDB) migrateSatellites(ctx context.Context, db *sql.DB) error {
+	err := queryRows(ctx, db, `SELECT node_id, address, added_at, status FROM satellites`, func(rows *sql.Rows) error {
+		var satellite satellites.Satellite
+		var address sql.NullString
+		if err := rows.Scan(&satellite.SatelliteID, &address, &satellite.AddedAt, &satellite.Status); err != nil {
+			return err
+		}
+		satellite.Address = address.String
+		return update(n.Satellites.db, func(txn kvTxn) error {
+			return setSatelliteEntry(txn, concat(satellitePrefix, satellite.SatelliteID.Bytes()), satellite)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return queryRows(ctx, db, `SELECT satellite_id, initiated_at, finished_at, starting_disk_usage, bytes_deleted, completion_receipt FROM satellite_exit_progress`, func(rows *sql.Rows) error {
+		var exit satellites.ExitProgress
+		if err := rows.Scan(&exit.SatelliteID, &exit.InitiatedAt, &exit.FinishedAt, &exit.StartingDiskUsage, &exit.BytesDeleted, &exit.CompletionReceipt); err != nil {
+			return err
+		}
+		return update(n.Satellites.db, func(txn kvTxn) error {
+			return setSatelliteEntry(txn, concat(satelliteExitPrefix, exit.SatelliteID.Bytes()), exit)
+		})
+	})
+}
+
+// MigrateUnsentOrders imports the unsent orders files of the storagenode
+// from unsentDir, removing every file once its orders are stored. The
+// corrupted entries of the files are skipped. It returns the number of
+// orders imported.
+func (n *NodeDB) MigrateUnsentOrders(ctx context.Context, unsentDir string) (imported int, err error) {
+	entries, err := os.ReadDir(unsentDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, ErrNodeDB.Wrap(err)
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return imported, ErrNodeDB.Wrap(err)
+		}
+		unsent, err := ordersfile.GetUnsentInfo(stat)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(unsentDir, entry.Name())
+		infos, err := readOrdersFile(path, unsent.Version)
+		if err != nil {
+			return imported, ErrNodeDB.Wrap(err)
+		}
+		if err := n.Orders.importOrders(infos); err != nil {
+			return imported, err
+		}
+		if err := os.Remove(path); err != nil {
+			return imported, ErrNodeDB.Wrap(err)
+		}
+		imported += len(infos)
+	}
+	return imported, nil
+}
+
+func readOrdersFile(path string, version ordersfile.Version) (infos []*ordersfile.Info, err error) {
+	file, err := ordersfile.OpenReadable(path, version)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, file.Close()) }()
+	for {
+		info, err := file.ReadOne()
+		switch {
+		case errors.Is(err, io.EOF):
+			return infos, nil
+		case ordersfile.ErrEntryCorrupt.Has(err):
+			continue
+		case err != nil:
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+}
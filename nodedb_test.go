@@ -0,0 +1,250 @@
+package badger
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/bandwidth"
+	"storj.io/storj/storagenode/orders"
+	"storj.io/storj/storagenode/orders/ordersfile"
+	"storj.io/storj/storagenode/pieces"
+	"storj.io/storj/storagenode/satellites"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQLTables are the rows of the tables served by the fakesql driver, by
+// database file and table.
+var fakeSQLTables = struct {
+	sync.Mutex
+	files map[string]map[string][][]driver.Value
+}{files: map[string]map[string][][]driver.Value{}}
+
+func init() {
+	sql.Register("fakesql", fakeSQLDriver{})
+}
+
+// fakeSQLDriver answers the SELECT queries of the SQLite migration with the
+// rows of fakeSQLTables, in the order of the columns queried.
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) { return fakeSQLConn(name), nil }
+
+type fakeSQLConn string
+
+func (c fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeSQLStmt{file: string(c), query: query}, nil
+}
+func (fakeSQLConn) Close() error              { return nil }
+func (fakeSQLConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type fakeSQLStmt struct {
+	file  string
+	query string
+}
+
+func (fakeSQLStmt) Close() error                                    { return nil }
+func (fakeSQLStmt) NumInput() int                                   { return 0 }
+func (fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+
+func (s fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns := strings.Split(strings.TrimSpace(s.query[len("SELECT"):strings.Index(s.query, "FROM")]), ", ")
+	table := strings.TrimSpace(s.query[strings.Index(s.query, "FROM")+len("FROM"):])
+	fakeSQLTables.Lock()
+	defer fakeSQLTables.Unlock()
+	return &fakeSQLRows{columns: columns, rows: fakeSQLTables.files[s.file][table]}, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func fakeSQLiteFile(t *testing.T, dir, name string, tables map[string][][]driver.Value) {
+	path := filepath.Join(dir, name+".db")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	fakeSQLTables.Lock()
+	defer fakeSQLTables.Unlock()
+	fakeSQLTables.files[path] = tables
+}
+
+func TestNodeDBMigrateSQLite(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	db := store.NodeDB(time.Hour)
+
+	dir := ctx.Dir("storage")
+	satellite := testrand.NodeID()
+	pieceID, v0PieceID := testrand.PieceID(), testrand.PieceID()
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	limit, err := pb.Marshal(&pb.OrderLimit{SatelliteId: satellite, PieceId: v0PieceID})
+	require.NoError(t, err)
+
+	fakeSQLiteFile(t, dir, "piece_expiration", map[string][][]driver.Value{
+		"piece_expirations": {{satellite.Bytes(), pieceID.Bytes(), now}},
+	})
+	fakeSQLiteFile(t, dir, "piece_spaced_used", map[string][][]driver.Value{
+		"piece_space_used": {
+			{nil, int64(1000), int64(900)},
+			{[]byte("trashtotal"), int64(50), int64(50)},
+			{satellite.Bytes(), int64(1000), int64(900)},
+		},
+	})
+	fakeSQLiteFile(t, dir, "pieceinfo", map[string][][]driver.Value{
+		"pieceinfo_": {{satellite.Bytes(), v0PieceID.Bytes(), int64(256), now, nil, limit, []byte{}}},
+	})
+	unsentOrder, archivedOrder := testOrder(satellite, now, 10), testOrder(satellite, now.Add(-time.Hour), 20)
+	rawOrder := func(info *ordersfile.Info) (limit, order []byte) {
+		limit, err := pb.Marshal(info.Limit)
+		require.NoError(t, err)
+		order, err = pb.Marshal(info.Order)
+		require.NoError(t, err)
+		return limit, order
+	}
+	unsentLimit, unsentOrderRaw := rawOrder(unsentOrder)
+	archivedLimit, archivedOrderRaw := rawOrder(archivedOrder)
+	fakeSQLiteFile(t, dir, "orders", map[string][][]driver.Value{
+		"unsent_order":   {{unsentLimit, unsentOrderRaw}},
+		"order_archive_": {{archivedLimit, archivedOrderRaw, int64(orders.StatusAccepted), now}},
+	})
+	fakeSQLiteFile(t, dir, "bandwidth", map[string][][]driver.Value{
+		"bandwidth_usage": {{now, satellite.Bytes(), int64(10), int64(20), int64(0), int64(0), int64(0), int64(5)}},
+	})
+	fakeSQLiteFile(t, dir, "satellites", map[string][][]driver.Value{
+		"satellites":              {{satellite.Bytes(), "sat:7777", now, int64(satellites.Exiting)}},
+		"satellite_exit_progress": {{satellite.Bytes(), now, nil, int64(1000), int64(100), nil}},
+	})
+
+	migrated, err := db.MigrateSQLite(ctx, "fakesql", dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"piece_expiration", "piece_spaced_used", "pieceinfo", "orders", "bandwidth", "satellites"}, migrated)
+
+	var expired []pieces.ExpiredInfo
+	require.NoError(t, db.PieceExpirations.GetExpired(ctx, now.Add(time.Second), func(ctx context.Context, piece pieces.ExpiredInfo) bool {
+		expired = append(expired, piece)
+		return true
+	}))
+	require.Equal(t, []pieces.ExpiredInfo{{SatelliteID: satellite, PieceID: pieceID}}, expired)
+
+	total, contentSize, err := db.PieceSpaceUsed.GetPieceTotals(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, total)
+	require.EqualValues(t, 900, contentSize)
+	trash, err := db.PieceSpaceUsed.GetTrashTotal(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 50, trash)
+	totals, err := db.PieceSpaceUsed.GetPieceTotalsForAllSatellites(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[storj.NodeID]pieces.SatelliteUsage{satellite: {Total: 1000, ContentSize: 900}}, totals)
+
+	info, err := db.V0PieceInfos.Get(ctx, satellite, v0PieceID)
+	require.NoError(t, err)
+	require.EqualValues(t, 256, info.PieceSize)
+	require.Equal(t, v0PieceID, info.OrderLimit.PieceId)
+	require.True(t, info.PieceExpiration.IsZero())
+
+	unsent, err := db.Orders.DB().ListUnsent(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, unsent, 1)
+	require.Equal(t, unsentOrder.Order.Amount, unsent[0].Order.Amount)
+	archived, err := db.Orders.DB().ListArchived(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	require.Equal(t, archivedOrder.Order.Amount, archived[0].Order.Amount)
+	require.Equal(t, orders.StatusAccepted, archived[0].Status)
+	require.True(t, now.Equal(archived[0].ArchivedAt))
+
+	usage, err := db.Bandwidth.Summary(ctx, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, &bandwidth.Usage{Put: 10, Get: 20, Delete: 5}, usage)
+
+	sat, err := db.Satellites.GetSatellite(ctx, satellite)
+	require.NoError(t, err)
+	require.Equal(t, "sat:7777", sat.Address)
+	exits, err := db.Satellites.ListGracefulExits(ctx)
+	require.NoError(t, err)
+	require.Len(t, exits, 1)
+	require.EqualValues(t, 100, exits[0].BytesDeleted)
+	require.Equal(t, satellites.Exiting, exits[0].Status)
+
+	// every database is migrated only once
+	migrated, err = db.MigrateSQLite(ctx, "fakesql", dir)
+	require.NoError(t, err)
+	require.Empty(t, migrated)
+	usage, err = db.Bandwidth.Summary(ctx, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.EqualValues(t, 35, usage.Total())
+
+	// the failed migrations keep their cause
+	other, err := NewBlobStore(ctx.Dir("other"))
+	require.NoError(t, err)
+	defer ctx.Check(other.Close)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = other.NodeDB(time.Hour).MigrateSQLite(canceled, "fakesql", dir)
+	require.True(t, ErrNodeDB.Has(err))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Contains(t, err.Error(), "migrating piece_expiration")
+}
+
+func TestNodeDBMigrateUnsentOrders(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	db := store.NodeDB(time.Hour)
+
+	unsentDir := ctx.Dir("orders", "unsent")
+	satellite := testrand.NodeID()
+	createdAt := time.Now().Add(-48 * time.Hour)
+	file, err := ordersfile.OpenWritableUnsent(unsentDir, satellite, createdAt)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, file.Append(testOrder(satellite, createdAt, int64(i))))
+	}
+	require.NoError(t, file.Close())
+
+	imported, err := db.MigrateUnsentOrders(ctx, unsentDir)
+	require.NoError(t, err)
+	require.Equal(t, 3, imported)
+	entries, err := os.ReadDir(unsentDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// the orders are past their grace period, so they're ready to be sent
+	unsent, err := db.Orders.ListUnsentBySatellite(ctx, time.Now())
+	require.NoError(t, err)
+	require.Len(t, unsent[satellite].InfoList, 3)
+
+	imported, err = db.MigrateUnsentOrders(ctx, filepath.Join(unsentDir, "missing"))
+	require.NoError(t, err)
+	require.Zero(t, imported)
+}
@@ -0,0 +1,527 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/orders"
+	"storj.io/storj/storagenode/orders/ordersfile"
+	"sync"
+	"time"
+)
+
+// unsentOrderPrefix keys are the orders to send, as satellite ID, creation
+// hour (8 bytes of Unix nanoseconds) and serial number.
+var unsentOrderPrefix = []byte("ordru")
+
+// archivedOrderPrefix keys are the sent orders, as archive time, satellite
+// ID, creation hour and serial number, so the old ones are cleaned from the
+// start.
+var archivedOrderPrefix = []byte("ordra")
+
+// orderBatchSize is the number of orders moved or removed in one
+// transaction.
+var orderBatchSize = 1000
+
+// ErrOrders is returned for the errors of the order store.
+var ErrOrders = errs.Class("orders")
+
+// UnsentOrders is a window of orders of a satellite, the ones created in
+// the same hour.
+type UnsentOrders struct {
+	CreatedAtHour time.Time
+	InfoList      []*ordersfile.Info
+}
+
+// ArchivedOrder is a sent order with the response of the satellite.
+type ArchivedOrder struct {
+	Limit      *pb.OrderLimit
+	Order      *pb.Order
+	Status     pb.SettlementWithWindowResponse_Status
+	ArchivedAt time.Time
+}
+
+// OrderStore keeps the orders of the storagenode in the badger store,
+// instead of the files of the orders directory. It has the methods of the
+// file store of the storagenode's orders package, with its own UnsentOrders
+// and ArchivedOrder types, and every order is written in a transaction, so a
+// crash loses none of the enqueued ones.
+type OrderStore struct {
+	db kvEngine
+	// gracePeriod is how long after their creation the orders are accepted
+	gracePeriod time.Duration
+
+	mu sync.Mutex
+	// active counts the enqueues in progress of the windows
+	active map[orderWindow]int
+
+	iterators iteratorProfiles
+}
+
+// orderWindow is the window of the orders of a satellite created in an hour.
+type orderWindow struct {
+	satellite storj.NodeID
+	hour      int64
+}
+
+func newOrderWindow(satellite storj.NodeID, createdAt time.Time) orderWindow {
+	return orderWindow{satellite: satellite, hour: createdAt.Truncate(time.Hour).UnixNano()}
+}
+
+func (w orderWindow) key(prefix []byte) []byte {
+	return concat(prefix, w.satellite.Bytes(), binary.BigEndian.AppendUint64(nil, uint64(w.hour)))
+}
+
+// Orders returns the order store kept in the store. The orders are rejected
+// gracePeriod after their creation, and their window is sent only after
+// that.
+func (b *BlobStore) Orders(gracePeriod time.Duration) *OrderStore {
+	return &OrderStore{db: b.db, gracePeriod: gracePeriod, active: map[orderWindow]int{}, iterators: b.iterators}
+}
+
+// BeginEnqueue returns the function to enqueue the order of the satellite
+// created at createdAt, or to abort with nil. The window of the order isn't
+// listed as unsent until it's called.
+func (o *OrderStore) BeginEnqueue(satelliteID storj.NodeID, createdAt time.Time) (commit func(*ordersfile.Info) error, err error) {
+	if time.Since(createdAt) > o.gracePeriod {
+		return nil, ErrOrders.New("grace period passed for order limit")
+	}
+	window := newOrderWindow(satelliteID, createdAt)
+	o.mu.Lock()
+	o.active[window]++
+	o.mu.Unlock()
+
+	return func(info *ordersfile.Info) error {
+		defer func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.active[window]--
+			if o.active[window] <= 0 {
+				delete(o.active, window)
+			}
+		}()
+		if info == nil {
+			return nil
+		}
+		if info.Limit.SatelliteId != satelliteID || !info.Limit.OrderCreation.Equal(createdAt) {
+			return ErrOrders.New("invalid info passed in to enqueue commit")
+		}
+		value, err := encodeOrder(info)
+		if err != nil {
+			return err
+		}
+		return update(o.db, func(txn kvTxn) error {
+			return txn.Set(concat(window.key(unsentOrderPrefix), info.Limit.SerialNumber.Bytes()), value)
+		})
+	}, nil
+}
+
+// Enqueue stores the order to be sent.
+func (o *OrderStore) Enqueue(info *ordersfile.Info) error {
+	commit, err := o.BeginEnqueue(info.Limit.SatelliteId, info.Limit.OrderCreation)
+	if err != nil {
+		return err
+	}
+	return commit(info)
+}
+
+// importOrders stores the orders in one transaction, without checking their
+// grace period.
+func (o *OrderStore) importOrders(infos []*ordersfile.Info) error {
+	entries := make([][]byte, len(infos))
+	for i, info := range infos {
+		value, err := encodeOrder(info)
+		if err != nil {
+			return err
+		}
+		entries[i] = value
+	}
+	return update(o.db, func(txn kvTxn) error {
+		for i, info := range infos {
+			window := newOrderWindow(info.Limit.SatelliteId, info.Limit.OrderCreation)
+			if err := txn.Set(concat(window.key(unsentOrderPrefix), info.Limit.SerialNumber.Bytes()), entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importArchived stores the archived orders in one transaction.
+func (o *OrderStore) importArchived(archived []*ArchivedOrder) error {
+	entries := make([][]byte, len(archived))
+	for i, order := range archived {
+		value, err := encodeOrder(&ordersfile.Info{Limit: order.Limit, Order: order.Order})
+		if err != nil {
+			return err
+		}
+		entries[i] = concat([]byte{byte(order.Status)}, value)
+	}
+	return update(o.db, func(txn kvTxn) error {
+		for i, order := range archived {
+			window := newOrderWindow(order.Limit.SatelliteId, order.Limit.OrderCreation)
+			key := archivedOrderKey(order.ArchivedAt, concat(window.key(unsentOrderPrefix), order.Limit.SerialNumber.Bytes()))
+			if err := txn.Set(key, entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListUnsentBySatellite returns the oldest window of the orders of every
+// satellite which no longer takes orders at now. The windows have to be
+// archived to list the next ones.
+func (o *OrderStore) ListUnsentBySatellite(ctx context.Context, now time.Time) (map[storj.NodeID]UnsentOrders, error) {
+	unsent := map[storj.NodeID]UnsentOrders{}
+	err := view(o.db, func(txn kvTxn) error {
+		it := txn.NewIterator(o.iterators.options(IteratorRecords, unsentOrderPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			window, err := parseOrderWindow(it.Item().Key()[len(unsentOrderPrefix):])
+			if err != nil {
+				return err
+			}
+			hour := time.Unix(0, window.hour)
+			if orders, ok := unsent[window.satellite]; ok && !orders.CreatedAtHour.Equal(hour) {
+				continue
+			}
+			// orders may still be added until the grace period of the last hour of the window
+			if now.Sub(hour.Add(time.Hour)) <= o.gracePeriod || o.enqueueing(window) {
+				continue
+			}
+			info, err := readOrder(it.Item())
+			if err != nil {
+				return err
+			}
+			orders := unsent[window.satellite]
+			orders.CreatedAtHour = hour
+			orders.InfoList = append(orders.InfoList, info)
+			unsent[window.satellite] = orders
+		}
+		return nil
+	})
+	return unsent, err
+}
+
+func (o *OrderStore) enqueueing(window orderWindow) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.active[window] > 0
+}
+
+// Archive moves the window of the satellite to the archive, with the status
+// the satellite responded with.
+func (o *OrderStore) Archive(satelliteID storj.NodeID, unsent UnsentOrders, archivedAt time.Time, status pb.SettlementWithWindowResponse_Status) error {
+	prefix := newOrderWindow(satelliteID, unsent.CreatedAtHour).key(unsentOrderPrefix)
+	for {
+		moved := 0
+		err := update(o.db, func(txn kvTxn) error {
+			moved = 0
+			it := txn.NewIterator(o.iterators.options(IteratorRecords, prefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && moved < orderBatchSize; it.Next() {
+				key := it.Item().KeyCopy(nil)
+				value, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return errs.Wrap(err)
+				}
+				if err := txn.Set(archivedOrderKey(archivedAt, key), concat([]byte{byte(status)}, value)); err != nil {
+					return err
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				moved++
+			}
+			return nil
+		})
+		if err != nil || moved < orderBatchSize {
+			return err
+		}
+	}
+}
+
+// archivedOrderKey returns the key of the order of the unsent key archived
+// at archivedAt.
+func archivedOrderKey(archivedAt time.Time, unsentKey []byte) []byte {
+	return concat(archivedOrderPrefix, binary.BigEndian.AppendUint64(nil, uint64(archivedAt.UnixNano())), unsentKey[len(unsentOrderPrefix):])
+}
+
+// ListArchived returns the archived orders.
+func (o *OrderStore) ListArchived() (archived []*ArchivedOrder, err error) {
+	return o.listArchived(0)
+}
+
+// listArchived returns at most limit archived orders, all of them if limit
+// is 0.
+func (o *OrderStore) listArchived(limit int) (archived []*ArchivedOrder, err error) {
+	err = view(o.db, func(txn kvTxn) error {
+		it := txn.NewIterator(o.iterators.options(IteratorRecords, archivedOrderPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid() && (limit == 0 || len(archived) < limit); it.Next() {
+			raw := it.Item().Key()[len(archivedOrderPrefix):]
+			if len(raw) < 8 {
+				return ErrOrders.New("invalid archive key %x", it.Item().Key())
+			}
+			order := &ArchivedOrder{ArchivedAt: time.Unix(0, int64(binary.BigEndian.Uint64(raw)))}
+			err := it.Item().Value(func(val []byte) error {
+				if len(val) == 0 {
+					return ErrOrders.New("empty archived order")
+				}
+				order.Status = pb.SettlementWithWindowResponse_Status(val[0])
+				info, err := decodeOrder(val[1:])
+				if err != nil {
+					return err
+				}
+				order.Limit, order.Order = info.Limit, info.Order
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			archived = append(archived, order)
+		}
+		return nil
+	})
+	return archived, err
+}
+
+// CleanArchive removes the orders archived before deleteBefore.
+func (o *OrderStore) CleanArchive(deleteBefore time.Time) error {
+	_, err := o.cleanArchive(deleteBefore)
+	return err
+}
+
+func (o *OrderStore) cleanArchive(deleteBefore time.Time) (count int, err error) {
+	end := concat(archivedOrderPrefix, binary.BigEndian.AppendUint64(nil, uint64(deleteBefore.UnixNano())))
+	for {
+		removed := 0
+		err := update(o.db, func(txn kvTxn) error {
+			removed = 0
+			it := txn.NewIterator(o.iterators.options(IteratorRecords, archivedOrderPrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && removed < orderBatchSize; it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if string(key) >= string(end) {
+					break
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+		count += removed
+		if err != nil || removed < orderBatchSize {
+			return count, err
+		}
+	}
+}
+
+// OrderDB is the orders.DB of the node, which the storagenode keeps the
+// orders of its older versions in, on the orders of the OrderStore. The
+// orders enqueued there are listed and archived by either.
+type OrderDB struct {
+	store *OrderStore
+}
+
+var _ orders.DB = (*OrderDB)(nil)
+
+// DB returns the orders.DB of the orders.
+func (o *OrderStore) DB() *OrderDB {
+	return &OrderDB{store: o}
+}
+
+// Enqueue stores the order to be sent, without checking its grace period.
+func (d *OrderDB) Enqueue(ctx context.Context, info *ordersfile.Info) error {
+	return d.store.importOrders([]*ordersfile.Info{info})
+}
+
+// ListUnsent returns at most limit orders to send.
+func (d *OrderDB) ListUnsent(ctx context.Context, limit int) (infos []*ordersfile.Info, err error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	err = d.walkUnsent(ctx, func(info *ordersfile.Info) bool {
+		infos = append(infos, info)
+		return len(infos) < limit
+	})
+	return infos, err
+}
+
+// ListUnsentBySatellite returns the orders to send which didn't expire yet,
+// by satellite.
+func (d *OrderDB) ListUnsentBySatellite(ctx context.Context) (map[storj.NodeID][]*ordersfile.Info, error) {
+	now := time.Now()
+	infos := map[storj.NodeID][]*ordersfile.Info{}
+	err := d.walkUnsent(ctx, func(info *ordersfile.Info) bool {
+		if !info.Limit.OrderExpiration.Before(now) {
+			infos[info.Limit.SatelliteId] = append(infos[info.Limit.SatelliteId], info)
+		}
+		return true
+	})
+	return infos, err
+}
+
+// walkUnsent calls fn for the unsent orders, until it returns false.
+func (d *OrderDB) walkUnsent(ctx context.Context, fn func(info *ordersfile.Info) bool) error {
+	return view(d.store.db, func(txn kvTxn) error {
+		it := txn.NewIterator(d.store.iterators.options(IteratorRecords, unsentOrderPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			info, err := readOrder(it.Item())
+			if err != nil {
+				return err
+			}
+			if !fn(info) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Archive moves the orders of the requests to the archive, with their
+// status. The requests whose order isn't found are returned as an
+// orders.OrderNotFoundError, after the others are archived. The statuses
+// have the values of the pb.SettlementWithWindowResponse_Status the
+// OrderStore archives with.
+func (d *OrderDB) Archive(ctx context.Context, archivedAt time.Time, requests ...orders.ArchiveRequest) error {
+	serials := map[storj.NodeID]map[storj.SerialNumber]orders.Status{}
+	for _, req := range requests {
+		if serials[req.Satellite] == nil {
+			serials[req.Satellite] = map[storj.SerialNumber]orders.Status{}
+		}
+		serials[req.Satellite][req.Serial] = req.Status
+	}
+	var notFound errs.Group
+	err := update(d.store.db, func(txn kvTxn) error {
+		notFound = nil
+		for satellite, statuses := range serials {
+			found := map[storj.SerialNumber]bool{}
+			var keys, values [][]byte
+			it := txn.NewIterator(d.store.iterators.options(IteratorRecords, concat(unsentOrderPrefix, satellite.Bytes())))
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().Key()
+				serial, err := storj.SerialNumberFromBytes(key[len(key)-len(storj.SerialNumber{}):])
+				if err != nil {
+					it.Close()
+					return ErrOrders.Wrap(err)
+				}
+				status, ok := statuses[serial]
+				if !ok {
+					continue
+				}
+				value, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					it.Close()
+					return errs.Wrap(err)
+				}
+				found[serial] = true
+				keys = append(keys, it.Item().KeyCopy(nil))
+				values = append(values, concat([]byte{byte(status)}, value))
+			}
+			it.Close()
+			for i, key := range keys {
+				if err := txn.Set(archivedOrderKey(archivedAt, key), values[i]); err != nil {
+					return err
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			for serial := range statuses {
+				if !found[serial] {
+					notFound.Add(orders.OrderNotFoundError.New("satellite: %s, serial number: %s", satellite, serial))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(notFound) > 0 {
+		return orders.OrderNotFoundError.Wrap(notFound.Err())
+	}
+	return nil
+}
+
+// ListArchived returns at most limit archived orders.
+func (d *OrderDB) ListArchived(ctx context.Context, limit int) ([]*orders.ArchivedInfo, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	archived, err := d.store.listArchived(limit)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*orders.ArchivedInfo, len(archived))
+	for i, order := range archived {
+		infos[i] = &orders.ArchivedInfo{Limit: order.Limit, Order: order.Order, Status: orders.Status(order.Status), ArchivedAt: order.ArchivedAt}
+	}
+	return infos, nil
+}
+
+// CleanArchive removes the orders archived before deleteBefore, and returns
+// their number.
+func (d *OrderDB) CleanArchive(ctx context.Context, deleteBefore time.Time) (int, error) {
+	return d.store.cleanArchive(deleteBefore)
+}
+
+func parseOrderWindow(raw []byte) (window orderWindow, err error) {
+	if len(raw) != len(storj.NodeID{})+8+len(storj.SerialNumber{}) {
+		return window, ErrOrders.New("invalid order key %x", raw)
+	}
+	copy(window.satellite[:], raw)
+	window.hour = int64(binary.BigEndian.Uint64(raw[len(storj.NodeID{}):]))
+	return window, nil
+}
+
+// encodeOrder encodes the limit and the order, prefixing the limit with its
+// length.
+func encodeOrder(info *ordersfile.Info) ([]byte, error) {
+	limit, err := pb.Marshal(info.Limit)
+	if err != nil {
+		return nil, ErrOrders.Wrap(err)
+	}
+	order, err := pb.Marshal(info.Order)
+	if err != nil {
+		return nil, ErrOrders.Wrap(err)
+	}
+	value := binary.AppendUvarint(nil, uint64(len(limit)))
+	return append(append(value, limit...), order...), nil
+}
+
+func decodeOrder(value []byte) (*ordersfile.Info, error) {
+	size, n := binary.Uvarint(value)
+	if n <= 0 || uint64(len(value)-n) < size {
+		return nil, ErrOrders.New("invalid order entry")
+	}
+	info := &ordersfile.Info{Limit: &pb.OrderLimit{}, Order: &pb.Order{}}
+	if err := pb.Unmarshal(value[n:n+int(size)], info.Limit); err != nil {
+		return nil, ErrOrders.Wrap(err)
+	}
+	if err := pb.Unmarshal(value[n+int(size):], info.Order); err != nil {
+		return nil, ErrOrders.Wrap(err)
+	}
+	return info, nil
+}
+
+func readOrder(item kvItem) (info *ordersfile.Info, err error) {
+	err = item.Value(func(val []byte) error {
+		info, err = decodeOrder(val)
+		return err
+	})
+	return info, err
+}
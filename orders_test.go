@@ -0,0 +1,154 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/orders"
+	"storj.io/storj/storagenode/orders/ordersfile"
+	"testing"
+	"time"
+)
+
+func testOrder(satellite storj.NodeID, createdAt time.Time, amount int64) *ordersfile.Info {
+	serial := testrand.SerialNumber()
+	return &ordersfile.Info{
+		Limit: &pb.OrderLimit{SatelliteId: satellite, SerialNumber: serial, OrderCreation: createdAt},
+		Order: &pb.Order{SerialNumber: serial, Amount: amount},
+	}
+}
+
+func TestOrderStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(size int) { orderBatchSize = size }(orderBatchSize)
+	orderBatchSize = 2
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	orderStore := store.Orders(24 * time.Hour)
+
+	now := time.Now()
+	satellite := testrand.NodeID()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, orderStore.Enqueue(testOrder(satellite, now.Add(-time.Hour), int64(i))))
+	}
+	// too old to be accepted
+	require.True(t, ErrOrders.Has(orderStore.Enqueue(testOrder(satellite, now.Add(-48*time.Hour), 1))))
+
+	// the window still takes orders
+	unsent, err := orderStore.ListUnsentBySatellite(ctx, now)
+	require.NoError(t, err)
+	require.Empty(t, unsent)
+
+	later := now.Add(48 * time.Hour)
+	// windows with enqueues in progress are not listed
+	commit, err := orderStore.BeginEnqueue(satellite, now.Add(-time.Hour))
+	require.NoError(t, err)
+	unsent, err = orderStore.ListUnsentBySatellite(ctx, later)
+	require.NoError(t, err)
+	require.Empty(t, unsent)
+	require.NoError(t, commit(nil))
+
+	unsent, err = orderStore.ListUnsentBySatellite(ctx, later)
+	require.NoError(t, err)
+	require.Len(t, unsent[satellite].InfoList, 3)
+	require.Equal(t, now.Add(-time.Hour).Truncate(time.Hour).Unix(), unsent[satellite].CreatedAtHour.Unix())
+
+	require.NoError(t, orderStore.Archive(satellite, unsent[satellite], now, pb.SettlementWithWindowResponse_REJECTED))
+	unsent, err = orderStore.ListUnsentBySatellite(ctx, later)
+	require.NoError(t, err)
+	require.Empty(t, unsent)
+
+	archived, err := orderStore.ListArchived()
+	require.NoError(t, err)
+	require.Len(t, archived, 3)
+	for _, order := range archived {
+		require.Equal(t, pb.SettlementWithWindowResponse_REJECTED, order.Status)
+		require.Equal(t, order.Limit.SerialNumber, order.Order.SerialNumber)
+		require.Equal(t, now.UnixNano(), order.ArchivedAt.UnixNano())
+	}
+
+	require.NoError(t, orderStore.CleanArchive(now))
+	archived, err = orderStore.ListArchived()
+	require.NoError(t, err)
+	require.Len(t, archived, 3)
+	require.NoError(t, orderStore.CleanArchive(now.Add(time.Second)))
+	archived, err = orderStore.ListArchived()
+	require.NoError(t, err)
+	require.Empty(t, archived)
+}
+
+func TestOrderDB(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	orderStore := store.Orders(24 * time.Hour)
+	db := orderStore.DB()
+
+	now := time.Now()
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	infos := []*ordersfile.Info{
+		testOrder(satellite1, now.Add(-time.Hour), 1),
+		testOrder(satellite1, now.Add(-time.Hour), 2),
+		testOrder(satellite2, now.Add(-72*time.Hour), 3),
+	}
+	for _, info := range infos {
+		info.Limit.OrderExpiration = now.Add(time.Hour)
+		require.NoError(t, db.Enqueue(ctx, info))
+	}
+	infos[2].Limit.OrderExpiration = now.Add(-time.Hour)
+	require.NoError(t, db.Enqueue(ctx, infos[2]))
+
+	unsent, err := db.ListUnsent(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, unsent, 2)
+	// the expired orders are not sent
+	bySatellite, err := db.ListUnsentBySatellite(ctx)
+	require.NoError(t, err)
+	require.Len(t, bySatellite, 1)
+	require.Len(t, bySatellite[satellite1], 2)
+
+	// the orders are the ones of the order store
+	windows, err := orderStore.ListUnsentBySatellite(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, windows[satellite1].InfoList, 2)
+
+	err = db.Archive(ctx, now,
+		orders.ArchiveRequest{Satellite: satellite1, Serial: infos[0].Limit.SerialNumber, Status: orders.StatusAccepted},
+		orders.ArchiveRequest{Satellite: satellite2, Serial: infos[2].Limit.SerialNumber, Status: orders.StatusRejected},
+		orders.ArchiveRequest{Satellite: satellite2, Serial: testrand.SerialNumber(), Status: orders.StatusAccepted},
+	)
+	require.True(t, orders.OrderNotFoundError.Has(err))
+	unsent, err = db.ListUnsent(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, unsent, 1)
+	require.Equal(t, infos[1].Limit.SerialNumber, unsent[0].Limit.SerialNumber)
+
+	archived, err := db.ListArchived(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, archived, 2)
+	statuses := map[storj.SerialNumber]orders.Status{}
+	for _, info := range archived {
+		require.Equal(t, now.UnixNano(), info.ArchivedAt.UnixNano())
+		statuses[info.Limit.SerialNumber] = info.Status
+	}
+	require.Equal(t, map[storj.SerialNumber]orders.Status{
+		infos[0].Limit.SerialNumber: orders.StatusAccepted,
+		infos[2].Limit.SerialNumber: orders.StatusRejected,
+	}, statuses)
+
+	removed, err := db.CleanArchive(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	archived, err = db.ListArchived(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, archived)
+}
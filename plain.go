@@ -0,0 +1,35 @@
+package badger
+
+import (
+	"context"
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// PlainBlobs wraps the store with a FreeSpace method and a WalkNamespace
+// without startFromPrefix, for callers which use those signatures. It's only
+// a wrapper: it isn't built or tested against any other storj release than
+// the one in go.mod, and it doesn't make the store implement the
+// blobstore.Blobs interface of other releases. The other methods are
+// promoted from BlobStore.
+type PlainBlobs struct {
+	*BlobStore
+}
+
+// plainBlobs are the methods PlainBlobs adds to BlobStore.
+type plainBlobs interface {
+	FreeSpace(ctx context.Context) (int64, error)
+	WalkNamespace(ctx context.Context, namespace []byte, walkFunc func(blobstore.BlobInfo) error) error
+}
+
+var _ plainBlobs = PlainBlobs{}
+
+// FreeSpace returns the space available for the node, see DiskInfo.
+func (p PlainBlobs) FreeSpace(ctx context.Context) (int64, error) {
+	info, err := p.BlobStore.DiskInfo(ctx)
+	return info.AvailableSpace, err
+}
+
+// WalkNamespace calls walkFunc for every blob of the namespace.
+func (p PlainBlobs) WalkNamespace(ctx context.Context, namespace []byte, walkFunc func(blobstore.BlobInfo) error) error {
+	return p.BlobStore.WalkNamespace(ctx, namespace, "", walkFunc)
+}
@@ -0,0 +1,37 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+)
+
+func TestPlainBlobs(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "data"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "data"))
+
+	plain := PlainBlobs{store}
+	free, err := plain.FreeSpace(ctx)
+	require.NoError(t, err)
+	require.Positive(t, free)
+
+	var keys []string
+	require.NoError(t, plain.WalkNamespace(ctx, []byte("ns"), func(info blobstore.BlobInfo) error {
+		keys = append(keys, string(info.BlobRef().Key))
+		return nil
+	}))
+	require.ElementsMatch(t, []string{"key1", "key2"}, keys)
+
+	// the unchanged methods work as they are
+	used, err := plain.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), used)
+}
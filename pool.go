@@ -0,0 +1,50 @@
+package badger
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Read buffers are pooled in power of two size classes between
+// minPooledBuffer and maxPooledBuffer. Larger buffers are allocated directly.
+const (
+	minPooledBufferBits = 12
+	maxPooledBufferBits = 23
+)
+
+var bufferPools [maxPooledBufferBits - minPooledBufferBits + 1]sync.Pool
+
+// bufferClass returns the index of the smallest pool with buffers of at least
+// size bytes, or -1 if size is too large to pool.
+func bufferClass(size int) int {
+	class := 0
+	if size > 1<<minPooledBufferBits {
+		class = bits.Len(uint(size-1)) - minPooledBufferBits
+	}
+	if class >= len(bufferPools) {
+		return -1
+	}
+	return class
+}
+
+// getBuffer returns an empty buffer with a capacity of at least size bytes.
+func getBuffer(size int) []byte {
+	class := bufferClass(size)
+	if class < 0 {
+		return make([]byte, 0, size)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:0]
+	}
+	return make([]byte, 0, 1<<(class+minPooledBufferBits))
+}
+
+// putBuffer returns a buffer from getBuffer to the pool. The buffer must not
+// be used afterwards.
+func putBuffer(buf []byte) {
+	class := bufferClass(cap(buf))
+	if class < 0 || cap(buf) != 1<<(class+minPooledBufferBits) {
+		return
+	}
+	bufferPools[class].Put(&buf)
+}
@@ -0,0 +1,73 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"strings"
+	"testing"
+)
+
+func TestBufferClass(t *testing.T) {
+	require.Equal(t, 0, bufferClass(0))
+	require.Equal(t, 0, bufferClass(4096))
+	require.Equal(t, 1, bufferClass(4097))
+	require.Equal(t, 1, bufferClass(8192))
+	require.Equal(t, len(bufferPools)-1, bufferClass(8<<20))
+	require.Equal(t, -1, bufferClass(8<<20+1))
+
+	for _, size := range []int{0, 100, 5000, 2 << 20, 9 << 20} {
+		buf := getBuffer(size)
+		require.Len(t, buf, 0)
+		require.GreaterOrEqual(t, cap(buf), size)
+		putBuffer(buf)
+	}
+}
+
+func BenchmarkReadBuffer(b *testing.B) {
+	const size = 2 << 20
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := make([]byte, 0, size)
+			_ = append(buf, 1)
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getBuffer(size)
+			putBuffer(append(buf, 1))
+		}
+	})
+}
+
+func BenchmarkOpenRead(b *testing.B) {
+	ctx := testcontext.New(b)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(b, err)
+	defer ctx.Check(store.Close)
+
+	data := strings.Repeat("x", (2 * memory.MiB).Int())
+	require.NoError(b, save(ctx, store, ref("ns", "key"), data))
+
+	buf := make([]byte, 32*memory.KiB)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, err := store.Open(ctx, ref("ns", "key"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.CopyBuffer(io.Discard, reader, buf); err != nil {
+			b.Fatal(err)
+		}
+		if err := reader.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
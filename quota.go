@@ -0,0 +1,113 @@
+package badger
+
+import (
+	"github.com/zeebo/errs"
+	"storj.io/common/memory"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned by the commits which would make a namespace
+// use more than its quota.
+var ErrQuotaExceeded = errs.Class("namespace quota exceeded")
+
+// quotas are the byte quotas of the namespaces.
+type quotas struct {
+	mu    sync.Mutex
+	bytes map[string]int64
+}
+
+// parseNamespaceQuotas parses the quotas of Config.NamespaceQuotas.
+func parseNamespaceQuotas(spec string) (*quotas, error) {
+	q := &quotas{bytes: map[string]int64{}}
+	if strings.TrimSpace(spec) == "" {
+		return q, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		satellite, size, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, ErrConfig.New("namespace quota %q", part)
+		}
+		id, err := storj.NodeIDFromString(satellite)
+		if err != nil {
+			return nil, ErrConfig.New("namespace quota %q: %v", part, err)
+		}
+		// memory.ParseString panics on sizes without digits
+		if size == "" || size[0] < '0' || size[0] > '9' {
+			return nil, ErrConfig.New("namespace quota %q has invalid size", part)
+		}
+		quota, err := memory.ParseString(size)
+		if err != nil || quota <= 0 {
+			return nil, ErrConfig.New("namespace quota %q has invalid size", part)
+		}
+		q.bytes[string(id.Bytes())] = quota
+	}
+	return q, nil
+}
+
+func (q *quotas) get(namespace []byte) (quota int64, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	quota, ok = q.bytes[string(namespace)]
+	return quota, ok
+}
+
+func (q *quotas) set(namespace []byte, quota int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if quota <= 0 {
+		delete(q.bytes, string(namespace))
+		return
+	}
+	q.bytes[string(namespace)] = quota
+}
+
+// check returns an ErrQuotaExceeded error if committing size bytes as the
+// blob would make its namespace use more than its quota. The usage is read
+// outside the commit, so the commits don't conflict on the counters:
+// concurrent uploads may overshoot the quota by their size.
+func (q *quotas) check(db kvEngine, ref blobstore.BlobRef, size int64) error {
+	if q == nil {
+		return nil
+	}
+	quota, ok := q.get(ref.Namespace)
+	if !ok {
+		return nil
+	}
+	var used usage
+	err := view(db, func(txn kvTxn) (err error) {
+		used, err = namespaceUsage(txn, ref.Namespace)
+		if err != nil {
+			return err
+		}
+		// the older versions of the blob are replaced
+		for _, stale := range blobVersions(txn, keyPrefix(ref)) {
+			used.Bytes -= stale.size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if used.Bytes+size > quota {
+		mon.Counter("quota_rejections").Inc(1)
+		return ErrQuotaExceeded.New("namespace %x uses %d of its %d bytes, the blob has %d", ref.Namespace, used.Bytes, quota, size)
+	}
+	return nil
+}
+
+// SetNamespaceQuota sets the byte quota of the namespace, overriding
+// Config.NamespaceQuotas until the store is closed. Zero removes the quota.
+// The blobs stored already are kept when a namespace is over its quota, only
+// the new commits fail.
+func (b *BlobStore) SetNamespaceQuota(namespace []byte, quota int64) {
+	b.quotas.set(namespace, quota)
+}
+
+// NamespaceQuota returns the byte quota of the namespace, false if it has
+// none.
+func (b *BlobStore) NamespaceQuota(namespace []byte) (quota int64, ok bool) {
+	return b.quotas.get(namespace)
+}
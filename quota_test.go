@@ -0,0 +1,45 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	satellite := testrand.NodeID()
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{NamespaceQuotas: satellite.String() + "=10B"})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	quota, ok := store.NamespaceQuota(satellite.Bytes())
+	require.True(t, ok)
+	require.EqualValues(t, 10, quota)
+
+	ns := string(satellite.Bytes())
+	require.NoError(t, save(ctx, store, ref(ns, "key1"), "12345"))
+	require.NoError(t, save(ctx, store, ref(ns, "key2"), "12345"))
+	err = save(ctx, store, ref(ns, "key3"), "1")
+	require.True(t, ErrQuotaExceeded.Has(err))
+	_, err = store.Stat(ctx, ref(ns, "key3"))
+	require.Error(t, err)
+
+	// replacing a blob counts only the difference
+	require.NoError(t, save(ctx, store, ref(ns, "key2"), "54321"))
+	// other namespaces have no quota
+	require.NoError(t, save(ctx, store, ref("other", "key"), "123456789012"))
+
+	store.SetNamespaceQuota([]byte("other"), 12)
+	require.True(t, ErrQuotaExceeded.Has(save(ctx, store, ref("other", "key2"), "1")))
+	store.SetNamespaceQuota([]byte("other"), 0)
+	require.NoError(t, save(ctx, store, ref("other", "key2"), "1"))
+
+	for _, spec := range []string{"invalid", satellite.String() + "=abc", "abc=1GB", satellite.String() + "=0B"} {
+		_, err := parseNamespaceQuotas(spec)
+		require.True(t, ErrConfig.Has(err), spec)
+	}
+}
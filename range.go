@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// ErrRange is returned for ranges outside of the blob.
+var ErrRange = errs.Class("invalid range")
+
+// ReadRange returns length bytes of the blob starting at offset. Only the
+// requested bytes are copied out of badger, instead of the whole blob as
+// Open does. Blobs stored as a single value may still need to be loaded from
+// the value log whole, chunked blobs only read the chunks holding the range.
+func (b *BlobStore) ReadRange(ctx context.Context, ref blobstore.BlobRef, offset, length int64) (_ []byte, err error) {
+	defer wrapRef("read range", ref, &err)
+	if offset < 0 || length < 0 {
+		return nil, ErrRange.New("%d bytes at %d", length, offset)
+	}
+	if b.cache != nil {
+		if data, _, ok := b.cache.get(ref); ok {
+			if offset > int64(len(data)) || length > int64(len(data))-offset {
+				return nil, ErrRange.New("%d bytes at %d of %d byte blob", length, offset, len(data))
+			}
+			if err := b.rateLimits.waitRead(ctx, ref.Namespace, 1, length); err != nil {
+				return nil, err
+			}
+			b.io.read(ref.Namespace)
+			b.io.readBytes(ref.Namespace, int(length))
+			b.accessed(ref)
+			return append([]byte(nil), data[offset:offset+length]...), nil
+		}
+	}
+	data, found, cold, err := b.readRange(ref, offset, length)
+	if err == nil && cold {
+		// fetched back from the cold store, then read from badger
+		if err := b.fetchCold(ctx, ref); err != nil {
+			return nil, err
+		}
+		data, found, cold, err = b.readRange(ref, offset, length)
+		if err == nil && cold {
+			err = ErrTiering.New("blob %x was evicted again", ref.Key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNotExist("%s", ref.Key)
+	}
+	// like Open, the read is limited once the range is known to be valid
+	if err := b.rateLimits.waitRead(ctx, ref.Namespace, 1, length); err != nil {
+		return nil, err
+	}
+	b.io.read(ref.Namespace)
+	b.io.readBytes(ref.Namespace, len(data))
+	b.accessed(ref)
+	return data, nil
+}
+
+// readRange reads the range of the blob from badger, unless the blob was
+// moved to the cold store.
+func (b *BlobStore) readRange(ref blobstore.BlobRef, offset, length int64) (data []byte, found, cold bool, err error) {
+	started := time.Now()
+	err = b.guard(func() error {
+		return view(b.db, func(txn kvTxn) error {
+			versions := blobVersions(txn, keyPrefix(ref))
+			if len(versions) == 0 {
+				return nil
+			}
+			found = true
+			size := versions[0].size
+			// compared without the sum, which overflows for huge lengths
+			if offset > size || length > size-offset {
+				return ErrRange.New("%d bytes at %d of %d byte blob", length, offset, size)
+			}
+			item, err := txn.Get(versions[0].key)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			if item.UserMeta()&userMetaCold != 0 {
+				cold = true
+				return nil
+			}
+			if item.UserMeta()&userMetaChunked != 0 {
+				data, err = chunkedRange(txn, item, offset, length)
+				return err
+			}
+			return blobValue(txn, item, func(val []byte) error {
+				if int64(len(val)) != size {
+					return ErrCorrupt.New("blob %x has %d bytes instead of %d", item.Key(), len(val), size)
+				}
+				data = append([]byte(nil), val[offset:offset+length]...)
+				return nil
+			})
+		})
+	})
+	b.diskHealth.observeRead(time.Since(started))
+	return data, found, cold, err
+}
@@ -0,0 +1,73 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestReadRange(t *testing.T) {
+	for _, config := range []Config{{}, {Dedup: true}, {ReadCache: memory.KiB}} {
+		ctx := testcontext.New(t)
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), config)
+		require.NoError(t, err)
+
+		require.NoError(t, save(ctx, store, ref("ns", "key"), "0123456789"))
+		for i := 0; i < 2; i++ {
+			data, err := store.ReadRange(ctx, ref("ns", "key"), 2, 5)
+			require.NoError(t, err)
+			require.Equal(t, "23456", string(data))
+		}
+
+		data, err := store.ReadRange(ctx, ref("ns", "key"), 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, data)
+
+		_, err = store.ReadRange(ctx, ref("ns", "key"), 8, 5)
+		require.True(t, ErrRange.Has(err))
+		_, err = store.ReadRange(ctx, ref("ns", "key"), -1, 5)
+		require.True(t, ErrRange.Has(err))
+		_, err = store.ReadRange(ctx, ref("ns", "key"), 11, 0)
+		require.True(t, ErrRange.Has(err))
+		// the end of the range overflows
+		_, err = store.ReadRange(ctx, ref("ns", "key"), 2, math.MaxInt64)
+		require.True(t, ErrRange.Has(err))
+		_, err = store.ReadRange(ctx, ref("ns", "missing"), 0, 1)
+		require.True(t, ErrNotExist.Has(err))
+		require.ErrorContains(t, err, "read range 6e73/6d697373696e67: ")
+
+		require.NoError(t, store.Close())
+		ctx.Cleanup()
+	}
+}
+
+func TestReadRangeRateLimit(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "0123456789"))
+	store.SetNamespaceRateLimits([]byte("ns"), RateLimits{ReadOps: 1})
+
+	// the invalid ranges and missing blobs aren't counted
+	_, err = store.ReadRange(ctx, ref("ns", "key"), 8, 5)
+	require.True(t, ErrRange.Has(err))
+	_, err = store.ReadRange(ctx, ref("ns", "missing"), 0, 1)
+	require.True(t, ErrNotExist.Has(err))
+
+	canceled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	data, err := store.ReadRange(canceled, ref("ns", "key"), 2, 5)
+	require.NoError(t, err)
+	require.Equal(t, "23456", string(data))
+	_, err = store.ReadRange(canceled, ref("ns", "key"), 2, 5)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,159 @@
+package badger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimits are the operations and bytes per second a namespace may read
+// and write. Zero doesn't limit them.
+type RateLimits struct {
+	ReadOps    int64
+	ReadBytes  int64
+	WriteOps   int64
+	WriteBytes int64
+}
+
+// rateLimits holds the token buckets of the namespaces. Every namespace has
+// its own, so the traffic of one doesn't slow down the others.
+type rateLimits struct {
+	mu        sync.Mutex
+	defaults  RateLimits
+	overrides map[string]RateLimits
+	buckets   map[string]*namespaceBuckets
+}
+
+// namespaceBuckets are the token buckets of a namespace, nil when the limit
+// is disabled.
+type namespaceBuckets struct {
+	readOps, readBytes, writeOps, writeBytes *tokenBucket
+}
+
+func newRateLimits(config Config) *rateLimits {
+	return &rateLimits{
+		defaults: RateLimits{
+			ReadOps:    int64(config.NamespaceReadOps),
+			ReadBytes:  config.NamespaceReadBytes.Int64(),
+			WriteOps:   int64(config.NamespaceWriteOps),
+			WriteBytes: config.NamespaceWriteBytes.Int64(),
+		},
+		overrides: map[string]RateLimits{},
+		buckets:   map[string]*namespaceBuckets{},
+	}
+}
+
+// tokenBucket allows rate tokens per second, with bursts of a second. Tokens
+// are taken even when there aren't enough, so requests larger than the burst
+// pass too, after waiting for them.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// take takes n tokens and returns how long to wait until they are available.
+func (t *tokenBucket) take(now time.Time, n int64) time.Duration {
+	if t == nil || n <= 0 {
+		return 0
+	}
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+func (r *rateLimits) namespace(namespace []byte, now time.Time) *namespaceBuckets {
+	buckets, ok := r.buckets[string(namespace)]
+	if ok {
+		return buckets
+	}
+	limits, ok := r.overrides[string(namespace)]
+	if !ok {
+		limits = r.defaults
+	}
+	buckets = &namespaceBuckets{
+		readOps:    newTokenBucket(limits.ReadOps, now),
+		readBytes:  newTokenBucket(limits.ReadBytes, now),
+		writeOps:   newTokenBucket(limits.WriteOps, now),
+		writeBytes: newTokenBucket(limits.WriteBytes, now),
+	}
+	r.buckets[string(namespace)] = buckets
+	return buckets
+}
+
+// waitRead waits until the namespace may read ops blobs of size bytes.
+func (r *rateLimits) waitRead(ctx context.Context, namespace []byte, ops, size int64) error {
+	return r.wait(ctx, namespace, func(buckets *namespaceBuckets, now time.Time) time.Duration {
+		return longest(buckets.readOps.take(now, ops), buckets.readBytes.take(now, size))
+	})
+}
+
+// waitWrite waits until the namespace may write ops blobs of size bytes.
+func (r *rateLimits) waitWrite(ctx context.Context, namespace []byte, ops, size int64) error {
+	return r.wait(ctx, namespace, func(buckets *namespaceBuckets, now time.Time) time.Duration {
+		return longest(buckets.writeOps.take(now, ops), buckets.writeBytes.take(now, size))
+	})
+}
+
+func longest(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (r *rateLimits) wait(ctx context.Context, namespace []byte, take func(*namespaceBuckets, time.Time) time.Duration) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	now := time.Now()
+	delay := take(r.namespace(namespace, now), now)
+	r.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	mon.Counter("rate_limited").Inc(1)
+	mon.DurationVal("rate_limit_delay").Observe(delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetNamespaceRateLimits overrides the rate limits of the configuration for
+// the namespace until the store is closed.
+func (b *BlobStore) SetNamespaceRateLimits(namespace []byte, limits RateLimits) {
+	b.rateLimits.mu.Lock()
+	defer b.rateLimits.mu.Unlock()
+	b.rateLimits.overrides[string(namespace)] = limits
+	delete(b.rateLimits.buckets, string(namespace))
+}
+
+// NamespaceRateLimits returns the rate limits of the namespace.
+func (b *BlobStore) NamespaceRateLimits(namespace []byte) RateLimits {
+	b.rateLimits.mu.Lock()
+	defer b.rateLimits.mu.Unlock()
+	if limits, ok := b.rateLimits.overrides[string(namespace)]; ok {
+		return limits
+	}
+	return b.rateLimits.defaults
+}
@@ -0,0 +1,60 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	require.Nil(t, newTokenBucket(0, now))
+	require.Zero(t, (*tokenBucket)(nil).take(now, 100))
+
+	bucket := newTokenBucket(10, now)
+	// a second of burst
+	require.Zero(t, bucket.take(now, 10))
+	require.Equal(t, 500*time.Millisecond, bucket.take(now, 5))
+	// the debt is paid over time
+	require.Zero(t, bucket.take(now.Add(time.Second), 5))
+	// the tokens don't accumulate beyond the burst
+	require.Equal(t, time.Second, bucket.take(now.Add(time.Hour), 20))
+}
+
+func TestNamespaceRateLimits(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{NamespaceWriteOps: 1000})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.EqualValues(t, 1000, store.NamespaceRateLimits([]byte("slow")).WriteOps)
+	store.SetNamespaceRateLimits([]byte("slow"), RateLimits{WriteOps: 1, ReadOps: 1})
+	require.EqualValues(t, 1, store.NamespaceRateLimits([]byte("slow")).WriteOps)
+
+	require.NoError(t, save(ctx, store, ref("slow", "key1"), "data"))
+	// the next commit of the namespace waits for a second
+	canceled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	w, err := store.Create(canceled, ref("slow", "key2"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.Commit(canceled), context.DeadlineExceeded)
+
+	// other namespaces aren't slowed down
+	started := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, save(ctx, store, ref("fast", string(rune('a'+i))), "data"))
+	}
+	require.Less(t, time.Since(started), time.Second)
+
+	// reads have their own limit
+	_, err = store.ReadRange(ctx, ref("slow", "key1"), 0, 4)
+	require.NoError(t, err)
+	_, err = store.ReadRange(canceled, ref("slow", "key1"), 0, 4)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
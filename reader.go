@@ -1,68 +1,275 @@
 package badger
 
 import (
-	badger "github.com/dgraph-io/badger/v4"
+	"context"
 	"github.com/pkg/errors"
 	"github.com/zeebo/errs"
 	"io"
 	"storj.io/storj/storagenode/blobstore"
-	"storj.io/storj/storagenode/blobstore/filestore"
+	"sync"
+	"time"
 )
 
+// reader reads a blob. Blobs stored as a single value are loaded with one
+// lookup on the first read and served from memory afterwards. Chunked blobs
+// are read a chunk at a time, and once the chunks are read in order the next
+// ones are read ahead concurrently, so large downloads don't wait for a
+// lookup at every chunk.
 type reader struct {
 	offset int
 	length int
 	buffer []byte
+
+	// the value is loaded on the first read, so stat-only callers don't pay
+	// for copying the blob
+	loaded     bool
+	pooled     bool
+	db         kvEngine
+	key        []byte
+	cache      *readCache
+	ref        blobstore.BlobRef
+	generation uint64
+	diskHealth *diskHealth
+	io         *ioStats
+	retry      *RetryPolicy
+	format     blobstore.FormatVersion
+	// cold is set for blobs moved to the cold store
+	cold bool
+
+	// chunks points to the chunks of chunked blobs, and header is the piece
+	// header of the FormatV2 ones; chunk is the last chunk read, fetches are
+	// the ones read ahead of it, up to readAhead. Close cancels the reads
+	// ahead and waits for them, so none outlives the reader.
+	chunks     *chunkPointer
+	header     []byte
+	readAhead  int
+	chunkIndex int
+	chunk      []byte
+	sequential bool
+	fetches    map[int]*chunkFetch
+	fetchCtx   context.Context
+	cancel     context.CancelFunc
+	fetching   sync.WaitGroup
+}
+
+// chunkFetch is a chunk read ahead, set when done is closed.
+type chunkFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
 }
 
 var _ blobstore.BlobReader = &reader{}
 
-func NewReader(db *badger.DB, ref blobstore.BlobRef) (blobstore.BlobReader, error) {
-	r := reader{}
-	r.buffer = make([]byte, 0)
-	var found bool
-	err := db.View(func(txn *badger.Txn) error {
-		pref := keyPrefix(ref)
-		it := txn.NewIterator(badger.IteratorOptions{
-			PrefetchSize:   1,
-			PrefetchValues: true,
-			Prefix:         pref,
-		})
-		defer it.Close()
+func NewReader(db kvEngine, ref blobstore.BlobRef) (blobstore.BlobReader, error) {
+	return newReader(db, ref)
+}
 
-		for it.Seek(pref); it.ValidForPrefix(pref); {
-			var err error
-			r.buffer, err = it.Item().ValueCopy(r.buffer)
+func newReader(db kvEngine, ref blobstore.BlobRef) (*reader, error) {
+	r := reader{db: db, ref: ref, readAhead: defaultChunkReadAhead, chunkIndex: -1}
+	err := view(db, func(txn kvTxn) error {
+		versions := blobVersions(txn, keyPrefix(ref))
+		if len(versions) == 0 {
+			return nil
+		}
+		r.key = versions[0].key
+		r.length = int(versions[0].size)
+		item, err := txn.Get(r.key)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		r.format = storedFormat(item.UserMeta())
+		r.cold = item.UserMeta()&userMetaCold != 0
+		if item.UserMeta()&userMetaChunked != 0 {
+			p, err := readChunkPointer(item)
 			if err != nil {
-				return errors.WithStack(err)
+				return err
 			}
-			found = true
-			break
+			r.chunks = &p
 		}
-
-		r.length = len(r.buffer)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	if !found {
-		return nil, errs.New("missing blob")
+	if r.key == nil {
+		return nil, errNotExist("%s", ref.Key)
 	}
 	return &r, nil
 }
+
+// load reads the value of the blob, unless it's already in memory.
+func (r *reader) load() error {
+	if r.loaded {
+		return nil
+	}
+	buffer := getBuffer(r.length)
+	started := time.Now()
+	err := r.retry.do(context.Background(), "read", func() error {
+		buffer = buffer[:0]
+		return view(r.db, func(txn kvTxn) error {
+			item, err := txn.Get(r.key)
+			if errs.Is(err, errKeyNotFound) {
+				return errNotExist("%s", r.ref.Key)
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = blobValue(txn, item, func(val []byte) error {
+				buffer = append(buffer, val...)
+				return nil
+			})
+			return errors.WithStack(err)
+		})
+	})
+	r.diskHealth.observeRead(time.Since(started))
+	if err == nil && len(buffer) != r.length {
+		err = ErrCorrupt.New("blob %x has %d bytes instead of %d", r.key, len(buffer), r.length)
+	}
+	if err != nil {
+		putBuffer(buffer)
+		return err
+	}
+	r.buffer = buffer
+	r.loaded = true
+	// cached buffers are shared with other readers and can't be reused
+	r.pooled = r.cache == nil || !r.cache.put(r.ref, r.buffer, r.format, r.generation)
+	return nil
+}
+
+// readAt copies the data at off to p, from the loaded value or the chunks.
+func (r *reader) readAt(p []byte, off int) (int, error) {
+	if r.chunks != nil {
+		return r.readChunked(p, off)
+	}
+	if err := r.load(); err != nil {
+		return 0, err
+	}
+	return copy(p, r.buffer[off:]), nil
+}
+
+// readChunked copies the data at off to p from the chunks.
+func (r *reader) readChunked(p []byte, off int) (int, error) {
+	if len(p) > r.length-off {
+		p = p[:r.length-off]
+	}
+	if r.format == FormatV2 && r.header == nil {
+		// the header alone isn't a sequential read
+		header, err := chunkedHeader(r.format, *r.chunks, func(index int) ([]byte, error) {
+			return r.loadChunk(index, false)
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.header = header
+	}
+	err := readBlobAt(r.header, *r.chunks, p, int64(off), func(index int) ([]byte, error) {
+		return r.loadChunk(index, true)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// loadChunk returns the data of the chunk. Once the chunks are read in
+// order, the next ones are read ahead; a jump elsewhere drops them.
+func (r *reader) loadChunk(index int, readAhead bool) (data []byte, err error) {
+	if index != r.chunkIndex {
+		r.sequential = index == r.chunkIndex+1
+		fetch := r.fetches[index]
+		if r.sequential {
+			delete(r.fetches, index)
+		} else {
+			r.fetches = nil
+		}
+		if fetch != nil {
+			<-fetch.done
+			data, err = fetch.data, fetch.err
+		} else {
+			data, err = r.fetchChunk(context.Background(), index)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.chunkIndex, r.chunk = index, data
+	}
+	if readAhead && r.sequential {
+		for next := index + 1; next <= index+r.readAhead && next < r.chunks.chunks(); next++ {
+			if _, ok := r.fetches[next]; ok {
+				continue
+			}
+			if r.fetches == nil {
+				r.fetches = map[int]*chunkFetch{}
+			}
+			if r.cancel == nil {
+				r.fetchCtx, r.cancel = context.WithCancel(context.Background())
+			}
+			fetch := &chunkFetch{done: make(chan struct{})}
+			r.fetches[next] = fetch
+			r.fetching.Add(1)
+			go func(index int) {
+				defer r.fetching.Done()
+				defer close(fetch.done)
+				fetch.data, fetch.err = r.fetchChunk(r.fetchCtx, index)
+			}(next)
+		}
+	}
+	return r.chunk, nil
+}
+
+// fetchChunk reads the chunk. It's called concurrently for the chunks read
+// ahead, so it only uses the fields set when the reader is opened; the
+// canceled reads ahead return without reading.
+func (r *reader) fetchChunk(ctx context.Context, index int) (data []byte, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	err = r.retry.do(ctx, "read", func() error {
+		return view(r.db, func(txn kvTxn) error {
+			err := readChunk(txn, *r.chunks, index, func(val []byte) error {
+				data = append(data[:0], val...)
+				return nil
+			})
+			if errs.Is(err, errKeyNotFound) {
+				// the blob was removed since it was opened
+				return errNotExist("%s", r.ref.Key)
+			}
+			return errors.WithStack(err)
+		})
+	})
+	r.diskHealth.observeRead(time.Since(started))
+	return data, err
+}
+
 func (r *reader) Read(p []byte) (n int, err error) {
 	if r.offset >= r.length {
 		return 0, io.EOF
 	}
-	n = copy(p, r.buffer[r.offset:])
+	n, err = r.readAt(p, r.offset)
+	if err != nil {
+		wrapRef("read", r.ref, &err)
+		return 0, err
+	}
 	r.offset += n
+	r.io.readBytes(r.ref.Namespace, n)
 	return
 }
 
 func (r *reader) ReadAt(p []byte, off int64) (n int, err error) {
-	n = copy(p, r.buffer[off:])
-	r.offset += n
+	if off >= int64(r.length) {
+		return 0, io.EOF
+	}
+	n, err = r.readAt(p, int(off))
+	if err != nil {
+		wrapRef("read", r.ref, &err)
+		return 0, err
+	}
+	r.io.readBytes(r.ref.Namespace, n)
+	if n < len(p) {
+		err = io.EOF
+	}
 	return
 }
 
@@ -75,14 +282,23 @@ func (r *reader) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (r *reader) Close() error {
+	if r.pooled {
+		putBuffer(r.buffer)
+		r.pooled = false
+	}
 	r.buffer = nil
+	if r.cancel != nil {
+		r.cancel()
+		r.fetching.Wait()
+	}
+	r.chunk, r.fetches = nil, nil
 	return nil
 }
 
 func (r *reader) Size() (int64, error) {
-	return int64(len(r.buffer)), nil
+	return int64(r.length), nil
 }
 
 func (r *reader) StorageFormatVersion() blobstore.FormatVersion {
-	return filestore.FormatV1
+	return r.format
 }
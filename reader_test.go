@@ -0,0 +1,45 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestLazyReader(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "0123456789"))
+
+	opened, err := store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	size, err := opened.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(10), size)
+	require.False(t, opened.(*reader).loaded)
+
+	buf := make([]byte, 4)
+	n, err := opened.ReadAt(buf, 8)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "89", string(buf[:n]))
+	require.True(t, opened.(*reader).loaded)
+
+	content, err := io.ReadAll(opened)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(content))
+	require.NoError(t, opened.Close())
+
+	// blobs deleted before the first read can't be read
+	opened, err = store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(ctx, ref("ns", "key")))
+	_, err = io.ReadAll(opened)
+	require.Error(t, err)
+	require.NoError(t, opened.Close())
+}
@@ -0,0 +1,137 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// readOnlyFileName is the marker file in the store directory which puts the
+// store in read-only mode, holding the reason.
+var readOnlyFileName = "read-only"
+
+// readOnlyCheckInterval is how often the marker file is checked, so the mode
+// changed by the read-only command of another process is picked up.
+var readOnlyCheckInterval = 10 * time.Second
+
+// readOnly is the runtime read-only mode of the store.
+type readOnly struct {
+	dir string
+	// reason is nil when the store is writable
+	reason atomic.Pointer[string]
+}
+
+// check returns an ErrReadOnly error in read-only mode.
+func (r *readOnly) check() error {
+	if r == nil {
+		return nil
+	}
+	if reason := r.reason.Load(); reason != nil {
+		return ErrReadOnly.New("store is in read-only mode: %s", *reason)
+	}
+	return nil
+}
+
+// load sets the mode from the marker file, returning whether it changed.
+func (r *readOnly) load() (changed bool, err error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, readOnlyFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return r.reason.Swap(nil) != nil, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+	reason := strings.TrimSpace(string(data))
+	previous := r.reason.Swap(&reason)
+	return previous == nil || *previous != reason, nil
+}
+
+// SetReadOnly puts the store in the read-only mode of the store directory
+// dir, rejecting the creation, deletion, trashing and restoring of blobs
+// with ErrReadOnly, while still serving reads. A running store picks the
+// change up within a few seconds, and the mode is kept over restarts.
+func SetReadOnly(dir string, reason string) error {
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return errs.Wrap(os.WriteFile(filepath.Join(dir, readOnlyFileName), []byte(reason+"\n"), 0644))
+}
+
+// SetWritable ends the read-only mode of the store directory dir.
+func SetWritable(dir string) error {
+	err := os.Remove(filepath.Join(dir, readOnlyFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return errs.Wrap(err)
+}
+
+// SetReadOnly puts the store in read-only mode, see the SetReadOnly function.
+// Uploads in progress fail at their commit.
+func (b *BlobStore) SetReadOnly(reason string) error {
+	if err := SetReadOnly(b.dir, reason); err != nil {
+		return err
+	}
+	b.reloadReadOnly()
+	return nil
+}
+
+// SetWritable ends the read-only mode of the store.
+func (b *BlobStore) SetWritable() error {
+	if err := SetWritable(b.dir); err != nil {
+		return err
+	}
+	b.reloadReadOnly()
+	return nil
+}
+
+// ReadOnly returns whether the store is in read-only mode, and why.
+func (b *BlobStore) ReadOnly() (reason string, readOnly bool) {
+	if reason := b.readOnly.reason.Load(); reason != nil {
+		return *reason, true
+	}
+	return "", false
+}
+
+func (b *BlobStore) reloadReadOnly() {
+	changed, err := b.readOnly.load()
+	if err != nil {
+		b.log.Warn("reading the read-only marker failed", zap.Error(err))
+		return
+	}
+	if !changed {
+		return
+	}
+	if reason, readOnly := b.ReadOnly(); readOnly {
+		mon.Event("read_only_on")
+		b.log.Warn("store is in read-only mode", zap.String("reason", reason))
+	} else {
+		mon.Event("read_only_off")
+		b.log.Info("store is writable again")
+	}
+}
+
+// startReadOnly loads the read-only mode and watches the marker file for
+// changes.
+func (b *BlobStore) startReadOnly() {
+	b.reloadReadOnly()
+	b.runBackground("read-only marker", func(ctx context.Context) {
+		ticker := time.NewTicker(readOnlyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				b.reloadReadOnly()
+			}
+		}
+	})
+}
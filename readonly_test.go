@@ -0,0 +1,82 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "data"))
+	pending, err := store.Create(ctx, ref("ns", "key2"))
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetReadOnly("backup"))
+	reason, readOnly := store.ReadOnly()
+	require.True(t, readOnly)
+	require.Equal(t, "backup", reason)
+
+	_, err = store.Create(ctx, ref("ns", "key3"))
+	require.True(t, ErrReadOnly.Has(err))
+	require.True(t, ErrReadOnly.Has(pending.Commit(ctx)))
+	require.True(t, ErrReadOnly.Has(store.Delete(ctx, ref("ns", "key1"))))
+	require.True(t, ErrReadOnly.Has(store.Trash(ctx, ref("ns", "key1"), time.Now())))
+	_, err = store.RestoreTrash(ctx, []byte("ns"))
+	require.True(t, ErrReadOnly.Has(err))
+	_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.True(t, ErrReadOnly.Has(err))
+
+	// reads are still served
+	r, err := store.Open(ctx, ref("ns", "key1"))
+	require.NoError(t, err)
+	data, err := rall(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "data", string(data))
+
+	// the mode is kept over restarts
+	require.NoError(t, store.Close())
+	store, err = NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	_, readOnly = store.ReadOnly()
+	require.True(t, readOnly)
+
+	require.NoError(t, store.SetWritable())
+	require.NoError(t, store.Delete(ctx, ref("ns", "key1")))
+}
+
+func TestReadOnlyMarker(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(interval time.Duration) { readOnlyCheckInterval = interval }(readOnlyCheckInterval)
+	readOnlyCheckInterval = 10 * time.Millisecond
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// like the read-only command does from another process
+	require.NoError(t, SetReadOnly(ctx.Dir("store"), ""))
+	require.Eventually(t, func() bool {
+		_, readOnly := store.ReadOnly()
+		return readOnly
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = store.Create(ctx, ref("ns", "key"))
+	require.True(t, ErrReadOnly.Has(err))
+
+	require.NoError(t, SetWritable(ctx.Dir("store")))
+	require.Eventually(t, func() bool {
+		_, readOnly := store.ReadOnly()
+		return !readOnly
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+}
@@ -0,0 +1,139 @@
+package badger
+
+import (
+	"bytes"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// RecoveryReport describes the data dropped by RecoverBlobStore.
+type RecoveryReport struct {
+	// Lost contains the blobs whose value couldn't be read back.
+	Lost []blobstore.BlobRef
+	// LostTrash contains the trashed blobs whose value couldn't be read back.
+	LostTrash []blobstore.BlobRef
+	// Unknown contains the raw keys of unreadable entries which couldn't be mapped to a namespace.
+	Unknown [][]byte
+}
+
+// RecoverBlobStore opens a store after an unclean shutdown, like a power loss.
+//
+// Badger truncates the torn tail of the value log on open, but index entries
+// may still point to the lost data. Recovery reads back every blob and trash
+// value with checksum verification, deletes the entries which can't be read
+// back completely, and returns them in the report so the operator knows what
+// was lost.
+func RecoverBlobStore(dir string, config Config) (*BlobStore, RecoveryReport, error) {
+	var report RecoveryReport
+
+	db, err := openEngine(dir, config, true)
+	if err != nil {
+		return nil, report, err
+	}
+
+	namespaces, err := listNamespaces(db)
+	if err != nil {
+		return nil, report, errs.Combine(err, db.Close())
+	}
+
+	for _, prefix := range [][]byte{blobPrefix, trashPrefix} {
+		lost, chunks, err := findUnreadable(db, prefix)
+		if err != nil {
+			return nil, report, errs.Combine(err, db.Close())
+		}
+		for _, key := range lost {
+			ref, ok := splitKey(namespaces, key[len(prefix):])
+			switch {
+			case !ok:
+				report.Unknown = append(report.Unknown, key)
+			case bytes.Equal(prefix, blobPrefix):
+				report.Lost = append(report.Lost, ref)
+			default:
+				report.LostTrash = append(report.LostTrash, ref)
+			}
+		}
+		if err := deleteKeys(db, append(lost, chunks...)); err != nil {
+			return nil, report, errs.Combine(err, db.Close())
+		}
+	}
+
+	if err := resetMeta(db); err != nil {
+		return nil, report, errs.Combine(err, db.Close())
+	}
+
+	if len(report.Lost) > 0 || len(report.Unknown) > 0 {
+		if err := invalidateUsage(db); err != nil {
+			return nil, report, errs.Combine(err, db.Close())
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		return nil, report, errs.Wrap(err)
+	}
+
+	store, err := NewBlobStoreWithConfig(dir, config)
+	return store, report, err
+}
+
+// findUnreadable returns the keys of the entries which can't be read back,
+// and the keys of the chunks left of the chunked ones.
+func findUnreadable(db kvEngine, prefix []byte) (keys, chunks [][]byte, err error) {
+	err = db.View(func(txn kvTxn) error {
+		it := txn.NewIterator(iteratorOptions(IteratorCopy, prefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			// badger logs unreadable values and yields them empty, so the
+			// size recorded in the key is what tells us about the loss
+			if err := checkItem(txn, it.Item(), len(prefix)); err != nil {
+				keys = append(keys, it.Item().KeyCopy(nil))
+				if it.Item().UserMeta()&userMetaChunked != 0 {
+					if p, err := readChunkPointer(it.Item()); err == nil {
+						chunks = append(chunks, p.keys()...)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return keys, chunks, errs.Wrap(err)
+}
+
+// deleteKeys deletes the keys in transactions small enough for the engine.
+func deleteKeys(db kvEngine, keys [][]byte) error {
+	limit := int(db.MaxBatchCount())
+	if limit < 1 {
+		limit = 1
+	}
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > limit {
+			batch = batch[:limit]
+		}
+		keys = keys[len(batch):]
+		err := update(db, func(txn kvTxn) error {
+			for _, key := range batch {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetMeta rewrites the meta record if it can't be read back, like when it
+// was written after the torn tail of the value log. The usage counters are
+// recomputed on open then.
+func resetMeta(db kvEngine) error {
+	return update(db, func(txn kvTxn) error {
+		_, _, err := readMeta(txn)
+		if !ErrCorrupt.Has(err) {
+			return err
+		}
+		return writeMeta(txn, meta{LayoutVersion: layoutVersion})
+	})
+}
@@ -0,0 +1,59 @@
+package badger
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestRecoverBlobStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), string(bytes.Repeat([]byte{'a'}, 1000))))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), string(bytes.Repeat([]byte{'b'}, 1000))))
+	require.NoError(t, store.Close())
+
+	// damage the value of key2 in the value log
+	vlogs, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	require.NoError(t, err)
+	damaged := false
+	for _, vlog := range vlogs {
+		raw, err := os.ReadFile(vlog)
+		require.NoError(t, err)
+		if i := bytes.Index(raw, bytes.Repeat([]byte{'b'}, 100)); i >= 0 {
+			copy(raw[i:], bytes.Repeat([]byte{'c'}, 100))
+			require.NoError(t, os.WriteFile(vlog, raw, 0644))
+			damaged = true
+		}
+	}
+	require.True(t, damaged)
+
+	store, report, err := RecoverBlobStore(dir, Config{})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.Len(t, report.Lost, 1)
+	require.Equal(t, []byte("ns"), report.Lost[0].Namespace)
+	require.Equal(t, []byte("key2"), report.Lost[0].Key)
+	require.Empty(t, report.LostTrash)
+	require.Empty(t, report.Unknown)
+
+	_, err = store.Open(ctx, ref("ns", "key2"))
+	require.Error(t, err)
+
+	reader, err := store.Open(ctx, ref("ns", "key1"))
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), used)
+}
@@ -0,0 +1,115 @@
+package badger
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// Operations recorded in the deletion reports.
+const (
+	reportTrash      = "trash"
+	reportEmptyTrash = "empty-trash"
+	reportForget     = "forget"
+)
+
+// deletion is a removed blob version to report.
+type deletion struct {
+	ref     blobstore.BlobRef
+	modTime time.Time
+	size    int64
+}
+
+func deletionOf(ref blobstore.BlobRef, key []byte) deletion {
+	modTime, size := stat(key)
+	return deletion{ref: ref, modTime: modTime, size: int64(size)}
+}
+
+// reportEntry is a line of a deletion report.
+type reportEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Satellite string    `json:"satellite"`
+	PieceID   string    `json:"piece_id"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// reportDeletions appends the deletions to the JSON lines reports of their
+// namespaces, named after the operation and the UTC day, like
+// <dir>/<satellite>/trash-2024-05-10.jsonl. The deletions are already
+// committed, so failures are only logged.
+func (b *BlobStore) reportDeletions(operation string, deletions []deletion) {
+	if b.config.DeletionReports == "" || len(deletions) == 0 {
+		return
+	}
+	now := b.clock.Now()
+	byNamespace := map[string][]reportEntry{}
+	for _, d := range deletions {
+		satellite := formatSatellite(d.ref.Namespace)
+		byNamespace[satellite] = append(byNamespace[satellite], reportEntry{
+			Time:      now,
+			Operation: operation,
+			Satellite: satellite,
+			PieceID:   formatPiece(d.ref.Key),
+			Size:      d.size,
+			ModTime:   d.modTime,
+		})
+	}
+	name := operation + "-" + now.UTC().Format("2006-01-02") + ".jsonl"
+	for satellite, entries := range byNamespace {
+		path := filepath.Join(b.config.DeletionReports, satellite, name)
+		if err := appendReport(path, entries); err != nil {
+			mon.Counter("deletion_report_failures").Inc(1)
+			b.log.Warn("writing deletion report failed", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+func appendReport(path string, entries []reportEntry) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errs.Wrap(err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, file.Close()) }()
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// formatSatellite formats a namespace as satellite ID.
+func formatSatellite(namespace []byte) string {
+	return formatID(namespace, func(raw []byte) (string, error) {
+		id, err := storj.NodeIDFromBytes(raw)
+		return id.String(), err
+	})
+}
+
+// formatPiece formats a blob key as piece ID.
+func formatPiece(key []byte) string {
+	return formatID(key, func(raw []byte) (string, error) {
+		id, err := storj.PieceIDFromBytes(raw)
+		return id.String(), err
+	})
+}
+
+// formatID formats storj IDs the usual way, and anything else as hex.
+func formatID(raw []byte, format func([]byte) (string, error)) string {
+	if formatted, err := format(raw); err == nil {
+		return formatted
+	}
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,75 @@
+package badger
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestDeletionReports(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	reports := ctx.Dir("reports")
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{DeletionReports: reports})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	store.SetClock(fixedClock(now))
+
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	piece1, piece2, piece3 := testrand.PieceID(), testrand.PieceID(), testrand.PieceID()
+	blob := func(satellite, piece []byte) blobstore.BlobRef {
+		return blobstore.BlobRef{Namespace: satellite, Key: piece}
+	}
+	require.NoError(t, save(ctx, store, blob(satellite1.Bytes(), piece1.Bytes()), "1234"))
+	require.NoError(t, save(ctx, store, blob(satellite1.Bytes(), piece2.Bytes()), "12"))
+	require.NoError(t, save(ctx, store, blob(satellite2.Bytes(), piece3.Bytes()), "123"))
+
+	require.NoError(t, store.Trash(ctx, blob(satellite1.Bytes(), piece1.Bytes()), now))
+	_, _, err = store.EmptyTrash(ctx, satellite1.Bytes(), now)
+	require.NoError(t, err)
+	require.NoError(t, store.ForgetNamespace(ctx, satellite2.Bytes()))
+
+	readReport := func(satellite, name string) (entries []reportEntry) {
+		file, err := os.Open(filepath.Join(reports, satellite, name))
+		require.NoError(t, err)
+		defer ctx.Check(file.Close)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry reportEntry
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+			entries = append(entries, entry)
+		}
+		require.NoError(t, scanner.Err())
+		return entries
+	}
+
+	trashed := readReport(satellite1.String(), "trash-2024-05-10.jsonl")
+	require.Len(t, trashed, 1)
+	require.Equal(t, reportTrash, trashed[0].Operation)
+	require.Equal(t, satellite1.String(), trashed[0].Satellite)
+	require.Equal(t, piece1.String(), trashed[0].PieceID)
+	require.Equal(t, int64(4), trashed[0].Size)
+	require.True(t, now.Equal(trashed[0].Time))
+
+	emptied := readReport(satellite1.String(), "empty-trash-2024-05-10.jsonl")
+	require.Len(t, emptied, 1)
+	require.Equal(t, piece1.String(), emptied[0].PieceID)
+
+	forgotten := readReport(satellite2.String(), "forget-2024-05-10.jsonl")
+	require.Len(t, forgotten, 1)
+	require.Equal(t, piece3.String(), forgotten[0].PieceID)
+	require.Equal(t, int64(3), forgotten[0].Size)
+
+	// blobs which aren't pieces are reported with hex keys
+	require.Equal(t, "6b6579", formatPiece([]byte("key")))
+}
@@ -0,0 +1,72 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/spacemonkeygo/monkit/v3"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries the commits and reads failing with transient I/O
+// errors, like the brief hiccups of USB attached disks.
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first failure.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for every
+	// further one.
+	Backoff time.Duration
+	// Retriable tells which errors are transient. Nil retries the ones
+	// IsTransient accepts.
+	Retriable func(err error) bool
+}
+
+// IsTransient returns whether the error is an I/O error or a timeout, which
+// may not happen again.
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EAGAIN) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// SetRetryPolicy replaces the retry policy of the writers and readers
+// created afterwards, which Config.IORetries and Config.IORetryBackoff set.
+func (b *BlobStore) SetRetryPolicy(policy RetryPolicy) {
+	b.retry = &policy
+}
+
+// newRetryPolicy returns the retry policy of the config, nil if retries are
+// disabled.
+func newRetryPolicy(config Config) *RetryPolicy {
+	if config.IORetries <= 0 {
+		return nil
+	}
+	return &RetryPolicy{Attempts: config.IORetries, Backoff: config.IORetryBackoff}
+}
+
+// do runs fn, and runs it again while it fails with a retriable error and
+// attempts are left. The operation tags the retry metrics.
+func (p *RetryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	err := fn()
+	if p == nil {
+		return err
+	}
+	retriable := p.Retriable
+	if retriable == nil {
+		retriable = IsTransient
+	}
+	backoff := p.Backoff
+	for attempt := 0; attempt < p.Attempts && err != nil && retriable(err); attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		mon.Counter("io_retries", monkit.NewSeriesTag("operation", operation)).Inc(1)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
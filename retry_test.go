@@ -0,0 +1,85 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"storj.io/common/testcontext"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	eio := &os.PathError{Op: "read", Path: "000001.vlog", Err: syscall.EIO}
+	failing := func(failures int, err error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= failures {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+
+	policy := &RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	fn, calls := failing(2, eio)
+	require.NoError(t, policy.do(ctx, "test", fn))
+	require.Equal(t, 3, *calls)
+
+	// the attempts run out
+	fn, calls = failing(10, eio)
+	require.ErrorIs(t, policy.do(ctx, "test", fn), syscall.EIO)
+	require.Equal(t, 4, *calls)
+
+	// other errors fail at once
+	fn, calls = failing(1, os.ErrPermission)
+	require.ErrorIs(t, policy.do(ctx, "test", fn), os.ErrPermission)
+	require.Equal(t, 1, *calls)
+
+	// unless the policy says they're retriable
+	custom := &RetryPolicy{Attempts: 1, Retriable: func(err error) bool { return errors.Is(err, os.ErrPermission) }}
+	fn, calls = failing(1, os.ErrPermission)
+	require.NoError(t, custom.do(ctx, "test", fn))
+	require.Equal(t, 2, *calls)
+
+	// no policy, no retries
+	var disabled *RetryPolicy
+	fn, calls = failing(1, eio)
+	require.Error(t, disabled.do(ctx, "test", fn))
+	require.Equal(t, 1, *calls)
+
+	// cancelling stops the backoff
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	slow := &RetryPolicy{Attempts: 1, Backoff: time.Hour}
+	fn, calls = failing(1, eio)
+	require.Error(t, slow.do(canceled, "test", fn))
+	require.Equal(t, 1, *calls)
+}
+
+func TestRetryConfig(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{IORetries: 2, IORetryBackoff: time.Second})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.Equal(t, &RetryPolicy{Attempts: 2, Backoff: time.Second}, store.retry)
+
+	store.SetRetryPolicy(RetryPolicy{Attempts: 5})
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	w, err := store.Create(ctx, ref("ns", "other"))
+	require.NoError(t, err)
+	require.Equal(t, 5, w.(*writer).retry.Attempts)
+	require.NoError(t, w.Cancel(ctx))
+	r, err := store.Open(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.Equal(t, 5, r.(*reader).retry.Attempts)
+	require.NoError(t, r.Close())
+}
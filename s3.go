@@ -0,0 +1,389 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/zeebo/errs"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrS3 is returned by the requests of the S3 backup target.
+var ErrS3 = errs.Class("s3")
+
+const (
+	// s3MinPartSize is the smallest part S3 accepts, besides the last one.
+	s3MinPartSize = 5 << 20
+	// s3DefaultPartSize is the part size when none is configured.
+	s3DefaultPartSize = 16 << 20
+	s3DefaultRetries  = 3
+)
+
+// s3RetryDelay is the delay before the first retry, doubled for every next one.
+var s3RetryDelay = time.Second
+
+// S3Target stores the backups as objects of an S3-compatible bucket. The
+// backups are uploaded in parts, so they are streamed without being kept in
+// memory or on disk. Failed requests are retried.
+type S3Target struct {
+	// Endpoint is the URL of the service, like https://s3.us-east-1.amazonaws.com.
+	// The buckets are addressed in the path.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	// PartSize is the size of the uploaded parts, at least 5MiB.
+	PartSize int
+	// Retries is the number of times a failed request is retried, 3 if zero
+	// and none if negative.
+	Retries int
+	// Client is used for the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+var _ BackupTarget = &S3Target{}
+
+// Create starts a multipart upload of the backup, completed when the writer
+// is closed.
+func (t *S3Target) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	resp, err := t.do(ctx, http.MethodPost, t.Prefix+name, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := decodeS3(resp, &result); err != nil {
+		return nil, err
+	}
+	partSize := t.PartSize
+	if partSize == 0 {
+		partSize = s3DefaultPartSize
+	}
+	if partSize < s3MinPartSize {
+		partSize = s3MinPartSize
+	}
+	return &s3Upload{
+		ctx:      ctx,
+		target:   t,
+		key:      t.Prefix + name,
+		uploadID: result.UploadID,
+		buf:      make([]byte, 0, partSize),
+	}, nil
+}
+
+func (t *S3Target) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := t.do(ctx, http.MethodGet, t.Prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (t *S3Target) List(ctx context.Context) (names []string, err error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {t.Prefix}}
+	for {
+		resp, err := t.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := decodeS3(resp, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, t.Prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *S3Target) Delete(ctx context.Context, name string) error {
+	resp, err := t.do(ctx, http.MethodDelete, t.Prefix+name, nil, nil)
+	if err != nil {
+		return err
+	}
+	return errs.Wrap(resp.Body.Close())
+}
+
+// do sends a signed request for the object of the bucket, retrying server
+// errors and failed connections. The body of the returned response must be
+// closed.
+func (t *S3Target) do(ctx context.Context, method, key string, query url.Values, body []byte) (resp *http.Response, err error) {
+	retries := t.Retries
+	if retries == 0 {
+		retries = s3DefaultRetries
+	}
+	delay := s3RetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err = t.send(ctx, method, key, query, body)
+		if err == nil {
+			if resp.StatusCode/100 == 2 {
+				return resp, nil
+			}
+			err = s3Error(resp)
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return nil, err
+			}
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return nil, err
+		}
+		mon.Counter("s3_retries").Inc(1)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ErrS3.Wrap(ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+func (t *S3Target) send(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(t.Endpoint, "/") + "/" + t.Bucket + "/" + s3EscapePath(key))
+	if err != nil {
+		return nil, ErrS3.Wrap(err)
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, ErrS3.Wrap(err)
+	}
+	req.ContentLength = int64(len(body))
+	hash := sha256.Sum256(body)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(hash[:]))
+	req.Header.Set("x-amz-date", time.Now().UTC().Format("20060102T150405Z"))
+	signS3(req, t.Region, t.AccessKey, t.SecretKey)
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	return resp, ErrS3.Wrap(err)
+}
+
+// s3Upload buffers the written data into the parts of a multipart upload.
+type s3Upload struct {
+	ctx      context.Context
+	target   *S3Target
+	key      string
+	uploadID string
+	buf      []byte
+	parts    []s3Part
+	err      error
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (u *s3Upload) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if u.err != nil {
+			return n, u.err
+		}
+		chunk := cap(u.buf) - len(u.buf)
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		u.buf = append(u.buf, p[:chunk]...)
+		p, n = p[chunk:], n+chunk
+		if len(u.buf) == cap(u.buf) {
+			u.err = u.uploadPart()
+		}
+	}
+	return n, u.err
+}
+
+func (u *s3Upload) uploadPart() error {
+	number := len(u.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.uploadID}}
+	resp, err := u.target.do(u.ctx, http.MethodPut, u.key, query, u.buf)
+	if err != nil {
+		return err
+	}
+	u.parts = append(u.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	u.buf = u.buf[:0]
+	return errs.Wrap(resp.Body.Close())
+}
+
+// Close uploads the last part and completes the upload, or aborts it after
+// a failure.
+func (u *s3Upload) Close() error {
+	if u.err == nil && (len(u.buf) > 0 || len(u.parts) == 0) {
+		u.err = u.uploadPart()
+	}
+	if u.err == nil {
+		u.err = u.complete()
+	}
+	if u.err != nil {
+		return errs.Combine(u.err, u.abort())
+	}
+	mon.Counter("s3_uploads").Inc(1)
+	return nil
+}
+
+func (u *s3Upload) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: u.parts})
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	resp, err := u.target.do(u.ctx, http.MethodPost, u.key, url.Values{"uploadId": {u.uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// the upload can still fail after the response started
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := decodeS3(resp, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return ErrS3.New("completing upload of %s: %s: %s", u.key, result.Code, result.Message)
+	}
+	return nil
+}
+
+func (u *s3Upload) abort() error {
+	// the upload is aborted even when writing stopped because of the context
+	ctx := context.Background()
+	resp, err := u.target.do(ctx, http.MethodDelete, u.key, url.Values{"uploadId": {u.uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	return errs.Wrap(resp.Body.Close())
+}
+
+func decodeS3(resp *http.Response, v interface{}) error {
+	defer func() { _ = resp.Body.Close() }()
+	return ErrS3.Wrap(xml.NewDecoder(resp.Body).Decode(v))
+}
+
+func s3Error(resp *http.Response) error {
+	defer func() { _ = resp.Body.Close() }()
+	var result struct {
+		Code    string
+		Message string
+	}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	return ErrS3.New("%s %s: %s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, result.Code, result.Message)
+}
+
+// signS3 adds the AWS signature version 4 of the request, signing all of its
+// headers. The x-amz-date and x-amz-content-sha256 headers must be set.
+func signS3(req *http.Request, region, accessKey, secretKey string) {
+	amzDate := req.Header.Get("x-amz-date")
+	date := amzDate[:8]
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery encodes the query sorted by the keys, as signed.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, false)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and
+// the slashes unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,234 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zeebo/errs"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/satellites"
+	"time"
+)
+
+// satellitePrefix keys hold the satellites, by satellite ID.
+var satellitePrefix = []byte("satel")
+
+// satelliteExitPrefix keys hold the progress of the graceful exits, by
+// satellite ID.
+var satelliteExitPrefix = []byte("satex")
+
+// ErrSatellites is returned for the errors of the satellite database.
+var ErrSatellites = errs.Class("satellites")
+
+// SatelliteDB keeps the satellites of the storagenode and their graceful
+// exits in the badger store.
+type SatelliteDB struct {
+	db        kvEngine
+	iterators iteratorProfiles
+}
+
+var _ satellites.DB = &SatelliteDB{}
+
+// Satellites returns the satellite database kept in the store.
+func (b *BlobStore) Satellites() *SatelliteDB {
+	return &SatelliteDB{db: b.db, iterators: b.iterators}
+}
+
+func getSatelliteEntry(txn kvTxn, key []byte, value interface{}) (found bool, err error) {
+	item, err := txn.Get(key)
+	if errs.Is(err, errKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(err)
+	}
+	return true, item.Value(func(val []byte) error {
+		return ErrSatellites.Wrap(json.Unmarshal(val, value))
+	})
+}
+
+func setSatelliteEntry(txn kvTxn, key []byte, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return ErrSatellites.Wrap(err)
+	}
+	return txn.Set(key, raw)
+}
+
+// modifySatellite calls fn with the satellite, a new one added now if it's
+// missing, and stores it.
+func modifySatellite(txn kvTxn, satelliteID storj.NodeID, fn func(satellite *satellites.Satellite)) error {
+	key := concat(satellitePrefix, satelliteID.Bytes())
+	satellite := satellites.Satellite{SatelliteID: satelliteID, AddedAt: time.Now().UTC(), Status: satellites.Normal}
+	if _, err := getSatelliteEntry(txn, key, &satellite); err != nil {
+		return err
+	}
+	fn(&satellite)
+	return setSatelliteEntry(txn, key, satellite)
+}
+
+// SetAddress adds the satellite, or updates its address.
+func (s *SatelliteDB) SetAddress(ctx context.Context, satelliteID storj.NodeID, address string) error {
+	return update(s.db, func(txn kvTxn) error {
+		return modifySatellite(txn, satelliteID, func(satellite *satellites.Satellite) {
+			satellite.Address = address
+		})
+	})
+}
+
+// SetAddressAndStatus adds the satellite, or updates its address and status.
+func (s *SatelliteDB) SetAddressAndStatus(ctx context.Context, satelliteID storj.NodeID, address string, status satellites.Status) error {
+	return update(s.db, func(txn kvTxn) error {
+		return modifySatellite(txn, satelliteID, func(satellite *satellites.Satellite) {
+			satellite.Address, satellite.Status = address, status
+		})
+	})
+}
+
+// GetSatellite returns the satellite, or the zero value if it's unknown.
+func (s *SatelliteDB) GetSatellite(ctx context.Context, satelliteID storj.NodeID) (satellite satellites.Satellite, err error) {
+	err = view(s.db, func(txn kvTxn) error {
+		_, err := getSatelliteEntry(txn, concat(satellitePrefix, satelliteID.Bytes()), &satellite)
+		return err
+	})
+	return satellite, err
+}
+
+// GetSatellites returns all satellites, including the untrusted ones.
+func (s *SatelliteDB) GetSatellites(ctx context.Context) (sats []satellites.Satellite, err error) {
+	err = view(s.db, func(txn kvTxn) error {
+		it := txn.NewIterator(s.iterators.options(IteratorRecords, satellitePrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var satellite satellites.Satellite
+			err := it.Item().Value(func(val []byte) error {
+				return ErrSatellites.Wrap(json.Unmarshal(val, &satellite))
+			})
+			if err != nil {
+				return err
+			}
+			sats = append(sats, satellite)
+		}
+		return nil
+	})
+	return sats, err
+}
+
+// GetSatellitesUrls returns the IDs and the addresses of the satellites.
+func (s *SatelliteDB) GetSatellitesUrls(ctx context.Context) (satelliteURLs []storj.NodeURL, err error) {
+	sats, err := s.GetSatellites(ctx)
+	for _, satellite := range sats {
+		satelliteURLs = append(satelliteURLs, storj.NodeURL{ID: satellite.SatelliteID, Address: satellite.Address})
+	}
+	return satelliteURLs, err
+}
+
+// DeleteSatellite removes the satellite.
+func (s *SatelliteDB) DeleteSatellite(ctx context.Context, satelliteID storj.NodeID) error {
+	return update(s.db, func(txn kvTxn) error {
+		return txn.Delete(concat(satellitePrefix, satelliteID.Bytes()))
+	})
+}
+
+// UpdateSatelliteStatus sets the status of the satellite, adding it if it's
+// unknown.
+func (s *SatelliteDB) UpdateSatelliteStatus(ctx context.Context, satelliteID storj.NodeID, status satellites.Status) error {
+	return update(s.db, func(txn kvTxn) error {
+		return modifySatellite(txn, satelliteID, func(satellite *satellites.Satellite) {
+			satellite.Status = status
+		})
+	})
+}
+
+// InitiateGracefulExit starts the graceful exit from the satellite.
+func (s *SatelliteDB) InitiateGracefulExit(ctx context.Context, satelliteID storj.NodeID, intitiatedAt time.Time, startingDiskUsage int64) error {
+	return update(s.db, func(txn kvTxn) error {
+		err := modifySatellite(txn, satelliteID, func(satellite *satellites.Satellite) {
+			satellite.Status = satellites.Exiting
+		})
+		if err != nil {
+			return err
+		}
+		initiatedAt := intitiatedAt.UTC()
+		return setSatelliteEntry(txn, concat(satelliteExitPrefix, satelliteID.Bytes()), satellites.ExitProgress{
+			SatelliteID:       satelliteID,
+			InitiatedAt:       &initiatedAt,
+			StartingDiskUsage: startingDiskUsage,
+		})
+	})
+}
+
+// CancelGracefulExit removes the graceful exit from the satellite.
+func (s *SatelliteDB) CancelGracefulExit(ctx context.Context, satelliteID storj.NodeID) error {
+	return update(s.db, func(txn kvTxn) error {
+		return txn.Delete(concat(satelliteExitPrefix, satelliteID.Bytes()))
+	})
+}
+
+// modifyExit calls fn with the graceful exit from the satellite, if there is
+// one, and stores it.
+func modifyExit(txn kvTxn, satelliteID storj.NodeID, fn func(exit *satellites.ExitProgress)) error {
+	key := concat(satelliteExitPrefix, satelliteID.Bytes())
+	var exit satellites.ExitProgress
+	found, err := getSatelliteEntry(txn, key, &exit)
+	if err != nil || !found {
+		return err
+	}
+	fn(&exit)
+	return setSatelliteEntry(txn, key, exit)
+}
+
+// UpdateGracefulExit adds to the bytes deleted by the graceful exit.
+func (s *SatelliteDB) UpdateGracefulExit(ctx context.Context, satelliteID storj.NodeID, bytesDeleted int64) error {
+	return update(s.db, func(txn kvTxn) error {
+		return modifyExit(txn, satelliteID, func(exit *satellites.ExitProgress) {
+			exit.BytesDeleted += bytesDeleted
+		})
+	})
+}
+
+// CompleteGracefulExit records the end of the graceful exit, with the status
+// of the satellite.
+func (s *SatelliteDB) CompleteGracefulExit(ctx context.Context, satelliteID storj.NodeID, finishedAt time.Time, exitStatus satellites.Status, completionReceipt []byte) error {
+	return update(s.db, func(txn kvTxn) error {
+		err := modifySatellite(txn, satelliteID, func(satellite *satellites.Satellite) {
+			satellite.Status = exitStatus
+		})
+		if err != nil {
+			return err
+		}
+		finished := finishedAt.UTC()
+		return modifyExit(txn, satelliteID, func(exit *satellites.ExitProgress) {
+			exit.FinishedAt, exit.CompletionReceipt = &finished, completionReceipt
+		})
+	})
+}
+
+// ListGracefulExits returns the graceful exits, with the status of their
+// satellites.
+func (s *SatelliteDB) ListGracefulExits(ctx context.Context) (exits []satellites.ExitProgress, err error) {
+	err = view(s.db, func(txn kvTxn) error {
+		it := txn.NewIterator(s.iterators.options(IteratorRecords, satelliteExitPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var exit satellites.ExitProgress
+			err := it.Item().Value(func(val []byte) error {
+				return ErrSatellites.Wrap(json.Unmarshal(val, &exit))
+			})
+			if err != nil {
+				return err
+			}
+			var satellite satellites.Satellite
+			found, err := getSatelliteEntry(txn, concat(satellitePrefix, exit.SatelliteID.Bytes()), &satellite)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			exit.Status = satellite.Status
+			exits = append(exits, exit)
+		}
+		return nil
+	})
+	return exits, err
+}
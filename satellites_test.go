@@ -0,0 +1,77 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/satellites"
+	"testing"
+	"time"
+)
+
+func TestSatelliteDB(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	db := store.Satellites()
+
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	require.NoError(t, db.SetAddress(ctx, satellite1, "one:7777"))
+	require.NoError(t, db.SetAddressAndStatus(ctx, satellite2, "two:7777", satellites.Untrusted))
+	require.NoError(t, db.SetAddress(ctx, satellite1, "uno:7777"))
+
+	satellite, err := db.GetSatellite(ctx, satellite1)
+	require.NoError(t, err)
+	require.Equal(t, "uno:7777", satellite.Address)
+	require.Equal(t, satellites.Normal, satellite.Status)
+	require.False(t, satellite.AddedAt.IsZero())
+
+	satellite, err = db.GetSatellite(ctx, testrand.NodeID())
+	require.NoError(t, err)
+	require.True(t, satellite.SatelliteID.IsZero())
+
+	sats, err := db.GetSatellites(ctx)
+	require.NoError(t, err)
+	require.Len(t, sats, 2)
+	urls, err := db.GetSatellitesUrls(ctx)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+
+	initiatedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, db.InitiateGracefulExit(ctx, satellite1, initiatedAt, 1000))
+	require.NoError(t, db.UpdateGracefulExit(ctx, satellite1, 100))
+	require.NoError(t, db.UpdateGracefulExit(ctx, satellite1, 50))
+	// updating the exit of a satellite which isn't exiting does nothing
+	require.NoError(t, db.UpdateGracefulExit(ctx, satellite2, 50))
+
+	exits, err := db.ListGracefulExits(ctx)
+	require.NoError(t, err)
+	require.Len(t, exits, 1)
+	require.Equal(t, satellite1, exits[0].SatelliteID)
+	require.Equal(t, satellites.Exiting, exits[0].Status)
+	require.True(t, initiatedAt.Equal(*exits[0].InitiatedAt))
+	require.EqualValues(t, 1000, exits[0].StartingDiskUsage)
+	require.EqualValues(t, 150, exits[0].BytesDeleted)
+	require.Nil(t, exits[0].FinishedAt)
+
+	finishedAt := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, db.CompleteGracefulExit(ctx, satellite1, finishedAt, satellites.ExitSucceeded, []byte("receipt")))
+	exits, err = db.ListGracefulExits(ctx)
+	require.NoError(t, err)
+	require.Equal(t, satellites.ExitSucceeded, exits[0].Status)
+	require.True(t, finishedAt.Equal(*exits[0].FinishedAt))
+	require.Equal(t, []byte("receipt"), exits[0].CompletionReceipt)
+
+	require.NoError(t, db.CancelGracefulExit(ctx, satellite1))
+	exits, err = db.ListGracefulExits(ctx)
+	require.NoError(t, err)
+	require.Empty(t, exits)
+
+	require.NoError(t, db.DeleteSatellite(ctx, satellite2))
+	sats, err = db.GetSatellites(ctx)
+	require.NoError(t, err)
+	require.Len(t, sats, 1)
+}
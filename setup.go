@@ -0,0 +1,109 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"os"
+	"storj.io/common/memory"
+	"storj.io/common/storj"
+	"time"
+)
+
+// ErrSetup is returned when a store can't be set up for a new node.
+var ErrSetup = errs.Class("setup")
+
+// The profiles of ConfigProfile.
+const (
+	// ProfileHDD is for nodes storing on spinning disks.
+	ProfileHDD = "hdd"
+	// ProfileSSD is for nodes storing on SSDs.
+	ProfileSSD = "ssd"
+)
+
+// ConfigProfile returns the configuration suggested for the disks of a new
+// node: the defaults of the Config flags, tuned for storagenode pieces.
+func ConfigProfile(profile string) (Config, error) {
+	config := Config{
+		QuickCheck:      true,
+		WriteBuffer:     4 * memory.MiB,
+		BreakerCooldown: 30 * time.Second,
+		StorageFormat:   1,
+		TieringInterval: time.Hour,
+		IORetryBackoff:  100 * time.Millisecond,
+		ConcurrentJobs:  1,
+		// the pieces are encrypted, compressing the tables only costs CPU
+		TableCompression: "none",
+	}
+	switch profile {
+	case ProfileHDD:
+		// fewer compactions compete less with the reads for the disk heads
+		config.Compactors = 2
+	case ProfileSSD:
+	default:
+		return Config{}, ErrConfig.New("unknown profile %q, use %s or %s", profile, ProfileHDD, ProfileSSD)
+	}
+	return config, nil
+}
+
+// SetupBlobStore creates the store of a new node in dir, which has to be
+// missing or empty: it creates the directory and the store, binds the store
+// to the node ID, writes the verification file and runs a health check. It
+// returns the open store and the result of the health check; if any step
+// fails, dir is removed.
+func SetupBlobStore(ctx context.Context, log *zap.Logger, dir string, id storj.NodeID, config Config) (_ *BlobStore, health Health, err error) {
+	if id.IsZero() {
+		return nil, health, ErrSetup.New("no node ID")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, health, ErrSetup.Wrap(err)
+	}
+	if len(entries) > 0 {
+		return nil, health, ErrSetup.New("%s isn't empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, health, ErrSetup.Wrap(err)
+	}
+	var store *BlobStore
+	defer func() {
+		if err == nil {
+			return
+		}
+		if store != nil {
+			err = errs.Combine(err, store.Close())
+		}
+		err = errs.Combine(err, os.RemoveAll(dir))
+	}()
+
+	store, err = NewBlobStoreWithLogger(log, dir, config)
+	if err != nil {
+		return nil, health, err
+	}
+	err = update(store.db, func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		if err != nil {
+			return err
+		}
+		m.NodeID = id.String()
+		return writeMeta(txn, m)
+	})
+	if err != nil {
+		return nil, health, err
+	}
+	if err := store.CreateVerificationFile(ctx, id); err != nil {
+		return nil, health, ErrSetup.Wrap(err)
+	}
+	if err := store.VerifyStorageDir(ctx, id); err != nil {
+		return nil, health, ErrSetup.Wrap(err)
+	}
+	health, err = store.HealthCheck(ctx)
+	if err != nil {
+		return nil, health, err
+	}
+	if !health.Healthy() {
+		return nil, health, ErrSetup.New("store isn't healthy: %+v", health)
+	}
+	log.Info("store set up", zap.String("dir", dir), zap.Stringer("node", id))
+	return store, health, nil
+}
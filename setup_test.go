@@ -0,0 +1,63 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+)
+
+func TestSetupBlobStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	config, err := ConfigProfile(ProfileHDD)
+	require.NoError(t, err)
+	require.Equal(t, 2, config.Compactors)
+	_, err = ConfigProfile("tape")
+	require.True(t, ErrConfig.Has(err))
+
+	dir := filepath.Join(ctx.Dir("node"), "storage")
+	id := testrand.NodeID()
+	_, _, err = SetupBlobStore(ctx, zap.NewNop(), dir, storj.NodeID{}, config)
+	require.True(t, ErrSetup.Has(err))
+
+	store, health, err := SetupBlobStore(ctx, zap.NewNop(), dir, id, config)
+	require.NoError(t, err)
+	require.True(t, health.Healthy())
+	require.NoError(t, store.VerifyStorageDir(ctx, id))
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+
+	// the store stays bound to the node, even with another verification file
+	other := testrand.NodeID()
+	require.NoError(t, store.CreateVerificationFile(ctx, other))
+	require.Error(t, store.VerifyStorageDir(ctx, other))
+	require.NoError(t, store.CreateVerificationFile(ctx, id))
+	require.NoError(t, store.Close())
+
+	// a used directory isn't set up again
+	_, _, err = SetupBlobStore(ctx, zap.NewNop(), dir, id, config)
+	require.True(t, ErrSetup.Has(err))
+	store, err = NewBlobStoreWithConfig(dir, config)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.NoError(t, store.VerifyStorageDir(ctx, id))
+	_, err = store.Stat(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+}
+
+func TestSetupBlobStoreFailure(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// the store can't be opened with an invalid config, and nothing is left
+	dir := filepath.Join(ctx.Dir("node"), "storage")
+	_, _, err := SetupBlobStore(ctx, zap.NewNop(), dir, testrand.NodeID(), Config{TableCompression: "lz4"})
+	require.Error(t, err)
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
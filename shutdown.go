@@ -0,0 +1,99 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+)
+
+// dirtyPrefix keys mark the namespaces whose usage counters changed since
+// the store was opened. They're removed on a clean shutdown, so after a crash
+// only the counters of these namespaces are reconciled.
+var dirtyPrefix = []byte("dirty")
+
+// setDirtyMarker marks the namespace as changed in the commits updating its
+// counters. The marker is written only with the first change.
+func setDirtyMarker(txn kvTxn, namespace []byte) error {
+	_, err := txn.Get(concat(dirtyPrefix, namespace))
+	if errs.Is(err, errKeyNotFound) {
+		return txn.Set(concat(dirtyPrefix, namespace), nil)
+	}
+	return errs.Wrap(err)
+}
+
+// openSession sets the dirty flag of the meta record. If it's already set,
+// the store wasn't closed cleanly, and the namespaces changed before are
+// returned.
+func openSession(db kvEngine) (dirty [][]byte, err error) {
+	err = update(db, func(txn kvTxn) error {
+		dirty = nil
+		m, _, err := readMeta(txn)
+		if err != nil {
+			return err
+		}
+		if m.Dirty {
+			it := txn.NewIterator(iteratorOptions(IteratorRecords, dirtyPrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				dirty = append(dirty, it.Item().KeyCopy(nil)[len(dirtyPrefix):])
+			}
+			return nil
+		}
+		m.Dirty = true
+		return writeMeta(txn, m)
+	})
+	return dirty, err
+}
+
+// closeSession removes the dirty markers and flag on a clean shutdown. They
+// are kept while the counters are approximate, so the next open reconciles
+// them again. Failures are only logged: the next open reconciles the
+// namespaces changed, which is slower but safe.
+func (b *BlobStore) closeSession() {
+	if b.approximateUsage.Load() {
+		return
+	}
+	err := update(b.db, func(txn kvTxn) error {
+		it := txn.NewIterator(iteratorOptions(IteratorRecords, dirtyPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		m, _, err := readMeta(txn)
+		if err != nil {
+			return err
+		}
+		m.Dirty = false
+		return writeMeta(txn, m)
+	})
+	if err != nil {
+		b.log.Warn("clearing the dirty flag failed", zap.Error(err))
+	}
+}
+
+// startDirtyReconciliation recomputes the usage counters of the namespaces
+// changed before an unclean shutdown in the background. The counters are
+// approximate until it finishes.
+func (b *BlobStore) startDirtyReconciliation(dirty [][]byte) {
+	if len(dirty) == 0 {
+		return
+	}
+	b.approximateUsage.Store(true)
+	b.log.Warn("unclean shutdown detected, reconciling usage counters", zap.Int("namespaces", len(dirty)))
+	b.runBackground("dirty usage reconciliation", func(ctx context.Context) {
+		if err := b.waitUsage(ctx); err != nil {
+			return
+		}
+		drift, err := recomputeUsage(ctx, b.db, dirty)
+		if err != nil {
+			if ctx.Err() == nil {
+				b.log.Error("reconciling usage counters failed", zap.Error(err))
+			}
+			return
+		}
+		b.logDrift(drift)
+		b.approximateUsage.Store(false)
+	})
+}
@@ -0,0 +1,74 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestUncleanShutdownReconciliation(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("other", "key"), "1"))
+	require.NoError(t, store.Close())
+
+	// crash after changing a namespace
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "12345"))
+	store.jobs.stop()
+	require.NoError(t, store.db.Close())
+
+	// counters missing the last changes
+	db, err := openEngine(dir, Config{}, false)
+	require.NoError(t, err)
+	err = db.Update(func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		require.NoError(t, err)
+		require.True(t, m.Dirty)
+		if err := addCounter(txn, usageKey([]byte("ns"), usageShard([]byte("key"))), usage{Bytes: 100}); err != nil {
+			return err
+		}
+		return addCounter(txn, usageKey([]byte("other"), usageShard([]byte("key"))), usage{Bytes: 7})
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return !store.Stats().ApproximateUsage
+	}, 10*time.Second, 10*time.Millisecond)
+	_, exact, err := store.EstimateSpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.True(t, exact)
+
+	used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.EqualValues(t, 5, used)
+	// only the namespaces changed before the crash are reconciled
+	used, err = store.SpaceUsedForBlobsInNamespace(ctx, []byte("other"))
+	require.NoError(t, err)
+	require.EqualValues(t, 8, used)
+	require.NoError(t, store.Close())
+
+	db, err = openEngine(dir, Config{}, false)
+	require.NoError(t, err)
+	err = db.View(func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		require.NoError(t, err)
+		require.False(t, m.Dirty)
+		it := txn.NewIterator(iteratorOptions(IteratorRecords, dirtyPrefix))
+		defer it.Close()
+		it.Rewind()
+		require.False(t, it.Valid())
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
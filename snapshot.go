@@ -0,0 +1,285 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/zeebo/errs"
+	"io"
+	"sort"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"time"
+)
+
+// ErrSnapshot is returned for the snapshots which can't be taken or aren't
+// retained.
+var ErrSnapshot = errs.Class("snapshot")
+
+// snapshotBatchSize is the number of entries written in one list of a
+// snapshot backup.
+var snapshotBatchSize = 1000
+
+// Snapshot is a read-only view of the store at a version, retained while
+// writes continue until it's released.
+//
+// Badger keeps the versions of the keys needed by its oldest open
+// transaction, so a snapshot is a transaction held open: the older versions
+// of the keys changed since are kept in the LSM tree and the value log,
+// which grow until the snapshot is released.
+type Snapshot struct {
+	store *BlobStore
+	// Version is the badger version the snapshot reads at.
+	Version uint64
+	// Created is the time the snapshot was taken.
+	Created time.Time
+
+	// refs counts the users of the snapshot, see snapshots
+	refs int
+
+	// mu serializes the reads, as badger transactions aren't safe for
+	// concurrent use
+	mu       sync.Mutex
+	txn      kvTxn
+	released bool
+}
+
+// snapshots are the snapshots retained, by version.
+type snapshots struct {
+	mu        sync.Mutex
+	byVersion map[uint64]*Snapshot
+}
+
+// Snapshot takes a snapshot of the current version of the store. It must be
+// released after use.
+func (b *BlobStore) Snapshot() (*Snapshot, error) {
+	txn := b.db.NewTransaction(false)
+	b.snapshots.mu.Lock()
+	defer b.snapshots.mu.Unlock()
+	if s, ok := b.snapshots.byVersion[txn.ReadTs()]; ok {
+		txn.Discard()
+		s.refs++
+		return s, nil
+	}
+	s := &Snapshot{store: b, Version: txn.ReadTs(), Created: b.clock.Now(), txn: txn, refs: 1}
+	if b.snapshots.byVersion == nil {
+		b.snapshots.byVersion = map[uint64]*Snapshot{}
+	}
+	b.snapshots.byVersion[s.Version] = s
+	mon.Counter("snapshots_taken").Inc(1)
+	return s, nil
+}
+
+// SnapshotAt returns the snapshot retained at the version, for read-only
+// access to the store as it was, like during an investigation. It must be
+// released after use too. Only the versions of the snapshots still retained
+// can be read.
+func (b *BlobStore) SnapshotAt(version uint64) (*Snapshot, error) {
+	b.snapshots.mu.Lock()
+	defer b.snapshots.mu.Unlock()
+	s, ok := b.snapshots.byVersion[version]
+	if !ok {
+		return nil, ErrSnapshot.New("version %d isn't retained", version)
+	}
+	s.refs++
+	return s, nil
+}
+
+// SnapshotVersions returns the versions of the snapshots retained, in
+// ascending order.
+func (b *BlobStore) SnapshotVersions() []uint64 {
+	b.snapshots.mu.Lock()
+	defer b.snapshots.mu.Unlock()
+	versions := make([]uint64, 0, len(b.snapshots.byVersion))
+	for version := range b.snapshots.byVersion {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// Release releases the snapshot. Badger can drop the versions it retained
+// once all the users of the version released it.
+func (s *Snapshot) Release() {
+	b := s.store
+	b.snapshots.mu.Lock()
+	defer b.snapshots.mu.Unlock()
+	if s.refs <= 0 {
+		return
+	}
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	delete(b.snapshots.byVersion, s.Version)
+	s.discard()
+}
+
+func (s *Snapshot) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = true
+	s.txn.Discard()
+}
+
+// releaseSnapshots releases all the snapshots when the store is closed.
+func (b *BlobStore) releaseSnapshots() {
+	b.snapshots.mu.Lock()
+	defer b.snapshots.mu.Unlock()
+	for version, s := range b.snapshots.byVersion {
+		s.refs = 0
+		s.discard()
+		delete(b.snapshots.byVersion, version)
+	}
+}
+
+// view calls fn with the transaction of the snapshot, unless it's released.
+func (s *Snapshot) view(fn func(txn kvTxn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return ErrSnapshot.New("snapshot at %d is released", s.Version)
+	}
+	return fn(s.txn)
+}
+
+// Stat returns the info of the blob at the version of the snapshot.
+func (s *Snapshot) Stat(ctx context.Context, ref blobstore.BlobRef) (_ blobstore.BlobInfo, err error) {
+	defer wrapRef("stat", ref, &err)
+	var info blobstore.BlobInfo
+	err = s.view(func(txn kvTxn) error {
+		versions := blobVersions(txn, keyPrefix(ref))
+		if len(versions) == 0 {
+			return errNotExist("%s", ref.Key)
+		}
+		item, err := txn.Get(versions[0].key)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		info = newBlobInfo(s.store.dir, ref, versions[0].key, item.UserMeta())
+		return nil
+	})
+	return info, err
+}
+
+// Open opens the blob as it was at the version of the snapshot.
+func (s *Snapshot) Open(ctx context.Context, ref blobstore.BlobRef) (_ blobstore.BlobReader, err error) {
+	defer wrapRef("open", ref, &err)
+	var data []byte
+	var format blobstore.FormatVersion
+	err = s.view(func(txn kvTxn) error {
+		versions := blobVersions(txn, keyPrefix(ref))
+		if len(versions) == 0 {
+			return errNotExist("%s", ref.Key)
+		}
+		item, err := txn.Get(versions[0].key)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		format = storedFormat(item.UserMeta())
+		return blobValue(txn, item, func(val []byte) error {
+			data = append([]byte{}, val...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.store.io.read(ref.Namespace)
+	return &reader{buffer: data, length: len(data), loaded: true, format: format, ref: ref, io: s.store.io}, nil
+}
+
+// WalkNamespace walks the blobs of the namespace at the version of the
+// snapshot, in the order of BlobStore.WalkNamespace.
+func (s *Snapshot) WalkNamespace(ctx context.Context, namespace []byte, walkFunc func(blobstore.BlobInfo) error) error {
+	prefix := concat(blobPrefix, namespace)
+	return s.view(func(txn kvTxn) error {
+		it := txn.NewIterator(s.store.iteratorOptions(IteratorWalk, prefix))
+		defer it.Close()
+		var last []byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			key := it.Item().KeyCopy(nil)
+			if last != nil && sameBlob(key[len(prefix):], last) {
+				continue
+			}
+			ref := blobstore.BlobRef{
+				Namespace: namespace,
+				Key:       key[len(prefix) : len(key)-16],
+			}
+			if err := walkFunc(newBlobInfo(s.store.dir, ref, key, it.Item().UserMeta())); err != nil {
+				return err
+			}
+			last = key[len(prefix):]
+		}
+		return nil
+	})
+}
+
+// Backup writes a full backup of the store at the version of the snapshot
+// to the target, in the format of BlobStore.Backup, so it's restored the
+// same way, and incremental backups continue from it. Restoring it to a new
+// store clones the store as it was at the version.
+func (s *Snapshot) Backup(ctx context.Context, target BackupTarget) (BackupInfo, error) {
+	var m meta
+	err := s.view(func(txn kvTxn) (err error) {
+		m, _, err = readMeta(txn)
+		return err
+	})
+	if err != nil {
+		return BackupInfo{}, ErrBackup.Wrap(err)
+	}
+	return s.store.writeBackup(ctx, target, 0, m, func(w io.Writer) (uint64, error) {
+		return s.Version, s.view(func(txn kvTxn) error {
+			return s.store.dumpSnapshot(ctx, txn, w)
+		})
+	})
+}
+
+// dumpSnapshot writes the entries visible in the transaction like
+// badger.DB.Backup does: as length prefixed lists of entries.
+func (b *BlobStore) dumpSnapshot(ctx context.Context, txn kvTxn, w io.Writer) error {
+	list := &pb.KVList{}
+	flush := func() error {
+		if len(list.Kv) == 0 {
+			return nil
+		}
+		raw, err := list.Marshal()
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(raw))); err != nil {
+			return err
+		}
+		_, err = w.Write(raw)
+		list.Kv = list.Kv[:0]
+		return err
+	}
+	it := txn.NewIterator(b.iteratorOptions(IteratorCopy, nil))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		list.Kv = append(list.Kv, &pb.KV{
+			Key:       item.KeyCopy(nil),
+			Value:     value,
+			UserMeta:  []byte{item.UserMeta()},
+			Version:   item.Version(),
+			ExpiresAt: item.ExpiresAt(),
+		})
+		if len(list.Kv) >= snapshotBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
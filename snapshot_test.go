@@ -0,0 +1,74 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		require.NoError(t, save(ctx, store, ref("ns", "key1"), "first"))
+		require.NoError(t, save(ctx, store, ref("ns", "key2"), "second"))
+		snapshot, err := store.Snapshot()
+		require.NoError(t, err)
+		require.Equal(t, []uint64{snapshot.Version}, store.SnapshotVersions())
+
+		// writes continue after the snapshot
+		require.NoError(t, store.Delete(ctx, ref("ns", "key1")))
+		require.NoError(t, save(ctx, store, ref("ns", "key3"), "third"))
+
+		at, err := store.SnapshotAt(snapshot.Version)
+		require.NoError(t, err)
+		require.Same(t, snapshot, at)
+		at.Release()
+
+		reader, err := snapshot.Open(ctx, ref("ns", "key1"))
+		require.NoError(t, err)
+		data, err := rall(reader)
+		require.NoError(t, err)
+		require.Equal(t, "first", string(data))
+		require.NoError(t, reader.Close())
+		_, err = snapshot.Stat(ctx, ref("ns", "key3"))
+		require.Error(t, err)
+
+		var keys []string
+		require.NoError(t, snapshot.WalkNamespace(ctx, []byte("ns"), func(info blobstore.BlobInfo) error {
+			keys = append(keys, string(info.BlobRef().Key))
+			return nil
+		}))
+		require.Equal(t, []string{"key1", "key2"}, keys)
+
+		// a backup of the snapshot clones the store at its version
+		target := DirTarget{Dir: ctx.Dir("backups")}
+		info, err := snapshot.Backup(ctx, target)
+		require.NoError(t, err)
+		require.Equal(t, snapshot.Version, info.Version)
+		clone, err := NewBlobStoreWithConfig(ctx.Dir("clone"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(clone.Close)
+		require.NoError(t, clone.Restore(ctx, target, []string{info.Name}))
+		_, err = clone.Stat(ctx, ref("ns", "key1"))
+		require.NoError(t, err)
+		_, err = clone.Stat(ctx, ref("ns", "key3"))
+		require.Error(t, err)
+		used, err := clone.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+		require.NoError(t, err)
+		require.EqualValues(t, len("first")+len("second"), used)
+
+		snapshot.Release()
+		require.Empty(t, store.SnapshotVersions())
+		_, err = store.SnapshotAt(snapshot.Version)
+		require.True(t, ErrSnapshot.Has(err))
+		_, err = snapshot.Stat(ctx, ref("ns", "key2"))
+		require.True(t, ErrSnapshot.Has(err))
+	})
+}
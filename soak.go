@@ -0,0 +1,510 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"io"
+	"math/rand"
+	"storj.io/common/bloomfilter"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// ErrSoak is returned when a soak run finds the store inconsistent with the
+// pieces written to it, or an operation of the run fails.
+var ErrSoak = errs.Class("soak")
+
+// SoakConfig is the workload of a soak run.
+type SoakConfig struct {
+	// Duration and Operations limit the run, zero runs until the context is
+	// cancelled.
+	Duration   time.Duration
+	Operations int64
+	// Satellites is the number of namespaces, Pieces the number of live and
+	// trashed pieces kept in them, and MaxPieceSize the largest piece.
+	Satellites   int
+	Pieces       int
+	MaxPieceSize int
+	// CheckEvery, GCEvery, CompactEvery and RestartEvery are the number of
+	// operations between the invariant checks, the garbage collections, the
+	// value log GC and compaction runs and the restarts of the store.
+	CheckEvery   int64
+	GCEvery      int64
+	CompactEvery int64
+	RestartEvery int64
+	// Seed makes the operations the same in every run.
+	Seed int64
+	// Store is the configuration the store is opened with.
+	Store Config
+}
+
+func (c SoakConfig) withDefaults() SoakConfig {
+	defaults := SoakConfig{
+		Satellites:   2,
+		Pieces:       1000,
+		MaxPieceSize: 64 << 10,
+		CheckEvery:   1000,
+		GCEvery:      5000,
+		CompactEvery: 10000,
+		RestartEvery: 20000,
+	}
+	for _, field := range []struct {
+		value    *int64
+		fallback int64
+	}{
+		{&c.CheckEvery, defaults.CheckEvery},
+		{&c.GCEvery, defaults.GCEvery},
+		{&c.CompactEvery, defaults.CompactEvery},
+		{&c.RestartEvery, defaults.RestartEvery},
+	} {
+		if *field.value <= 0 {
+			*field.value = field.fallback
+		}
+	}
+	if c.Satellites <= 0 {
+		c.Satellites = defaults.Satellites
+	}
+	if c.Pieces <= 0 {
+		c.Pieces = defaults.Pieces
+	}
+	if c.MaxPieceSize <= 0 {
+		c.MaxPieceSize = defaults.MaxPieceSize
+	}
+	return c
+}
+
+// SoakReport counts what a soak run did.
+type SoakReport struct {
+	Operations   int64
+	Writes       int64
+	Reads        int64
+	Trashes      int64
+	Restores     int64
+	Deletes      int64
+	TrashEmptied int64
+	GCRuns       int64
+	GCTrashed    int64
+	Compactions  int64
+	Restarts     int64
+	Checks       int64
+	// Live and Trashed are the pieces in the store at the end.
+	Live    int
+	Trashed int
+}
+
+// soakPiece is a piece written by a soak run. Its data is generated from
+// the seed, so it isn't kept in memory.
+type soakPiece struct {
+	id      storj.PieceID
+	ref     blobstore.BlobRef
+	seed    int64
+	size    int
+	trashed bool
+}
+
+func (p *soakPiece) data() []byte {
+	data := make([]byte, p.size)
+	_, _ = rand.New(rand.NewSource(p.seed)).Read(data)
+	return data
+}
+
+// soakRun is the state of a soak run: the store and the pieces it should
+// have.
+type soakRun struct {
+	log    *zap.Logger
+	dir    string
+	config SoakConfig
+	random *rand.Rand
+	store  *BlobStore
+	report SoakReport
+
+	satellites []storj.NodeID
+	pieces     []*soakPiece
+	index      map[storj.PieceID]int
+}
+
+// RunSoak runs a long mixed workload on the store in dir: it writes, reads,
+// trashes, restores and deletes pieces, and periodically runs garbage
+// collection with bloom filters, value log GC and compaction, and restarts
+// the store. After every CheckEvery operations, every restart and at the end
+// it checks that the walks find exactly the pieces written and not deleted,
+// and that the usage counters match a scan of the keys. Only the namespaces
+// of the run are checked, so dir should be a store used for nothing else.
+func RunSoak(ctx context.Context, log *zap.Logger, dir string, config SoakConfig) (report SoakReport, err error) {
+	config = config.withDefaults()
+	run := &soakRun{
+		log:    log,
+		dir:    dir,
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+		index:  map[storj.PieceID]int{},
+	}
+	for i := 0; i < config.Satellites; i++ {
+		var satellite storj.NodeID
+		_, _ = run.random.Read(satellite[:])
+		run.satellites = append(run.satellites, satellite)
+	}
+	run.store, err = NewBlobStoreWithLogger(log, dir, config.Store)
+	if err != nil {
+		return run.report, err
+	}
+	defer func() {
+		if run.store != nil {
+			err = errs.Combine(err, run.store.Close())
+		}
+		report.Live, report.Trashed = run.count()
+	}()
+
+	var deadline time.Time
+	if config.Duration > 0 {
+		deadline = time.Now().Add(config.Duration)
+	}
+	for {
+		if ctx.Err() != nil {
+			// interrupted, the store is left as it is
+			return run.report, nil
+		}
+		if config.Operations > 0 && run.report.Operations >= config.Operations || !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		if err := run.operation(ctx); err != nil {
+			return run.report, err
+		}
+		run.report.Operations++
+		ops := run.report.Operations
+		if ops%config.GCEvery == 0 {
+			if err := run.gc(ctx); err != nil {
+				return run.report, err
+			}
+		}
+		if ops%config.CompactEvery == 0 {
+			if err := run.store.reclaimSpace(ctx, time.Time{}); err != nil {
+				return run.report, ErrSoak.New("compaction: %v", err)
+			}
+			run.report.Compactions++
+		}
+		if ops%config.RestartEvery == 0 {
+			if err := run.restart(); err != nil {
+				return run.report, err
+			}
+		}
+		if ops%config.CheckEvery == 0 || ops%config.RestartEvery == 0 {
+			if err := run.check(ctx); err != nil {
+				return run.report, err
+			}
+		}
+	}
+	return run.report, run.check(ctx)
+}
+
+// operation runs a random operation on a random piece. Writes turn into
+// deletes once the run has all its pieces.
+func (r *soakRun) operation(ctx context.Context) error {
+	switch n := r.random.Intn(100); {
+	case n < 40 && len(r.pieces) < r.config.Pieces:
+		return r.write(ctx)
+	case n < 65:
+		return r.read(ctx)
+	case n < 75:
+		return r.trash(ctx)
+	case n < 78:
+		return r.restore(ctx)
+	case n < 80:
+		return r.emptyTrash(ctx)
+	default:
+		return r.delete(ctx)
+	}
+}
+
+func (r *soakRun) write(ctx context.Context) error {
+	var pieceID storj.PieceID
+	_, _ = r.random.Read(pieceID[:])
+	piece := &soakPiece{
+		id:   pieceID,
+		ref:  blobstore.BlobRef{Namespace: r.satellites[r.random.Intn(len(r.satellites))].Bytes(), Key: pieceID.Bytes()},
+		seed: r.random.Int63(),
+		size: 1 + r.random.Intn(r.config.MaxPieceSize),
+	}
+	w, err := r.store.Create(ctx, piece.ref)
+	if err != nil {
+		return ErrSoak.New("create %x: %v", piece.ref.Key, err)
+	}
+	if _, err := w.Write(piece.data()); err != nil {
+		return ErrSoak.New("write %x: %v", piece.ref.Key, errs.Combine(err, w.Cancel(ctx)))
+	}
+	if err := w.Commit(ctx); err != nil {
+		return ErrSoak.New("commit %x: %v", piece.ref.Key, err)
+	}
+	r.index[pieceID] = len(r.pieces)
+	r.pieces = append(r.pieces, piece)
+	r.report.Writes++
+	return nil
+}
+
+func (r *soakRun) read(ctx context.Context) error {
+	piece := r.pick(false)
+	if piece == nil {
+		return nil
+	}
+	reader, err := r.store.Open(ctx, piece.ref)
+	if err != nil {
+		return ErrSoak.New("open %x: %v", piece.ref.Key, err)
+	}
+	data, err := io.ReadAll(reader)
+	err = errs.Combine(err, reader.Close())
+	if err != nil {
+		return ErrSoak.New("read %x: %v", piece.ref.Key, err)
+	}
+	if !bytes.Equal(data, piece.data()) {
+		return ErrSoak.New("piece %x has different data", piece.ref.Key)
+	}
+	r.report.Reads++
+	return nil
+}
+
+func (r *soakRun) trash(ctx context.Context) error {
+	piece := r.pick(false)
+	if piece == nil {
+		return nil
+	}
+	if err := r.store.Trash(ctx, piece.ref, time.Now()); err != nil {
+		return ErrSoak.New("trash %x: %v", piece.ref.Key, err)
+	}
+	piece.trashed = true
+	r.report.Trashes++
+	return nil
+}
+
+func (r *soakRun) restore(ctx context.Context) error {
+	piece := r.pick(true)
+	if piece == nil {
+		return nil
+	}
+	keys, err := r.store.RestoreTrash(ctx, piece.ref.Namespace)
+	if err != nil {
+		return ErrSoak.New("restore trash: %v", err)
+	}
+	restored := 0
+	for _, p := range r.pieces {
+		if p.trashed && bytes.Equal(p.ref.Namespace, piece.ref.Namespace) {
+			p.trashed = false
+			restored++
+		}
+	}
+	if len(keys) != restored {
+		return ErrSoak.New("restored %d pieces instead of %d", len(keys), restored)
+	}
+	r.report.Restores++
+	return nil
+}
+
+func (r *soakRun) emptyTrash(ctx context.Context) error {
+	piece := r.pick(true)
+	if piece == nil {
+		return nil
+	}
+	_, keys, err := r.store.EmptyTrash(ctx, piece.ref.Namespace, time.Now().Add(time.Minute))
+	if err != nil {
+		return ErrSoak.New("empty trash: %v", err)
+	}
+	emptied := 0
+	for i := 0; i < len(r.pieces); {
+		if p := r.pieces[i]; p.trashed && bytes.Equal(p.ref.Namespace, piece.ref.Namespace) {
+			r.remove(i)
+			emptied++
+			continue
+		}
+		i++
+	}
+	if len(keys) != emptied {
+		return ErrSoak.New("emptied %d pieces instead of %d", len(keys), emptied)
+	}
+	r.report.TrashEmptied++
+	return nil
+}
+
+func (r *soakRun) delete(ctx context.Context) error {
+	piece := r.pick(false)
+	if piece == nil {
+		return nil
+	}
+	if err := r.store.Delete(ctx, piece.ref); err != nil {
+		return ErrSoak.New("delete %x: %v", piece.ref.Key, err)
+	}
+	r.remove(r.index[piece.id])
+	r.report.Deletes++
+	return nil
+}
+
+// gc trashes a random tenth of the live pieces of a satellite with a bloom
+// filter of the others, like the garbage collection of the storagenode.
+func (r *soakRun) gc(ctx context.Context) error {
+	satellite := r.satellites[r.random.Intn(len(r.satellites))]
+	filter := bloomfilter.NewOptimal(int64(len(r.pieces)+1), 0.01)
+	garbage := map[storj.PieceID]bool{}
+	for _, p := range r.pieces {
+		if p.trashed || !bytes.Equal(p.ref.Namespace, satellite.Bytes()) {
+			continue
+		}
+		if r.random.Intn(10) == 0 {
+			garbage[p.id] = true
+		} else {
+			filter.Add(p.id)
+		}
+	}
+	now := time.Now()
+	pieceIDs, _, _, err := r.store.WalkSatellitePiecesToTrash(ctx, satellite, now.Add(time.Minute), filter, func(pieceID storj.PieceID) error {
+		return r.store.Trash(ctx, blobstore.BlobRef{Namespace: satellite.Bytes(), Key: pieceID.Bytes()}, now)
+	})
+	if err != nil {
+		return ErrSoak.New("gc: %v", err)
+	}
+	for _, pieceID := range pieceIDs {
+		if !garbage[pieceID] {
+			return ErrSoak.New("gc trashed piece %s kept by the bloom filter", pieceID)
+		}
+		r.pieces[r.index[pieceID]].trashed = true
+		delete(garbage, pieceID)
+	}
+	// the false positives of the filter are kept
+	for pieceID := range garbage {
+		if !filter.Contains(pieceID) {
+			return ErrSoak.New("gc didn't trash garbage piece %s", pieceID)
+		}
+	}
+	r.report.GCRuns++
+	r.report.GCTrashed += int64(len(pieceIDs))
+	return nil
+}
+
+func (r *soakRun) restart() (err error) {
+	err = r.store.Close()
+	r.store = nil
+	if err != nil {
+		return ErrSoak.New("close: %v", err)
+	}
+	r.store, err = NewBlobStoreWithLogger(r.log, r.dir, r.config.Store)
+	if err != nil {
+		return ErrSoak.New("reopen: %v", err)
+	}
+	r.report.Restarts++
+	return nil
+}
+
+// check compares the walks and the usage counters of the store with the
+// pieces of the run.
+func (r *soakRun) check(ctx context.Context) error {
+	if err := r.store.waitUsage(ctx); err != nil {
+		return err
+	}
+	drift, err := recomputeUsage(ctx, r.store.db, nil)
+	if err != nil {
+		return ErrSoak.New("usage scan: %v", err)
+	}
+	if len(drift) > 0 {
+		r.store.logDrift(drift)
+		return ErrSoak.New("%d usage counters differ from the scan", len(drift))
+	}
+	for _, satellite := range r.satellites {
+		namespace := satellite.Bytes()
+		for _, trashed := range []bool{false, true} {
+			walk, kind := r.store.WalkNamespace, "live"
+			if trashed {
+				walk, kind = r.store.WalkTrash, "trashed"
+			}
+			var walked int64
+			err := walk(ctx, namespace, "", func(info blobstore.BlobInfo) error {
+				var pieceID storj.PieceID
+				copy(pieceID[:], info.BlobRef().Key)
+				i, ok := r.index[pieceID]
+				if !ok || r.pieces[i].trashed != trashed {
+					return ErrSoak.New("unexpected %s piece %x", kind, info.BlobRef().Key)
+				}
+				fileInfo, err := info.Stat(ctx)
+				if err != nil {
+					return err
+				}
+				if fileInfo.Size() != int64(r.pieces[i].size) {
+					return ErrSoak.New("%s piece %x has %d bytes instead of %d", kind, info.BlobRef().Key, fileInfo.Size(), r.pieces[i].size)
+				}
+				walked++
+				return nil
+			})
+			if err != nil {
+				return ErrSoak.Wrap(err)
+			}
+			var expected, expectedBytes int64
+			for _, p := range r.pieces {
+				if p.trashed == trashed && bytes.Equal(p.ref.Namespace, namespace) {
+					expected++
+					expectedBytes += int64(p.size)
+				}
+			}
+			if walked != expected {
+				return ErrSoak.New("found %d %s pieces of %s instead of %d", walked, kind, satellite, expected)
+			}
+			counted, err := r.usage(ctx, namespace, trashed)
+			if err != nil {
+				return err
+			}
+			if counted.Pieces != expected || counted.Bytes != expectedBytes {
+				return ErrSoak.New("%s usage of %s is %d pieces, %d bytes instead of %d, %d", kind, satellite, counted.Pieces, counted.Bytes, expected, expectedBytes)
+			}
+		}
+	}
+	r.report.Checks++
+	live, trashed := r.count()
+	r.log.Info("soak check passed", zap.Int64("operations", r.report.Operations), zap.Int("live", live), zap.Int("trashed", trashed))
+	return nil
+}
+
+func (r *soakRun) usage(ctx context.Context, namespace []byte, trashed bool) (u usage, err error) {
+	var stats NamespaceStats
+	err = r.store.ViewRecords(ctx, func(v *RecordView) (err error) {
+		stats, err = v.Usage(namespace)
+		return err
+	})
+	if trashed {
+		return usage{Pieces: stats.TrashPieces, Bytes: stats.TrashBytes}, err
+	}
+	return usage{Pieces: stats.Pieces, Bytes: stats.Bytes}, err
+}
+
+// pick returns a random live or trashed piece, nil if there is none.
+func (r *soakRun) pick(trashed bool) *soakPiece {
+	if len(r.pieces) == 0 {
+		return nil
+	}
+	start := r.random.Intn(len(r.pieces))
+	for i := range r.pieces {
+		if p := r.pieces[(start+i)%len(r.pieces)]; p.trashed == trashed {
+			return p
+		}
+	}
+	return nil
+}
+
+// remove drops the piece at i, moving the last one in its place.
+func (r *soakRun) remove(i int) {
+	last := len(r.pieces) - 1
+	delete(r.index, r.pieces[i].id)
+	if i != last {
+		r.pieces[i] = r.pieces[last]
+		r.index[r.pieces[i].id] = i
+	}
+	r.pieces = r.pieces[:last]
+}
+
+func (r *soakRun) count() (live, trashed int) {
+	for _, p := range r.pieces {
+		if p.trashed {
+			trashed++
+		} else {
+			live++
+		}
+	}
+	return live, trashed
+}
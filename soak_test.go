@@ -0,0 +1,75 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math/rand"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestRunSoak(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	report, err := RunSoak(ctx, zap.NewNop(), ctx.Dir("store"), SoakConfig{
+		Operations:   600,
+		Pieces:       50,
+		MaxPieceSize: 4096,
+		CheckEvery:   100,
+		GCEvery:      150,
+		CompactEvery: 200,
+		RestartEvery: 250,
+		Seed:         1,
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 600, report.Operations)
+	require.EqualValues(t, 4, report.GCRuns)
+	require.EqualValues(t, 3, report.Compactions)
+	require.EqualValues(t, 2, report.Restarts)
+	// every 100 operations, after the restart at 250 and at the end
+	require.EqualValues(t, 8, report.Checks)
+	require.Positive(t, report.Writes)
+	require.Positive(t, report.Reads)
+	require.Positive(t, report.Trashes)
+	require.Positive(t, report.Deletes)
+	require.Positive(t, report.Live)
+
+	// the pieces are still there after the run
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Positive(t, used)
+}
+
+func TestSoakCheckFindsLostPieces(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	run := &soakRun{
+		log:        zap.NewNop(),
+		config:     SoakConfig{Pieces: 10, MaxPieceSize: 100}.withDefaults(),
+		random:     rand.New(rand.NewSource(1)),
+		store:      store,
+		satellites: []storj.NodeID{{1}},
+		index:      map[storj.PieceID]int{},
+	}
+	defer func() { ctx.Check(run.store.Close) }()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, run.write(ctx))
+	}
+	require.NoError(t, run.trash(ctx))
+	require.NoError(t, run.check(ctx))
+
+	// a piece deleted behind the back of the run is lost
+	live := run.pick(false)
+	require.NoError(t, store.Delete(ctx, live.ref))
+	err = run.check(ctx)
+	require.True(t, ErrSoak.Has(err), err)
+	require.Contains(t, err.Error(), "live pieces")
+}
@@ -0,0 +1,86 @@
+package badger
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// startUsageScan computes the missing usage counters in the background when
+// Config.BackgroundUsageScan is set. Otherwise they are already computed.
+func (b *BlobStore) startUsageScan() {
+	b.usageDone = make(chan struct{})
+	if !b.config.BackgroundUsageScan {
+		close(b.usageDone)
+		return
+	}
+	b.runBackground("usage scan", func(ctx context.Context) {
+		b.usageErr = initUsage(ctx, b.db)
+		close(b.usageDone)
+	})
+}
+
+// startUsageRecalculation periodically recomputes the usage counters, as
+// they may drift after crashes or bugs.
+func (b *BlobStore) startUsageRecalculation() {
+	interval := b.config.UsageRecalculation
+	if interval <= 0 {
+		return
+	}
+	b.startJob(ChoreUsageReconciliation, interval, b.reconcileUsage)
+}
+
+// reconcileUsage recomputes the usage counters and logs the drift corrected.
+func (b *BlobStore) reconcileUsage(ctx context.Context) error {
+	drift, err := recomputeUsage(ctx, b.db, nil)
+	if err != nil {
+		return err
+	}
+	b.logDrift(drift)
+	b.chores.done(ChoreUsageReconciliation)
+	return nil
+}
+
+// logDrift logs the corrections of the usage counters by counter key.
+func (b *BlobStore) logDrift(drift map[string]usage) {
+	for counter, correction := range drift {
+		mon.Counter("usage_drift_corrections").Inc(1)
+		b.log.Warn("corrected usage counter drift",
+			zap.Binary("namespace", []byte(counter[len(usagePrefix):])),
+			zap.Bool("trash", strings.HasPrefix(counter, string(trashUsagePrefix))),
+			zap.Int64("bytes", correction.Bytes),
+			zap.Int64("pieces", correction.Pieces))
+	}
+}
+
+// waitUsage waits until the usage counters are accurate.
+func (b *BlobStore) waitUsage(ctx context.Context) error {
+	select {
+	case <-b.usageDone:
+		return b.usageErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EstimateSpaceUsedForBlobs returns the space used by the blobs without
+// waiting for the usage counters. While they are computed in the background
+// (see Config.BackgroundUsageScan), the size of the badger value log is
+// returned as an estimate, and exact is false. It's false too while the
+// counters are reconciled after an unclean shutdown, see Stats.ApproximateUsage.
+func (b *BlobStore) EstimateSpaceUsedForBlobs(ctx context.Context) (used int64, exact bool, err error) {
+	select {
+	case <-b.usageDone:
+		if b.usageErr == nil {
+			var total usage
+			err := b.db.View(func(txn kvTxn) (err error) {
+				total, err = totalUsage(txn)
+				return err
+			})
+			return total.Bytes, !b.approximateUsage.Load(), err
+		}
+	default:
+	}
+	_, vlog := b.db.Size()
+	return vlog, false, nil
+}
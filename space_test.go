@@ -0,0 +1,124 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestBackgroundUsageScan(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "123"))
+	require.NoError(t, store.Close())
+	require.NoError(t, clearUsage(dir))
+
+	store, err = NewBlobStoreWithConfig(dir, Config{BackgroundUsageScan: true})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), used)
+
+	used, exact, err := store.EstimateSpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.True(t, exact)
+	require.Equal(t, int64(8), used)
+}
+
+func TestApplyUsageScan(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "12345"))
+
+	// the counters drifted before the scan
+	var atScan map[string]usage
+	err = store.db.Update(func(txn kvTxn) (err error) {
+		if err := addUsage(txn, ref("ns", "key1"), usage{Bytes: 100, Pieces: 1}); err != nil {
+			return err
+		}
+		atScan, err = readCounters(txn, nil)
+		return err
+	})
+	require.NoError(t, err)
+	scanned := map[string]usage{string(usageKey([]byte("ns"), usageShard([]byte("key1")))): {Bytes: 5, Pieces: 1}}
+
+	// commits between the scan and its write are kept
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "123"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key"), "1"))
+	err = store.db.Update(func(txn kvTxn) error {
+		drift, err := applyUsageScan(txn, scanned, atScan, nil)
+		require.Equal(t, map[string]usage{string(usagePrefix) + "ns": {Bytes: -100, Pieces: -1}}, drift)
+		return err
+	})
+	require.NoError(t, err)
+
+	used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), used)
+	used, err = store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), used)
+}
+
+// clearUsage removes the usage counters of a closed store, like stores
+// created before the counters existed.
+func clearUsage(dir string) error {
+	db, err := openEngine(dir, Config{}, false)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: usagePrefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		return writeMeta(txn, meta{LayoutVersion: layoutVersion})
+	})
+	return errs.Combine(err, db.Close())
+}
+
+func TestUsageRecalculation(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	core, logs := observer.New(zap.WarnLevel)
+	store, err := NewBlobStoreWithLogger(zap.New(core), ctx.Dir("store"), Config{UsageRecalculation: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "12345"))
+	err = store.db.Update(func(txn kvTxn) error {
+		return addUsage(txn, ref("ns", "key"), usage{Bytes: 100})
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		used, err := store.SpaceUsedForBlobs(ctx)
+		require.NoError(t, err)
+		return used == 5
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("corrected usage counter drift").Len() == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	entry := logs.All()[0]
+	require.Equal(t, int64(-100), entry.ContextMap()["bytes"])
+}
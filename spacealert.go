@@ -0,0 +1,177 @@
+package badger
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"time"
+)
+
+// spaceAlertInterval is how often the disk usage is checked against the
+// alert thresholds.
+var spaceAlertInterval = time.Minute
+
+// spaceAlertHysteresis is how far the usage has to drop below a threshold to
+// clear its alert, so usage hovering around it doesn't flap.
+var spaceAlertHysteresis = 0.02
+
+// SpaceAlert is the level of the disk usage alert.
+type SpaceAlert int
+
+const (
+	// SpaceOK is below the thresholds.
+	SpaceOK SpaceAlert = iota
+	// SpaceWarning is over Config.SpaceWarning.
+	SpaceWarning
+	// SpaceCritical is over Config.SpaceCritical.
+	SpaceCritical
+)
+
+func (a SpaceAlert) String() string {
+	switch a {
+	case SpaceWarning:
+		return "warning"
+	case SpaceCritical:
+		return "critical"
+	default:
+		return "ok"
+	}
+}
+
+// spaceAlert raises and clears the disk usage alert.
+type spaceAlert struct {
+	warning  float64
+	critical float64
+
+	mu       sync.Mutex
+	level    SpaceAlert
+	callback func(SpaceAlert)
+}
+
+// next returns the alert level for the usage. Crossing a threshold raises
+// it, but it's lowered only below the threshold minus the hysteresis.
+func (a *spaceAlert) next(usage float64) SpaceAlert {
+	level := a.level
+	if a.critical > 0 && usage >= a.critical {
+		return SpaceCritical
+	}
+	if level == SpaceCritical && (a.critical <= 0 || usage < a.critical-spaceAlertHysteresis) {
+		level = SpaceWarning
+	}
+	if a.warning > 0 && usage >= a.warning {
+		if level < SpaceWarning {
+			level = SpaceWarning
+		}
+		return level
+	}
+	if level == SpaceWarning && (a.warning <= 0 || usage < a.warning-spaceAlertHysteresis) {
+		level = SpaceOK
+	}
+	return level
+}
+
+// update evaluates the space, reporting changes of the alert level.
+func (a *spaceAlert) update(log *zap.Logger, space SpaceInfo) {
+	usage := spaceUsage(space)
+	a.mu.Lock()
+	level := a.next(usage)
+	changed := level != a.level
+	a.level = level
+	callback := a.callback
+	a.mu.Unlock()
+
+	mon.FloatVal("space_usage").Observe(usage)
+	mon.IntVal("space_alert").Observe(int64(level))
+	if !changed {
+		return
+	}
+	mon.Event("space_alert_" + level.String())
+	fields := []zap.Field{zap.Float64("usage", usage), zap.Int64("used", space.UsedSpace),
+		zap.Int64("allocation", space.Allocation), zap.Int64("free", space.FreeSpace)}
+	switch level {
+	case SpaceCritical:
+		log.Error("disk space critically low", fields...)
+	case SpaceWarning:
+		log.Warn("disk space low", fields...)
+	default:
+		log.Info("disk space recovered", fields...)
+	}
+	if callback != nil {
+		callback(level)
+	}
+}
+
+// spaceUsage returns the used part of the allocation or of the file system,
+// whichever is fuller.
+func spaceUsage(space SpaceInfo) float64 {
+	var usage float64
+	if space.TotalSpace > 0 {
+		usage = float64(space.TotalSpace-space.FreeSpace) / float64(space.TotalSpace)
+	}
+	if space.Allocation > 0 {
+		usage = math.Max(usage, float64(space.UsedSpace)/float64(space.Allocation))
+	}
+	return usage
+}
+
+// checkSpaceAlert validates the alert thresholds.
+func checkSpaceAlert(config Config) error {
+	for _, threshold := range []float64{config.SpaceWarning, config.SpaceCritical} {
+		if threshold < 0 || threshold > 1 {
+			return ErrConfig.New("disk space alert thresholds must be between 0 and 1, got %v", threshold)
+		}
+	}
+	if config.SpaceWarning > 0 && config.SpaceCritical > 0 && config.SpaceWarning >= config.SpaceCritical {
+		return ErrConfig.New("disk space warning threshold %v must be below the critical one %v", config.SpaceWarning, config.SpaceCritical)
+	}
+	return nil
+}
+
+// startSpaceAlert checks the disk usage periodically, when enabled by the config.
+func (b *BlobStore) startSpaceAlert() {
+	if b.config.SpaceWarning <= 0 && b.config.SpaceCritical <= 0 {
+		return
+	}
+	b.spaceAlert = &spaceAlert{warning: b.config.SpaceWarning, critical: b.config.SpaceCritical}
+	b.runBackground("space alert", func(ctx context.Context) {
+		ticker := time.NewTicker(spaceAlertInterval)
+		defer ticker.Stop()
+		for {
+			space, err := b.SpaceInfo(ctx)
+			if err != nil && ctx.Err() == nil {
+				b.log.Warn("checking disk space failed", zap.Error(err))
+			}
+			if err == nil {
+				b.spaceAlert.update(b.log, space)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// SpaceAlert returns the disk usage alert level as of the last check. It's
+// always SpaceOK when the thresholds are disabled.
+func (b *BlobStore) SpaceAlert() SpaceAlert {
+	if b.spaceAlert == nil {
+		return SpaceOK
+	}
+	b.spaceAlert.mu.Lock()
+	defer b.spaceAlert.mu.Unlock()
+	return b.spaceAlert.level
+}
+
+// OnSpaceAlert sets the callback called when the disk usage alert level
+// changes. It's called from a background goroutine.
+func (b *BlobStore) OnSpaceAlert(callback func(SpaceAlert)) {
+	if b.spaceAlert == nil {
+		return
+	}
+	b.spaceAlert.mu.Lock()
+	defer b.spaceAlert.mu.Unlock()
+	b.spaceAlert.callback = callback
+}
@@ -0,0 +1,65 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestSpaceAlert(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	defer func(interval time.Duration) { spaceAlertInterval = interval }(spaceAlertInterval)
+	spaceAlertInterval = 10 * time.Millisecond
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Allocation: 100, SpaceWarning: 0.5, SpaceCritical: 0.9})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	alerts := make(chan SpaceAlert, 3)
+	store.OnSpaceAlert(func(level SpaceAlert) { alerts <- level })
+
+	// a file system fuller than the thresholds may raise the alert earlier
+	require.NoError(t, save(ctx, store, ref("ns", "key"), string(make([]byte, 95))))
+	for level := range alerts {
+		if level == SpaceCritical {
+			break
+		}
+	}
+	require.Equal(t, SpaceCritical, store.SpaceAlert())
+}
+
+func TestSpaceAlertHysteresis(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	alert := &spaceAlert{warning: 0.9, critical: 0.95}
+	update := func(used int64) SpaceAlert {
+		alert.update(zap.New(core), SpaceInfo{Allocation: 100, UsedSpace: used})
+		return alert.level
+	}
+
+	require.Equal(t, SpaceOK, update(89))
+	require.Equal(t, SpaceWarning, update(90))
+	// dropping just below the threshold keeps the alert
+	require.Equal(t, SpaceWarning, update(89))
+	require.Equal(t, SpaceCritical, update(96))
+	require.Equal(t, SpaceCritical, update(94))
+	require.Equal(t, SpaceWarning, update(92))
+	require.Equal(t, SpaceOK, update(50))
+
+	// only the changes are logged
+	require.Equal(t, 4, logs.Len())
+	require.Equal(t, "disk space low", logs.All()[0].Message)
+	require.Equal(t, "disk space critically low", logs.All()[1].Message)
+	require.Equal(t, "disk space recovered", logs.All()[3].Message)
+}
+
+func TestSpaceAlertConfig(t *testing.T) {
+	require.NoError(t, checkSpaceAlert(Config{SpaceWarning: 0.9, SpaceCritical: 0.95}))
+	require.NoError(t, checkSpaceAlert(Config{SpaceCritical: 0.95}))
+	require.True(t, ErrConfig.Has(checkSpaceAlert(Config{SpaceWarning: 90})))
+	require.True(t, ErrConfig.Has(checkSpaceAlert(Config{SpaceWarning: 0.95, SpaceCritical: 0.9})))
+}
@@ -0,0 +1,147 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/pieces"
+)
+
+// spaceUsedPrefix keys hold the cached space used by the pieces of each
+// satellite, by satellite ID.
+var spaceUsedPrefix = []byte("spusd")
+
+// spaceUsedTotalKey holds the cached space used by all pieces.
+var spaceUsedTotalKey = []byte("sptot")
+
+// spaceUsedTrashKey holds the cached space used by the trash.
+var spaceUsedTrashKey = []byte("sptrs")
+
+// PieceSpaceUsed is the cache of the space used by the pieces, which the
+// storagenode keeps in its piece_spaced_used database to start up without
+// walking the pieces. It's the pieces.PieceSpaceUsedDB of the node.
+type PieceSpaceUsed struct {
+	db        kvEngine
+	iterators iteratorProfiles
+}
+
+var _ pieces.PieceSpaceUsedDB = (*PieceSpaceUsed)(nil)
+
+// PieceSpaceUsed returns the space used cache kept in the store.
+func (b *BlobStore) PieceSpaceUsed() *PieceSpaceUsed {
+	return &PieceSpaceUsed{db: b.db, iterators: b.iterators}
+}
+
+func encodeSatelliteUsage(u pieces.SatelliteUsage) []byte {
+	raw := binary.BigEndian.AppendUint64(nil, uint64(u.Total))
+	return binary.BigEndian.AppendUint64(raw, uint64(u.ContentSize))
+}
+
+func readSatelliteUsage(item kvItem) (u pieces.SatelliteUsage, err error) {
+	err = item.Value(func(val []byte) error {
+		if len(val) != 16 {
+			return ErrCorrupt.New("space used entry has %d bytes", len(val))
+		}
+		u.Total = int64(binary.BigEndian.Uint64(val))
+		u.ContentSize = int64(binary.BigEndian.Uint64(val[8:]))
+		return nil
+	})
+	return u, err
+}
+
+func (s *PieceSpaceUsed) get(key []byte) (u pieces.SatelliteUsage, err error) {
+	err = view(s.db, func(txn kvTxn) error {
+		item, err := txn.Get(key)
+		if errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		u, err = readSatelliteUsage(item)
+		return err
+	})
+	return u, err
+}
+
+// Init does nothing, the missing totals are zero.
+func (s *PieceSpaceUsed) Init(ctx context.Context) error {
+	return nil
+}
+
+// GetPieceTotals returns the space used by all pieces.
+func (s *PieceSpaceUsed) GetPieceTotals(ctx context.Context) (piecesTotal int64, piecesContentSize int64, err error) {
+	u, err := s.get(spaceUsedTotalKey)
+	return u.Total, u.ContentSize, err
+}
+
+// UpdatePieceTotals replaces the space used by all pieces.
+func (s *PieceSpaceUsed) UpdatePieceTotals(ctx context.Context, piecesTotal, piecesContentSize int64) error {
+	return update(s.db, func(txn kvTxn) error {
+		return txn.Set(spaceUsedTotalKey, encodeSatelliteUsage(pieces.SatelliteUsage{Total: piecesTotal, ContentSize: piecesContentSize}))
+	})
+}
+
+// GetPieceTotalsForAllSatellites returns the space used by the pieces of
+// each satellite.
+func (s *PieceSpaceUsed) GetPieceTotalsForAllSatellites(ctx context.Context) (map[storj.NodeID]pieces.SatelliteUsage, error) {
+	totals := map[storj.NodeID]pieces.SatelliteUsage{}
+	err := view(s.db, func(txn kvTxn) error {
+		it := txn.NewIterator(s.iterators.options(IteratorRecords, spaceUsedPrefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			satellite, err := storj.NodeIDFromBytes(it.Item().Key()[len(spaceUsedPrefix):])
+			if err != nil {
+				return ErrCorrupt.Wrap(err)
+			}
+			u, err := readSatelliteUsage(it.Item())
+			if err != nil {
+				return err
+			}
+			totals[satellite] = u
+		}
+		return nil
+	})
+	return totals, err
+}
+
+// UpdatePieceTotalsForAllSatellites replaces the space used by the pieces of
+// the satellites, removing the satellites missing from newTotalsBySatellites.
+func (s *PieceSpaceUsed) UpdatePieceTotalsForAllSatellites(ctx context.Context, newTotalsBySatellites map[storj.NodeID]pieces.SatelliteUsage) error {
+	return update(s.db, func(txn kvTxn) error {
+		it := txn.NewIterator(s.iterators.options(IteratorRecords, spaceUsedPrefix))
+		var removed [][]byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			satellite, err := storj.NodeIDFromBytes(it.Item().Key()[len(spaceUsedPrefix):])
+			if _, ok := newTotalsBySatellites[satellite]; err != nil || !ok {
+				removed = append(removed, it.Item().KeyCopy(nil))
+			}
+		}
+		it.Close()
+		for _, key := range removed {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		for satellite, u := range newTotalsBySatellites {
+			if err := txn.Set(concat(spaceUsedPrefix, satellite.Bytes()), encodeSatelliteUsage(u)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetTrashTotal returns the space used by the trash.
+func (s *PieceSpaceUsed) GetTrashTotal(ctx context.Context) (int64, error) {
+	u, err := s.get(spaceUsedTrashKey)
+	return u.Total, err
+}
+
+// UpdateTrashTotal replaces the space used by the trash.
+func (s *PieceSpaceUsed) UpdateTrashTotal(ctx context.Context, newTotal int64) error {
+	return update(s.db, func(txn kvTxn) error {
+		return txn.Set(spaceUsedTrashKey, encodeSatelliteUsage(pieces.SatelliteUsage{Total: newTotal}))
+	})
+}
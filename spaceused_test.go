@@ -0,0 +1,50 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/pieces"
+	"testing"
+)
+
+func TestPieceSpaceUsed(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	spaceUsed := store.PieceSpaceUsed()
+	require.NoError(t, spaceUsed.Init(ctx))
+
+	total, contentSize, err := spaceUsed.GetPieceTotals(ctx)
+	require.NoError(t, err)
+	require.Zero(t, total)
+	require.Zero(t, contentSize)
+
+	require.NoError(t, spaceUsed.UpdatePieceTotals(ctx, 1000, 900))
+	total, contentSize, err = spaceUsed.GetPieceTotals(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, total)
+	require.EqualValues(t, 900, contentSize)
+
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	require.NoError(t, spaceUsed.UpdatePieceTotalsForAllSatellites(ctx, map[storj.NodeID]pieces.SatelliteUsage{
+		satellite1: {Total: 600, ContentSize: 500},
+		satellite2: {Total: 400, ContentSize: 400},
+	}))
+	// the satellites missing from the new totals are removed
+	require.NoError(t, spaceUsed.UpdatePieceTotalsForAllSatellites(ctx, map[storj.NodeID]pieces.SatelliteUsage{
+		satellite1: {Total: 700, ContentSize: 600},
+	}))
+	totals, err := spaceUsed.GetPieceTotalsForAllSatellites(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[storj.NodeID]pieces.SatelliteUsage{satellite1: {Total: 700, ContentSize: 600}}, totals)
+
+	require.NoError(t, spaceUsed.UpdateTrashTotal(ctx, 123))
+	trash, err := spaceUsed.GetTrashTotal(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 123, trash)
+}
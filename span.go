@@ -0,0 +1,392 @@
+package badger
+
+import (
+	"context"
+	"github.com/zeebo/errs"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// ErrSpanning is returned for the errors of the spanning store itself.
+var ErrSpanning = errs.Class("spanning store")
+
+// spanLocationPrefix is the prefix of the keys recording which directory of
+// a SpanningStore holds a blob, kept in the store of the first directory.
+var spanLocationPrefix = []byte("spanl")
+
+// SpanDir is a directory of a SpanningStore with the config of its store,
+// like the allocation of the disk.
+type SpanDir struct {
+	Dir    string
+	Config Config
+}
+
+// SpanningStore spreads the blobs over the badger stores of several
+// directories, typically on different disks. New blobs go to the directory
+// with the most available space, and the directory of every blob is recorded
+// in the store of the first directory. The directories must be given in the
+// same order every time, new ones can be added only at the end.
+type SpanningStore struct {
+	stores []*BlobStore
+}
+
+var _ blobstore.Blobs = &SpanningStore{}
+
+// NewSpanningStore opens the stores of the directories.
+func NewSpanningStore(dirs ...SpanDir) (*SpanningStore, error) {
+	if len(dirs) == 0 {
+		return nil, ErrSpanning.New("no directories")
+	}
+	if len(dirs) > 256 {
+		return nil, ErrSpanning.New("too many directories: %d", len(dirs))
+	}
+	s := &SpanningStore{}
+	for _, dir := range dirs {
+		store, err := NewBlobStoreWithConfig(dir.Dir, dir.Config)
+		if err != nil {
+			return nil, errs.Combine(ErrSpanning.New("opening %s: %v", dir.Dir, err), s.Close())
+		}
+		s.stores = append(s.stores, store)
+	}
+	return s, nil
+}
+
+// Stores returns the stores of the directories, in their order.
+func (s *SpanningStore) Stores() []*BlobStore {
+	return s.stores
+}
+
+func spanLocationKey(ref blobstore.BlobRef) []byte {
+	return concat(spanLocationPrefix, ref.Namespace, ref.Key)
+}
+
+// locate returns the index of the store holding the blob. Blobs missing from
+// the locations, like the ones committed right before a crash, are looked up
+// in every store and their location is recorded again.
+func (s *SpanningStore) locate(ctx context.Context, ref blobstore.BlobRef) (int, bool, error) {
+	var index int
+	found := false
+	err := view(s.stores[0].db, func(txn kvTxn) error {
+		item, err := txn.Get(spanLocationKey(ref))
+		if errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) != 1 || int(val[0]) >= len(s.stores) {
+				return ErrSpanning.New("invalid location of %x/%x: %x", ref.Namespace, ref.Key, val)
+			}
+			index, found = int(val[0]), true
+			return nil
+		})
+	})
+	if err != nil || found {
+		return index, found, err
+	}
+	for i, store := range s.stores {
+		if _, err := store.Stat(ctx, ref); err != nil {
+			if ErrNotExist.Has(err) {
+				continue
+			}
+			return 0, false, err
+		}
+		return i, true, s.setLocation(ref, i)
+	}
+	return 0, false, nil
+}
+
+// store returns the store holding the blob, or an ErrNotExist error.
+func (s *SpanningStore) store(ctx context.Context, ref blobstore.BlobRef) (*BlobStore, error) {
+	index, found, err := s.locate(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNotExist("%s", ref.Key)
+	}
+	return s.stores[index], nil
+}
+
+func (s *SpanningStore) setLocation(ref blobstore.BlobRef, index int) error {
+	return update(s.stores[0].db, func(txn kvTxn) error {
+		return txn.Set(spanLocationKey(ref), []byte{byte(index)})
+	})
+}
+
+func (s *SpanningStore) removeLocations(namespace []byte, keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return update(s.stores[0].db, func(txn kvTxn) error {
+		for _, key := range keys {
+			if err := txn.Delete(spanLocationKey(blobstore.BlobRef{Namespace: namespace, Key: key})); err != nil {
+				return errs.Wrap(err)
+			}
+		}
+		return nil
+	})
+}
+
+// pick returns the index of the store with the most available space.
+func (s *SpanningStore) pick(ctx context.Context) (int, error) {
+	best, bestSpace := 0, int64(-1)
+	for i, store := range s.stores {
+		info, err := store.DiskInfo(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if info.AvailableSpace > bestSpace {
+			best, bestSpace = i, info.AvailableSpace
+		}
+	}
+	return best, nil
+}
+
+// Create creates the blob in the store already holding the ref, or in the one
+// with the most available space.
+func (s *SpanningStore) Create(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobWriter, error) {
+	index, found, err := s.locate(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if index, err = s.pick(ctx); err != nil {
+			return nil, err
+		}
+	}
+	w, err := s.stores[index].Create(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &spanWriter{BlobWriter: w, span: s, ref: ref, index: index}, nil
+}
+
+// spanWriter records the location of the blob when it's committed.
+type spanWriter struct {
+	blobstore.BlobWriter
+	span  *SpanningStore
+	ref   blobstore.BlobRef
+	index int
+}
+
+func (w *spanWriter) Commit(ctx context.Context) error {
+	if err := w.BlobWriter.Commit(ctx); err != nil {
+		return err
+	}
+	return w.span.setLocation(w.ref, w.index)
+}
+
+func (s *SpanningStore) Open(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobReader, error) {
+	store, err := s.store(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(ctx, ref)
+}
+
+func (s *SpanningStore) OpenWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (blobstore.BlobReader, error) {
+	store, err := s.store(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return store.OpenWithStorageFormat(ctx, ref, formatVer)
+}
+
+func (s *SpanningStore) Delete(ctx context.Context, ref blobstore.BlobRef) error {
+	return s.delete(ctx, ref, func(store *BlobStore) error { return store.Delete(ctx, ref) })
+}
+
+func (s *SpanningStore) DeleteWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) error {
+	return s.delete(ctx, ref, func(store *BlobStore) error { return store.DeleteWithStorageFormat(ctx, ref, formatVer) })
+}
+
+// delete deletes the blob from its store with fn and forgets its location.
+// Missing blobs are ignored, like by BlobStore.Delete.
+func (s *SpanningStore) delete(ctx context.Context, ref blobstore.BlobRef, fn func(store *BlobStore) error) error {
+	index, found, err := s.locate(ctx, ref)
+	if err != nil || !found {
+		return err
+	}
+	if err := fn(s.stores[index]); err != nil {
+		return err
+	}
+	return s.removeLocations(ref.Namespace, [][]byte{ref.Key})
+}
+
+func (s *SpanningStore) DeleteNamespace(ctx context.Context, namespace []byte) error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.DeleteNamespace(ctx, namespace))
+	}
+	return group.Err()
+}
+
+func (s *SpanningStore) DeleteTrashNamespace(ctx context.Context, namespace []byte) error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.DeleteTrashNamespace(ctx, namespace))
+	}
+	return group.Err()
+}
+
+// Trash trashes the blob in its store, where it stays until restored or
+// emptied.
+func (s *SpanningStore) Trash(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time) error {
+	index, found, err := s.locate(ctx, ref)
+	if err != nil || !found {
+		return err
+	}
+	return s.stores[index].Trash(ctx, ref, timestamp)
+}
+
+func (s *SpanningStore) RestoreTrash(ctx context.Context, namespace []byte) ([][]byte, error) {
+	var restored [][]byte
+	for _, store := range s.stores {
+		keys, err := store.RestoreTrash(ctx, namespace)
+		if err != nil {
+			return restored, err
+		}
+		restored = append(restored, keys...)
+	}
+	return restored, nil
+}
+
+func (s *SpanningStore) EmptyTrash(ctx context.Context, namespace []byte, trashedBefore time.Time) (int64, [][]byte, error) {
+	var freed int64
+	var deleted [][]byte
+	for _, store := range s.stores {
+		bytes, keys, err := store.EmptyTrash(ctx, namespace, trashedBefore)
+		freed += bytes
+		deleted = append(deleted, keys...)
+		if err != nil {
+			return freed, deleted, errs.Combine(err, s.removeLocations(namespace, deleted))
+		}
+	}
+	return freed, deleted, s.removeLocations(namespace, deleted)
+}
+
+func (s *SpanningStore) TryRestoreTrashBlob(ctx context.Context, ref blobstore.BlobRef) error {
+	store, err := s.store(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return store.TryRestoreTrashBlob(ctx, ref)
+}
+
+func (s *SpanningStore) Stat(ctx context.Context, ref blobstore.BlobRef) (blobstore.BlobInfo, error) {
+	store, err := s.store(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return store.Stat(ctx, ref)
+}
+
+func (s *SpanningStore) StatWithStorageFormat(ctx context.Context, ref blobstore.BlobRef, formatVer blobstore.FormatVersion) (blobstore.BlobInfo, error) {
+	store, err := s.store(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return store.StatWithStorageFormat(ctx, ref, formatVer)
+}
+
+// DiskInfo returns the sums of the directories. Directories sharing a file
+// system count its capacity more than once.
+func (s *SpanningStore) DiskInfo(ctx context.Context) (total blobstore.DiskInfo, err error) {
+	for _, store := range s.stores {
+		info, err := store.DiskInfo(ctx)
+		if err != nil {
+			return total, err
+		}
+		total.TotalSpace += info.TotalSpace
+		total.AvailableSpace += info.AvailableSpace
+	}
+	return total, nil
+}
+
+func (s *SpanningStore) SpaceUsedForTrash(ctx context.Context) (int64, error) {
+	return s.sum(func(store *BlobStore) (int64, error) { return store.SpaceUsedForTrash(ctx) })
+}
+
+func (s *SpanningStore) SpaceUsedForBlobs(ctx context.Context) (int64, error) {
+	return s.sum(func(store *BlobStore) (int64, error) { return store.SpaceUsedForBlobs(ctx) })
+}
+
+func (s *SpanningStore) SpaceUsedForBlobsInNamespace(ctx context.Context, namespace []byte) (int64, error) {
+	return s.sum(func(store *BlobStore) (int64, error) { return store.SpaceUsedForBlobsInNamespace(ctx, namespace) })
+}
+
+func (s *SpanningStore) sum(fn func(store *BlobStore) (int64, error)) (total int64, err error) {
+	for _, store := range s.stores {
+		used, err := fn(store)
+		if err != nil {
+			return total, err
+		}
+		total += used
+	}
+	return total, nil
+}
+
+// ListNamespaces returns the namespaces of every directory, without
+// duplicates.
+func (s *SpanningStore) ListNamespaces(ctx context.Context) (namespaces [][]byte, err error) {
+	seen := map[string]bool{}
+	for _, store := range s.stores {
+		list, err := store.ListNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, namespace := range list {
+			if !seen[string(namespace)] {
+				seen[string(namespace)] = true
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	return namespaces, nil
+}
+
+// WalkNamespace walks the namespace in the directories one after the other.
+func (s *SpanningStore) WalkNamespace(ctx context.Context, namespace []byte, startFromPrefix string, walkFunc func(blobstore.BlobInfo) error) error {
+	for _, store := range s.stores {
+		if err := store.WalkNamespace(ctx, namespace, startFromPrefix, walkFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SpanningStore) CheckWritability(ctx context.Context) error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.CheckWritability(ctx))
+	}
+	return group.Err()
+}
+
+func (s *SpanningStore) CreateVerificationFile(ctx context.Context, id storj.NodeID) error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.CreateVerificationFile(ctx, id))
+	}
+	return group.Err()
+}
+
+func (s *SpanningStore) VerifyStorageDir(ctx context.Context, id storj.NodeID) error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.VerifyStorageDir(ctx, id))
+	}
+	return group.Err()
+}
+
+// Close closes the stores of every directory.
+func (s *SpanningStore) Close() error {
+	var group errs.Group
+	for _, store := range s.stores {
+		group.Add(store.Close())
+	}
+	return group.Err()
+}
@@ -0,0 +1,99 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestSpanningStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dirs := []SpanDir{
+		{Dir: ctx.Dir("disk1"), Config: Config{Allocation: 100}},
+		{Dir: ctx.Dir("disk2"), Config: Config{Allocation: 200}},
+	}
+	store, err := NewSpanningStore(dirs...)
+	require.NoError(t, err)
+
+	// the second directory has more space, then the first one
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), string(make([]byte, 150))))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "data"))
+
+	_, err = store.Stores()[1].Stat(ctx, ref("ns", "key1"))
+	require.NoError(t, err)
+	_, err = store.Stores()[0].Stat(ctx, ref("ns", "key2"))
+	require.NoError(t, err)
+
+	// overwriting stays in the same directory
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), string(make([]byte, 160))))
+	_, err = store.Stores()[0].Stat(ctx, ref("ns", "key1"))
+	require.True(t, ErrNotExist.Has(err))
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(164), used)
+
+	info, err := store.DiskInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(96+40), info.AvailableSpace)
+
+	var keys []string
+	require.NoError(t, store.WalkNamespace(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+		keys = append(keys, string(info.BlobRef().Key))
+		return nil
+	}))
+	require.ElementsMatch(t, []string{"key1", "key2"}, keys)
+
+	namespaces, err := store.ListNamespaces(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("ns")}, namespaces)
+
+	require.NoError(t, store.Close())
+
+	// the locations are kept over restarts
+	store, err = NewSpanningStore(dirs...)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	r, err := store.Open(ctx, ref("ns", "key2"))
+	require.NoError(t, err)
+	data, err := rall(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "data", string(data))
+
+	require.NoError(t, store.Trash(ctx, ref("ns", "key1"), time.Now()))
+	_, deleted, err := store.EmptyTrash(ctx, []byte("ns"), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("key1")}, deleted)
+
+	require.NoError(t, store.Delete(ctx, ref("ns", "key2")))
+	_, err = store.Stat(ctx, ref("ns", "key2"))
+	require.True(t, ErrNotExist.Has(err))
+	_, found, err := store.locate(ctx, ref("ns", "key1"))
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestSpanningStoreLostLocation(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewSpanningStore(SpanDir{Dir: ctx.Dir("disk1")}, SpanDir{Dir: ctx.Dir("disk2")})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	// a blob committed without its location, like before a crash
+	require.NoError(t, save(ctx, store.Stores()[1], ref("ns", "key"), "data"))
+
+	_, err = store.Stat(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	index, found, err := store.locate(ctx, ref("ns", "key"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, 1, index)
+}
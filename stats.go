@@ -0,0 +1,70 @@
+package badger
+
+import "go.uber.org/zap"
+
+// Stats describes the state of the underlying badger database.
+type Stats struct {
+	LSMSize  int64
+	VLogSize int64
+	Levels   []LevelStats
+
+	// Compactors is the number of concurrent compactions.
+	Compactors int
+	// PendingCompactions is the number of levels over their target size,
+	// which the compactors are working on.
+	PendingCompactions int
+
+	// Namespaces is the I/O per namespace since the store was opened.
+	Namespaces []NamespaceIO
+
+	// ValueLogFiles are the files of the value log, which shrink only when
+	// value log GC rewrites the ones with enough garbage.
+	ValueLogFiles []ValueLogFile
+
+	// ApproximateUsage is set after an unclean shutdown, until the usage
+	// counters of the namespaces changed before it are reconciled. They may
+	// miss the last changes until then.
+	ApproximateUsage bool
+}
+
+// LevelStats describes a level of the LSM tree.
+type LevelStats struct {
+	Level      int
+	Tables     int
+	Size       int64
+	TargetSize int64
+	// Score is the size of the level relative to its target; levels with a
+	// score of 1 or more are compacted.
+	Score float64
+}
+
+// Stats returns the current state of the badger database, like the
+// compaction activity, and the I/O of the namespaces.
+func (b *BlobStore) Stats() Stats {
+	stats := Stats{
+		Namespaces:       b.io.snapshot(),
+		ApproximateUsage: b.approximateUsage.Load(),
+	}
+	if db := badgerDB(b.db); db != nil {
+		stats.Compactors = db.Opts().NumCompactors
+	}
+	stats.LSMSize, stats.VLogSize = b.db.Size()
+	for _, level := range levels(b.db) {
+		stats.Levels = append(stats.Levels, LevelStats{
+			Level:      level.Level,
+			Tables:     level.NumTables,
+			Size:       level.Size,
+			TargetSize: level.TargetSize,
+			Score:      level.Score,
+		})
+		if level.Score >= 1 {
+			stats.PendingCompactions++
+		}
+	}
+	files, err := b.valueLogFiles()
+	if err != nil {
+		b.log.Warn("reading value log stats failed", zap.Error(err))
+	}
+	stats.ValueLogFiles = files
+	return stats
+}
@@ -0,0 +1,134 @@
+package badger
+
+import (
+	"encoding/binary"
+	"github.com/dgraph-io/badger/v4/options"
+	"github.com/stretchr/testify/require"
+	"os"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"testing"
+)
+
+func TestCompactionConfig(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{
+		Compactors:          2,
+		LevelBaseSize:       5 * memory.MiB,
+		LevelSizeMultiplier: 5,
+	})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+
+	stats := store.Stats()
+	require.Equal(t, 2, stats.Compactors)
+	require.Len(t, stats.Levels, 7)
+	require.Equal(t, 0, stats.Levels[0].Level)
+	require.Zero(t, stats.PendingCompactions)
+
+	for _, config := range []Config{
+		{Compactors: 1},
+		{LevelSizeMultiplier: 1},
+		{Level0Tables: 10, Level0TablesStall: 8},
+	} {
+		_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), config)
+		require.True(t, ErrConfig.Has(err), "%+v", config)
+	}
+}
+
+func TestMemTableConfig(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MemoryBudget: 40 * memory.MiB, MemTables: 4})
+	require.NoError(t, err)
+	options := badgerDB(store.db).Opts()
+	require.Equal(t, 4, options.NumMemtables)
+	require.Equal(t, (5 * memory.MiB).Int64(), options.MemTableSize)
+	require.Equal(t, (20 * memory.MiB).Int64(), options.BlockCacheSize)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.NoError(t, store.Close())
+
+	for _, config := range []Config{
+		{MemTables: 1},
+		{MemTableSize: 100 * memory.KiB},
+		{MemoryBudget: 2 * memory.MiB},
+		{MemoryBudget: 40 * memory.MiB, MemTableSize: 16 * memory.MiB},
+	} {
+		_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), config)
+		require.True(t, ErrConfig.Has(err), "%+v", config)
+	}
+}
+
+func TestTableCompression(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{TableCompression: "zstd:3"})
+	require.NoError(t, err)
+	require.Equal(t, options.ZSTD, badgerDB(store.db).Opts().Compression)
+	require.Equal(t, 3, badgerDB(store.db).Opts().ZSTDCompressionLevel)
+	require.NoError(t, save(ctx, store, ref("ns", "key"), "data"))
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStoreWithConfig(ctx.Dir("store"), Config{TableCompression: "none"})
+	require.NoError(t, err)
+	require.Equal(t, options.None, badgerDB(store.db).Opts().Compression)
+	data, err := store.ReadRange(ctx, ref("ns", "key"), 0, 4)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+	require.NoError(t, store.Close())
+
+	for _, spec := range []string{"lz4", "snappy:2", "zstd:0", "zstd:fast"} {
+		_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{TableCompression: spec})
+		require.True(t, ErrConfig.Has(err), spec)
+	}
+}
+
+func TestValueLogConfig(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{ValueLogFileSize: memory.MiB, ValueLogMaxEntries: 5000})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	require.Equal(t, uint32(5000), badgerDB(store.db).Opts().ValueLogMaxEntries)
+
+	data := string(testrand.BytesInt(512 * memory.KiB.Int()))
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		require.NoError(t, save(ctx, store, ref("ns", key), data))
+	}
+	files := store.Stats().ValueLogFiles
+	require.GreaterOrEqual(t, len(files), 2)
+	for _, file := range files {
+		require.Positive(t, file.Size)
+	}
+
+	for _, size := range []memory.Size{512 * memory.KiB, 2 * memory.GiB} {
+		_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{ValueLogFileSize: size})
+		require.True(t, ErrConfig.Has(err), size)
+	}
+}
+
+func TestReadDiscardStats(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	raw := make([]byte, 64)
+	binary.BigEndian.PutUint64(raw[0:], 1)
+	binary.BigEndian.PutUint64(raw[8:], 100)
+	binary.BigEndian.PutUint64(raw[16:], 3)
+	binary.BigEndian.PutUint64(raw[24:], 300)
+	// the slots after the first empty one are ignored
+	binary.BigEndian.PutUint64(raw[48:], 4)
+	path := ctx.File("DISCARD")
+	require.NoError(t, os.WriteFile(path, raw, 0600))
+
+	discardable, err := readDiscardStats(path)
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]int64{1: 100, 3: 300}, discardable)
+}
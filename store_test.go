@@ -24,133 +24,142 @@ const (
 	keySize       = 32
 )
 
-func TestStoreLoad(t *testing.T) {
-	const blobSize = 8 << 10
-	const repeatCount = 16
+// testEngines runs the test on a store of every engine.
+func testEngines(t *testing.T, test func(t *testing.T, engine string)) {
+	for _, engine := range []string{badger.EngineBadger, badger.EngineBolt} {
+		t.Run(engine, func(t *testing.T) { test(t, engine) })
+	}
+}
 
-	ctx := testcontext.New(t)
-	defer ctx.Cleanup()
+func TestStoreLoad(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		const blobSize = 8 << 10
+		const repeatCount = 16
 
-	store, err := badger.NewBlobStore(ctx.Dir("store"))
-	require.NoError(t, err)
-	defer ctx.Check(store.Close)
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
 
-	data := testrand.Bytes(blobSize)
-	temp := make([]byte, len(data))
+		store, err := badger.NewBlobStoreWithConfig(ctx.Dir("store"), badger.Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
 
-	refs := []blobstore.BlobRef{}
+		data := testrand.Bytes(blobSize)
+		temp := make([]byte, len(data))
 
-	namespace := testrand.Bytes(32)
+		refs := []blobstore.BlobRef{}
 
-	// store without size
-	for i := 0; i < repeatCount; i++ {
-		ref := blobstore.BlobRef{
-			Namespace: namespace,
-			Key:       testrand.Bytes(32),
-		}
-		refs = append(refs, ref)
+		namespace := testrand.Bytes(32)
 
-		writer, err := store.Create(ctx, ref)
-		require.NoError(t, err)
+		// store without size
+		for i := 0; i < repeatCount; i++ {
+			ref := blobstore.BlobRef{
+				Namespace: namespace,
+				Key:       testrand.Bytes(32),
+			}
+			refs = append(refs, ref)
 
-		n, err := writer.Write(data)
-		require.NoError(t, err)
-		require.Equal(t, n, len(data))
+			writer, err := store.Create(ctx, ref)
+			require.NoError(t, err)
 
-		require.NoError(t, writer.Commit(ctx))
-		// after committing we should be able to call cancel without an error
-		require.NoError(t, writer.Cancel(ctx))
-		// two commits should fail
-		require.Error(t, writer.Commit(ctx))
-	}
+			n, err := writer.Write(data)
+			require.NoError(t, err)
+			require.Equal(t, n, len(data))
 
-	namespace = testrand.Bytes(32)
-	// store with size
-	for i := 0; i < repeatCount; i++ {
-		ref := blobstore.BlobRef{
-			Namespace: namespace,
-			Key:       testrand.Bytes(32),
+			require.NoError(t, writer.Commit(ctx))
+			// after committing we should be able to call cancel without an error
+			require.NoError(t, writer.Cancel(ctx))
+			// two commits should fail
+			require.Error(t, writer.Commit(ctx))
 		}
-		refs = append(refs, ref)
 
-		writer, err := store.Create(ctx, ref)
-		require.NoError(t, err)
+		namespace = testrand.Bytes(32)
+		// store with size
+		for i := 0; i < repeatCount; i++ {
+			ref := blobstore.BlobRef{
+				Namespace: namespace,
+				Key:       testrand.Bytes(32),
+			}
+			refs = append(refs, ref)
 
-		n, err := writer.Write(data)
-		require.NoError(t, err)
-		require.Equal(t, n, len(data))
+			writer, err := store.Create(ctx, ref)
+			require.NoError(t, err)
 
-		require.NoError(t, writer.Commit(ctx))
-	}
+			n, err := writer.Write(data)
+			require.NoError(t, err)
+			require.Equal(t, n, len(data))
 
-	namespace = testrand.Bytes(32)
-	// store with larger size
-	{
-		ref := blobstore.BlobRef{
-			Namespace: namespace,
-			Key:       testrand.Bytes(32),
+			require.NoError(t, writer.Commit(ctx))
 		}
-		refs = append(refs, ref)
 
-		writer, err := store.Create(ctx, ref)
-		require.NoError(t, err)
+		namespace = testrand.Bytes(32)
+		// store with larger size
+		{
+			ref := blobstore.BlobRef{
+				Namespace: namespace,
+				Key:       testrand.Bytes(32),
+			}
+			refs = append(refs, ref)
 
-		n, err := writer.Write(data)
-		require.NoError(t, err)
-		require.Equal(t, n, len(data))
+			writer, err := store.Create(ctx, ref)
+			require.NoError(t, err)
 
-		require.NoError(t, writer.Commit(ctx))
-	}
+			n, err := writer.Write(data)
+			require.NoError(t, err)
+			require.Equal(t, n, len(data))
 
-	namespace = testrand.Bytes(32)
-	// store with error
-	{
-		ref := blobstore.BlobRef{
-			Namespace: namespace,
-			Key:       testrand.Bytes(32),
+			require.NoError(t, writer.Commit(ctx))
 		}
 
-		writer, err := store.Create(ctx, ref)
-		require.NoError(t, err)
+		namespace = testrand.Bytes(32)
+		// store with error
+		{
+			ref := blobstore.BlobRef{
+				Namespace: namespace,
+				Key:       testrand.Bytes(32),
+			}
 
-		n, err := writer.Write(data)
-		require.NoError(t, err)
-		require.Equal(t, n, len(data))
+			writer, err := store.Create(ctx, ref)
+			require.NoError(t, err)
 
-		require.NoError(t, writer.Cancel(ctx))
-		// commit after cancel should return an error
-		require.Error(t, writer.Commit(ctx))
+			n, err := writer.Write(data)
+			require.NoError(t, err)
+			require.Equal(t, n, len(data))
 
-		_, err = store.Open(ctx, ref)
-		require.Error(t, err)
-	}
+			require.NoError(t, writer.Cancel(ctx))
+			// commit after cancel should return an error
+			require.Error(t, writer.Commit(ctx))
 
-	// try reading all the blobs
-	for _, ref := range refs {
-		reader, err := store.Open(ctx, ref)
-		require.NoError(t, err)
+			_, err = store.Open(ctx, ref)
+			require.Error(t, err)
+		}
 
-		size, err := reader.Size()
-		require.NoError(t, err)
-		require.Equal(t, size, int64(len(data)))
+		// try reading all the blobs
+		for _, ref := range refs {
+			reader, err := store.Open(ctx, ref)
+			require.NoError(t, err)
 
-		_, err = io.ReadFull(reader, temp)
-		require.NoError(t, err)
+			size, err := reader.Size()
+			require.NoError(t, err)
+			require.Equal(t, size, int64(len(data)))
 
-		require.NoError(t, reader.Close())
-		require.Equal(t, data, temp)
-	}
+			_, err = io.ReadFull(reader, temp)
+			require.NoError(t, err)
 
-	// delete the blobs
-	for _, ref := range refs {
-		err := store.Delete(ctx, ref)
-		require.NoError(t, err)
-	}
-	// try reading all the blobs
-	for _, ref := range refs {
-		_, err := store.Open(ctx, ref)
-		require.Error(t, err)
-	}
+			require.NoError(t, reader.Close())
+			require.Equal(t, data, temp)
+		}
+
+		// delete the blobs
+		for _, ref := range refs {
+			err := store.Delete(ctx, ref)
+			require.NoError(t, err)
+		}
+		// try reading all the blobs
+		for _, ref := range refs {
+			_, err := store.Open(ctx, ref)
+			require.Error(t, err)
+		}
+	})
 }
 
 //	func TestDeleteWhileReading(t *testing.T) {
@@ -402,125 +411,127 @@ func TestStoreLoad(t *testing.T) {
 //
 // Check that ListNamespaces and WalkNamespace work as expected.
 func TestStoreTraversals(t *testing.T) {
-	ctx := testcontext.New(t)
-	defer ctx.Cleanup()
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
 
-	store, err := badger.NewBlobStore(ctx.Dir("store"))
-	require.NoError(t, err)
-	defer ctx.Check(store.Close)
+		store, err := badger.NewBlobStoreWithConfig(ctx.Dir("store"), badger.Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
 
-	// invent some namespaces and store stuff in them
-	type namespaceWithBlobs struct {
-		namespace []byte
-		blobs     []blobstore.BlobRef
-	}
-	const numNamespaces = 4
-	recordsToInsert := make([]namespaceWithBlobs, numNamespaces)
-
-	var namespaceBase = testrand.Bytes(namespaceSize)
-	for i := range recordsToInsert {
-		// give each namespace a similar ID but modified in the last byte to distinguish
-		recordsToInsert[i].namespace = make([]byte, len(namespaceBase))
-		copy(recordsToInsert[i].namespace, namespaceBase)
-		recordsToInsert[i].namespace[len(namespaceBase)-1] = byte(i)
-
-		// put varying numbers of blobs in the namespaces
-		recordsToInsert[i].blobs = make([]blobstore.BlobRef, i+1)
-		for j := range recordsToInsert[i].blobs {
-			recordsToInsert[i].blobs[j] = blobstore.BlobRef{
-				Namespace: recordsToInsert[i].namespace,
-				Key:       testrand.Bytes(keySize),
+		// invent some namespaces and store stuff in them
+		type namespaceWithBlobs struct {
+			namespace []byte
+			blobs     []blobstore.BlobRef
+		}
+		const numNamespaces = 4
+		recordsToInsert := make([]namespaceWithBlobs, numNamespaces)
+
+		var namespaceBase = testrand.Bytes(namespaceSize)
+		for i := range recordsToInsert {
+			// give each namespace a similar ID but modified in the last byte to distinguish
+			recordsToInsert[i].namespace = make([]byte, len(namespaceBase))
+			copy(recordsToInsert[i].namespace, namespaceBase)
+			recordsToInsert[i].namespace[len(namespaceBase)-1] = byte(i)
+
+			// put varying numbers of blobs in the namespaces
+			recordsToInsert[i].blobs = make([]blobstore.BlobRef, i+1)
+			for j := range recordsToInsert[i].blobs {
+				recordsToInsert[i].blobs[j] = blobstore.BlobRef{
+					Namespace: recordsToInsert[i].namespace,
+					Key:       testrand.Bytes(keySize),
+				}
+				blobWriter, err := store.Create(ctx, recordsToInsert[i].blobs[j])
+				require.NoError(t, err)
+				// also vary the sizes of the blobs so we can check Stat results
+				_, err = blobWriter.Write(testrand.Bytes(memory.Size(j)))
+				require.NoError(t, err)
+				err = blobWriter.Commit(ctx)
+				require.NoError(t, err)
 			}
-			blobWriter, err := store.Create(ctx, recordsToInsert[i].blobs[j])
-			require.NoError(t, err)
-			// also vary the sizes of the blobs so we can check Stat results
-			_, err = blobWriter.Write(testrand.Bytes(memory.Size(j)))
-			require.NoError(t, err)
-			err = blobWriter.Commit(ctx)
-			require.NoError(t, err)
 		}
-	}
 
-	// test ListNamespaces
-	gotNamespaces, err := store.ListNamespaces(ctx)
-	require.NoError(t, err)
-	sort.Slice(gotNamespaces, func(i, j int) bool {
-		return bytes.Compare(gotNamespaces[i], gotNamespaces[j]) < 0
-	})
-	sort.Slice(recordsToInsert, func(i, j int) bool {
-		return bytes.Compare(recordsToInsert[i].namespace, recordsToInsert[j].namespace) < 0
-	})
-	for i, expected := range recordsToInsert {
-		require.Equalf(t, expected.namespace, gotNamespaces[i], "mismatch at index %d: recordsToInsert is %+v and gotNamespaces is %v", i, recordsToInsert, gotNamespaces)
-	}
+		// test ListNamespaces
+		gotNamespaces, err := store.ListNamespaces(ctx)
+		require.NoError(t, err)
+		sort.Slice(gotNamespaces, func(i, j int) bool {
+			return bytes.Compare(gotNamespaces[i], gotNamespaces[j]) < 0
+		})
+		sort.Slice(recordsToInsert, func(i, j int) bool {
+			return bytes.Compare(recordsToInsert[i].namespace, recordsToInsert[j].namespace) < 0
+		})
+		for i, expected := range recordsToInsert {
+			require.Equalf(t, expected.namespace, gotNamespaces[i], "mismatch at index %d: recordsToInsert is %+v and gotNamespaces is %v", i, recordsToInsert, gotNamespaces)
+		}
 
-	// test WalkNamespace
-	for _, expected := range recordsToInsert {
-		// this isn't strictly necessary, since the function closure below is not persisted
-		// past the end of a loop iteration, but this keeps the linter from complaining.
-		expected := expected
-
-		// keep track of which blobs we visit with WalkNamespace
-		found := make([]bool, len(expected.blobs))
-
-		err = store.WalkNamespace(ctx, expected.namespace, "", func(info blobstore.BlobInfo) error {
-			gotBlobRef := info.BlobRef()
-			assert.Equal(t, expected.namespace, gotBlobRef.Namespace)
-			// find which blob this is in expected.blobs
-			blobIdentified := -1
-			for i, expectedBlobRef := range expected.blobs {
-				if bytes.Equal(gotBlobRef.Key, expectedBlobRef.Key) {
-					found[i] = true
-					blobIdentified = i
+		// test WalkNamespace
+		for _, expected := range recordsToInsert {
+			// this isn't strictly necessary, since the function closure below is not persisted
+			// past the end of a loop iteration, but this keeps the linter from complaining.
+			expected := expected
+
+			// keep track of which blobs we visit with WalkNamespace
+			found := make([]bool, len(expected.blobs))
+
+			err = store.WalkNamespace(ctx, expected.namespace, "", func(info blobstore.BlobInfo) error {
+				gotBlobRef := info.BlobRef()
+				assert.Equal(t, expected.namespace, gotBlobRef.Namespace)
+				// find which blob this is in expected.blobs
+				blobIdentified := -1
+				for i, expectedBlobRef := range expected.blobs {
+					if bytes.Equal(gotBlobRef.Key, expectedBlobRef.Key) {
+						found[i] = true
+						blobIdentified = i
+					}
 				}
+				// make sure this is a blob we actually put in
+				require.NotEqualf(t, -1, blobIdentified,
+					"WalkNamespace gave BlobRef %v, but I don't remember storing that",
+					gotBlobRef)
+
+				// check BlobInfo sanity
+				stat, err := info.Stat(ctx)
+				require.NoError(t, err)
+				//nameFromStat := stat.Name()
+				//fullPath, err := info.FullPath(ctx)
+				//require.NoError(t, err)
+				//basePath := filepath.Base(fullPath)
+				//assert.Equal(t, nameFromStat, basePath)
+				assert.Equal(t, int64(blobIdentified), stat.Size())
+				return nil
+			})
+			require.NoError(t, err)
+
+			// make sure all blobs were visited
+			for i := range found {
+				assert.True(t, found[i],
+					"WalkNamespace never yielded blob at index %d: %v",
+					i, expected.blobs[i])
 			}
-			// make sure this is a blob we actually put in
-			require.NotEqualf(t, -1, blobIdentified,
-				"WalkNamespace gave BlobRef %v, but I don't remember storing that",
-				gotBlobRef)
+		}
 
-			// check BlobInfo sanity
-			stat, err := info.Stat(ctx)
-			require.NoError(t, err)
-			//nameFromStat := stat.Name()
-			//fullPath, err := info.FullPath(ctx)
-			//require.NoError(t, err)
-			//basePath := filepath.Base(fullPath)
-			//assert.Equal(t, nameFromStat, basePath)
-			assert.Equal(t, int64(blobIdentified), stat.Size())
+		// test WalkNamespace on a nonexistent namespace also
+		namespaceBase[len(namespaceBase)-1] = byte(numNamespaces)
+		err = store.WalkNamespace(ctx, namespaceBase, "", func(_ blobstore.BlobInfo) error {
+			t.Fatal("this should not have been called")
 			return nil
 		})
 		require.NoError(t, err)
 
-		// make sure all blobs were visited
-		for i := range found {
-			assert.True(t, found[i],
-				"WalkNamespace never yielded blob at index %d: %v",
-				i, expected.blobs[i])
-		}
-	}
-
-	// test WalkNamespace on a nonexistent namespace also
-	namespaceBase[len(namespaceBase)-1] = byte(numNamespaces)
-	err = store.WalkNamespace(ctx, namespaceBase, "", func(_ blobstore.BlobInfo) error {
-		t.Fatal("this should not have been called")
-		return nil
-	})
-	require.NoError(t, err)
-
-	// check that WalkNamespace stops iterating after an error return
-	iterations := 0
-	expectedErr := errs.New("an expected error")
-	err = store.WalkNamespace(ctx, recordsToInsert[numNamespaces-1].namespace, "", func(_ blobstore.BlobInfo) error {
-		iterations++
-		if iterations == 2 {
-			return expectedErr
-		}
-		return nil
+		// check that WalkNamespace stops iterating after an error return
+		iterations := 0
+		expectedErr := errs.New("an expected error")
+		err = store.WalkNamespace(ctx, recordsToInsert[numNamespaces-1].namespace, "", func(_ blobstore.BlobInfo) error {
+			iterations++
+			if iterations == 2 {
+				return expectedErr
+			}
+			return nil
+		})
+		assert.Error(t, err)
+		assert.Equal(t, err, expectedErr)
+		assert.Equal(t, 2, iterations)
 	})
-	assert.Error(t, err)
-	assert.Equal(t, err, expectedErr)
-	assert.Equal(t, 2, iterations)
 }
 
 //
@@ -819,44 +830,46 @@ func TestStoreTraversals(t *testing.T) {
 // TestBlobMemoryBuffer ensures that buffering doesn't have problems with
 // small writes randomly seeked through the file.
 func TestBlobMemoryBuffer(t *testing.T) {
-	ctx := testcontext.New(t)
-	defer ctx.Cleanup()
-
-	const size = 2048
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
 
-	store, err := badger.NewBlobStore(ctx.Dir("store"))
-	require.NoError(t, err)
-	defer ctx.Check(store.Close)
+		const size = 2048
 
-	ref := blobstore.BlobRef{
-		Namespace: testrand.Bytes(32),
-		Key:       testrand.Bytes(32),
-	}
+		store, err := badger.NewBlobStoreWithConfig(ctx.Dir("store"), badger.Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
 
-	writer, err := store.Create(ctx, ref)
-	require.NoError(t, err)
+		ref := blobstore.BlobRef{
+			Namespace: testrand.Bytes(32),
+			Key:       testrand.Bytes(32),
+		}
 
-	for _, v := range rand.Perm(size) {
-		_, err := writer.Seek(int64(v), io.SeekStart)
-		require.NoError(t, err)
-		n, err := writer.Write([]byte{byte(v)})
+		writer, err := store.Create(ctx, ref)
 		require.NoError(t, err)
-		require.Equal(t, n, 1)
-	}
 
-	_, err = writer.Seek(size, io.SeekStart)
-	require.NoError(t, err)
+		for _, v := range rand.Perm(size) {
+			_, err := writer.Seek(int64(v), io.SeekStart)
+			require.NoError(t, err)
+			n, err := writer.Write([]byte{byte(v)})
+			require.NoError(t, err)
+			require.Equal(t, n, 1)
+		}
 
-	require.NoError(t, writer.Commit(ctx))
+		_, err = writer.Seek(size, io.SeekStart)
+		require.NoError(t, err)
 
-	reader, err := store.Open(ctx, ref)
-	require.NoError(t, err)
+		require.NoError(t, writer.Commit(ctx))
 
-	buf, err := io.ReadAll(reader)
-	require.NoError(t, err)
+		reader, err := store.Open(ctx, ref)
+		require.NoError(t, err)
 
-	for i := range buf {
-		require.Equal(t, byte(i), buf[i])
-	}
-	require.Equal(t, size, len(buf))
+		buf, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		for i := range buf {
+			require.Equal(t, byte(i), buf[i])
+		}
+		require.Equal(t, size, len(buf))
+	})
 }
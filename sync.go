@@ -0,0 +1,435 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/gob"
+	"errors"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"hash"
+	"io"
+	"net"
+	"storj.io/common/identity"
+	"storj.io/common/peertls/tlsopts"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"sync"
+	"time"
+)
+
+// ErrSync is returned when synchronizing stores fails.
+var ErrSync = errs.Class("sync")
+
+// syncBuckets is the number of key ranges compared by hash per namespace.
+// The ranges are defined by the first byte of the blob keys.
+const syncBuckets = 256
+
+// syncFetchSize is the number of blobs requested at once.
+var syncFetchSize = 100
+
+// Operations of the sync protocol.
+const (
+	syncNamespaces = "namespaces"
+	syncHashes     = "hashes"
+	syncKeys       = "keys"
+	syncBlobs      = "blobs"
+)
+
+// syncRequest is sent by the client, the server answers with a syncResponse,
+// or with a syncBlob per requested key for syncBlobs.
+type syncRequest struct {
+	Op        string
+	Namespace []byte
+	Bucket    int
+	Keys      [][]byte
+}
+
+type syncResponse struct {
+	Error      string
+	Namespaces [][]byte
+	Hashes     [][]byte
+	Entries    []syncEntry
+}
+
+// syncEntry is the version of a blob.
+type syncEntry struct {
+	Key     []byte
+	ModTime int64
+	Size    int64
+	Format  blobstore.FormatVersion
+}
+
+type syncBlob struct {
+	Error string
+	Entry syncEntry
+	Found bool
+	Data  []byte
+}
+
+// SyncStats counts the work done by Sync.
+type SyncStats struct {
+	Namespaces int64
+	// Buckets is the number of key ranges which differed.
+	Buckets int64
+	Blobs   int64
+	Bytes   int64
+}
+
+// SyncServerTLS returns the TLS config of ServeSync with the node identity.
+// Only the clients with the identities of the given nodes are served.
+func SyncServerTLS(ident *identity.FullIdentity, clients ...storj.NodeID) (*tls.Config, error) {
+	if len(clients) == 0 {
+		return nil, ErrSync.New("no clients allowed")
+	}
+	options, err := syncTLSOptions(ident)
+	if err != nil {
+		return nil, err
+	}
+	options.VerificationFuncs.ServerAdd(func(_ [][]byte, chains [][]*x509.Certificate) error {
+		peer, err := identity.PeerIdentityFromChain(chains[0])
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			if peer.ID == client {
+				return nil
+			}
+		}
+		return ErrSync.New("client %s isn't allowed", peer.ID)
+	})
+	return options.ServerTLSConfig(), nil
+}
+
+// SyncClientTLS returns the TLS config of Sync with the node identity, which
+// connects only to the server with the identity of the given node.
+func SyncClientTLS(ident *identity.FullIdentity, server storj.NodeID) (*tls.Config, error) {
+	options, err := syncTLSOptions(ident)
+	if err != nil {
+		return nil, err
+	}
+	return options.ClientTLSConfig(server), nil
+}
+
+func syncTLSOptions(ident *identity.FullIdentity) (*tlsopts.Options, error) {
+	options, err := tlsopts.NewOptions(ident, tlsopts.Config{PeerIDVersions: "latest"}, nil)
+	return options, ErrSync.Wrap(err)
+}
+
+// ServeSync serves the blobs of the store to Sync clients connecting to the
+// listener, until ctx is canceled. The connections use TLS with the config of
+// SyncServerTLS, so both sides are authenticated by their node identities.
+func (b *BlobStore) ServeSync(ctx context.Context, listener net.Listener, config *tls.Config) error {
+	if config == nil {
+		return ErrSync.New("missing TLS config")
+	}
+	listener = tls.NewListener(listener, config)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return ErrSync.Wrap(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer closeOnDone(ctx, conn)()
+			if err := b.serveSyncConn(conn); err != nil && ctx.Err() == nil {
+				b.log.Warn("serving sync failed", zap.Stringer("remote", conn.RemoteAddr()), zap.Error(err))
+			}
+		}()
+	}
+}
+
+func (b *BlobStore) serveSyncConn(conn net.Conn) error {
+	decoder, encoder := gob.NewDecoder(conn), gob.NewEncoder(conn)
+	for {
+		var request syncRequest
+		if err := decoder.Decode(&request); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return ErrSync.Wrap(err)
+		}
+		if request.Op == syncBlobs {
+			if err := b.sendBlobs(encoder, request); err != nil {
+				return err
+			}
+			continue
+		}
+		var response syncResponse
+		var err error
+		switch request.Op {
+		case syncNamespaces:
+			response.Namespaces, err = listNamespaces(b.db)
+		case syncHashes:
+			response.Hashes, err = b.syncHashes(request.Namespace)
+		case syncKeys:
+			response.Entries, err = b.syncEntries(request.Namespace, request.Bucket)
+		default:
+			err = ErrSync.New("unknown operation %q", request.Op)
+		}
+		if err != nil {
+			response = syncResponse{Error: err.Error()}
+		}
+		if err := encoder.Encode(response); err != nil {
+			return ErrSync.Wrap(err)
+		}
+	}
+}
+
+func (b *BlobStore) sendBlobs(encoder *gob.Encoder, request syncRequest) error {
+	for _, key := range request.Keys {
+		var blob syncBlob
+		err := b.db.View(func(txn kvTxn) error {
+			versions := blobVersions(txn, keyPrefix(blobstore.BlobRef{Namespace: request.Namespace, Key: key}))
+			if len(versions) == 0 {
+				return nil
+			}
+			item, err := txn.Get(versions[0].key)
+			if err != nil {
+				return errs.Wrap(err)
+			}
+			blob.Entry = newSyncEntry(key, versions[0].key, item.UserMeta())
+			blob.Found = true
+			return blobValue(txn, item, func(val []byte) error {
+				blob.Data = append([]byte(nil), val...)
+				return nil
+			})
+		})
+		if err != nil {
+			blob = syncBlob{Error: err.Error()}
+		}
+		if err := encoder.Encode(blob); err != nil {
+			return ErrSync.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// syncHashes returns the hash of the blob versions of every bucket of the
+// namespace, read in one transaction.
+func (b *BlobStore) syncHashes(namespace []byte) ([][]byte, error) {
+	hashers := make([]hash.Hash, syncBuckets)
+	for bucket := range hashers {
+		hashers[bucket] = sha256.New()
+	}
+	err := b.syncWalk(namespace, nil, func(entry syncEntry) error {
+		if len(entry.Key) == 0 {
+			return nil
+		}
+		return errs.Wrap(gob.NewEncoder(hashers[entry.Key[0]]).Encode(entry))
+	})
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, syncBuckets)
+	for bucket, h := range hashers {
+		hashes[bucket] = h.Sum(nil)
+	}
+	return hashes, nil
+}
+
+// syncEntries returns the blob versions of the bucket of the namespace.
+func (b *BlobStore) syncEntries(namespace []byte, bucket int) (entries []syncEntry, err error) {
+	err = b.syncWalk(namespace, []byte{byte(bucket)}, func(entry syncEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// syncWalk calls fn with the blob versions of the namespace having the key
+// prefix, in key order.
+func (b *BlobStore) syncWalk(namespace []byte, keyPrefix []byte, fn func(syncEntry) error) error {
+	prefix := concat(blobPrefix, namespace)
+	return b.db.View(func(txn kvTxn) error {
+		namespaces := readNamespaces(txn)
+		it := txn.NewIterator(b.iteratorOptions(IteratorWalk, concat(prefix, keyPrefix)))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			ref, ok := splitKey(namespaces, item.Key()[len(blobPrefix):])
+			// namespaces having this one as prefix share the key prefix
+			if !ok || !bytesEq(ref.Namespace, namespace) {
+				continue
+			}
+			if err := fn(newSyncEntry(ref.Key, item.Key(), item.UserMeta())); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e syncEntry) sameVersion(other syncEntry) bool {
+	return e.ModTime == other.ModTime && e.Size == other.Size && e.Format == other.Format
+}
+
+func newSyncEntry(blobKey []byte, key []byte, userMeta byte) syncEntry {
+	modTime, size := stat(key)
+	return syncEntry{Key: blobKey, ModTime: modTime.Unix(), Size: int64(size), Format: storedFormat(userMeta)}
+}
+
+// Sync copies the blobs which are missing or differ from the store served
+// with ServeSync at the remote address. The namespaces are compared by the
+// hashes of key ranges first, so only the keys of the differing ranges are
+// listed. Blobs missing from the remote store are kept, and the trash isn't
+// synchronized. The connection uses TLS with the config of SyncClientTLS.
+func (b *BlobStore) Sync(ctx context.Context, remote string, config *tls.Config) (stats SyncStats, err error) {
+	if config == nil {
+		return stats, ErrSync.New("missing TLS config")
+	}
+	dialer := tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", remote)
+	if err != nil {
+		return stats, ErrSync.Wrap(err)
+	}
+	defer closeOnDone(ctx, conn)()
+
+	client := &syncClient{decoder: gob.NewDecoder(conn), encoder: gob.NewEncoder(conn)}
+	response, err := client.call(syncRequest{Op: syncNamespaces})
+	if err != nil {
+		return stats, err
+	}
+	for _, namespace := range response.Namespaces {
+		if err := b.syncNamespace(ctx, client, namespace, &stats); err != nil {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			return stats, err
+		}
+		stats.Namespaces++
+	}
+	return stats, nil
+}
+
+func (b *BlobStore) syncNamespace(ctx context.Context, client *syncClient, namespace []byte, stats *SyncStats) error {
+	remote, err := client.call(syncRequest{Op: syncHashes, Namespace: namespace})
+	if err != nil {
+		return err
+	}
+	local, err := b.syncHashes(namespace)
+	if err != nil {
+		return err
+	}
+	if len(remote.Hashes) != syncBuckets {
+		return ErrSync.New("got %d hashes instead of %d", len(remote.Hashes), syncBuckets)
+	}
+	for bucket := 0; bucket < syncBuckets; bucket++ {
+		if bytes.Equal(remote.Hashes[bucket], local[bucket]) {
+			continue
+		}
+		stats.Buckets++
+		remoteEntries, err := client.call(syncRequest{Op: syncKeys, Namespace: namespace, Bucket: bucket})
+		if err != nil {
+			return err
+		}
+		localEntries, err := b.syncEntries(namespace, bucket)
+		if err != nil {
+			return err
+		}
+		have := map[string]syncEntry{}
+		for _, entry := range localEntries {
+			have[string(entry.Key)] = entry
+		}
+		var missing [][]byte
+		for _, entry := range remoteEntries.Entries {
+			if current, ok := have[string(entry.Key)]; !ok || !current.sameVersion(entry) {
+				missing = append(missing, entry.Key)
+			}
+		}
+		for len(missing) > 0 {
+			n := len(missing)
+			if n > syncFetchSize {
+				n = syncFetchSize
+			}
+			if err := b.fetchBlobs(ctx, client, namespace, missing[:n], stats); err != nil {
+				return err
+			}
+			missing = missing[n:]
+		}
+	}
+	return nil
+}
+
+func (b *BlobStore) fetchBlobs(ctx context.Context, client *syncClient, namespace []byte, keys [][]byte, stats *SyncStats) error {
+	if err := client.encoder.Encode(syncRequest{Op: syncBlobs, Namespace: namespace, Keys: keys}); err != nil {
+		return ErrSync.Wrap(err)
+	}
+	for range keys {
+		var blob syncBlob
+		if err := client.decoder.Decode(&blob); err != nil {
+			return ErrSync.Wrap(err)
+		}
+		if blob.Error != "" {
+			return ErrSync.New("remote: %s", blob.Error)
+		}
+		// deleted since it was listed
+		if !blob.Found {
+			continue
+		}
+		if err := b.storeSynced(ctx, blobstore.BlobRef{Namespace: namespace, Key: blob.Entry.Key}, blob); err != nil {
+			return err
+		}
+		stats.Blobs++
+		stats.Bytes += int64(len(blob.Data))
+	}
+	return nil
+}
+
+func (b *BlobStore) storeSynced(ctx context.Context, ref blobstore.BlobRef, blob syncBlob) (err error) {
+	w, err := b.CreateWithStorageFormat(ctx, ref, blob.Entry.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(blob.Data); err != nil {
+		return errs.Combine(err, w.Cancel(ctx))
+	}
+	return w.(ModTimeCommitter).CommitWithModTime(ctx, time.Unix(blob.Entry.ModTime, 0))
+}
+
+type syncClient struct {
+	decoder *gob.Decoder
+	encoder *gob.Encoder
+}
+
+func (c *syncClient) call(request syncRequest) (response syncResponse, err error) {
+	if err := c.encoder.Encode(request); err != nil {
+		return response, ErrSync.Wrap(err)
+	}
+	if err := c.decoder.Decode(&response); err != nil {
+		return response, ErrSync.Wrap(err)
+	}
+	if response.Error != "" {
+		return response, ErrSync.New("remote: %s", response.Error)
+	}
+	return response, nil
+}
+
+// closeOnDone closes conn when ctx is done, interrupting the blocked reads
+// and writes, or when the returned function is called.
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"storj.io/common/identity"
+	"storj.io/common/identity/testidentity"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	source, err := NewBlobStore(ctx.Dir("source"))
+	require.NoError(t, err)
+	defer ctx.Check(source.Close)
+	target, err := NewBlobStore(ctx.Dir("target"))
+	require.NoError(t, err)
+	defer ctx.Check(target.Close)
+
+	modTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	source.SetClock(fixedClock(modTime))
+	target.SetClock(fixedClock(modTime))
+
+	for _, key := range []string{"a1", "a2", "b1", "c1"} {
+		require.NoError(t, save(ctx, source, ref("ns", key), "data of "+key))
+	}
+	require.NoError(t, save(ctx, source, ref("ns1", "a1"), "other namespace"))
+	w, err := source.TestCreateV0(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("v0 piece"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+
+	// the target has some blobs already, one of them changed and one deleted since
+	require.NoError(t, save(ctx, target, ref("ns", "a1"), "data of a1"))
+	require.NoError(t, save(ctx, target, ref("ns", "b1"), "stale"))
+	require.NoError(t, save(ctx, target, ref("ns", "d1"), "deleted on source"))
+
+	server, client, stranger := syncIdentity(0), syncIdentity(1), syncIdentity(2)
+	serverTLS, err := SyncServerTLS(server, client.ID)
+	require.NoError(t, err)
+	clientTLS, err := SyncClientTLS(client, server.ID)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveCtx, cancel := context.WithCancel(ctx)
+	ctx.Go(func() error { return source.ServeSync(serveCtx, listener, serverTLS) })
+	defer cancel()
+
+	// only the allowed clients are served, and only by the expected server
+	strangerTLS, err := SyncClientTLS(stranger, server.ID)
+	require.NoError(t, err)
+	_, err = target.Sync(ctx, listener.Addr().String(), strangerTLS)
+	require.Error(t, err)
+	impostorTLS, err := SyncClientTLS(client, stranger.ID)
+	require.NoError(t, err)
+	_, err = target.Sync(ctx, listener.Addr().String(), impostorTLS)
+	require.Error(t, err)
+	_, err = target.Sync(ctx, listener.Addr().String(), nil)
+	require.True(t, ErrSync.Has(err))
+
+	stats, err := target.Sync(ctx, listener.Addr().String(), clientTLS)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Namespaces)
+	require.Equal(t, int64(5), stats.Blobs)
+	require.Equal(t, int64(6), stats.Buckets)
+
+	for ns, blobs := range map[string]map[string]string{
+		"ns":  {"a1": "data of a1", "a2": "data of a2", "b1": "data of b1", "c1": "data of c1", "v0": "v0 piece", "d1": "deleted on source"},
+		"ns1": {"a1": "other namespace"},
+	} {
+		for key, expected := range blobs {
+			reader, err := target.Open(ctx, ref(ns, key))
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, expected, string(data))
+			require.NoError(t, reader.Close())
+		}
+	}
+	info, err := target.Stat(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	require.Equal(t, filestore.FormatV0, info.StorageFormatVersion())
+
+	// nothing is transferred once the stores match
+	require.NoError(t, target.Delete(ctx, ref("ns", "d1")))
+	stats, err = target.Sync(ctx, listener.Addr().String(), clientTLS)
+	require.NoError(t, err)
+	require.Equal(t, SyncStats{Namespaces: 2}, stats)
+}
+
+func syncIdentity(index int) *identity.FullIdentity {
+	return testidentity.MustPregeneratedIdentity(index, storj.LatestIDVersion())
+}
@@ -0,0 +1,195 @@
+package badger
+
+import (
+	"archive/tar"
+	"context"
+	"github.com/zeebo/errs"
+	"io"
+	"path"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"strings"
+)
+
+// ErrTar is returned for tar archives which can't be read.
+var ErrTar = errs.Class("tar archive")
+
+// v1PieceFileSuffix is the file name suffix of FormatV1 pieces in the
+// filestore, FormatV0 pieces have none.
+const v1PieceFileSuffix = ".sj1"
+
+// ExportStats counts the blobs written to a tar archive.
+type ExportStats struct {
+	Blobs int64
+	Bytes int64
+}
+
+// ExportTar writes the blobs of the namespace, or of all namespaces if it's
+// nil, to a tar stream in the filestore layout read by ImportTar. The blobs
+// are read from a single snapshot of the store. The trash isn't exported.
+func (b *BlobStore) ExportTar(ctx context.Context, w io.Writer, namespace []byte) (stats ExportStats, err error) {
+	archive := tar.NewWriter(w)
+	err = b.db.View(func(txn kvTxn) error {
+		namespaces := readNamespaces(txn)
+		it := txn.NewIterator(b.iteratorOptions(IteratorCopy, concat(blobPrefix, namespace)))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			ref, ok := splitKey(namespaces, item.Key()[len(blobPrefix):])
+			// namespaces having this one as prefix share the key prefix
+			if !ok || (namespace != nil && !bytesEq(ref.Namespace, namespace)) {
+				continue
+			}
+			modTime, size := stat(item.Key())
+			header := &tar.Header{
+				Name:     piecePath(ref, storedFormat(item.UserMeta())),
+				Typeflag: tar.TypeReg,
+				Mode:     0644,
+				Size:     int64(size),
+				ModTime:  modTime,
+			}
+			if err := archive.WriteHeader(header); err != nil {
+				return ErrTar.Wrap(err)
+			}
+			err := blobValue(txn, item, func(val []byte) error {
+				if len(val) != size {
+					return ErrCorrupt.New("blob %x has %d bytes instead of %d", item.Key(), len(val), size)
+				}
+				_, err := archive.Write(val)
+				return ErrTar.Wrap(err)
+			})
+			if err != nil {
+				return err
+			}
+			stats.Blobs++
+			stats.Bytes += int64(size)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, ErrTar.Wrap(archive.Close())
+}
+
+// piecePath returns the path of the blob in the filestore layout.
+func piecePath(ref blobstore.BlobRef, format blobstore.FormatVersion) string {
+	key := filestore.PathEncoding.EncodeToString(ref.Key)
+	if len(key) < 3 {
+		key = "11" + key
+	}
+	name := path.Join("blobs", filestore.PathEncoding.EncodeToString(ref.Namespace), key[:2], key[2:])
+	if format == filestore.FormatV0 {
+		return name
+	}
+	return name + v1PieceFileSuffix
+}
+
+// ImportStats counts the entries of an imported tar archive.
+type ImportStats struct {
+	Blobs int64
+	Trash int64
+	Bytes int64
+	// Skipped is the number of entries which aren't pieces, like the
+	// directories and the temporary files of the filestore.
+	Skipped int64
+}
+
+// ImportTar stores the pieces of a tar stream laid out like the filestore
+// directory: blobs/<namespace>/<key prefix>/<key rest>[.sj1] for the blobs and
+// trash/<namespace>/<day>/<key prefix>/<key rest>[.sj1] for the trash, with
+// namespace and key in filestore.PathEncoding. The layout may be below a
+// parent directory. The pieces keep the modification time of their entries.
+func (b *BlobStore) ImportTar(ctx context.Context, r io.Reader) (stats ImportStats, err error) {
+	archive := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		header, err := archive.Next()
+		if errs.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, ErrTar.Wrap(err)
+		}
+		ref, format, trash, ok := parsePiecePath(header.Name)
+		if !ok || header.Typeflag != tar.TypeReg {
+			stats.Skipped++
+			continue
+		}
+		if err := b.importPiece(ctx, ref, format, archive, header); err != nil {
+			return stats, err
+		}
+		if trash {
+			if _, err := b.trash(ref, trashTime{}); err != nil {
+				return stats, err
+			}
+			stats.Trash++
+		} else {
+			stats.Blobs++
+		}
+		stats.Bytes += header.Size
+	}
+}
+
+func (b *BlobStore) importPiece(ctx context.Context, ref blobstore.BlobRef, format blobstore.FormatVersion, r io.Reader, header *tar.Header) (err error) {
+	if format == filestore.FormatV1 {
+		format = b.defaultFormat()
+	}
+	w, err := b.CreateWithStorageFormat(ctx, ref, format)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, w.Cancel(ctx))
+		}
+	}()
+	if _, err := io.Copy(w, r); err != nil {
+		return ErrTar.New("reading %s: %v", header.Name, err)
+	}
+	return w.(ModTimeCommitter).CommitWithModTime(ctx, header.ModTime)
+}
+
+// parsePiecePath returns the piece of a path in filestore layout.
+func parsePiecePath(name string) (ref blobstore.BlobRef, format blobstore.FormatVersion, trash bool, ok bool) {
+	parts := strings.Split(path.Clean(strings.TrimPrefix(name, "/")), "/")
+	for i, part := range parts {
+		var rest []string
+		switch part {
+		case "blobs":
+			rest = parts[i+1:]
+		case "trash":
+			// the trash has a directory per day, except in old filestores
+			rest = parts[i+1:]
+			if len(rest) == 4 {
+				rest = append(rest[:1:1], rest[2:]...)
+			}
+			trash = true
+		default:
+			continue
+		}
+		if len(rest) != 3 {
+			return ref, 0, false, false
+		}
+		namespace, err := filestore.PathEncoding.DecodeString(rest[0])
+		if err != nil {
+			return ref, 0, false, false
+		}
+		encodedKey, format := rest[1]+rest[2], filestore.FormatV0
+		if strings.HasSuffix(encodedKey, v1PieceFileSuffix) {
+			encodedKey, format = strings.TrimSuffix(encodedKey, v1PieceFileSuffix), filestore.FormatV1
+		}
+		// short keys are padded with '1', which isn't a base32 character
+		key, err := filestore.PathEncoding.DecodeString(strings.TrimLeft(encodedKey, "1"))
+		if err != nil || len(namespace) == 0 || len(key) == 0 {
+			return ref, 0, false, false
+		}
+		return blobstore.BlobRef{Namespace: namespace, Key: key}, format, trash, true
+	}
+	return ref, 0, false, false
+}
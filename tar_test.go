@@ -0,0 +1,174 @@
+package badger
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"testing"
+	"time"
+)
+
+func TestParsePiecePath(t *testing.T) {
+	ns := filestore.PathEncoding.EncodeToString([]byte("namespace"))
+	key := filestore.PathEncoding.EncodeToString([]byte("piece"))
+	for _, tc := range []struct {
+		name   string
+		ok     bool
+		format blobstore.FormatVersion
+		trash  bool
+		key    string
+	}{
+		{name: "blobs/" + ns + "/" + key[:2] + "/" + key[2:] + ".sj1", ok: true, format: filestore.FormatV1, key: "piece"},
+		{name: "./storage/blobs/" + ns + "/" + key[:2] + "/" + key[2:], ok: true, format: filestore.FormatV0, key: "piece"},
+		{name: "trash/" + ns + "/2024-05-10/" + key[:2] + "/" + key[2:] + ".sj1", ok: true, format: filestore.FormatV1, trash: true, key: "piece"},
+		{name: "trash/" + ns + "/" + key[:2] + "/" + key[2:] + ".sj1", ok: true, format: filestore.FormatV1, trash: true, key: "piece"},
+		{name: "blobs/" + ns + "/11/me.sj1", ok: true, format: filestore.FormatV1, key: "a"},
+		{name: "blobs/" + ns + "/" + key[:2]},
+		{name: "temp/blob-123.partial"},
+		{name: "storage-dir-verification"},
+		{name: "blobs/" + ns + "/08/invalid"},
+	} {
+		ref, format, trash, ok := parsePiecePath(tc.name)
+		require.Equal(t, tc.ok, ok, tc.name)
+		if !ok {
+			continue
+		}
+		require.Equal(t, "namespace", string(ref.Namespace), tc.name)
+		require.Equal(t, tc.key, string(ref.Key), tc.name)
+		require.Equal(t, tc.format, format, tc.name)
+		require.Equal(t, tc.trash, trash, tc.name)
+	}
+}
+
+func TestImportTar(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	modTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ns := filestore.PathEncoding.EncodeToString([]byte("ns"))
+	piecePath := func(dir, key, suffix string) string {
+		encoded := filestore.PathEncoding.EncodeToString([]byte(key))
+		return dir + "/" + ns + "/" + encoded[:2] + "/" + encoded[2:] + suffix
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, entry := range []struct {
+		name string
+		data string
+	}{
+		{name: "storage-dir-verification", data: "id"},
+		{name: piecePath("storage/blobs", "piece1", ".sj1"), data: "v1 piece"},
+		{name: piecePath("storage/blobs", "piece2", ""), data: "v0 piece"},
+		{name: piecePath("storage/trash", "piece3", ".sj1"), data: "trashed"},
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Size: int64(len(entry.data)), ModTime: modTime, Mode: 0600}))
+		_, err := tw.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "storage/blobs/", Typeflag: tar.TypeDir, ModTime: modTime}))
+	require.NoError(t, tw.Close())
+
+	stats, err := store.ImportTar(ctx, &archive)
+	require.NoError(t, err)
+	require.Equal(t, ImportStats{Blobs: 2, Trash: 1, Bytes: 23, Skipped: 2}, stats)
+
+	for key, expected := range map[string]struct {
+		data   string
+		format blobstore.FormatVersion
+	}{
+		"piece1": {"v1 piece", filestore.FormatV1},
+		"piece2": {"v0 piece", filestore.FormatV0},
+	} {
+		reader, err := store.Open(ctx, ref("ns", key))
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, expected.data, string(data))
+		require.Equal(t, expected.format, reader.StorageFormatVersion())
+		require.NoError(t, reader.Close())
+
+		info, err := store.Stat(ctx, ref("ns", key))
+		require.NoError(t, err)
+		stat, err := info.Stat(ctx)
+		require.NoError(t, err)
+		require.True(t, modTime.Equal(stat.ModTime()))
+	}
+
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(16), used)
+	trash, err := store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), trash)
+
+	// truncated archives fail
+	_, err = store.ImportTar(ctx, bytes.NewReader(archive.Bytes()[:700]))
+	require.Error(t, err)
+}
+
+func TestExportTar(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("source"), Config{StorageFormat: int(FormatV2)})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	modTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	store.SetClock(fixedClock(modTime))
+	require.NoError(t, save(ctx, store, ref("ns", "v2"), pieceData("header", "content")))
+	require.NoError(t, save(ctx, store, ref("ns", "a"), "short key"))
+	require.NoError(t, save(ctx, store, ref("ns1", "other"), "other namespace"))
+	w, err := store.TestCreateV0(ctx, ref("ns", "v0"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("v0 piece"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, store.Trash(ctx, ref("ns", "a"), modTime))
+
+	var archive bytes.Buffer
+	stats, err := store.ExportTar(ctx, &archive, []byte("ns"))
+	require.NoError(t, err)
+	require.Equal(t, ExportStats{Blobs: 2, Bytes: int64(len(pieceData("header", "content")) + len("v0 piece"))}, stats)
+
+	target, err := NewBlobStore(ctx.Dir("target"))
+	require.NoError(t, err)
+	defer ctx.Check(target.Close)
+	imported, err := target.ImportTar(ctx, &archive)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), imported.Blobs)
+
+	for key, expected := range map[string]struct {
+		data   string
+		format blobstore.FormatVersion
+	}{
+		"v2": {pieceData("header", "content"), filestore.FormatV1},
+		"v0": {"v0 piece", filestore.FormatV0},
+	} {
+		reader, err := target.Open(ctx, ref("ns", key))
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, expected.data, string(data))
+		require.Equal(t, expected.format, reader.StorageFormatVersion())
+		require.NoError(t, reader.Close())
+	}
+	_, err = target.Open(ctx, ref("ns1", "other"))
+	require.True(t, ErrNotExist.Has(err))
+
+	require.Equal(t, "blobs/nzzq/11/me.sj1", piecePath(ref("ns", "a"), FormatV2))
+
+	archive.Reset()
+	stats, err = store.ExportTar(ctx, &archive, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Blobs)
+}
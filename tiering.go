@@ -0,0 +1,375 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// ErrTiering is returned for the failures moving blobs to and from the cold
+// store.
+var ErrTiering = errs.Class("tiering")
+
+// userMetaCold marks the entries of the blobs moved to the cold store. Their
+// value is the namespace of the blob, the data is in the cold store.
+const userMetaCold byte = 0b1000
+
+// coldDeletePrefix keys are the copies in the cold store of the blobs deleted
+// or fetched back since, to be removed from it. The value is the namespace.
+var coldDeletePrefix = []byte("coldl")
+
+// tieringBatchSize is the number of blobs looked up at once for eviction.
+var tieringBatchSize = 100
+
+// TieringReport is the result of an eviction run.
+type TieringReport struct {
+	// Evicted and EvictedBytes are the blobs moved to the cold store.
+	Evicted      int64
+	EvictedBytes int64
+	// Purged are the copies of deleted or fetched back blobs removed from
+	// the cold store.
+	Purged int64
+}
+
+func checkTiering(config Config) error {
+	if config.ColdAfter > 0 && config.AccessTracking <= 0 {
+		return ErrConfig.New("ColdAfter requires AccessTracking")
+	}
+	return nil
+}
+
+// coldRef returns the reference of the blob or trash key in the cold store.
+// The version of the key is part of it, so the copies of different versions
+// don't replace each other.
+func coldRef(namespace []byte, key []byte) blobstore.BlobRef {
+	body := key[len(blobPrefix)+len(namespace):]
+	return blobstore.BlobRef{Namespace: namespace, Key: append([]byte{}, body...)}
+}
+
+func coldDeleteKey(key []byte) []byte {
+	return concat(coldDeletePrefix, key[len(blobPrefix):])
+}
+
+// releaseCold queues the removal of the cold copy of an entry which is
+// deleted or fetched back.
+func releaseCold(txn kvTxn, item kvItem) error {
+	if item.UserMeta()&userMetaCold == 0 {
+		return nil
+	}
+	namespace, err := item.ValueCopy(nil)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	return txn.Set(coldDeleteKey(item.Key()), namespace)
+}
+
+// SetColdStore sets the store the blobs not read for Config.ColdAfter are
+// moved to. It has to be set before the first eviction and kept afterwards:
+// the evicted blobs are read from it.
+func (b *BlobStore) SetColdStore(cold blobstore.Blobs) {
+	b.coldMu.Lock()
+	defer b.coldMu.Unlock()
+	b.cold = cold
+}
+
+func (b *BlobStore) coldStore() (blobstore.Blobs, error) {
+	b.coldMu.Lock()
+	defer b.coldMu.Unlock()
+	if b.cold == nil {
+		return nil, ErrTiering.New("no cold store")
+	}
+	return b.cold, nil
+}
+
+func (b *BlobStore) startTiering() {
+	if b.config.ColdAfter <= 0 {
+		return
+	}
+	b.startJob(ChoreTiering, b.config.TieringInterval, func(ctx context.Context) error {
+		report, err := b.EvictCold(ctx)
+		if err != nil {
+			return err
+		}
+		b.chores.done(ChoreTiering)
+		if report.Evicted > 0 || report.Purged > 0 {
+			b.log.Info("moved cold blobs", zap.Int64("evicted", report.Evicted), zap.Int64("bytes", report.EvictedBytes), zap.Int64("purged", report.Purged))
+		}
+		return nil
+	})
+}
+
+// EvictCold moves the blobs not read for Config.ColdAfter to the cold store,
+// and removes the copies of the blobs deleted or fetched back since from it.
+// The blobs never read since the tracking was enabled are evicted by their
+// modification time. Deduplicated blobs stay in badger.
+func (b *BlobStore) EvictCold(ctx context.Context) (report TieringReport, err error) {
+	defer mon.Task()(&ctx)(&err)
+	if err := b.readOnly.check(); err != nil {
+		return report, err
+	}
+	cold, err := b.coldStore()
+	if err != nil {
+		return report, err
+	}
+	if err := b.purgeCold(ctx, cold, &report); err != nil {
+		return report, err
+	}
+	if b.config.ColdAfter <= 0 {
+		return report, nil
+	}
+	cutoff := b.clock.Now().Add(-b.config.ColdAfter)
+	namespaces, err := b.ListNamespaces(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, namespace := range namespaces {
+		if err := b.evictNamespace(ctx, cold, namespace, cutoff, &report); err != nil {
+			return report, err
+		}
+	}
+	mon.IntVal("cold_evicted").Observe(report.Evicted)
+	return report, nil
+}
+
+// coldCandidate is a blob version to evict.
+type coldCandidate struct {
+	key     []byte
+	version uint64
+}
+
+func (b *BlobStore) evictNamespace(ctx context.Context, cold blobstore.Blobs, namespace []byte, cutoff time.Time, report *TieringReport) error {
+	prefix := concat(blobPrefix, namespace)
+	after := prefix
+	for {
+		var candidates []coldCandidate
+		more := false
+		err := view(b.db, func(txn kvTxn) error {
+			candidates, more = nil, false
+			it := txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix))
+			defer it.Close()
+			for it.Seek(after); it.Valid(); it.Next() {
+				item := it.Item()
+				if bytes.Equal(item.Key(), after) || item.UserMeta()&(userMetaCold|userMetaDedup) != 0 || len(item.Key()) <= len(prefix)+16 {
+					continue
+				}
+				if len(candidates) == tieringBatchSize {
+					more = true
+					return nil
+				}
+				key := item.KeyCopy(nil)
+				after = key
+				ref := blobstore.BlobRef{Namespace: namespace, Key: key[len(prefix) : len(key)-16]}
+				lastAccess, err := b.access.lastAccess(txn, ref)
+				if err != nil {
+					return err
+				}
+				if lastAccess.IsZero() {
+					lastAccess, _ = stat(key)
+				}
+				if !lastAccess.Before(cutoff) {
+					continue
+				}
+				candidates = append(candidates, coldCandidate{key: key, version: item.Version()})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, candidate := range candidates {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := b.evict(ctx, cold, namespace, candidate, report); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// evict copies the blob version to the cold store and replaces its data
+// with the marker, unless it changed meanwhile.
+func (b *BlobStore) evict(ctx context.Context, cold blobstore.Blobs, namespace []byte, candidate coldCandidate, report *TieringReport) error {
+	var data []byte
+	var changed bool
+	err := view(b.db, func(txn kvTxn) error {
+		item, err := txn.Get(candidate.key)
+		if errs.Is(err, errKeyNotFound) {
+			changed = true
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if item.Version() != candidate.version {
+			changed = true
+			return nil
+		}
+		return storedValue(txn, item, func(val []byte) error {
+			data = append([]byte(nil), val...)
+			return nil
+		})
+	})
+	if err != nil || changed {
+		return err
+	}
+	target := coldRef(namespace, candidate.key)
+	if err := writeCold(ctx, cold, target, data); err != nil {
+		return err
+	}
+	err = update(b.db, func(txn kvTxn) error {
+		changed = false
+		item, err := txn.Get(candidate.key)
+		if errs.Is(err, errKeyNotFound) {
+			changed = true
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if item.Version() != candidate.version {
+			changed = true
+			return nil
+		}
+		// the copy may be queued for removal from an earlier eviction
+		if err := txn.Delete(coldDeleteKey(candidate.key)); err != nil {
+			return err
+		}
+		if err := releaseChunks(txn, item); err != nil {
+			return err
+		}
+		return txn.SetWithMeta(candidate.key, namespace, (item.UserMeta()|userMetaCold)&^userMetaChunked)
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrTiering.Wrap(cold.Delete(ctx, target))
+	}
+	b.invalidate(blobstore.BlobRef{Namespace: namespace, Key: target.Key[:len(target.Key)-16]})
+	report.Evicted++
+	report.EvictedBytes += int64(len(data))
+	return nil
+}
+
+func writeCold(ctx context.Context, cold blobstore.Blobs, ref blobstore.BlobRef, data []byte) (err error) {
+	w, err := cold.Create(ctx, ref)
+	if err != nil {
+		return ErrTiering.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			err = errs.Combine(err, ErrTiering.Wrap(w.Cancel(ctx)))
+		}
+	}()
+	if _, err := w.Write(data); err != nil {
+		return ErrTiering.Wrap(err)
+	}
+	return ErrTiering.Wrap(w.Commit(ctx))
+}
+
+// purgeCold removes the copies of the blobs deleted or fetched back from the
+// cold store.
+func (b *BlobStore) purgeCold(ctx context.Context, cold blobstore.Blobs, report *TieringReport) error {
+	for {
+		var keys, namespaces [][]byte
+		err := view(b.db, func(txn kvTxn) error {
+			it := txn.NewIterator(b.iteratorOptions(IteratorRecords, coldDeletePrefix))
+			defer it.Close()
+			for it.Rewind(); it.Valid() && len(keys) < tieringBatchSize; it.Next() {
+				namespace, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return errs.Wrap(err)
+				}
+				keys = append(keys, it.Item().KeyCopy(nil))
+				namespaces = append(namespaces, namespace)
+			}
+			return nil
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		for i, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := cold.Delete(ctx, coldRef(namespaces[i], key))
+			if err != nil && !errs.Is(err, os.ErrNotExist) {
+				return ErrTiering.Wrap(err)
+			}
+			err = update(b.db, func(txn kvTxn) error {
+				return txn.Delete(key)
+			})
+			if err != nil {
+				return err
+			}
+			report.Purged++
+		}
+	}
+}
+
+// fetchCold moves the blob back from the cold store, if it was evicted.
+func (b *BlobStore) fetchCold(ctx context.Context, ref blobstore.BlobRef) (err error) {
+	defer mon.Task()(&ctx)(&err)
+	cold, err := b.coldStore()
+	if err != nil {
+		return err
+	}
+	var key []byte
+	var version uint64
+	err = view(b.db, func(txn kvTxn) error {
+		versions := blobVersions(txn, keyPrefix(ref))
+		if len(versions) == 0 {
+			return nil
+		}
+		item, err := txn.Get(versions[0].key)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if item.UserMeta()&userMetaCold != 0 {
+			key, version = versions[0].key, item.Version()
+		}
+		return nil
+	})
+	if err != nil || key == nil {
+		return err
+	}
+	r, err := cold.Open(ctx, coldRef(ref.Namespace, key))
+	if err != nil {
+		return ErrTiering.Wrap(err)
+	}
+	data, err := io.ReadAll(r)
+	err = errs.Combine(err, r.Close())
+	if err != nil {
+		return ErrTiering.Wrap(err)
+	}
+	err = update(b.db, func(txn kvTxn) error {
+		item, err := txn.Get(key)
+		if errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if item.Version() != version {
+			return nil
+		}
+		if err := releaseCold(txn, item); err != nil {
+			return err
+		}
+		return setBlob(txn, key, data, item.UserMeta()&^userMetaCold, false, b.config.ChunkSize.Int64())
+	})
+	if err != nil {
+		return err
+	}
+	mon.Counter("cold_fetched").Inc(1)
+	return nil
+}
@@ -0,0 +1,97 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"storj.io/common/testcontext"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestTiering(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	_, err := NewBlobStoreWithConfig(ctx.Dir("invalid"), Config{ColdAfter: time.Hour})
+	require.True(t, ErrConfig.Has(err))
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{AccessTracking: time.Hour, ColdAfter: 48 * time.Hour})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	cold, err := NewBlobStore(ctx.Dir("cold"))
+	require.NoError(t, err)
+	defer ctx.Check(cold.Close)
+
+	now := time.Unix(time.Now().Unix(), 0)
+	store.SetClock(fixedClock(now.Add(-72 * time.Hour)))
+	require.NoError(t, save(ctx, store, ref("ns", "old"), "old data"))
+	store.SetClock(fixedClock(now))
+	require.NoError(t, save(ctx, store, ref("ns", "new"), "new data"))
+
+	_, err = store.EvictCold(ctx)
+	require.True(t, ErrTiering.Has(err))
+
+	isCold := func(blob blobstore.BlobRef) (cold bool) {
+		require.NoError(t, store.db.View(func(txn kvTxn) error {
+			item, err := txn.Get(blobVersions(txn, keyPrefix(blob))[0].key)
+			require.NoError(t, err)
+			cold = item.UserMeta()&userMetaCold != 0
+			return nil
+		}))
+		return cold
+	}
+	coldBlobs := func() (count int) {
+		require.NoError(t, cold.WalkNamespace(ctx, []byte("ns"), "", func(blobstore.BlobInfo) error {
+			count++
+			return nil
+		}))
+		return count
+	}
+
+	store.SetColdStore(cold)
+	report, err := store.EvictCold(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TieringReport{Evicted: 1, EvictedBytes: 8}, report)
+	require.True(t, isCold(ref("ns", "old")))
+	require.False(t, isCold(ref("ns", "new")))
+	require.Equal(t, 1, coldBlobs())
+
+	// the evicted blobs are still there for stat and walks
+	info, err := store.Stat(ctx, ref("ns", "old"))
+	require.NoError(t, err)
+	fileInfo, err := info.Stat(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 8, fileInfo.Size())
+
+	// and fetched back when read
+	r, err := store.Open(ctx, ref("ns", "old"))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "old data", string(data))
+	require.False(t, isCold(ref("ns", "old")))
+
+	// the copy is purged, and the blob read just now is kept
+	report, err = store.EvictCold(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TieringReport{Purged: 1}, report)
+	require.Equal(t, 0, coldBlobs())
+
+	store.SetClock(fixedClock(now.Add(100 * time.Hour)))
+	report, err = store.EvictCold(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, report.Evicted)
+	require.Equal(t, 2, coldBlobs())
+
+	data, err = store.ReadRange(ctx, ref("ns", "new"), 4, 4)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+	require.NoError(t, store.Delete(ctx, ref("ns", "old")))
+	require.NoError(t, store.FlushDeletes(ctx))
+	report, err = store.EvictCold(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, report.Purged)
+	require.Equal(t, 0, coldBlobs())
+}
@@ -0,0 +1,365 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"sort"
+	"storj.io/storj/storagenode/blobstore"
+	"time"
+)
+
+// trashTimePrefix keys hold when the trash entry with the same key after the
+// trash prefix was trashed, and optionally when it expires. Entries trashed
+// before they existed, or imported from a filestore, have no trash time.
+var trashTimePrefix = []byte("trtim")
+
+// ErrTrash is returned for invalid trash requests.
+var ErrTrash = errs.Class("trash")
+
+func trashTimeKey(trashKey []byte) []byte {
+	return concat(trashTimePrefix, trashKey[len(trashPrefix):])
+}
+
+// trashTime is the value of the trash time keys.
+type trashTime struct {
+	trashedAt time.Time
+	// expires overrides the retention of the namespace, if not zero
+	expires time.Time
+}
+
+func (t trashTime) encode() []byte {
+	raw := binary.BigEndian.AppendUint64(nil, uint64(t.trashedAt.UnixNano()))
+	if t.expires.IsZero() {
+		return raw
+	}
+	return binary.BigEndian.AppendUint64(raw, uint64(t.expires.UnixNano()))
+}
+
+func writeTrashTime(txn kvTxn, trashKey []byte, t trashTime) error {
+	return txn.Set(trashTimeKey(trashKey), t.encode())
+}
+
+// readTrashTime returns when the entry was trashed and expires, zero if
+// they are unknown.
+func readTrashTime(txn kvTxn, trashKey []byte) (t trashTime, err error) {
+	item, err := txn.Get(trashTimeKey(trashKey))
+	if errs.Is(err, errKeyNotFound) {
+		return t, nil
+	}
+	if err != nil {
+		return t, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 && len(val) != 16 {
+			return ErrCorrupt.New("trash time of %x has %d bytes", trashKey, len(val))
+		}
+		t.trashedAt = time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+		if len(val) == 16 {
+			t.expires = time.Unix(0, int64(binary.BigEndian.Uint64(val[8:])))
+		}
+		return nil
+	})
+	return t, err
+}
+
+// expired returns whether EmptyTrash deletes the entry: when its own expiry
+// passed, or without one when it was trashed before the retention of the
+// namespace.
+func (t trashTime) expired(now, trashedBefore time.Time) bool {
+	if !t.expires.IsZero() {
+		return !t.expires.After(now)
+	}
+	return !t.trashedAt.After(trashedBefore)
+}
+
+// TrashWithExpiry trashes the blob like Trash, but EmptyTrash keeps it until
+// the expiry instead of the retention of the namespace, for special cleanup
+// requests.
+func (b *BlobStore) TrashWithExpiry(ctx context.Context, ref blobstore.BlobRef, timestamp time.Time, expires time.Time) error {
+	if expires.IsZero() {
+		return ErrTrash.New("missing expiry")
+	}
+	return b.trashBlob(ctx, ref, trashTime{trashedAt: timestamp, expires: expires})
+}
+
+// RestoreTrashPrefix moves the trashed blobs of the namespace with keys
+// starting with keyPrefix back, and returns their keys.
+func (b *BlobStore) RestoreTrashPrefix(ctx context.Context, namespace []byte, keyPrefix []byte) ([][]byte, error) {
+	keys, _, err := b.restoreTrash(ctx, namespace, trashFilter{keyPrefix: keyPrefix}, false)
+	return keys, err
+}
+
+// RestoreTrashAfter is like RestoreTrashPrefix, but only restores the blobs
+// trashed after the time, like the ones of a wrong retain run. Blobs without
+// a known trash time are not restored.
+func (b *BlobStore) RestoreTrashAfter(ctx context.Context, namespace []byte, keyPrefix []byte, trashedAfter time.Time) ([][]byte, error) {
+	keys, _, err := b.restoreTrash(ctx, namespace, trashFilter{keyPrefix: keyPrefix, trashedAfter: trashedAfter}, false)
+	return keys, err
+}
+
+// RestoreTrashWindow restores the trashed blobs of the namespace trashed
+// after trashedAfter and before trashedBefore, and returns their keys. A zero
+// time leaves that side of the window open. Blobs without a known trash time
+// are not restored.
+func (b *BlobStore) RestoreTrashWindow(ctx context.Context, namespace []byte, trashedAfter, trashedBefore time.Time) ([][]byte, error) {
+	if trashedAfter.IsZero() && trashedBefore.IsZero() {
+		return nil, ErrTrash.New("missing window")
+	}
+	keys, _, err := b.restoreTrash(ctx, namespace, trashFilter{trashedAfter: trashedAfter, trashedBefore: trashedBefore}, false)
+	return keys, err
+}
+
+// TryRestoreTrashBlob moves the trashed blob back, or returns an ErrNotExist
+// error if it isn't in the trash.
+func (b *BlobStore) TryRestoreTrashBlob(ctx context.Context, ref blobstore.BlobRef) (err error) {
+	defer wrapRef("restore trash blob", ref, &err)
+	keys, _, err := b.restoreTrash(ctx, ref.Namespace, trashFilter{keyPrefix: ref.Key, exact: true}, false)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errNotExist("%s", ref.Key)
+	}
+	return nil
+}
+
+// TrashDays returns the UTC days the trashed blobs of the namespace were
+// trashed on, in order, like the per-day trash directories of the filestore.
+// Blobs without a known trash time are left out.
+func (b *BlobStore) TrashDays(ctx context.Context, namespace []byte) (days []time.Time, err error) {
+	err = view(b.db, func(txn kvTxn) error {
+		seen := map[time.Time]bool{}
+		return b.namespaceTrash(txn, namespace, nil, nil, func(key []byte, ref blobstore.BlobRef) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			t, err := readTrashTime(txn, key)
+			if err != nil || t.trashedAt.IsZero() {
+				return err
+			}
+			year, month, day := t.trashedAt.UTC().Date()
+			date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+			if !seen[date] {
+				seen[date] = true
+				days = append(days, date)
+			}
+			return nil
+		})
+	})
+	sort.Slice(days, func(i, k int) bool { return days[i].Before(days[k]) })
+	return days, err
+}
+
+// DeleteTrashNamespace deletes all trashed blobs of the namespace, including
+// the ones whose expiry didn't pass yet.
+func (b *BlobStore) DeleteTrashNamespace(ctx context.Context, namespace []byte) (err error) {
+	defer wrapRef("delete trash namespace", blobstore.BlobRef{Namespace: namespace}, &err)
+	_, _, err = b.emptyTrash(ctx, namespace, func(trashTime) bool { return true }, false)
+	return err
+}
+
+// trashFilter selects the trashed blobs to restore.
+type trashFilter struct {
+	keyPrefix []byte
+	// exact selects only the key equal to keyPrefix
+	exact bool
+	// the trash time has to be in the window, when either end is set
+	trashedAfter  time.Time
+	trashedBefore time.Time
+}
+
+func (f trashFilter) window() bool {
+	return !f.trashedAfter.IsZero() || !f.trashedBefore.IsZero()
+}
+
+func (f trashFilter) inWindow(t trashTime) bool {
+	switch {
+	case t.trashedAt.IsZero():
+		return false
+	case !f.trashedAfter.IsZero() && !t.trashedAt.After(f.trashedAfter):
+		return false
+	case !f.trashedBefore.IsZero() && !t.trashedAt.Before(f.trashedBefore):
+		return false
+	}
+	return true
+}
+
+// restoreTrash restores the trash of the namespace selected by the filter,
+// and returns the keys and the restored versions. A dry run only finds them.
+func (b *BlobStore) restoreTrash(ctx context.Context, namespace []byte, filter trashFilter, dryRun bool) ([][]byte, []deletion, error) {
+	// queued trashing has to happen before, or it would trash the restored blobs
+	if !dryRun {
+		if err := b.readOnly.check(); err != nil {
+			return nil, nil, err
+		}
+		if err := b.FlushDeletes(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	var restored []deletion
+	err := b.trashBatches(ctx, namespace, filter.keyPrefix, dryRun, func(txn kvTxn, key []byte, ref blobstore.BlobRef) (bool, error) {
+		if filter.exact && !bytes.Equal(ref.Key, filter.keyPrefix) {
+			return false, nil
+		}
+		if filter.window() {
+			t, err := readTrashTime(txn, key)
+			if err != nil {
+				return false, err
+			}
+			if !filter.inWindow(t) {
+				return false, nil
+			}
+		}
+		if dryRun {
+			return true, nil
+		}
+		if err := b.move(txn, key, concat(blobPrefix, key[len(trashPrefix):])); err != nil {
+			return false, err
+		}
+		if err := txn.Delete(trashTimeKey(key)); err != nil {
+			return false, err
+		}
+		_, size := stat(key)
+		delta := usage{Bytes: int64(size), Pieces: 1}
+		if err := addUsage(txn, ref, delta); err != nil {
+			return false, err
+		}
+		if err := addTrashUsage(txn, ref, delta.negate()); err != nil {
+			return false, err
+		}
+		// the namespace may be known by its trash only
+		return true, setNamespaceMarker(txn, ref.Namespace)
+	}, func(batch []deletion) {
+		restored = append(restored, batch...)
+		if dryRun {
+			return
+		}
+		for _, d := range batch {
+			b.invalidate(d.ref)
+		}
+		b.hooks.callEach(&b.hooks.restore, batch)
+		b.audit(ctx, auditRestore, batch)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return trashKeys(restored), restored, nil
+}
+
+// trashBatchSize is the most trash entries changed in one transaction, as
+// badger rejects the transactions which don't fit in a memtable.
+var trashBatchSize = 1000
+
+// trashEntryWrites is a bound of the writes of a trash entry change: the
+// entries, their trash time, usage counter shards and refcounts. The values
+// are in the value log, so the writes are small.
+const trashEntryWrites = 16
+
+// trashBatchLimit returns the number of trash entries changed in one
+// transaction, fewer than trashBatchSize with small memtables.
+func (b *BlobStore) trashBatchLimit() int {
+	limit := int(b.db.MaxBatchCount() / trashEntryWrites)
+	if limit > trashBatchSize {
+		limit = trashBatchSize
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// errBatchFull stops the iteration of a full batch.
+var errBatchFull = errs.New("batch full")
+
+// trashBatches calls fn with the trash keys of the namespace with the key
+// prefix, like namespaceTrash, in batches of trashBatchLimit keys committed in
+// their own transactions. Every batch resumes after the last key of the
+// previous one. fn returns whether it changed the entry, and done is called
+// with the changed versions of every batch once it's committed. A dry run
+// reads all the keys in a single read-only transaction, and fn has to stop
+// before changing them.
+func (b *BlobStore) trashBatches(ctx context.Context, namespace []byte, keyPrefix []byte, dryRun bool, fn func(txn kvTxn, key []byte, ref blobstore.BlobRef) (bool, error), done func(batch []deletion)) error {
+	limit := b.trashBatchLimit()
+	if dryRun {
+		limit = -1
+	}
+	var after []byte
+	for more := true; more; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var batch []deletion
+		var last []byte
+		err := updateOrView(b.db, dryRun, func(txn kvTxn) error {
+			batch, last, more = nil, nil, false
+			count := 0
+			err := b.namespaceTrash(txn, namespace, keyPrefix, after, func(key []byte, ref blobstore.BlobRef) error {
+				if count == limit {
+					more = true
+					return errBatchFull
+				}
+				count++
+				last = key
+				changed, err := fn(txn, key, ref)
+				if changed {
+					batch = append(batch, deletionOf(ref, key))
+				}
+				return err
+			})
+			if errs.Is(err, errBatchFull) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		after = last
+		if len(batch) > 0 {
+			done(batch)
+		}
+	}
+	return nil
+}
+
+// trashKeys returns the keys of the versions, once per blob.
+func trashKeys(versions []deletion) (keys [][]byte) {
+	seen := map[string]bool{}
+	for _, d := range versions {
+		if !seen[string(d.ref.Key)] {
+			seen[string(d.ref.Key)] = true
+			keys = append(keys, d.ref.Key)
+		}
+	}
+	return keys
+}
+
+// namespaceTrash calls fn with the trash keys of the namespace with the key
+// prefix after the given key, skipping the ones of longer namespaces starting
+// with it.
+func (b *BlobStore) namespaceTrash(txn kvTxn, namespace []byte, keyPrefix []byte, after []byte, fn func(key []byte, ref blobstore.BlobRef) error) error {
+	namespaces := b.knownNamespaces()
+	it := txn.NewIterator(b.iteratorOptions(IteratorWalk, concat(trashPrefix, namespace, keyPrefix)))
+	defer it.Close()
+	if after == nil {
+		it.Rewind()
+	} else {
+		it.Seek(after)
+	}
+	for ; it.Valid(); it.Next() {
+		key := it.Item().KeyCopy(nil)
+		if bytes.Equal(key, after) {
+			continue
+		}
+		ref, ok := splitKey(namespaces, key[len(trashPrefix):])
+		if !ok || !bytes.Equal(ref.Namespace, namespace) {
+			continue
+		}
+		if err := fn(key, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
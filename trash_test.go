@@ -0,0 +1,219 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestPartialTrashRestore(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		retain := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+		trashed := map[string]time.Time{
+			"a1": retain.Add(-time.Hour),
+			"a2": retain.Add(time.Hour),
+			"b1": retain.Add(time.Hour),
+			"b2": retain.Add(-time.Hour),
+		}
+		for key, trashedAt := range trashed {
+			require.NoError(t, save(ctx, store, ref("ns", key), "data"))
+			require.NoError(t, store.Trash(ctx, ref("ns", key), trashedAt))
+		}
+
+		restored, err := store.RestoreTrashPrefix(ctx, []byte("ns"), []byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("a1"), []byte("a2")}, restored)
+
+		restored, err = store.RestoreTrashAfter(ctx, []byte("ns"), nil, retain)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("b1")}, restored)
+
+		for key, expected := range map[string]bool{"a1": true, "a2": true, "b1": true, "b2": false} {
+			_, err := store.Stat(ctx, ref("ns", key))
+			require.Equal(t, expected, err == nil, key)
+		}
+		used, err := store.SpaceUsedForTrash(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), used)
+
+		// the trash times go with the entries
+		_, _, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+		require.NoError(t, err)
+		err = store.db.View(func(txn kvTxn) error {
+			it := txn.NewIterator(kvIteratorOptions{Prefix: trashTimePrefix})
+			defer it.Close()
+			it.Rewind()
+			require.False(t, it.Valid())
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestTrashExpiry(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+		store.SetClock(fixedClock(now))
+		week := 7 * 24 * time.Hour
+
+		for _, key := range []string{"default", "shorter", "longer"} {
+			require.NoError(t, save(ctx, store, ref("ns", key), "data"))
+		}
+		require.NoError(t, store.Trash(ctx, ref("ns", "default"), now.Add(-2*week)))
+		require.NoError(t, store.TrashWithExpiry(ctx, ref("ns", "shorter"), now, now.Add(-time.Minute)))
+		require.NoError(t, store.TrashWithExpiry(ctx, ref("ns", "longer"), now.Add(-2*week), now.Add(week)))
+		require.True(t, ErrTrash.Has(store.TrashWithExpiry(ctx, ref("ns", "longer"), now, time.Time{})))
+
+		_, emptied, err := store.EmptyTrash(ctx, []byte("ns"), now.Add(-week))
+		require.NoError(t, err)
+		require.ElementsMatch(t, [][]byte{[]byte("default"), []byte("shorter")}, emptied)
+
+		store.SetClock(fixedClock(now.Add(week)))
+		_, emptied, err = store.EmptyTrash(ctx, []byte("ns"), now)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("longer")}, emptied)
+	})
+}
+
+func TestTrashChoreHooks(t *testing.T) {
+	testEngines(t, func(t *testing.T, engine string) {
+		ctx := testcontext.New(t)
+		defer ctx.Cleanup()
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{Engine: engine})
+		require.NoError(t, err)
+		defer ctx.Check(store.Close)
+
+		day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+		trashed := map[string]time.Time{
+			"a": day.Add(-24*time.Hour + time.Hour),
+			"b": day.Add(time.Hour),
+			"c": day.Add(2 * time.Hour),
+			"d": day.Add(24*time.Hour + time.Hour),
+		}
+		for key, trashedAt := range trashed {
+			require.NoError(t, save(ctx, store, ref("ns", key), "data"))
+			require.NoError(t, store.Trash(ctx, ref("ns", key), trashedAt))
+		}
+
+		days, err := store.TrashDays(ctx, []byte("ns"))
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{day.Add(-24 * time.Hour), day, day.Add(24 * time.Hour)}, days)
+
+		restored, err := store.RestoreTrashWindow(ctx, []byte("ns"), day, day.Add(90*time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("b")}, restored)
+		_, err = store.RestoreTrashWindow(ctx, []byte("ns"), time.Time{}, time.Time{})
+		require.True(t, ErrTrash.Has(err))
+
+		require.NoError(t, store.TryRestoreTrashBlob(ctx, ref("ns", "c")))
+		_, err = store.Stat(ctx, ref("ns", "c"))
+		require.NoError(t, err)
+		err = store.TryRestoreTrashBlob(ctx, ref("ns", "c"))
+		require.True(t, ErrNotExist.Has(err))
+
+		require.NoError(t, store.DeleteTrashNamespace(ctx, []byte("ns")))
+		days, err = store.TrashDays(ctx, []byte("ns"))
+		require.NoError(t, err)
+		require.Empty(t, days)
+		used, err := store.SpaceUsedForTrash(ctx)
+		require.NoError(t, err)
+		require.Zero(t, used)
+	})
+}
+
+func TestTrashBatches(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	// a small memtable, so the trash doesn't fit in one transaction
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MemTableSize: memory.MiB})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	count := int(store.db.MaxBatchCount())
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		require.NoError(t, save(ctx, store, ref("ns", key), "x"))
+		require.NoError(t, store.Trash(ctx, ref("ns", key), time.Now()))
+	}
+
+	restored, err := store.RestoreTrash(ctx, []byte("ns"))
+	require.NoError(t, err)
+	require.Len(t, restored, count)
+	used, err := store.SpaceUsedForBlobs(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, count, used)
+
+	for i := 0; i < count; i++ {
+		require.NoError(t, store.Trash(ctx, ref("ns", fmt.Sprintf("key%05d", i)), time.Now()))
+	}
+	// nothing is reported as freed when emptying fails
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	freed, keys, err := store.EmptyTrash(cancelled, []byte("ns"), time.Now())
+	require.Error(t, err)
+	require.Zero(t, freed)
+	require.Nil(t, keys)
+
+	freed, keys, err = store.EmptyTrash(ctx, []byte("ns"), time.Now())
+	require.NoError(t, err)
+	require.EqualValues(t, count, freed)
+	require.Len(t, keys, count)
+	used, err = store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Zero(t, used)
+}
+
+func TestPartialTrashRestoreBatches(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{MemTableSize: memory.MiB})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	retain := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	count := int(store.db.MaxBatchCount())
+	trash := func(prefix string, trashedAt time.Time) {
+		for i := 0; i < count; i++ {
+			key := fmt.Sprintf("%s%05d", prefix, i)
+			require.NoError(t, save(ctx, store, ref("ns", key), "x"))
+			require.NoError(t, store.Trash(ctx, ref("ns", key), trashedAt))
+		}
+	}
+	trash("a", retain.Add(time.Hour))
+	trash("b", retain.Add(2*time.Hour))
+
+	restored, err := store.RestoreTrashPrefix(ctx, []byte("ns"), []byte("a"))
+	require.NoError(t, err)
+	require.Len(t, restored, count)
+
+	trash("c", retain.Add(-time.Hour))
+	restored, err = store.RestoreTrashWindow(ctx, []byte("ns"), retain, retain.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, restored, count)
+	restored, err = store.RestoreTrashAfter(ctx, []byte("ns"), nil, retain.Add(-2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, restored, count)
+
+	used, err := store.SpaceUsedForTrash(ctx)
+	require.NoError(t, err)
+	require.Zero(t, used)
+}
@@ -0,0 +1,40 @@
+package badger
+
+import (
+	"errors"
+	"github.com/spacemonkeygo/monkit/v3"
+	"time"
+)
+
+var mon = monkit.Package()
+
+// maxConflictRetries is how many times a conflicting transaction is retried.
+const maxConflictRetries = 5
+
+// update runs fn in a read-write transaction, retrying it when it conflicts
+// with a concurrent transaction. fn may run multiple times, so it must reset
+// any state it collects.
+//
+// Badger's conflict detection has to stay enabled: besides the blob keys,
+// the transactions read and rewrite keys shared by unrelated blobs, like the
+// usage counter shards and the refcounts of deduplicated content, and the
+// conflicts are what keeps those updates from getting lost.
+func update(db kvEngine, fn func(txn kvTxn) error) error {
+	for attempt := 1; ; attempt++ {
+		err := db.Update(fn)
+		if !errors.Is(err, errConflict) {
+			return classify(err)
+		}
+		mon.Counter("txn_conflicts").Inc(1)
+		if attempt > maxConflictRetries {
+			return classify(err)
+		}
+		mon.Counter("txn_conflict_retries").Inc(1)
+		time.Sleep(time.Duration(attempt) * time.Millisecond)
+	}
+}
+
+// view runs fn in a read-only transaction.
+func view(db kvEngine, fn func(txn kvTxn) error) error {
+	return classify(db.View(fn))
+}
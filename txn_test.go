@@ -0,0 +1,80 @@
+package badger
+
+import (
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"storj.io/common/testcontext"
+	"testing"
+)
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	conflictKey := []byte("conflict")
+	conflictsBefore := counterValue("txn_conflicts")
+	retriesBefore := counterValue("txn_conflict_retries")
+
+	attempts := 0
+	err = update(store.db, func(txn kvTxn) error {
+		attempts++
+		if _, err := txn.Get(conflictKey); err != nil && !errs.Is(err, errKeyNotFound) {
+			return err
+		}
+		if attempts == 1 {
+			// a concurrent writer changes the key read by the first attempt
+			err := store.db.Update(func(other kvTxn) error {
+				return other.Set(conflictKey, []byte("other"))
+			})
+			require.NoError(t, err)
+		}
+		return txn.Set(conflictKey, []byte("mine"))
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+
+	require.Equal(t, conflictsBefore+1, counterValue("txn_conflicts"))
+	require.Equal(t, retriesBefore+1, counterValue("txn_conflict_retries"))
+}
+
+func TestUpdateConflictsExhausted(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	conflictKey := []byte("conflict")
+	attempts := 0
+	err = update(store.db, func(txn kvTxn) error {
+		attempts++
+		if _, err := txn.Get(conflictKey); err != nil && !errs.Is(err, errKeyNotFound) {
+			return err
+		}
+		// every attempt conflicts with a concurrent writer
+		err := store.db.Update(func(other kvTxn) error {
+			return other.Set(conflictKey, []byte("other"))
+		})
+		require.NoError(t, err)
+		return txn.Set(conflictKey, []byte("mine"))
+	})
+	require.True(t, ErrOverloaded.Has(err))
+	require.ErrorIs(t, err, errConflict)
+	require.Equal(t, maxConflictRetries+1, attempts)
+}
+
+// counterValue returns the current value of a monkit counter of the package.
+func counterValue(name string) (total int64) {
+	mon.Stats(func(key monkit.SeriesKey, field string, val float64) {
+		if key.Measurement == name && field == "value" {
+			total = int64(val)
+		}
+	})
+	return total
+}
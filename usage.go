@@ -0,0 +1,367 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/zeebo/errs"
+	"hash/fnv"
+	"storj.io/storj/storagenode/blobstore"
+)
+
+// usagePrefix keys hold the per-namespace usage counters. Every namespace has
+// usageShards counters, so concurrent commits rarely update the same key.
+var usagePrefix = []byte("usage")
+
+// trashUsagePrefix keys hold the usage counters of the trashed blobs, sharded
+// the same way.
+var trashUsagePrefix = []byte("trshu")
+
+const usageShards = 16
+
+// usage is the space used by the blobs of a namespace.
+type usage struct {
+	Bytes  int64
+	Pieces int64
+}
+
+func (u usage) add(other usage) usage {
+	return usage{Bytes: u.Bytes + other.Bytes, Pieces: u.Pieces + other.Pieces}
+}
+
+func (u usage) negate() usage {
+	return usage{Bytes: -u.Bytes, Pieces: -u.Pieces}
+}
+
+func decodeUsage(raw []byte) (usage, error) {
+	if len(raw) != 16 {
+		return usage{}, ErrCorrupt.New("usage counter has %d bytes", len(raw))
+	}
+	return usage{
+		Bytes:  int64(binary.BigEndian.Uint64(raw[:8])),
+		Pieces: int64(binary.BigEndian.Uint64(raw[8:])),
+	}, nil
+}
+
+func (u usage) encode() []byte {
+	raw := make([]byte, 0, 16)
+	raw = binary.BigEndian.AppendUint64(raw, uint64(u.Bytes))
+	raw = binary.BigEndian.AppendUint64(raw, uint64(u.Pieces))
+	return raw
+}
+
+func usageKey(namespace []byte, shard byte) []byte {
+	return concat(usagePrefix, namespace, []byte{shard})
+}
+
+func trashUsageKey(namespace []byte, shard byte) []byte {
+	return concat(trashUsagePrefix, namespace, []byte{shard})
+}
+
+func usageShard(key []byte) byte {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return byte(h.Sum32() % usageShards)
+}
+
+// addUsage adds delta to the usage counters of the namespace of ref.
+func addUsage(txn kvTxn, ref blobstore.BlobRef, delta usage) error {
+	if err := setDirtyMarker(txn, ref.Namespace); err != nil {
+		return err
+	}
+	return addCounter(txn, usageKey(ref.Namespace, usageShard(ref.Key)), delta)
+}
+
+// addTrashUsage adds delta to the trash usage counters of the namespace of ref.
+func addTrashUsage(txn kvTxn, ref blobstore.BlobRef, delta usage) error {
+	if err := setDirtyMarker(txn, ref.Namespace); err != nil {
+		return err
+	}
+	return addCounter(txn, trashUsageKey(ref.Namespace, usageShard(ref.Key)), delta)
+}
+
+func addCounter(txn kvTxn, counterKey []byte, delta usage) error {
+	if delta == (usage{}) {
+		return nil
+	}
+	current, err := readCounter(txn, counterKey)
+	if err != nil {
+		return err
+	}
+	return txn.Set(counterKey, current.add(delta).encode())
+}
+
+func readCounter(txn kvTxn, counterKey []byte) (u usage, err error) {
+	item, err := txn.Get(counterKey)
+	if errs.Is(err, errKeyNotFound) {
+		return u, nil
+	}
+	if err != nil {
+		return u, errs.Wrap(err)
+	}
+	err = item.Value(func(val []byte) error {
+		u, err = decodeUsage(val)
+		return err
+	})
+	return u, err
+}
+
+// namespaceUsage sums the usage counters of the namespace.
+func namespaceUsage(txn kvTxn, namespace []byte) (total usage, err error) {
+	for shard := 0; shard < usageShards; shard++ {
+		u, err := readCounter(txn, usageKey(namespace, byte(shard)))
+		if err != nil {
+			return total, err
+		}
+		total = total.add(u)
+	}
+	return total, nil
+}
+
+// namespaceTrashUsage sums the trash usage counters of the namespace.
+func namespaceTrashUsage(txn kvTxn, namespace []byte) (total usage, err error) {
+	for shard := 0; shard < usageShards; shard++ {
+		u, err := readCounter(txn, trashUsageKey(namespace, byte(shard)))
+		if err != nil {
+			return total, err
+		}
+		total = total.add(u)
+	}
+	return total, nil
+}
+
+// totalUsage sums the usage counters of all namespaces.
+func totalUsage(txn kvTxn) (total usage, err error) {
+	return sumCounters(txn, usagePrefix)
+}
+
+// totalTrashUsage sums the trash usage counters of all namespaces.
+func totalTrashUsage(txn kvTxn) (total usage, err error) {
+	return sumCounters(txn, trashUsagePrefix)
+}
+
+func sumCounters(txn kvTxn, prefix []byte) (total usage, err error) {
+	it := txn.NewIterator(iteratorOptions(IteratorRecords, prefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		err := it.Item().Value(func(val []byte) error {
+			u, err := decodeUsage(val)
+			total = total.add(u)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// blobVersion is a stored key of a blob with the size recorded in it.
+type blobVersion struct {
+	key  []byte
+	size int64
+}
+
+// blobVersions returns the keys stored for exactly the given blob key prefix
+// (skipping longer blob keys sharing the prefix). Normally there is at most one.
+func blobVersions(txn kvTxn, prefix []byte) (versions []blobVersion) {
+	it := txn.NewIterator(iteratorOptions(IteratorWalk, prefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().KeyCopy(nil)
+		if len(key) != len(prefix)+16 {
+			continue
+		}
+		_, size := stat(key)
+		versions = append(versions, blobVersion{key: key, size: int64(size)})
+	}
+	return versions
+}
+
+// initUsage computes the usage counters when they are not known to be
+// accurate: for stores created before the counters existed and after recovery.
+func initUsage(ctx context.Context, db kvEngine) error {
+	var valid bool
+	err := db.View(func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		valid = m.UsageCounters && m.TrashCounters
+		return err
+	})
+	if err != nil || valid {
+		return err
+	}
+	_, err = recomputeUsage(ctx, db, nil)
+	return err
+}
+
+// recomputeUsage rewrites the usage counters from a scan of the blob and
+// trash keys, and returns the corrections by counter key without the shard.
+// Only the counters of the namespaces in only are recomputed, unless it's
+// nil. Commits may update the counters while the scan runs: their changes
+// since the snapshot of the scan are kept.
+func recomputeUsage(ctx context.Context, db kvEngine, only [][]byte) (drift map[string]usage, err error) {
+	var scanned, atScan map[string]usage
+	err = db.View(func(txn kvTxn) (err error) {
+		scanned = map[string]usage{}
+		namespaces := readNamespaces(txn)
+		if err := scanUsage(ctx, txn, namespaces, only, blobPrefix, usageKey, scanned); err != nil {
+			return err
+		}
+		if err := scanUsage(ctx, txn, namespaces, only, trashPrefix, trashUsageKey, scanned); err != nil {
+			return err
+		}
+		atScan, err = readCounters(txn, only)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = update(db, func(txn kvTxn) (err error) {
+		drift, err = applyUsageScan(txn, scanned, atScan, only)
+		return err
+	})
+	return drift, err
+}
+
+// scanUsage adds the size of the keys with the prefix to their counters,
+// only for the namespaces in only if it isn't nil.
+func scanUsage(ctx context.Context, txn kvTxn, namespaces, only [][]byte, prefix []byte, counterKey func([]byte, byte) []byte, counters map[string]usage) error {
+	prefixes := [][]byte{prefix}
+	if only != nil {
+		prefixes = prefixes[:0]
+		for _, namespace := range only {
+			prefixes = append(prefixes, concat(prefix, namespace))
+		}
+	}
+	for _, scanPrefix := range prefixes {
+		if err := scanUsagePrefix(ctx, txn, namespaces, only, scanPrefix, len(prefix), counterKey, counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanUsagePrefix(ctx context.Context, txn kvTxn, namespaces, only [][]byte, scanPrefix []byte, prefixLen int, counterKey func([]byte, byte) []byte, counters map[string]usage) error {
+	it := txn.NewIterator(iteratorOptions(IteratorWalk, scanPrefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := it.Item().KeyCopy(nil)
+		ref, ok := splitKey(namespaces, key[prefixLen:])
+		// the prefix of a namespace may be another, longer namespace
+		if !ok || (only != nil && !containsNamespace(only, ref.Namespace)) {
+			continue
+		}
+		_, size := stat(key)
+		k := string(counterKey(ref.Namespace, usageShard(ref.Key)))
+		counters[k] = counters[k].add(usage{Bytes: int64(size), Pieces: 1})
+	}
+	return nil
+}
+
+// applyUsageScan sets the counters to the scanned usage plus the changes made
+// to them since the scan. It returns the corrections by counter key without
+// the shard.
+func applyUsageScan(txn kvTxn, scanned, atScan map[string]usage, only [][]byte) (map[string]usage, error) {
+	current, err := readCounters(txn, only)
+	if err != nil {
+		return nil, err
+	}
+	drift := map[string]usage{}
+	counterKeys := map[string]bool{}
+	for _, counters := range []map[string]usage{scanned, atScan, current} {
+		for counterKey := range counters {
+			counterKeys[counterKey] = true
+		}
+	}
+	for counterKey := range counterKeys {
+		changed := current[counterKey].add(atScan[counterKey].negate())
+		value := scanned[counterKey].add(changed)
+		if correction := value.add(current[counterKey].negate()); correction != (usage{}) {
+			unsharded := counterKey[:len(counterKey)-1]
+			drift[unsharded] = drift[unsharded].add(correction)
+		}
+		if value == (usage{}) {
+			if _, ok := current[counterKey]; ok {
+				if err := txn.Delete([]byte(counterKey)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if value != current[counterKey] {
+			if err := txn.Set([]byte(counterKey), value.encode()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if only != nil {
+		return drift, nil
+	}
+	m, _, err := readMeta(txn)
+	if err != nil || (m.UsageCounters && m.TrashCounters) {
+		return drift, err
+	}
+	m.UsageCounters, m.TrashCounters = true, true
+	return drift, writeMeta(txn, m)
+}
+
+// readCounters returns the usage and trash usage counters by key, of all
+// namespaces or only the ones in only if it isn't nil. Corrupt counters are
+// returned as zero, as they are about to be recomputed.
+func readCounters(txn kvTxn, only [][]byte) (map[string]usage, error) {
+	counters := map[string]usage{}
+	for _, prefix := range [][]byte{usagePrefix, trashUsagePrefix} {
+		if only == nil {
+			if err := readCountersWithPrefix(txn, prefix, counters); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, namespace := range only {
+			namespaceCounters := map[string]usage{}
+			if err := readCountersWithPrefix(txn, concat(prefix, namespace), namespaceCounters); err != nil {
+				return nil, err
+			}
+			// skip the counters of the longer namespaces with this prefix
+			for counterKey, u := range namespaceCounters {
+				if len(counterKey) == len(prefix)+len(namespace)+1 {
+					counters[counterKey] = u
+				}
+			}
+		}
+	}
+	return counters, nil
+}
+
+func readCountersWithPrefix(txn kvTxn, prefix []byte, counters map[string]usage) error {
+	it := txn.NewIterator(iteratorOptions(IteratorRecords, prefix))
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		err := it.Item().Value(func(val []byte) error {
+			u, err := decodeUsage(val)
+			if ErrCorrupt.Has(err) {
+				u = usage{}
+			}
+			counters[string(it.Item().KeyCopy(nil))] = u
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateUsage makes the next open recompute the usage counters.
+func invalidateUsage(db kvEngine) error {
+	return update(db, func(txn kvTxn) error {
+		m, _, err := readMeta(txn)
+		if err != nil {
+			return err
+		}
+		m.UsageCounters = false
+		return writeMeta(txn, m)
+	})
+}
@@ -0,0 +1,121 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"storj.io/common/testcontext"
+	"testing"
+	"time"
+)
+
+func TestUsageCounters(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	requireUsage := func(ns1, ns2 int64) {
+		used, err := store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns1"))
+		require.NoError(t, err)
+		require.Equal(t, ns1, used)
+		used, err = store.SpaceUsedForBlobsInNamespace(ctx, []byte("ns2"))
+		require.NoError(t, err)
+		require.Equal(t, ns2, used)
+		used, err = store.SpaceUsedForBlobs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, ns1+ns2, used)
+	}
+
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "1234567890"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key2"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "123"))
+	requireUsage(15, 3)
+
+	// committing again replaces the previous version
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "12"))
+	requireUsage(7, 3)
+	err = store.db.View(func(txn kvTxn) error {
+		require.Len(t, blobVersions(txn, keyPrefix(ref("ns1", "key1"))), 1)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Delete(ctx, ref("ns1", "key2")))
+	requireUsage(2, 3)
+
+	require.NoError(t, store.Trash(ctx, ref("ns2", "key1"), time.Now()))
+	requireUsage(2, 0)
+
+	_, err = store.RestoreTrash(ctx, []byte("ns2"))
+	require.NoError(t, err)
+	requireUsage(2, 3)
+
+	// stores without counters get them computed on open
+	err = store.db.Update(func(txn kvTxn) error {
+		it := txn.NewIterator(kvIteratorOptions{Prefix: usagePrefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		return writeMeta(txn, meta{LayoutVersion: layoutVersion})
+	})
+	require.NoError(t, err)
+	requireUsage(0, 0)
+	require.NoError(t, store.Close())
+
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	requireUsage(2, 3)
+}
+
+func TestTrashUsage(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStore(dir)
+	require.NoError(t, err)
+
+	requireTrash := func(expected int64) {
+		used, err := store.SpaceUsedForTrash(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, used)
+	}
+
+	require.NoError(t, save(ctx, store, ref("ns1", "key1"), "1234567890"))
+	require.NoError(t, save(ctx, store, ref("ns1", "key2"), "12345"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "123"))
+	requireTrash(0)
+
+	require.NoError(t, store.Trash(ctx, ref("ns1", "key1"), time.Now()))
+	require.NoError(t, store.Trash(ctx, ref("ns2", "key1"), time.Now()))
+	requireTrash(13)
+
+	// restoring is per namespace
+	_, err = store.RestoreTrash(ctx, []byte("ns1"))
+	require.NoError(t, err)
+	requireTrash(3)
+	_, err = store.RestoreTrash(ctx, []byte("ns2"))
+	require.NoError(t, err)
+	requireTrash(0)
+
+	require.NoError(t, store.Trash(ctx, ref("ns1", "key2"), time.Now()))
+	requireTrash(5)
+
+	// trash counters are computed for stores without them
+	require.NoError(t, store.Close())
+	require.NoError(t, clearUsage(dir))
+	store, err = NewBlobStore(dir)
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	requireTrash(5)
+
+	freed, _, err := store.EmptyTrash(ctx, []byte("ns1"), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), freed)
+	requireTrash(0)
+}
@@ -0,0 +1,348 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"storj.io/storj/storagenode/pieces"
+)
+
+// v0PieceInfoPrefix keys hold the metadata of the pieces stored in the v0
+// format, as satellite ID and piece ID.
+var v0PieceInfoPrefix = []byte("pcinf")
+
+// ErrV0PieceInfo is returned for the errors of the v0 piece infos.
+var ErrV0PieceInfo = errs.Class("v0 piece info")
+
+// V0PieceInfos keeps the metadata of the v0 pieces of the nodes upgraded
+// from the oldest versions, so it can be imported from their SQLite database
+// while migrating to the badger store. It's the pieces.V0PieceInfoDB of the
+// node.
+type V0PieceInfos struct {
+	db        kvEngine
+	iterators iteratorProfiles
+}
+
+var _ pieces.V0PieceInfoDBForTest = (*V0PieceInfos)(nil)
+
+// V0PieceInfos returns the v0 piece infos kept in the store.
+func (b *BlobStore) V0PieceInfos() *V0PieceInfos {
+	return &V0PieceInfos{db: b.db, iterators: b.iterators}
+}
+
+func v0PieceInfoKey(satellite storj.NodeID, pieceID storj.PieceID) []byte {
+	return concat(v0PieceInfoPrefix, satellite.Bytes(), pieceID.Bytes())
+}
+
+// Add stores the info of a piece.
+func (v *V0PieceInfos) Add(ctx context.Context, info *pieces.Info) error {
+	return v.Import(ctx, []*pieces.Info{info})
+}
+
+// Import stores the infos in one transaction, replacing the ones of the same
+// pieces.
+func (v *V0PieceInfos) Import(ctx context.Context, infos []*pieces.Info) error {
+	entries := make([][]byte, len(infos))
+	for i, info := range infos {
+		value, err := encodeV0PieceInfo(info, time.Time{})
+		if err != nil {
+			return err
+		}
+		entries[i] = value
+	}
+	return update(v.db, func(txn kvTxn) error {
+		for i, info := range infos {
+			if err := txn.Set(v0PieceInfoKey(info.SatelliteID, info.PieceID), entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the info of the piece, or an ErrNotExist error.
+func (v *V0PieceInfos) Get(ctx context.Context, satellite storj.NodeID, pieceID storj.PieceID) (info *pieces.Info, err error) {
+	err = view(v.db, func(txn kvTxn) error {
+		item, err := txn.Get(v0PieceInfoKey(satellite, pieceID))
+		if errs.Is(err, errKeyNotFound) {
+			return errNotExist("v0 piece info %s/%s", satellite, pieceID)
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		info, _, err = readV0PieceInfo(item)
+		return err
+	})
+	return info, err
+}
+
+// Delete removes the info of the piece.
+func (v *V0PieceInfos) Delete(ctx context.Context, satellite storj.NodeID, pieceID storj.PieceID) error {
+	return update(v.db, func(txn kvTxn) error {
+		return txn.Delete(v0PieceInfoKey(satellite, pieceID))
+	})
+}
+
+// DeleteFailed records that deleting the expired piece failed at failedAt,
+// so GetExpired skips it until the next expiration pass.
+func (v *V0PieceInfos) DeleteFailed(ctx context.Context, satelliteID storj.NodeID, pieceID storj.PieceID, failedAt time.Time) error {
+	return update(v.db, func(txn kvTxn) error {
+		key := v0PieceInfoKey(satelliteID, pieceID)
+		item, err := txn.Get(key)
+		if errs.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		info, _, err := readV0PieceInfo(item)
+		if err != nil {
+			return err
+		}
+		value, err := encodeV0PieceInfo(info, failedAt)
+		if err != nil {
+			return err
+		}
+		return txn.Set(key, value)
+	})
+}
+
+// GetExpired calls cb for the pieces which expired before expiredAt, except
+// the ones whose deletion failed at expiredAt, until it returns false. The v0
+// pieces are few and only get fewer, so all of them are scanned, and cb is
+// called after the scan, so it may delete them.
+func (v *V0PieceInfos) GetExpired(ctx context.Context, expiredAt time.Time, cb func(context.Context, pieces.ExpiredInfo) bool) error {
+	var expired []pieces.ExpiredInfo
+	err := v.walk(ctx, v0PieceInfoPrefix, func(info *pieces.Info, deletionFailedAt time.Time) (bool, error) {
+		if info.PieceExpiration.IsZero() || !info.PieceExpiration.Before(expiredAt) || deletionFailedAt.Equal(expiredAt) {
+			return true, nil
+		}
+		expired = append(expired, pieces.ExpiredInfo{SatelliteID: info.SatelliteID, PieceID: info.PieceID, InPieceInfo: true})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, info := range expired {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cb(ctx, info) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteExpirations removes the infos of the pieces which expired before
+// expiresAt.
+func (v *V0PieceInfos) DeleteExpirations(ctx context.Context, expiresAt time.Time) error {
+	var expired [][]byte
+	err := v.walk(ctx, v0PieceInfoPrefix, func(info *pieces.Info, _ time.Time) (bool, error) {
+		if !info.PieceExpiration.IsZero() && info.PieceExpiration.Before(expiresAt) {
+			expired = append(expired, v0PieceInfoKey(info.SatelliteID, info.PieceID))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return update(v.db, func(txn kvTxn) error {
+		for _, key := range expired {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WalkSatelliteV0Pieces calls walkFunc for the pieces of the satellite, until
+// it returns an error. The blobs of the pieces are looked up in blobStore
+// when they are stat'ed. The infos are read before walkFunc is called, so it
+// may change them.
+func (v *V0PieceInfos) WalkSatelliteV0Pieces(ctx context.Context, blobStore blobstore.Blobs, satellite storj.NodeID, walkFunc func(pieces.StoredPieceAccess) error) error {
+	var accesses []*v0StoredPieceAccess
+	err := v.walk(ctx, concat(v0PieceInfoPrefix, satellite.Bytes()), func(info *pieces.Info, _ time.Time) (bool, error) {
+		accesses = append(accesses, &v0StoredPieceAccess{blobStore: blobStore, info: info})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, access := range accesses {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := walkFunc(access); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *V0PieceInfos) walk(ctx context.Context, prefix []byte, fn func(info *pieces.Info, deletionFailedAt time.Time) (bool, error)) error {
+	return view(v.db, func(txn kvTxn) error {
+		it := txn.NewIterator(v.iterators.options(IteratorRecords, prefix))
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			info, deletionFailedAt, err := readV0PieceInfo(it.Item())
+			if err != nil {
+				return err
+			}
+			more, err := fn(info, deletionFailedAt)
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encodeV0PieceInfo encodes the size, the times and the protobufs of the
+// info, prefixing the order limit with its length. The zero times are
+// encoded as 0.
+func encodeV0PieceInfo(info *pieces.Info, deletionFailedAt time.Time) ([]byte, error) {
+	limit, err := pb.Marshal(info.OrderLimit)
+	if err != nil {
+		return nil, ErrV0PieceInfo.Wrap(err)
+	}
+	hash, err := pb.Marshal(info.UplinkPieceHash)
+	if err != nil {
+		return nil, ErrV0PieceInfo.Wrap(err)
+	}
+	value := binary.AppendVarint(nil, info.PieceSize)
+	for _, t := range []time.Time{info.PieceCreation, info.PieceExpiration, deletionFailedAt} {
+		value = binary.AppendVarint(value, v0Time(t))
+	}
+	value = binary.AppendUvarint(value, uint64(len(limit)))
+	return append(append(value, limit...), hash...), nil
+}
+
+func v0Time(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func readV0PieceInfo(item kvItem) (info *pieces.Info, deletionFailedAt time.Time, err error) {
+	raw := item.Key()[len(v0PieceInfoPrefix):]
+	if len(raw) != len(storj.NodeID{})+len(storj.PieceID{}) {
+		return nil, deletionFailedAt, ErrV0PieceInfo.New("invalid key %x", item.Key())
+	}
+	info = &pieces.Info{OrderLimit: &pb.OrderLimit{}, UplinkPieceHash: &pb.PieceHash{}}
+	copy(info.SatelliteID[:], raw)
+	copy(info.PieceID[:], raw[len(storj.NodeID{}):])
+	err = item.Value(func(val []byte) error {
+		var fields [4]int64
+		for i := range fields {
+			field, n := binary.Varint(val)
+			if n <= 0 {
+				return ErrV0PieceInfo.New("invalid entry of %x", item.Key())
+			}
+			fields[i], val = field, val[n:]
+		}
+		size, n := binary.Uvarint(val)
+		if n <= 0 || uint64(len(val)-n) < size {
+			return ErrV0PieceInfo.New("invalid entry of %x", item.Key())
+		}
+		if err := pb.Unmarshal(val[n:n+int(size)], info.OrderLimit); err != nil {
+			return ErrV0PieceInfo.Wrap(err)
+		}
+		if err := pb.Unmarshal(val[n+int(size):], info.UplinkPieceHash); err != nil {
+			return ErrV0PieceInfo.Wrap(err)
+		}
+		info.PieceSize = fields[0]
+		info.PieceCreation, info.PieceExpiration, deletionFailedAt = fromV0Time(fields[1]), fromV0Time(fields[2]), fromV0Time(fields[3])
+		return nil
+	})
+	return info, deletionFailedAt, err
+}
+
+func fromV0Time(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// v0StoredPieceAccess is the pieces.StoredPieceAccess of the v0 pieces, whose
+// size and creation time come from their infos.
+type v0StoredPieceAccess struct {
+	blobStore blobstore.Blobs
+	info      *pieces.Info
+	blobInfo  blobstore.BlobInfo
+}
+
+// BlobRef returns the reference of the blob of the piece.
+func (a *v0StoredPieceAccess) BlobRef() blobstore.BlobRef {
+	return blobstore.BlobRef{Namespace: a.info.SatelliteID.Bytes(), Key: a.info.PieceID.Bytes()}
+}
+
+// StorageFormatVersion returns FormatV0.
+func (a *v0StoredPieceAccess) StorageFormatVersion() blobstore.FormatVersion {
+	return filestore.FormatV0
+}
+
+// PieceID returns the ID of the piece.
+func (a *v0StoredPieceAccess) PieceID() storj.PieceID {
+	return a.info.PieceID
+}
+
+// Satellite returns the ID of the satellite of the piece.
+func (a *v0StoredPieceAccess) Satellite() (storj.NodeID, error) {
+	return a.info.SatelliteID, nil
+}
+
+// Size returns the size of the piece, which is all content.
+func (a *v0StoredPieceAccess) Size(ctx context.Context) (int64, int64, error) {
+	return a.info.PieceSize, a.info.PieceSize, nil
+}
+
+// CreationTime returns the creation time of the piece.
+func (a *v0StoredPieceAccess) CreationTime(ctx context.Context) (time.Time, error) {
+	return a.info.PieceCreation, nil
+}
+
+// ModTime returns the creation time of the piece, which is as fast.
+func (a *v0StoredPieceAccess) ModTime(ctx context.Context) (time.Time, error) {
+	return a.info.PieceCreation, nil
+}
+
+// Stat stats the blob of the piece.
+func (a *v0StoredPieceAccess) Stat(ctx context.Context) (blobstore.FileInfo, error) {
+	if err := a.statBlob(ctx); err != nil {
+		return nil, err
+	}
+	return a.blobInfo.Stat(ctx)
+}
+
+// FullPath returns the path of the blob of the piece.
+func (a *v0StoredPieceAccess) FullPath(ctx context.Context) (string, error) {
+	if err := a.statBlob(ctx); err != nil {
+		return "", err
+	}
+	return a.blobInfo.FullPath(ctx)
+}
+
+func (a *v0StoredPieceAccess) statBlob(ctx context.Context) (err error) {
+	if a.blobInfo != nil {
+		return nil
+	}
+	if a.blobStore == nil {
+		return ErrV0PieceInfo.New("no blob store to stat %s/%s", a.info.SatelliteID, a.info.PieceID)
+	}
+	a.blobInfo, err = a.blobStore.StatWithStorageFormat(ctx, a.BlobRef(), filestore.FormatV0)
+	return err
+}
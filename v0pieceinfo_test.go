@@ -0,0 +1,103 @@
+package badger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"storj.io/common/pb"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/pieces"
+	"testing"
+	"time"
+)
+
+func TestV0PieceInfos(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+	infos := store.V0PieceInfos()
+
+	now := time.Unix(1700000000, 0)
+	satellite1, satellite2 := testrand.NodeID(), testrand.NodeID()
+	newInfo := func(satellite storj.NodeID, expiration time.Time) *pieces.Info {
+		pieceID := testrand.PieceID()
+		return &pieces.Info{
+			SatelliteID:     satellite,
+			PieceID:         pieceID,
+			PieceSize:       testrand.Int63n(1000),
+			PieceCreation:   now.Add(-time.Hour),
+			PieceExpiration: expiration,
+			OrderLimit:      &pb.OrderLimit{SatelliteId: satellite, PieceId: pieceID, SerialNumber: testrand.SerialNumber()},
+			UplinkPieceHash: &pb.PieceHash{PieceId: pieceID, Hash: testrand.BytesInt(32)},
+		}
+	}
+	expired := newInfo(satellite1, now.Add(-time.Minute))
+	kept := newInfo(satellite1, time.Time{})
+	other := newInfo(satellite2, now.Add(time.Hour))
+	require.NoError(t, infos.Import(ctx, []*pieces.Info{expired, kept}))
+	require.NoError(t, infos.Add(ctx, other))
+
+	info, err := infos.Get(ctx, satellite1, expired.PieceID)
+	require.NoError(t, err)
+	require.Equal(t, expired.PieceSize, info.PieceSize)
+	require.True(t, expired.PieceCreation.Equal(info.PieceCreation))
+	require.True(t, expired.PieceExpiration.Equal(info.PieceExpiration))
+	require.Equal(t, expired.OrderLimit.SerialNumber, info.OrderLimit.SerialNumber)
+	require.Equal(t, expired.UplinkPieceHash.Hash, info.UplinkPieceHash.Hash)
+
+	info, err = infos.Get(ctx, satellite1, kept.PieceID)
+	require.NoError(t, err)
+	require.True(t, info.PieceExpiration.IsZero())
+
+	_, err = infos.Get(ctx, satellite2, expired.PieceID)
+	require.True(t, ErrNotExist.Has(err))
+
+	// the pieces are stat'ed in the blob store
+	w, err := store.TestCreateV0(ctx, blobstore.BlobRef{Namespace: satellite2.Bytes(), Key: other.PieceID.Bytes()})
+	require.NoError(t, err)
+	_, err = w.Write(testrand.BytesInt(int(other.PieceSize)))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	var walked []storj.PieceID
+	require.NoError(t, infos.WalkSatelliteV0Pieces(ctx, store, satellite2, func(access pieces.StoredPieceAccess) error {
+		walked = append(walked, access.PieceID())
+		total, contentSize, err := access.Size(ctx)
+		require.NoError(t, err)
+		require.Equal(t, other.PieceSize, total)
+		require.Equal(t, other.PieceSize, contentSize)
+		stat, err := access.Stat(ctx)
+		require.NoError(t, err)
+		require.Equal(t, other.PieceSize, stat.Size())
+		return nil
+	}))
+	require.Equal(t, []storj.PieceID{other.PieceID}, walked)
+
+	getExpired := func(expiredAt time.Time, limit int) (found []pieces.ExpiredInfo) {
+		require.NoError(t, infos.GetExpired(ctx, expiredAt, func(ctx context.Context, info pieces.ExpiredInfo) bool {
+			found = append(found, info)
+			return len(found) < limit
+		}))
+		return found
+	}
+	require.Equal(t, []pieces.ExpiredInfo{{SatelliteID: satellite1, PieceID: expired.PieceID, InPieceInfo: true}}, getExpired(now, 10))
+
+	// a failed deletion is skipped until the next pass
+	require.NoError(t, infos.DeleteFailed(ctx, satellite1, expired.PieceID, now))
+	require.Empty(t, getExpired(now, 10))
+	require.Len(t, getExpired(now.Add(2*time.Hour), 1), 1)
+
+	require.NoError(t, infos.Delete(ctx, satellite1, expired.PieceID))
+	_, err = infos.Get(ctx, satellite1, expired.PieceID)
+	require.True(t, ErrNotExist.Has(err))
+
+	require.NoError(t, infos.DeleteExpirations(ctx, now.Add(2*time.Hour)))
+	_, err = infos.Get(ctx, satellite2, other.PieceID)
+	require.True(t, ErrNotExist.Has(err))
+	_, err = infos.Get(ctx, satellite1, kept.PieceID)
+	require.NoError(t, err)
+}
@@ -0,0 +1,137 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"storj.io/common/memory"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// valueLogStatsInterval is how often the value log metrics are published.
+var valueLogStatsInterval = time.Minute
+
+// discardStatsFile is where badger keeps the discardable bytes of the value
+// log files, as pairs of big endian file id and bytes, up to an empty slot.
+const discardStatsFile = "DISCARD"
+
+// ValueLogFile describes a file of the value log. Deleted blobs stay in the
+// files until value log GC rewrites them, which happens only for files with
+// enough garbage.
+type ValueLogFile struct {
+	ID   uint32
+	Size int64
+	// Discardable is the size of the deleted and overwritten values in the
+	// file. Badger learns about them during compactions, so it lags behind
+	// the deletes.
+	Discardable int64
+	// GarbageRatio is the discardable part of the file.
+	GarbageRatio float64
+}
+
+// valueLogFiles returns the value log files of the store, ordered by id,
+// none for the engines without a value log.
+func (b *BlobStore) valueLogFiles() ([]ValueLogFile, error) {
+	db := badgerDB(b.db)
+	if db == nil {
+		return nil, nil
+	}
+	dir := db.Opts().ValueDir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	discardable, err := readDiscardStats(filepath.Join(dir, discardStatsFile))
+	if err != nil {
+		return nil, err
+	}
+	var files []ValueLogFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".vlog") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ".vlog"), 10, 32)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if errs.Is(err, os.ErrNotExist) {
+			// removed by value log GC meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+		file := ValueLogFile{ID: uint32(id), Size: info.Size(), Discardable: discardable[uint32(id)]}
+		if file.Size > 0 {
+			file.GarbageRatio = float64(file.Discardable) / float64(file.Size)
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files, nil
+}
+
+// readDiscardStats returns the discardable bytes by value log file id.
+func readDiscardStats(path string) (map[uint32]int64, error) {
+	raw, err := os.ReadFile(path)
+	if errs.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap(err)
+	}
+	discardable := map[uint32]int64{}
+	for slot := 0; slot+16 <= len(raw); slot += 16 {
+		id := binary.BigEndian.Uint64(raw[slot:])
+		if id == 0 {
+			break
+		}
+		discardable[uint32(id)] = int64(binary.BigEndian.Uint64(raw[slot+8:]))
+	}
+	return discardable, nil
+}
+
+// startValueLogStats publishes the number of value log files and their
+// garbage ratios periodically.
+func (b *BlobStore) startValueLogStats() {
+	b.runBackground("value log stats", func(ctx context.Context) {
+		ticker := time.NewTicker(valueLogStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			files, err := b.valueLogFiles()
+			if err != nil {
+				b.log.Warn("reading value log stats failed", zap.Error(err))
+				continue
+			}
+			mon.IntVal("vlog_files").Observe(int64(len(files)))
+			for _, file := range files {
+				tag := monkit.NewSeriesTag("file", strconv.FormatUint(uint64(file.ID), 10))
+				mon.FloatVal("vlog_garbage_ratio", tag).Observe(file.GarbageRatio)
+			}
+		}
+	})
+}
+
+// checkValueLog validates the value log file size, which badger rejects
+// with an error not telling the limits.
+func checkValueLog(options badger.Options) error {
+	if options.ValueLogFileSize < memory.MiB.Int64() || options.ValueLogFileSize >= 2*memory.GiB.Int64() {
+		return ErrConfig.New("value log file size must be at least 1MiB and below 2GiB, got %s", memory.Size(options.ValueLogFileSize))
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"github.com/zeebo/errs"
+	"storj.io/storj/storagenode/blobstore"
+	"storj.io/storj/storagenode/blobstore/filestore"
+	"strings"
+	"time"
+)
+
+// ErrResumeToken is returned for resume tokens not created by this store.
+var ErrResumeToken = errs.Class("invalid resume token")
+
+const resumeTokenVersion = 1
+
+// ResumeToken is the opaque position of an interrupted walk.
+type ResumeToken []byte
+
+// WalkNamespaceResumable walks the namespace like WalkNamespace, starting
+// after the position of token (from the beginning for a nil token). When
+// walkFunc fails or ctx is canceled, the returned token points after the
+// last blob walkFunc completed, so a later call can continue from there. The
+// token is nil when the walk finished.
+func (b *BlobStore) WalkNamespaceResumable(ctx context.Context, namespace []byte, token ResumeToken, walkFunc func(blobstore.BlobInfo) error) (ResumeToken, error) {
+	var after []byte
+	if token != nil {
+		if len(token) < 17 || token[0] != resumeTokenVersion {
+			return nil, ErrResumeToken.New("%x", []byte(token))
+		}
+		after = token[1:]
+	}
+	last, err := b.walk(ctx, concat(blobPrefix, namespace), namespace, after, walkFunc)
+	if err == nil {
+		return nil, nil
+	}
+	if last == nil {
+		return token, err
+	}
+	return ResumeToken(concat([]byte{resumeTokenVersion}, last)), err
+}
+
+// walk calls walkFunc for the keys with the given prefix, skipping the ones
+// up to and including prefix+after. It returns the last walked key without
+// the prefix.
+//
+// Keys are walked in the order badger stores them, which is lexicographic
+// order of the blob keys, as long as no key is a prefix of another one
+// followed by zero bytes (the high bytes of the modification time stored
+// after every key). Fixed length keys, like piece IDs, are always sorted.
+//
+// The keys are read from a single snapshot, so blobs committed or deleted
+// during the walk don't make it skip or repeat blobs. With
+// Config.WalkSnapshotRefresh set, long walks continue on a new snapshot after
+// that time, from the position they reached: blobs changed after that
+// position are walked as they are in the new snapshot, and a blob is still
+// visited only once, even when a new version of it was committed meanwhile.
+func (b *BlobStore) walk(ctx context.Context, prefix []byte, namespace []byte, after []byte, walkFunc func(blobstore.BlobInfo) error) (last []byte, err error) {
+	last = after
+	for done := false; !done; {
+		err = b.db.View(func(txn kvTxn) error {
+			started := time.Now()
+			it := txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix))
+			defer it.Close()
+			for it.Seek(concat(prefix, last)); it.ValidForPrefix(prefix); it.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				key := it.Item().KeyCopy(nil)
+				if last != nil && sameBlob(key[len(prefix):], last) {
+					continue
+				}
+				ref := blobstore.BlobRef{
+					Namespace: namespace,
+					Key:       key[len(prefix) : len(key)-16],
+				}
+				info := newBlobInfo(b.dir, ref, key, it.Item().UserMeta())
+				lastAccess, err := b.access.lastAccess(txn, ref)
+				if err != nil {
+					return err
+				}
+				info.lastAccess = lastAccess
+				if err := walkFunc(info); err != nil {
+					return err
+				}
+				last = key[len(prefix):]
+				if refresh := b.config.WalkSnapshotRefresh; refresh > 0 && time.Since(started) > refresh {
+					return nil
+				}
+			}
+			done = true
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	if bytes.Equal(last, after) {
+		last = nil
+	}
+	return last, err
+}
+
+// walkStart returns the smallest blob key the file store puts in the prefix
+// directory startFromPrefix, or nil for an empty prefix. The directories are
+// named after the first characters of the keys in filestore.PathEncoding,
+// whose characters sort like the bits they encode, so walking from the
+// returned key skips the same blobs as the file store does. The key is
+// shorter than the 16 bytes sameBlob needs, so walk doesn't skip a blob
+// equal to it.
+func walkStart(startFromPrefix string) ([]byte, error) {
+	if startFromPrefix == "" {
+		return nil, nil
+	}
+	if len(startFromPrefix) > 2 {
+		return nil, errs.New("invalid start prefix %q", startFromPrefix)
+	}
+	// the missing bits of the key are zero, which encode as 'a'
+	padded := startFromPrefix + strings.Repeat("a", 8-len(startFromPrefix))
+	key, err := filestore.PathEncoding.DecodeString(padded)
+	if err != nil {
+		return nil, errs.New("invalid start prefix %q", startFromPrefix)
+	}
+	return key[:(len(startFromPrefix)*5+7)/8], nil
+}
+
+// sameBlob returns whether two blob keys (without prefix) are versions of the
+// same blob.
+func sameBlob(a, b []byte) bool {
+	return len(a) >= 16 && len(b) >= 16 && bytes.Equal(a[:len(a)-16], b[:len(b)-16])
+}
+
+// Iterator iterates over the blobs of a namespace in the same order as
+// WalkNamespace, from a single snapshot. It must be closed after use.
+type Iterator struct {
+	dir       string
+	txn       kvTxn
+	it        kvIterator
+	prefix    []byte
+	namespace []byte
+	after     []byte
+	started   bool
+	info      BlobInfo
+	last      []byte
+}
+
+// NewIterator returns an iterator over the blobs of the namespace with keys
+// after the given one, or all the blobs when after is nil.
+func (b *BlobStore) NewIterator(namespace []byte, after []byte) *Iterator {
+	txn := b.db.NewTransaction(false)
+	prefix := concat(blobPrefix, namespace)
+	return &Iterator{
+		dir:       b.dir,
+		txn:       txn,
+		it:        txn.NewIterator(b.iteratorOptions(IteratorWalk, prefix)),
+		prefix:    prefix,
+		namespace: namespace,
+		after:     after,
+	}
+}
+
+// Next moves to the next blob and returns whether there is one.
+func (i *Iterator) Next() bool {
+	if i.started {
+		i.it.Next()
+	} else {
+		i.it.Seek(concat(i.prefix, i.after))
+		i.started = true
+	}
+	for ; i.it.ValidForPrefix(i.prefix); i.it.Next() {
+		key := i.it.Item().KeyCopy(nil)
+		if len(key) < len(i.prefix)+16 {
+			continue
+		}
+		blobKey := key[len(i.prefix) : len(key)-16]
+		if i.after != nil && bytes.Equal(blobKey, i.after) {
+			continue
+		}
+		i.info = newBlobInfo(i.dir, blobstore.BlobRef{Namespace: i.namespace, Key: blobKey}, key, i.it.Item().UserMeta())
+		i.last = blobKey
+		return true
+	}
+	return false
+}
+
+// Info returns the info of the current blob.
+func (i *Iterator) Info() blobstore.BlobInfo {
+	return i.info
+}
+
+// LastKey returns the key of the last blob visited by Next, or nil. A new
+// iterator created with it continues after that blob.
+func (i *Iterator) LastKey() []byte {
+	return i.last
+}
+
+// Close releases the snapshot of the iterator.
+func (i *Iterator) Close() error {
+	i.it.Close()
+	i.txn.Discard()
+	return nil
+}
@@ -0,0 +1,187 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"sort"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/storj/storagenode/blobstore"
+	"testing"
+	"time"
+)
+
+func TestWalkTrash(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "22"))
+	require.NoError(t, save(ctx, store, ref("ns", "key3"), "333"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key3"), time.Now()))
+
+	type walker func(context.Context, []byte, string, func(blobstore.BlobInfo) error) error
+	walk := func(walk walker) map[string]int64 {
+		found := map[string]int64{}
+		err := walk(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+			require.Equal(t, "ns", string(info.BlobRef().Namespace))
+			stat, err := info.Stat(ctx)
+			require.NoError(t, err)
+			found[string(info.BlobRef().Key)] = stat.Size()
+			return nil
+		})
+		require.NoError(t, err)
+		return found
+	}
+
+	require.Equal(t, map[string]int64{"key1": 1}, walk(store.WalkNamespace))
+	require.Equal(t, map[string]int64{"key2": 2, "key3": 3}, walk(store.WalkTrash))
+}
+
+func TestWalkAllNamespaces(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	require.NoError(t, save(ctx, store, ref("ns", "key1"), "1"))
+	require.NoError(t, save(ctx, store, ref("ns", "key2"), "22"))
+	require.NoError(t, save(ctx, store, ref("ns2", "key1"), "333"))
+	require.NoError(t, save(ctx, store, ref("other", "key"), "4444"))
+	require.NoError(t, store.Trash(ctx, ref("ns", "key2"), time.Now()))
+
+	found := map[string]int64{}
+	err = store.WalkAllNamespaces(ctx, func(info blobstore.BlobInfo) error {
+		stat, err := info.Stat(ctx)
+		require.NoError(t, err)
+		found[string(info.BlobRef().Namespace)+"/"+string(info.BlobRef().Key)] = stat.Size()
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"ns/key1": 1, "ns2/key1": 3, "other/key": 4}, found)
+}
+
+func TestWalkNamespaceResumable(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, save(ctx, store, ref("ns", fmt.Sprintf("key%d", i)), "data"))
+	}
+
+	errInterrupted := errs.New("interrupted")
+	var visited []string
+	var token ResumeToken
+	for round := 0; ; round++ {
+		require.Less(t, round, 10)
+		count := 0
+		token, err = store.WalkNamespaceResumable(ctx, []byte("ns"), token, func(info blobstore.BlobInfo) error {
+			if count == 3 {
+				return errInterrupted
+			}
+			count++
+			visited = append(visited, string(info.BlobRef().Key))
+			return nil
+		})
+		if err == nil {
+			require.Nil(t, token)
+			break
+		}
+		require.ErrorIs(t, err, errInterrupted)
+		require.NotNil(t, token)
+	}
+	require.Len(t, visited, 10)
+	for i, key := range visited {
+		require.Equal(t, fmt.Sprintf("key%d", i), key)
+	}
+
+	_, err = store.WalkNamespaceResumable(ctx, []byte("ns"), ResumeToken("garbage"), func(blobstore.BlobInfo) error { return nil })
+	require.True(t, ErrResumeToken.Has(err))
+}
+
+func TestWalkSnapshot(t *testing.T) {
+	for _, refresh := range []time.Duration{0, time.Nanosecond} {
+		ctx := testcontext.New(t)
+
+		store, err := NewBlobStoreWithConfig(ctx.Dir("store"), Config{WalkSnapshotRefresh: refresh})
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, save(ctx, store, ref("ns", fmt.Sprintf("key%d", i)), "data"))
+		}
+
+		var visited []string
+		err = store.WalkNamespace(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+			key := string(info.BlobRef().Key)
+			visited = append(visited, key)
+			if key == "key1" {
+				// new versions of walked blobs aren't walked again
+				require.NoError(t, save(ctx, store, ref("ns", "key0"), "new data"))
+				require.NoError(t, save(ctx, store, ref("ns", "key1"), "new data"))
+				require.NoError(t, save(ctx, store, ref("ns", "key5"), "data"))
+				require.NoError(t, store.Delete(ctx, ref("ns", "key3")))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		if refresh == 0 {
+			require.Equal(t, []string{"key0", "key1", "key2", "key3", "key4"}, visited)
+		} else {
+			require.Equal(t, []string{"key0", "key1", "key2", "key4", "key5"}, visited)
+		}
+
+		require.NoError(t, store.Close())
+		ctx.Cleanup()
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	store, err := NewBlobStore(ctx.Dir("store"))
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		key := testrand.PieceID()
+		keys = append(keys, string(key[:]))
+		require.NoError(t, save(ctx, store, blobstore.BlobRef{Namespace: []byte("ns"), Key: key[:]}, "data"))
+	}
+	sort.Strings(keys)
+
+	var walked []string
+	err = store.WalkNamespace(ctx, []byte("ns"), "", func(info blobstore.BlobInfo) error {
+		walked = append(walked, string(info.BlobRef().Key))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, keys, walked)
+
+	// iterators continue after the last key of an earlier one
+	var iterated []string
+	var last []byte
+	for len(iterated) < len(keys) {
+		it := store.NewIterator([]byte("ns"), last)
+		for i := 0; i < 7 && it.Next(); i++ {
+			iterated = append(iterated, string(it.Info().BlobRef().Key))
+		}
+		last = it.LastKey()
+		require.NoError(t, it.Close())
+	}
+	require.Equal(t, keys, iterated)
+}
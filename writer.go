@@ -2,9 +2,10 @@ package badger
 
 import (
 	"context"
-	"github.com/dgraph-io/badger/v4"
+	"crypto/sha256"
 	"github.com/zeebo/errs"
 	"io"
+	"os"
 	"storj.io/storj/storagenode/blobstore/filestore"
 	"time"
 
@@ -16,14 +17,51 @@ type writer struct {
 	length int
 	buffer []byte
 	ref    blobstore.BlobRef
-	db     *badger.DB
+	db     kvEngine
+	dedup  bool
+	cache  *readCache
+	clock  Clock
+	done   bool
+	format blobstore.FormatVersion
+
+	// chunkSize splits the larger blobs into chunks, when set
+	chunkSize int64
+
+	// backpressure, breaker and diskHealth are told the commit results, when enabled
+	backpressure *backpressure
+	breaker      *breaker
+	diskHealth   *diskHealth
+	io           *ioStats
+	retry        *RetryPolicy
+	readOnly     *readOnly
+	quotas       *quotas
+	rateLimits   *rateLimits
+
+	// own is the in-flight upload of the writer if it's the first of the
+	// ref, first the one of the earlier writer to wait for otherwise; sum is
+	// the hash of the committed data told to the waiting writers
+	inflight *inflightUploads
+	own      *inflightUpload
+	first    *inflightUpload
+	sum      []byte
+
+	// committed is called with the blob and its size after a successful
+	// commit, to add the namespace to the known ones of the store
+	committed func(ref blobstore.BlobRef, size int64)
+
+	// once the data grows over memoryLimit it's moved from buffer to a
+	// temporary file in spillDir, keeping the memory use of large uploads bounded
+	memoryLimit int
+	spillDir    string
+	spill       *os.File
 }
 
-func NewWriter(db *badger.DB, ref blobstore.BlobRef) *writer {
+func NewWriter(db kvEngine, ref blobstore.BlobRef) *writer {
 	return &writer{
 		db:     db,
 		ref:    ref,
-		buffer: make([]byte, 5000000),
+		clock:  systemClock{},
+		format: filestore.FormatV1,
 	}
 }
 func (w *writer) Seek(offset int64, whence int) (int64, error) {
@@ -37,36 +75,181 @@ func (w *writer) Seek(offset int64, whence int) (int64, error) {
 	return int64(w.offset), nil
 }
 
-func (w *writer) Cancel(ctx context.Context) error {
+// Cancel discards the written data. Nothing is persisted before Commit, so
+// releasing the buffer and the spill file is all the cleanup needed.
+func (w *writer) Cancel(ctx context.Context) (err error) {
+	defer wrapRef("cancel", w.ref, &err)
+	w.done = true
 	w.buffer = nil
-	return nil
+	w.finishInflight(false)
+	return w.removeSpill()
 }
 
 func (w *writer) Commit(ctx context.Context) error {
-	if w.buffer == nil {
-		return errs.New("Already committed")
+	return w.CommitWithModTime(ctx, w.clock.Now())
+}
+
+// CommitWithModTime commits the blob with the given modification time
+// instead of the current one.
+func (w *writer) CommitWithModTime(ctx context.Context, modTime time.Time) (err error) {
+	defer wrapRef("commit", w.ref, &err)
+	if w.done {
+		return ErrClosed.New("blob already committed")
+	}
+	w.done = true
+	defer func() {
+		w.buffer = nil
+		err = errs.Combine(err, w.removeSpill())
+		w.finishInflight(err == nil)
+	}()
+	if err := w.readOnly.check(); err != nil {
+		return err
 	}
-	err := w.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(key(w.ref, time.Now(), w.offset), w.buffer[:w.offset])
+	data, err := w.data()
+	if err != nil {
+		return classify(err)
+	}
+	// the same blob uploaded concurrently is committed only once
+	if duplicate, err := w.first.duplicate(ctx, data); err != nil {
+		return err
+	} else if duplicate {
+		mon.Counter("duplicate_uploads").Inc(1)
+		return nil
+	}
+	if err := w.quotas.check(w.db, w.ref, int64(w.offset)); err != nil {
+		return err
+	}
+	if err := w.rateLimits.waitWrite(ctx, w.ref.Namespace, 1, int64(w.offset)); err != nil {
+		return err
+	}
+	// the chunks are never larger than the engine stores
+	if limit := w.db.MaxValueSize(); int64(len(data)) > limit && (w.chunkSize == 0 || w.dedup) {
+		return ErrTooLarge.New("blob has %d bytes, the engine stores only %d", len(data), limit)
+	}
+	// the new version, the removal of older versions and the usage counters
+	// are written in one transaction, so a crash can't leave them inconsistent
+	started := time.Now()
+	var stored blobstore.FormatVersion
+	err = w.retry.do(ctx, "commit", func() error {
+		return update(w.db, func(txn kvTxn) error {
+			delta := usage{Bytes: int64(w.offset), Pieces: 1}
+			for _, stale := range blobVersions(txn, keyPrefix(w.ref)) {
+				if err := removeEntry(txn, stale.key); err != nil {
+					return err
+				}
+				delta = delta.add(usage{Bytes: -stale.size, Pieces: -1})
+			}
+			blobKey := key(w.ref, modTime, w.offset)
+			var value []byte
+			value, stored = encodeValue(w.format, data)
+			if err := setBlob(txn, blobKey, value, formatUserMeta(stored), w.dedup, w.chunkSize); err != nil {
+				return err
+			}
+			if err := setNamespaceMarker(txn, w.ref.Namespace); err != nil {
+				return err
+			}
+			return addUsage(txn, w.ref, delta)
+		})
 	})
-	w.buffer = nil
+	if err == nil {
+		w.format = stored
+		if w.own != nil {
+			sum := sha256.Sum256(data)
+			w.sum = sum[:]
+		}
+		w.io.write(w.ref.Namespace, w.offset)
+		if w.committed != nil {
+			w.committed(w.ref, int64(w.offset))
+		}
+	}
+	latency := time.Since(started)
+	w.backpressure.observeCommit(latency)
+	w.diskHealth.observeCommit(latency)
+	if w.breaker != nil {
+		w.breaker.record(latency, err)
+	}
+	if w.cache != nil {
+		w.cache.invalidate(w.ref)
+	}
 	return err
+}
 
+// finishInflight releases the writers waiting for this one.
+func (w *writer) finishInflight(committed bool) {
+	w.inflight.finish(w.ref, w.own, committed, w.sum)
+	w.own = nil
+}
+
+// data returns the committed part of the blob. Badger needs values in
+// memory, so spilled data is read back from the file.
+func (w *writer) data() ([]byte, error) {
+	if w.spill == nil {
+		return w.buffer[:w.offset], nil
+	}
+	data := make([]byte, w.offset)
+	_, err := w.spill.ReadAt(data, 0)
+	return data, errs.Wrap(err)
+}
+
+func (w *writer) startSpill() error {
+	if err := os.MkdirAll(w.spillDir, 0700); err != nil {
+		return errs.Wrap(err)
+	}
+	spill, err := os.CreateTemp(w.spillDir, "upload-*")
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if _, err := spill.Write(w.buffer); err != nil {
+		return errs.Combine(err, spill.Close(), os.Remove(spill.Name()))
+	}
+	w.spill = spill
+	w.buffer = nil
+	return nil
+}
+
+func (w *writer) removeSpill() error {
+	if w.spill == nil {
+		return nil
+	}
+	spill := w.spill
+	w.spill = nil
+	return errs.Combine(spill.Close(), os.Remove(spill.Name()))
 }
 
 func (w *writer) Size() (int64, error) {
 	return int64(w.offset), nil
 }
 
+// StorageFormatVersion returns the format the blob is written in. After
+// Commit it's the format it was stored in, which is FormatV1 for FormatV2
+// data without a piece header.
 func (w *writer) StorageFormatVersion() blobstore.FormatVersion {
-	return filestore.FormatV1
+	return w.format
 }
 
 func (w *writer) Write(p []byte) (n int, err error) {
-	i := copy(w.buffer[w.offset:len(p)+w.offset], p)
-	w.offset += i
+	defer wrapRef("write", w.ref, &err)
+	if w.done {
+		return 0, ErrClosed.New("write to finished blob")
+	}
+	end := w.offset + len(p)
+	if w.spill == nil && w.memoryLimit > 0 && end > w.memoryLimit {
+		if err := w.startSpill(); err != nil {
+			return 0, classify(err)
+		}
+	}
+	if w.spill != nil {
+		n, err = w.spill.WriteAt(p, int64(w.offset))
+		err = classify(errs.Wrap(err))
+	} else {
+		if end > len(w.buffer) {
+			w.buffer = append(w.buffer, make([]byte, end-len(w.buffer))...)
+		}
+		n = copy(w.buffer[w.offset:], p)
+	}
+	w.offset += n
 	if w.offset > w.length {
 		w.length = w.offset
 	}
-	return i, nil
+	return n, err
 }
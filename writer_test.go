@@ -0,0 +1,75 @@
+package badger
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"strings"
+	"testing"
+)
+
+func TestWriterSpill(t *testing.T) {
+	ctx := testcontext.New(t)
+	defer ctx.Cleanup()
+
+	dir := ctx.Dir("store")
+	store, err := NewBlobStoreWithConfig(dir, Config{WriteBuffer: 10 * memory.B})
+	require.NoError(t, err)
+	defer ctx.Check(store.Close)
+
+	spillFiles := func() int {
+		entries, err := os.ReadDir(filepath.Join(dir, spillDir))
+		if os.IsNotExist(err) {
+			return 0
+		}
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	w, err := store.Create(ctx, ref("ns", "small"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, 0, spillFiles())
+	require.NoError(t, w.Commit(ctx))
+
+	w, err = store.Create(ctx, ref("ns", "large"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("01234567"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte(strings.Repeat("x", 100)))
+	require.NoError(t, err)
+	require.Equal(t, 1, spillFiles())
+	// rewriting the beginning of the blob works after the spill too
+	_, err = w.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("abcdefgh"))
+	require.NoError(t, err)
+	_, err = w.Seek(108, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.Equal(t, 0, spillFiles())
+
+	for key, expected := range map[string]string{
+		"small": "0123456789",
+		"large": "abcdefgh" + strings.Repeat("x", 100),
+	} {
+		reader, err := store.Open(ctx, ref("ns", key))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content))
+		require.NoError(t, reader.Close())
+	}
+
+	w, err = store.Create(ctx, ref("ns", "canceled"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte(strings.Repeat("x", 100)))
+	require.NoError(t, err)
+	require.Equal(t, 1, spillFiles())
+	require.NoError(t, w.Cancel(ctx))
+	require.Equal(t, 0, spillFiles())
+}